@@ -0,0 +1,146 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"time"
+)
+
+//AccessTokenProvider is the provider of the identities authenticated with a personal access token
+const AccessTokenProvider = "token"
+
+//accessTokenUseResolution limits the updates of the last use date of the access tokens
+const accessTokenUseResolution = 5 * time.Minute
+
+//CreateAccessToken creates a personal access token for the current user.
+//The returned secret must be sent in the Authorization header of the API requests,
+//it cannot be retrieved later as only its hash is stored.
+//A zero expiration date creates a token that never expires.
+func (app App) CreateAccessToken(name string, scopes []Scope, expirationDate time.Time) (string, Token, error) {
+	identity, err := app.sessionIdentity("Create access token")
+	if err != nil {
+		return "", Token{}, err
+	}
+
+	if len(name) == 0 {
+		return "", Token{}, DataError{"name", "must not be empty"}
+	}
+	if len(scopes) == 0 {
+		return "", Token{}, DataError{"scope", "at least one scope is required"}
+	}
+	now := time.Now()
+	if !expirationDate.IsZero() && !expirationDate.After(now) {
+		return "", Token{}, DataError{"expiration date", "must be in the future"}
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return "", Token{}, err
+	}
+
+	token := Token{
+		Hash:           hashSecret(secret),
+		Kind:           TokenKindACCESS,
+		UserName:       identity.UserName,
+		CreationDate:   now,
+		ExpirationDate: expirationDate,
+		ID:             generateID(),
+		Name:           name,
+	}
+	for _, scope := range scopes {
+		if !token.HasScope(scope) {
+			token.Scopes = append(token.Scopes, scope)
+		}
+	}
+	if err := app.repository.StoreToken(token); err != nil {
+		return "", Token{}, err
+	}
+
+	return secret, token, nil
+}
+
+//AccessTokens returns the personal access tokens of the current user
+func (app App) AccessTokens() ([]Token, error) {
+	identity, err := app.sessionIdentity("List access tokens")
+	if err != nil {
+		return nil, err
+	}
+
+	return app.repository.GetTokens(identity.UserName, TokenKindACCESS)
+}
+
+//RevokeAccessToken deletes a personal access token of the current user
+func (app App) RevokeAccessToken(tokenID string) error {
+	identity, err := app.sessionIdentity("Revoke access token")
+	if err != nil {
+		return err
+	}
+
+	tokens, err := app.repository.GetTokens(identity.UserName, TokenKindACCESS)
+	if err != nil {
+		return err
+	}
+	for _, token := range tokens {
+		if token.ID == tokenID {
+			return app.repository.DeleteToken(token.Hash)
+		}
+	}
+	return NotInDatastoreError{"Access token", tokenID}
+}
+
+//WithAccessToken returns an App acting on behalf of the owner of the access token
+//of the given secret. Its operations are limited to the scopes of the token.
+func (app App) WithAccessToken(secret string) (App, error) {
+	now := time.Now()
+
+	var token Token
+	err := app.repository.RunInTransaction(func(repo Repository) error {
+		var err error
+		token, err = repo.GetToken(hashSecret(secret))
+		if _, notFound := err.(NotInDatastoreError); notFound || (err == nil && (token.Kind != TokenKindACCESS || token.IsExpired(now))) {
+			return NotAuthorizedError{"Access token"}
+		}
+		if err != nil {
+			return err
+		}
+
+		if now.Sub(token.LastUseDate) < accessTokenUseResolution {
+			return nil
+		}
+		token.LastUseDate = now
+		return repo.StoreToken(token)
+	})
+	if err != nil {
+		return App{}, err
+	}
+
+	app.accessToken = &token
+	return app, nil
+}
+
+//checkScope verifies that the access token used, if any, allows the operation
+func (app App) checkScope(scope Scope, operation string) error {
+	if app.accessToken != nil && !app.accessToken.HasScope(scope) {
+		return NotAuthorizedError{operation}
+	}
+	return nil
+}
+
+//sessionIdentity returns the identity of the current user, refusing access tokens.
+//It protects the account management, which is never delegated to scripts.
+func (app App) sessionIdentity(operation string) (Identity, error) {
+	if app.accessToken != nil {
+		return Identity{}, NotAuthorizedError{operation}
+	}
+
+	identity, err := app.CurrentIdentity()
+	if err != nil {
+		return Identity{}, err
+	}
+	if len(identity.UserName) == 0 {
+		return Identity{}, NotAuthorizedError{operation}
+	}
+	return identity, nil
+}
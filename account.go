@@ -0,0 +1,129 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"time"
+)
+
+//identityLinkDuration is the time allowed to log in with the identity to link
+const identityLinkDuration = 30 * time.Minute
+
+//Identities returns the identities linked to the current user
+func (app App) Identities() ([]Identity, error) {
+	identity, err := app.sessionIdentity("List identities")
+	if err != nil {
+		return nil, err
+	}
+
+	return app.repository.GetIdentities(identity.UserName)
+}
+
+//NewIdentityLink starts linking a new identity to the current user.
+//The returned secret must be given to LinkIdentity, once logged in with the new identity.
+func (app App) NewIdentityLink() (string, error) {
+	identity, err := app.sessionIdentity("Link identity")
+	if err != nil {
+		return "", err
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	token := Token{
+		Hash:           hashSecret(secret),
+		Kind:           TokenKindLINK,
+		UserName:       identity.UserName,
+		CreationDate:   now,
+		ExpirationDate: now.Add(identityLinkDuration),
+	}
+	if err := app.repository.StoreToken(token); err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+//getLinkToken returns the valid link token of the given secret
+func getLinkToken(repo Repository, secret string) (Token, error) {
+	token, err := repo.GetToken(hashSecret(secret))
+	if _, notFound := err.(NotInDatastoreError); notFound || (err == nil && (token.Kind != TokenKindLINK || token.IsExpired(time.Now()))) {
+		return Token{}, DataError{"link", "unknown or expired, please start again"}
+	}
+	if err != nil {
+		return Token{}, err
+	}
+	return token, nil
+}
+
+//IdentityLinkUser returns the name of the user to which the identity link of the given secret is linking
+func (app App) IdentityLinkUser(secret string) (string, error) {
+	token, err := getLinkToken(app.repository, secret)
+	if err != nil {
+		return "", err
+	}
+	return token.UserName, nil
+}
+
+//LinkIdentity links the current identity to the user that started the link with NewIdentityLink.
+//The current identity must not be linked to a user yet.
+func (app App) LinkIdentity(secret string) error {
+	if app.accessToken != nil {
+		return NotAuthorizedError{"Link identity"}
+	}
+
+	identity, err := app.CurrentIdentity()
+	if err != nil && err != ErrFirstUserConnection {
+		return err
+	}
+	if len(identity.Identity) == 0 {
+		return NotAuthorizedError{"Link identity"}
+	}
+	if len(identity.UserName) > 0 {
+		return DataError{"identity", "already linked to user " + identity.UserName}
+	}
+
+	return app.repository.RunInTransaction(func(repo Repository) error {
+		token, err := getLinkToken(repo, secret)
+		if err != nil {
+			return err
+		}
+
+		if err := repo.StoreIdentity(Identity{identity.Ident, token.UserName}); err != nil {
+			return err
+		}
+		return repo.DeleteToken(token.Hash)
+	})
+}
+
+//UnlinkIdentity removes an identity of the current user.
+//The last identity of a user cannot be removed.
+func (app App) UnlinkIdentity(ident Ident) error {
+	identity, err := app.sessionIdentity("Unlink identity")
+	if err != nil {
+		return err
+	}
+
+	return app.repository.RunInTransaction(func(repo Repository) error {
+		identities, err := repo.GetIdentities(identity.UserName)
+		if err != nil {
+			return err
+		}
+
+		for _, i := range identities {
+			if i.Ident != ident {
+				continue
+			}
+			if len(identities) == 1 {
+				return DataError{"identity", "the last identity of a user cannot be removed"}
+			}
+			return repo.DeleteIdentity(i)
+		}
+		return NotInDatastoreError{"Identity", ident.Identity}
+	})
+}
@@ -0,0 +1,144 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+
+	"github.com/okinotes/okinotes"
+	"github.com/okinotes/okinotes/local"
+)
+
+func TestLinkIdentity(t *testing.T) {
+	newApp := newTestSite(t).IdentityApp
+
+	google := okinotes.Ident{"Google", "1234"}
+	github := okinotes.Ident{"github", "5678"}
+
+	if err := newApp(google).CreateUser(google, "user01"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newApp(google).UnlinkIdentity(google); err == nil {
+		t.Errorf("UnlinkIdentity of the last identity succeeded")
+	}
+
+	secret, err := newApp(google).NewIdentityLink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := newApp(github).LinkIdentity("wrong secret"); err == nil {
+		t.Errorf("LinkIdentity with a wrong secret succeeded")
+	}
+	if err := newApp(github).LinkIdentity(secret); err != nil {
+		t.Fatal(err)
+	}
+	if err := newApp(github).LinkIdentity(secret); err == nil {
+		t.Errorf("LinkIdentity succeeded twice with the same secret")
+	}
+
+	if userName := newApp(github).CurrentUserName(); userName != "user01" {
+		t.Errorf("CurrentUserName with linked identity = %s, wanted user01", userName)
+	}
+	identities, err := newApp(github).Identities()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 2 {
+		t.Errorf("Identities = %v, wanted 2 identities", identities)
+	}
+
+	if err := newApp(github).UnlinkIdentity(google); err != nil {
+		t.Fatal(err)
+	}
+	if identities, _ := newApp(github).Identities(); len(identities) != 1 || identities[0].Ident != github {
+		t.Errorf("Identities after UnlinkIdentity = %v", identities)
+	}
+}
+
+func TestAccessToken(t *testing.T) {
+	site := newTestSite(t)
+	site.Admin = true
+	google := okinotes.Ident{"Google", "1234"}
+	f := local.AppFactory{
+		Repository:     site.Repository,
+		UserInteractor: func(r *http.Request) okinotes.UserInteractor { return local.SingleUser{} },
+		LogInteractor:  local.NewLogger(local.LevelCritical),
+	}
+	owner := site.IdentityApp(google)
+
+	if err := owner.CreateUser(google, "user01"); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.CreatePage(okinotes.Page{Name: "page01"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := owner.CreateAccessToken("script", nil, time.Time{}); err == nil {
+		t.Errorf("CreateAccessToken without scope succeeded")
+	}
+	secret, token, err := owner.CreateAccessToken("script", []okinotes.Scope{okinotes.ScopeREAD, okinotes.ScopeWRITEITEMS}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//The administrators keep their session, but not their rights, when they use a token
+	app, err := site.App("").WithAccessToken(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userName := app.CurrentUserName(); userName != "user01" {
+		t.Errorf("CurrentUserName with access token = %s, wanted user01", userName)
+	}
+	if app.CurrentUserIsAdmin() {
+		t.Errorf("CurrentUserIsAdmin with access token of an administrator")
+	}
+	if _, err := app.CreateItem("user01", "page01", okinotes.Item{Content: "item"}); err != nil {
+		t.Errorf("CreateItem with scope write:items: %v", err)
+	}
+	if err := app.DeletePage("user01", "page01"); err == nil {
+		t.Errorf("DeletePage without scope admin:pages succeeded")
+	}
+	if _, _, err := app.CreateAccessToken("other", []okinotes.Scope{okinotes.ScopeADMINPAGES}, time.Time{}); err == nil {
+		t.Errorf("CreateAccessToken with an access token succeeded")
+	}
+	if err := app.StoreTemplate(okinotes.Template{ID: "template01"}); err == nil {
+		t.Errorf("StoreTemplate with an access token succeeded")
+	}
+
+	//API requests
+	m := mux.NewRouter()
+	if err := okinotes.RegisterAPIOnRouter(m, f); err != nil {
+		t.Fatal(err)
+	}
+	get := func(authorization string) int {
+		r := httptest.NewRequest("GET", "/users/user01/pages/page01/items", nil)
+		if len(authorization) > 0 {
+			r.Header.Set("Authorization", authorization)
+		}
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, r)
+		return w.Code
+	}
+	if code := get("Bearer " + secret); code != http.StatusOK {
+		t.Errorf("GET items with access token: status %d", code)
+	}
+	if code := get("Bearer wrong"); code != http.StatusUnauthorized {
+		t.Errorf("GET items with wrong access token: status %d", code)
+	}
+
+	if err := owner.RevokeAccessToken(token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if code := get("Bearer " + secret); code != http.StatusUnauthorized {
+		t.Errorf("GET items with revoked access token: status %d", code)
+	}
+	if tokens, err := owner.AccessTokens(); err != nil || len(tokens) != 0 {
+		t.Errorf("AccessTokens after revocation = %v, %v", tokens, err)
+	}
+}
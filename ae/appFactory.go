@@ -24,7 +24,7 @@ func (f AppFactory) CreateApp(r *http.Request) (okinotes.App, error) {
 	logInteractor := c
 	uploadInteractor := uploadInteractor{c}
 
-	app := okinotes.NewApp(repository, userInteractor, logInteractor, uploadInteractor)
+	app := okinotes.NewApp(repository, userInteractor, logInteractor, uploadInteractor).WithLinkInteractor(linkInteractor{c}).WithLinkFetcher(linkInteractor{c})
 
 	return app, nil
 }
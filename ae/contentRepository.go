@@ -5,7 +5,8 @@
 package ae
 
 import (
-	//"time"
+	"strconv"
+	"time"
 
 	"appengine"
 	"appengine/datastore"
@@ -26,6 +27,15 @@ func pageKey(c appengine.Context, userName, pageName string) *datastore.Key {
 func itemKey(c appengine.Context, userName, pageName, itemID string) *datastore.Key {
 	return datastore.NewKey(c, "Item", itemID, 0, pageKey(c, userName, pageName))
 }
+func revisionKey(c appengine.Context, userName, pageName, itemID string, number int) *datastore.Key {
+	return datastore.NewKey(c, "Revision", "", int64(number), itemKey(c, userName, pageName, itemID))
+}
+func trashEntryKey(c appengine.Context, userName, entryID string) *datastore.Key {
+	return datastore.NewKey(c, "TrashEntry", entryID, 0, userKey(c, userName))
+}
+func searchDocumentKey(c appengine.Context, userName, pageName, itemID string) *datastore.Key {
+	return datastore.NewKey(c, "SearchDocument", itemID, 0, pageKey(c, userName, pageName))
+}
 func templateKey(c appengine.Context, templateID string) *datastore.Key {
 	return datastore.NewKey(c, "Template", templateID, 0, nil)
 }
@@ -42,7 +52,7 @@ func (repo repository) RunInTransaction(f func(repo okinotes.Repository) error)
 		txRepo := repository{c}
 
 		return f(txRepo)
-	}, nil)
+	}, &datastore.TransactionOptions{XG: true}) //Users, identities and tokens are distinct entity groups
 
 	return err
 }
@@ -69,9 +79,10 @@ func (repo repository) DeletePage(userName, pageName string) error {
 }
 
 type aePageQuery struct {
-	c     appengine.Context
-	q     *datastore.Query
-	limit int
+	c      appengine.Context
+	q      *datastore.Query
+	limit  int
+	cursor string
 }
 
 func (repo repository) NewPageQuery() okinotes.PageQuery {
@@ -79,6 +90,7 @@ func (repo repository) NewPageQuery() okinotes.PageQuery {
 		repo.c,
 		datastore.NewQuery("Page"),
 		-1,
+		"",
 	}
 }
 func (q *aePageQuery) User(userName string) okinotes.PageQuery {
@@ -94,33 +106,92 @@ func (q *aePageQuery) Order(fieldName string) okinotes.PageQuery {
 	return q
 }
 func (q *aePageQuery) Limit(limit int) okinotes.PageQuery {
-	q.q = q.q.Limit(limit + 1)
 	q.limit = limit
 	return q
 }
+func (q *aePageQuery) Start(cursor string) okinotes.PageQuery {
+	q.cursor = cursor
+	return q
+}
 
-func (q *aePageQuery) GetAll() ([]okinotes.Page, bool, error) { //TODO: use cursor string instead of bool
+func (q *aePageQuery) GetAll() ([]okinotes.Page, string, error) {
 	var pages []okinotes.Page
+	cursor, err := getAllWithCursor(q.c, q.q, q.limit, q.cursor, func(t *datastore.Iterator) error {
+		var p okinotes.Page
+		if _, err := t.Next(&p); err != nil {
+			return err
+		}
+		pages = append(pages, p)
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return pages, cursor, nil
+}
 
-	_, err := q.q.GetAll(q.c, &pages)
-	if err != nil && err != datastore.ErrNoSuchEntity {
-		return nil, false, err
+//getAllWithCursor runs a query from a cursor, reading at most limit entities with next.
+//Returns the cursor of the following entities, or an empty string if there are no more.
+func getAllWithCursor(c appengine.Context, q *datastore.Query, limit int, cursor string, next func(t *datastore.Iterator) error) (string, error) {
+	if len(cursor) > 0 {
+		start, err := datastore.DecodeCursor(cursor)
+		if err != nil {
+			return "", okinotes.DataError{"cursor", "invalid cursor"}
+		}
+		q = q.Start(start)
 	}
-	if q.limit > 0 && len(pages) > q.limit {
-		return pages[:q.limit], true, nil
+	if limit >= 0 {
+		//Limit is set to requested value +1 in order to know if there is more data available
+		q = q.Limit(limit + 1)
 	}
-	return pages, false, nil
-}
 
-func (repo repository) GetItemsFromPage(userName, pageName string, limit int) ([]okinotes.Item, error) {
-	var items []okinotes.Item
+	t := q.Run(c)
+	for n := 0; limit < 0 || n < limit; n++ {
+		err := next(t)
+		if err == datastore.Done {
+			return "", nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
 
-	_, err := datastore.NewQuery("Item").Ancestor(pageKey(repo.c, userName, pageName)).Order("-LastModificationDate").Limit(limit).GetAll(repo.c, &items)
-	if err != nil && err != datastore.ErrNoSuchEntity {
-		return nil, err
+	end, err := t.Cursor()
+	if err != nil {
+		return "", err
+	}
+	//Check if there is more data available
+	if _, err := t.Next(nil); err == datastore.Done {
+		return "", nil
+	} else if err != nil {
+		return "", err
 	}
+	return end.String(), nil
+}
 
-	return items, nil
+func (repo repository) GetItemsFromPage(userName, pageName string, order okinotes.ItemOrder, limit int, cursor string) ([]okinotes.Item, string, error) {
+	var items []okinotes.Item
+	q := datastore.NewQuery("Item").Ancestor(pageKey(repo.c, userName, pageName))
+	switch order {
+	case okinotes.ItemOrderMANUAL:
+		q = q.Order("Position").Order("-LastModificationDate")
+	case okinotes.ItemOrderCREATED:
+		q = q.Order("-CreationDate")
+	default:
+		q = q.Order("-LastModificationDate")
+	}
+	next, err := getAllWithCursor(repo.c, q, limit, cursor, func(t *datastore.Iterator) error {
+		var i okinotes.Item
+		if _, err := t.Next(&i); err != nil {
+			return err
+		}
+		items = append(items, i)
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return items, next, nil
 }
 func (repo repository) FindItem(userName string, pageName string, itemID string) (bool, error) {
 
@@ -165,6 +236,123 @@ func (repo repository) DeleteItemsFromPage(userName, pageName string) error {
 	return datastore.DeleteMulti(repo.c, keys)
 }
 
+func (repo repository) GetRevisions(userName, pageName, itemID string) ([]okinotes.Revision, error) {
+	var revisions []okinotes.Revision
+	_, err := datastore.NewQuery("Revision").Ancestor(itemKey(repo.c, userName, pageName, itemID)).Order("Number").GetAll(repo.c, &revisions)
+	if err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+func (repo repository) GetRevision(userName, pageName, itemID string, number int) (okinotes.Revision, error) {
+	var r okinotes.Revision
+	err := datastore.Get(repo.c, revisionKey(repo.c, userName, pageName, itemID, number), &r)
+	if err == datastore.ErrNoSuchEntity {
+		return okinotes.Revision{}, okinotes.NotInDatastoreError{"Revision", strconv.Itoa(number)}
+	}
+	if err != nil {
+		return okinotes.Revision{}, err
+	}
+
+	return r, nil
+}
+func (repo repository) StoreRevision(userName, pageName string, r okinotes.Revision) error {
+	_, err := datastore.Put(repo.c, revisionKey(repo.c, userName, pageName, r.ItemID, r.Number), &r)
+	return err
+}
+func (repo repository) DeleteRevisions(userName, pageName, itemID string) error {
+	keys, err := datastore.NewQuery("Revision").Ancestor(itemKey(repo.c, userName, pageName, itemID)).KeysOnly().GetAll(repo.c, nil)
+	if err != nil {
+		return err
+	}
+
+	return datastore.DeleteMulti(repo.c, keys)
+}
+func (repo repository) DeleteRevisionsFromPage(userName, pageName string) error {
+	keys, err := datastore.NewQuery("Revision").Ancestor(pageKey(repo.c, userName, pageName)).KeysOnly().GetAll(repo.c, nil)
+	if err != nil {
+		return err
+	}
+
+	return datastore.DeleteMulti(repo.c, keys)
+}
+
+func (repo repository) GetTrash(userName string) ([]okinotes.TrashEntry, error) {
+	var entries []okinotes.TrashEntry
+	_, err := datastore.NewQuery("TrashEntry").Ancestor(userKey(repo.c, userName)).Order("-DeletionDate").GetAll(repo.c, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+func (repo repository) GetTrashEntry(userName, entryID string) (okinotes.TrashEntry, error) {
+	var e okinotes.TrashEntry
+	err := datastore.Get(repo.c, trashEntryKey(repo.c, userName, entryID), &e)
+	if err == datastore.ErrNoSuchEntity {
+		return okinotes.TrashEntry{}, okinotes.NotInDatastoreError{"Trash entry", entryID}
+	}
+	if err != nil {
+		return okinotes.TrashEntry{}, err
+	}
+
+	return e, nil
+}
+func (repo repository) GetExpiredTrash(before time.Time) ([]okinotes.TrashEntry, error) {
+	var entries []okinotes.TrashEntry
+	_, err := datastore.NewQuery("TrashEntry").Filter("DeletionDate <", before).Order("-DeletionDate").GetAll(repo.c, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+func (repo repository) StoreTrashEntry(e okinotes.TrashEntry) error {
+	_, err := datastore.Put(repo.c, trashEntryKey(repo.c, e.UserName, e.ID), &e)
+	return err
+}
+func (repo repository) DeleteTrashEntry(userName, entryID string) error {
+	return datastore.Delete(repo.c, trashEntryKey(repo.c, userName, entryID))
+}
+
+func (repo repository) StoreSearchDocument(d okinotes.SearchDocument) error {
+	_, err := datastore.Put(repo.c, searchDocumentKey(repo.c, d.UserName, d.PageName, d.ItemID), &d)
+	return err
+}
+func (repo repository) DeleteSearchDocument(userName, pageName, itemID string) error {
+	return datastore.Delete(repo.c, searchDocumentKey(repo.c, userName, pageName, itemID))
+}
+func (repo repository) DeleteSearchDocumentsFromPage(userName, pageName string) error {
+	keys, err := datastore.NewQuery("SearchDocument").Ancestor(pageKey(repo.c, userName, pageName)).KeysOnly().GetAll(repo.c, nil)
+	if err != nil {
+		return err
+	}
+
+	return datastore.DeleteMulti(repo.c, keys)
+}
+func (repo repository) FindSearchDocuments(terms []string, limit int, cursor string) ([]okinotes.SearchDocument, string, error) {
+	//Equality filters on the multi-valued Terms property are merged by the datastore
+	q := datastore.NewQuery("SearchDocument")
+	for _, t := range terms {
+		q = q.Filter("Terms =", t)
+	}
+
+	var documents []okinotes.SearchDocument
+	next, err := getAllWithCursor(repo.c, q, limit, cursor, func(t *datastore.Iterator) error {
+		var d okinotes.SearchDocument
+		if _, err := t.Next(&d); err != nil {
+			return err
+		}
+		documents = append(documents, d)
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	return documents, next, nil
+}
+
 func (repo repository) FindUser(userName string) (bool, error) {
 
 	user := okinotes.User{}
@@ -199,6 +387,18 @@ func (repo repository) GetUser(ident okinotes.Ident) (okinotes.User, error) {
 
 	return user, nil
 }
+func (repo repository) GetUserByName(userName string) (okinotes.User, error) {
+	user := okinotes.User{}
+	err := datastore.Get(repo.c, userKey(repo.c, userName), &user)
+	if err == datastore.ErrNoSuchEntity {
+		return okinotes.User{}, okinotes.NotInDatastoreError{"User", userName}
+	}
+	if err != nil {
+		return okinotes.User{}, err
+	}
+
+	return user, nil
+}
 func (repo repository) GetIdentity(ident okinotes.Ident) (okinotes.Identity, error) {
 
 	var identities []okinotes.Identity
@@ -224,6 +424,121 @@ func (repo repository) StoreIdentity(identity okinotes.Identity) error {
 
 }
 
+func (repo repository) GetIdentities(userName string) ([]okinotes.Identity, error) {
+	var identities []okinotes.Identity
+	_, err := datastore.NewQuery("Identity").Ancestor(userKey(repo.c, userName)).GetAll(repo.c, &identities)
+	if err != nil {
+		return nil, err
+	}
+
+	return identities, nil
+}
+func (repo repository) DeleteIdentity(identity okinotes.Identity) error {
+	keys, err := datastore.NewQuery("Identity").Ancestor(userKey(repo.c, identity.UserName)).Filter("Provider =", identity.Provider).Filter("Identity =", identity.Identity).KeysOnly().GetAll(repo.c, nil)
+	if err != nil {
+		return err
+	}
+
+	return datastore.DeleteMulti(repo.c, keys)
+}
+
+//Memberships are stored in the entity group of their organisation
+func membershipKey(c appengine.Context, orgName, userName string) *datastore.Key {
+	return datastore.NewKey(c, "Membership", userName, 0, userKey(c, orgName))
+}
+
+func (repo repository) GetMembership(orgName string, userName string) (okinotes.Membership, error) {
+	membership := okinotes.Membership{}
+	err := datastore.Get(repo.c, membershipKey(repo.c, orgName, userName), &membership)
+	if err == datastore.ErrNoSuchEntity {
+		return okinotes.Membership{}, okinotes.NotInDatastoreError{"Membership", userName}
+	}
+	if err != nil {
+		return okinotes.Membership{}, err
+	}
+
+	return membership, nil
+}
+func (repo repository) GetMembers(orgName string) ([]okinotes.Membership, error) {
+	var memberships []okinotes.Membership
+	_, err := datastore.NewQuery("Membership").Ancestor(userKey(repo.c, orgName)).Order("UserName").GetAll(repo.c, &memberships)
+	if err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+func (repo repository) GetMemberships(userName string) ([]okinotes.Membership, error) {
+	var memberships []okinotes.Membership
+	_, err := datastore.NewQuery("Membership").Filter("UserName =", userName).Order("OrgName").GetAll(repo.c, &memberships)
+	if err != nil {
+		return nil, err
+	}
+
+	return memberships, nil
+}
+func (repo repository) StoreMembership(membership okinotes.Membership) error {
+	_, err := datastore.Put(repo.c, membershipKey(repo.c, membership.OrgName, membership.UserName), &membership)
+	return err
+}
+func (repo repository) DeleteMembership(orgName string, userName string) error {
+	return datastore.Delete(repo.c, membershipKey(repo.c, orgName, userName))
+}
+
+func credentialsKey(c appengine.Context, login string) *datastore.Key {
+	return datastore.NewKey(c, "Credentials", login, 0, nil)
+}
+
+func (repo repository) GetCredentials(login string) (okinotes.Credentials, error) {
+	credentials := okinotes.Credentials{}
+	err := datastore.Get(repo.c, credentialsKey(repo.c, login), &credentials)
+	if err == datastore.ErrNoSuchEntity {
+		return okinotes.Credentials{}, okinotes.NotInDatastoreError{"Credentials", login}
+	}
+	if err != nil {
+		return okinotes.Credentials{}, err
+	}
+
+	return credentials, nil
+}
+func (repo repository) StoreCredentials(credentials okinotes.Credentials) error {
+	_, err := datastore.Put(repo.c, credentialsKey(repo.c, credentials.Login), &credentials)
+	return err
+}
+
+func tokenKey(c appengine.Context, hash string) *datastore.Key {
+	return datastore.NewKey(c, "Token", hash, 0, nil)
+}
+
+func (repo repository) GetToken(hash string) (okinotes.Token, error) {
+	token := okinotes.Token{}
+	err := datastore.Get(repo.c, tokenKey(repo.c, hash), &token)
+	if err == datastore.ErrNoSuchEntity {
+		return okinotes.Token{}, okinotes.NotInDatastoreError{"Token", hash}
+	}
+	if err != nil {
+		return okinotes.Token{}, err
+	}
+
+	return token, nil
+}
+func (repo repository) GetTokens(userName string, kind okinotes.TokenKind) ([]okinotes.Token, error) {
+	var tokens []okinotes.Token
+	_, err := datastore.NewQuery("Token").Filter("UserName =", userName).Filter("Kind =", string(kind)).Order("CreationDate").GetAll(repo.c, &tokens)
+	if err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+func (repo repository) StoreToken(token okinotes.Token) error {
+	_, err := datastore.Put(repo.c, tokenKey(repo.c, token.Hash), &token)
+	return err
+}
+func (repo repository) DeleteToken(hash string) error {
+	return datastore.Delete(repo.c, tokenKey(repo.c, hash))
+}
+
 func (repo repository) StoreImage(img okinotes.UploadInfo, userName string) error {
 	_, err := datastore.Put(repo.c, imageKey(repo.c, userName, img.Key), &img)
 	return err
@@ -0,0 +1,39 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package ae
+
+import (
+	"net/http"
+	"time"
+
+	"appengine"
+	"appengine/urlfetch"
+
+	"github.com/okinotes/okinotes"
+)
+
+//linkInteractor fetches the linked pages with the URL Fetch service, which
+//cannot reach the internal network of the application
+type linkInteractor struct {
+	c appengine.Context
+}
+
+//Preview fetches the title, description and image of a linked page
+func (i linkInteractor) Preview(url string) (okinotes.LinkPreview, error) {
+	return okinotes.FetchLinkPreview(i.client(), url)
+}
+
+//Fetch downloads a linked page, or one of its assets, to take a snapshot
+func (i linkInteractor) Fetch(url string, maxBytes int, deadline time.Time) (okinotes.WebDocument, error) {
+	return okinotes.FetchDocument(i.client(), url, maxBytes, deadline)
+}
+
+//client returns an HTTP client using the URL Fetch service, with the timeout of the previews
+func (i linkInteractor) client() *http.Client {
+	return &http.Client{
+		Transport: &urlfetch.Transport{Context: i.c, Deadline: okinotes.PreviewTimeout},
+		Timeout:   okinotes.PreviewTimeout,
+	}
+}
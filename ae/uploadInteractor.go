@@ -6,7 +6,10 @@ package ae
 
 import (
 	"errors"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"time"
 
 	"appengine"
 	"appengine/blobstore"
@@ -58,3 +61,38 @@ func (i uploadInteractor) Delete(key string) error {
 
 	return blobstore.Delete(i.c, appengine.BlobKey(key))
 }
+
+//Open reads the content of an uploaded blob
+func (i uploadInteractor) Open(key string) (io.ReadCloser, error) {
+	if _, err := blobstore.Stat(i.c, appengine.BlobKey(key)); err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(blobstore.NewReader(i.c, appengine.BlobKey(key))), nil
+}
+
+//Store writes a new blob, such as a snapshot or an image restored from a takeout archive
+func (i uploadInteractor) Store(filename string, contentType string, r io.Reader) (okinotes.UploadInfo, error) {
+	w, err := blobstore.Create(i.c, contentType)
+	if err != nil {
+		return okinotes.UploadInfo{}, err
+	}
+	size, err := io.Copy(w, r)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return okinotes.UploadInfo{}, err
+	}
+	key, err := w.Key()
+	if err != nil {
+		return okinotes.UploadInfo{}, err
+	}
+
+	return okinotes.UploadInfo{
+		Key:          string(key),
+		ContentType:  contentType,
+		CreationTime: time.Now(),
+		Filename:     filename,
+		Size:         size,
+	}, nil
+}
@@ -31,6 +31,11 @@ type App struct {
 	userInteractor   UserInteractor
 	logInteractor    LogInteractor
 	uploadInteractor UploadInteractor
+	linkInteractor   LinkInteractor //Optional, see WithLinkInteractor
+	linkFetcher      LinkFetcher    //Optional, see WithLinkFetcher
+
+	accessToken *Token //Set when authenticated with a personal access token
+	shareSecret string //Secret of the share link used to read an unlisted page
 }
 
 //NewApp creates a new App using the given services
@@ -55,11 +60,18 @@ func (app App) CurrentUserName() string {
 
 //CurrentUserIsAdmin returns true if the current user is an administrator
 func (app App) CurrentUserIsAdmin() bool {
+	if app.accessToken != nil {
+		return false //Administration is never delegated to access tokens
+	}
 	return app.userInteractor.CurrentUserIsAdmin()
 }
 
 //CurrentUser returns the current user
 func (app App) CurrentUser() (User, error) {
+	if app.accessToken != nil {
+		return app.repository.GetUserByName(app.accessToken.UserName)
+	}
+
 	ident, err := app.userInteractor.CurrentIdentity()
 	if err != nil {
 		app.logInteractor.Infof("CurrentUser error: %v", err)
@@ -79,6 +91,10 @@ func (app App) CurrentUser() (User, error) {
 
 //CurrentIdentity returns the Identity used to logged in.
 func (app App) CurrentIdentity() (Identity, error) {
+	if app.accessToken != nil {
+		return Identity{Ident{AccessTokenProvider, app.accessToken.ID}, app.accessToken.UserName}, nil
+	}
+
 	ident, err := app.userInteractor.CurrentIdentity()
 	if err != nil {
 		app.logInteractor.Infof("CurrentIdentity error: %v", err)
@@ -157,6 +173,10 @@ func (app App) CreateUser(ident Ident, userName string) error {
 //GetPage retrieve an existing single page
 func (app App) GetPage(userName string, pageName string) (Page, error) {
 
+	if err := app.checkScope(ScopeREAD, "Read page"); err != nil {
+		return Page{}, err
+	}
+
 	//Read the page in the datastore
 	page, err := app.repository.GetPage(userName, pageName)
 	if err != nil {
@@ -164,61 +184,90 @@ func (app App) GetPage(userName string, pageName string) (Page, error) {
 		return Page{}, err
 	}
 
-	if page.Policy == PolicyPRIVATE && app.CurrentUserName() != userName {
-		app.logInteractor.Infof("Not authorized to read the page")
-		return Page{}, NotAuthorizedError{"Read page"}
+	if page.Policy == PolicyPRIVATE || page.Policy == PolicyUNLISTED {
+		err := app.checkRole(userName, pageName, RoleVIEWER, "Read page")
+		if _, notAuthorized := err.(NotAuthorizedError); notAuthorized && page.Policy == PolicyUNLISTED {
+			var shared bool
+			shared, err = app.checkShareSecret(page)
+			if err == nil && !shared {
+				err = NotAuthorizedError{"Read page"}
+			}
+		}
+		if err != nil {
+			app.logInteractor.Infof("Not authorized to read the page")
+			return Page{}, err
+		}
 	}
 
 	return page, nil
 }
 
-//ListPublicPages returns the list of public pages for a given user
-func (app App) ListPublicPages(limit int) ([]Page, bool, error) {
+//ListPublicPages returns the list of public pages, the most recently modified first.
+//The cursor returned when more pages are available allows reading the next ones.
+func (app App) ListPublicPages(limit int, cursor string) ([]Page, string, error) {
 
 	//Get the list of public pages
-	pages, bMore, err := app.repository.NewPageQuery().Filter("Policy =", PolicyPUBLIC).Order("-LastModificationDate").Limit(limit).GetAll()
+	pages, next, err := app.repository.NewPageQuery().Filter("Policy =", PolicyPUBLIC).Order("-LastModificationDate").Limit(limit).Start(cursor).GetAll()
 	if err != nil {
 		app.logInteractor.Errorf("ListPublicPages failed in GetAll(PUBLIC): %v", err)
-		return nil, false, err
+		return nil, "", err
 	}
 
-	return pages, bMore, nil
+	return pages, next, nil
 }
 
-//ListOwnedPages returns the list of pages owned by a given user
-func (app App) ListOwnedPages(limit int) ([]Page, bool, error) {
+//ListOwnedPages returns the list of pages owned by the current user, the most recently modified first.
+//The cursor returned when more pages are available allows reading the next ones.
+func (app App) ListOwnedPages(limit int, cursor string) ([]Page, string, error) {
+
+	if err := app.checkScope(ScopeREAD, "List pages"); err != nil {
+		return nil, "", err
+	}
 
 	user, err := app.CurrentUser()
 	if err != nil {
 		app.logInteractor.Errorf("ListOwnedPages failed in CurrentUser: %v", err)
-		return nil, false, err
+		return nil, "", err
 	}
 
 	//Get the list of public pages
-	pages, bMore, err := app.repository.NewPageQuery().Filter("UserName=", user.Name).Order("-LastModificationDate").Limit(limit).GetAll()
+	pages, next, err := app.repository.NewPageQuery().Filter("UserName=", user.Name).Order("-LastModificationDate").Limit(limit).Start(cursor).GetAll()
 	if err != nil {
 		app.logInteractor.Errorf("ListOwnedPages failed in GetAll: %v", err)
-		return nil, false, err
+		return nil, "", err
 	}
 
-	return pages, bMore, nil
+	return pages, next, nil
 }
 
 //CreatePage create a new page for the given user
 //Returns the id of the stored page.
 func (app App) CreatePage(page Page) error {
-	currentUserName := app.CurrentUserName()
-	//We can only update owned pages
-	if len(currentUserName) == 0 {
-		return NotAuthorizedError{"Update page"}
+	if err := app.checkScope(ScopeADMINPAGES, "Create page"); err != nil {
+		return err
+	}
+	//Pages are created for the current user, or for one of its organisations
+	if len(page.UserName) == 0 {
+		page.UserName = app.CurrentUserName()
+		if len(page.UserName) == 0 {
+			return NotAuthorizedError{"Create page"}
+		}
+	}
+	if err := app.checkMemberRole(page.UserName, RoleEDITOR, "Create page"); err != nil {
+		return err
 	}
-	page.UserName = currentUserName
 	page.LastModificationDate = time.Now()
 	page.CreationDate = page.LastModificationDate
 
 	if len(page.Policy) == 0 {
 		page.Policy = PolicyPRIVATE
 	}
+	if len(page.ItemOrder) == 0 {
+		page.ItemOrder = ItemOrderMODIFIED
+	}
+	if err := checkItemOrder(page.ItemOrder); err != nil {
+		return err
+	}
 
 	err := app.repository.RunInTransaction(func(repo Repository) error {
 		//Check for existence of user/page
@@ -230,6 +279,15 @@ func (app App) CreatePage(page Page) error {
 			return err
 		}
 
+		//The items of a deleted page are kept until it is purged
+		_, trashed, err := findTrashedPage(repo, page.UserName, page.Name)
+		if err != nil {
+			return err
+		}
+		if trashed {
+			return DataError{"page name", "a deleted page with this name is in the trash"}
+		}
+
 		//Store
 		return repo.StorePage(page)
 	})
@@ -240,10 +298,15 @@ func (app App) CreatePage(page Page) error {
 //UpdatePage updates a given page.
 //The description of tags in the current template must be provided.
 func (app App) UpdatePage(page Page, pageTags TagDescriptionList) error {
-	currentUserName := app.CurrentUserName()
-	//We can only update owned pages
-	if len(currentUserName) == 0 || currentUserName != page.UserName {
-		return NotAuthorizedError{"Update page"}
+	if err := app.checkScope(ScopeADMINPAGES, "Update page"); err != nil {
+		return err
+	}
+	if err := app.checkRole(page.UserName, page.Name, RoleOWNER, "Update page"); err != nil {
+		return err
+	}
+
+	if err := checkItemOrder(page.ItemOrder); err != nil {
+		return err
 	}
 
 	page.LastModificationDate = time.Now()
@@ -257,6 +320,13 @@ func (app App) UpdatePage(page Page, pageTags TagDescriptionList) error {
 
 		page.CreationDate = oldPage.CreationDate
 
+		//Items keep the order they had when the page becomes ordered manually
+		if page.ItemOrder == ItemOrderMANUAL && oldPage.ItemOrder != ItemOrderMANUAL {
+			if _, err := positionItems(repo, page.UserName, page.Name, oldPage.ItemOrder); err != nil {
+				return err
+			}
+		}
+
 		//Remove previous images usages
 		err = repo.DeleteUsages(page.UserName, page.Name)
 		if err != nil {
@@ -274,7 +344,15 @@ func (app App) UpdatePage(page Page, pageTags TagDescriptionList) error {
 		}
 
 		//Store
-		return repo.StorePage(page)
+		if err := repo.StorePage(page); err != nil {
+			return err
+		}
+
+		//The title of the page is indexed with each item
+		if page.Title != oldPage.Title {
+			return indexPage(repo, page)
+		}
+		return nil
 	})
 
 	return err
@@ -283,10 +361,11 @@ func (app App) UpdatePage(page Page, pageTags TagDescriptionList) error {
 
 //UpdateTemplate changes the template of page.
 func (app App) UpdateTemplate(userName string, pageName string, newTemplateID string) error {
-	currentUserName := app.CurrentUserName()
-	//We can only update owned pages
-	if len(currentUserName) == 0 || currentUserName != userName {
-		return NotAuthorizedError{"Update page"}
+	if err := app.checkScope(ScopeADMINPAGES, "Update page"); err != nil {
+		return err
+	}
+	if err := app.checkRole(userName, pageName, RoleOWNER, "Update page"); err != nil {
+		return err
 	}
 
 	tNow := time.Now()
@@ -313,38 +392,49 @@ func (app App) UpdateTemplate(userName string, pageName string, newTemplateID st
 
 }
 
-//DeletePage removes permanently a page and all the associated content (items and permissions)
+//DeletePage moves a page to the trash of its owner, hiding it with all its items.
+//The page can be restored until it is purged from the trash.
 func (app App) DeletePage(userName, pageName string) error {
-	currentUserName := app.CurrentUserName()
-	//We can only update owned pages
-	if len(currentUserName) == 0 || currentUserName != userName {
-		return NotAuthorizedError{"Delete page"}
-	}
-
-	//TODO: Use a transactional mechanism inn order to have them all succeed or all failed?
-
-	//Delete items
-	err := app.repository.DeleteItemsFromPage(userName, pageName)
-	if err != nil {
+	if err := app.checkScope(ScopeADMINPAGES, "Delete page"); err != nil {
 		return err
 	}
-
-	//Delete page
-	err = app.repository.DeletePage(userName, pageName)
-	if err != nil {
+	if err := app.checkRole(userName, pageName, RoleOWNER, "Delete page"); err != nil {
 		return err
 	}
 
-	return nil
+	return app.repository.RunInTransaction(func(repo Repository) error {
+		page, err := repo.GetPage(userName, pageName)
+		if err != nil {
+			return err
+		}
+
+		entry := TrashEntry{
+			ID:           generateID(),
+			UserName:     userName,
+			PageName:     pageName,
+			Kind:         TrashKindPAGE,
+			DeletionDate: time.Now(),
+			DeletedBy:    app.CurrentUserName(),
+			Page:         page,
+		}
+		if err := repo.StoreTrashEntry(entry); err != nil {
+			return err
+		}
+		if err := repo.DeleteSearchDocumentsFromPage(userName, pageName); err != nil {
+			return err
+		}
+		return repo.DeletePage(userName, pageName)
+	})
 }
 
 //CreateItem stores an item
 //Returns the stored item.
 func (app App) CreateItem(userName, pageName string, i Item) (Item, error) {
-	currentUserName := app.CurrentUserName()
-	//We can only update owned pages
-	if len(currentUserName) == 0 || currentUserName != userName {
-		return Item{}, NotAuthorizedError{"Store item"}
+	if err := app.checkScope(ScopeWRITEITEMS, "Store item"); err != nil {
+		return Item{}, err
+	}
+	if err := app.checkRole(userName, pageName, RoleEDITOR, "Store item"); err != nil {
+		return Item{}, err
 	}
 
 	if len(i.Content) == 0 && len(i.URL) == 0 {
@@ -354,6 +444,10 @@ func (app App) CreateItem(userName, pageName string, i Item) (Item, error) {
 	//Compute HTML from markdown
 	i.HTMLContent = template.HTML(markdownToHTML(i.Content))
 
+	//The linked page is fetched before the transaction
+	applyLinkPreview(&i, nil, app.previewLink(i, nil))
+	keepSnapshot(&i, nil)
+
 	i.CreationDate = time.Now()
 	i.LastModificationDate = i.CreationDate
 	i.ID = generateID()
@@ -372,8 +466,21 @@ func (app App) CreateItem(userName, pageName string, i Item) (Item, error) {
 			i.ID = generateID()
 		}
 
+		//New items are added at the top of the pages ordered manually
+		position, err := firstPosition(repo, userName, pageName)
+		if err != nil {
+			return err
+		}
+		i.Position = position
+
 		//Store
-		return repo.StoreItem(userName, pageName, i)
+		if err := repo.StoreItem(userName, pageName, i); err != nil {
+			return err
+		}
+		if err := app.recordRevision(repo, userName, pageName, nil, i); err != nil {
+			return err
+		}
+		return indexItem(repo, userName, pageName, i)
 	})
 	if err != nil {
 		return Item{}, err
@@ -397,16 +504,19 @@ func (app App) CreateItem(userName, pageName string, i Item) (Item, error) {
 			return Item{}, err
 		}
 	}
-	return i, nil
+
+	//The linked page is archived once the item is stored
+	return app.archiveLink(userName, pageName, i, nil), nil
 }
 
 //PutItem stores a fully defined item. Replace the item if it already exists
 //Returns the stored item.
 func (app App) PutItem(userName, pageName string, i Item) (Item, error) {
-	currentUserName := app.CurrentUserName()
-	//We can only update owned pages
-	if len(currentUserName) == 0 || currentUserName != userName {
-		return Item{}, NotAuthorizedError{"Store item"}
+	if err := app.checkScope(ScopeWRITEITEMS, "Store item"); err != nil {
+		return Item{}, err
+	}
+	if err := app.checkRole(userName, pageName, RoleEDITOR, "Store item"); err != nil {
+		return Item{}, err
 	}
 
 	if len(i.Content) == 0 && len(i.URL) == 0 {
@@ -416,10 +526,44 @@ func (app App) PutItem(userName, pageName string, i Item) (Item, error) {
 	//Compute HTML from markdown
 	i.HTMLContent = template.HTML(markdownToHTML(i.Content))
 
+	//The linked page is fetched before the transaction, if the URL changes
+	var preview *LinkPreview
+	oldItem, err := app.repository.GetItem(userName, pageName, i.ID)
+	switch err.(type) {
+	case nil:
+		preview = app.previewLink(i, &oldItem)
+	case NotInDatastoreError:
+		preview = app.previewLink(i, nil)
+	default:
+		return Item{}, err
+	}
+
 	//Stores the item
-	err := app.repository.RunInTransaction(func(repo Repository) error {
+	var previous *Item
+	err = app.repository.RunInTransaction(func(repo Repository) error {
+		previous = nil
+		oldItem, err := repo.GetItem(userName, pageName, i.ID)
+		if err == nil {
+			previous = &oldItem
+			i.Position = oldItem.Position
+		} else if _, notFound := err.(NotInDatastoreError); notFound {
+			if i.Position, err = firstPosition(repo, userName, pageName); err != nil {
+				return err
+			}
+		} else {
+			return err
+		}
+		applyLinkPreview(&i, previous, preview)
+		keepSnapshot(&i, previous)
+
 		//Store
-		return repo.StoreItem(userName, pageName, i)
+		if err := repo.StoreItem(userName, pageName, i); err != nil {
+			return err
+		}
+		if err := app.recordRevision(repo, userName, pageName, previous, i); err != nil {
+			return err
+		}
+		return indexItem(repo, userName, pageName, i)
 	})
 	if err != nil {
 		return Item{}, err
@@ -443,15 +587,18 @@ func (app App) PutItem(userName, pageName string, i Item) (Item, error) {
 			return Item{}, err
 		}
 	}
-	return i, nil
+
+	//The linked page is archived once the item is stored
+	return app.archiveLink(userName, pageName, i, previous), nil
 }
 
 //UpdateItem stores an updated item
 func (app App) UpdateItem(userName, pageName string, i Item, updateTags bool) (Item, error) {
-	currentUserName := app.CurrentUserName()
-	//We can only update owned pages
-	if len(currentUserName) == 0 || currentUserName != userName {
-		return Item{}, NotAuthorizedError{"Store item"}
+	if err := app.checkScope(ScopeWRITEITEMS, "Update item"); err != nil {
+		return Item{}, err
+	}
+	if err := app.checkRole(userName, pageName, RoleEDITOR, "Store item"); err != nil {
+		return Item{}, err
 	}
 
 	if len(i.Content) == 0 && len(i.URL) == 0 {
@@ -463,22 +610,40 @@ func (app App) UpdateItem(userName, pageName string, i Item, updateTags bool) (I
 
 	i.LastModificationDate = time.Now()
 
+	//The linked page is fetched before the transaction, if the URL changes
+	oldItem, err := app.repository.GetItem(userName, pageName, i.ID)
+	if err != nil {
+		return Item{}, err
+	}
+	preview := app.previewLink(i, &oldItem)
+
 	//Stores the item
-	err := app.repository.RunInTransaction(func(repo Repository) error {
+	var previous Item
+	err = app.repository.RunInTransaction(func(repo Repository) error {
 
 		oldItem, err := repo.GetItem(userName, pageName, i.ID)
 		if err != nil {
 			return err
 		}
+		previous = oldItem
 
 		i.CreationDate = oldItem.CreationDate
+		i.Position = oldItem.Position
 
 		if !updateTags {
 			i.Tags = oldItem.Tags
 		}
+		applyLinkPreview(&i, &oldItem, preview)
+		keepSnapshot(&i, &oldItem)
 
 		//Store
-		return repo.StoreItem(userName, pageName, i)
+		if err := repo.StoreItem(userName, pageName, i); err != nil {
+			return err
+		}
+		if err := app.recordRevision(repo, userName, pageName, &oldItem, i); err != nil {
+			return err
+		}
+		return indexItem(repo, userName, pageName, i)
 	})
 	if err != nil {
 		return Item{}, err
@@ -502,20 +667,26 @@ func (app App) UpdateItem(userName, pageName string, i Item, updateTags bool) (I
 			return Item{}, err
 		}
 	}
-	return i, nil
+
+	//The linked page is archived once the item is stored
+	return app.archiveLink(userName, pageName, i, &previous), nil
 }
 
 //SetItemTag stores a new value for an item tag
 func (app App) SetItemTag(userName, pageName string, itemID string, tagKey string, tagValue string) error {
-	currentUserName := app.CurrentUserName()
-	//We can only update owned pages
-	if len(currentUserName) == 0 || currentUserName != userName {
-		return NotAuthorizedError{"Store item"}
+	if err := app.checkScope(ScopeWRITEITEMS, "Update item"); err != nil {
+		return err
+	}
+	if err := app.checkRole(userName, pageName, RoleEDITOR, "Store item"); err != nil {
+		return err
 	}
 
 	if len(tagKey) == 0 {
 		return errors.New("Empty tag key not allowed.")
 	}
+	if tagKey == linkTagSNAPSHOT || tagKey == linkTagARCHIVED {
+		return DataError{"tag", "the snapshot tags are set by the archiver"}
+	}
 
 	tNow := time.Now()
 
@@ -527,12 +698,18 @@ func (app App) SetItemTag(userName, pageName string, itemID string, tagKey strin
 			return err
 		}
 
+		previous := oldItem
+		previous.Tags = append(TagList(nil), oldItem.Tags...)
+
 		oldItem.LastModificationDate = tNow
 
 		oldItem.Tags.SetTag(tagKey, tagValue)
 
 		//Store
-		return repo.StoreItem(userName, pageName, oldItem)
+		if err := repo.StoreItem(userName, pageName, oldItem); err != nil {
+			return err
+		}
+		return app.recordRevision(repo, userName, pageName, &previous, oldItem)
 	})
 	if err != nil {
 		return err
@@ -559,33 +736,110 @@ func (app App) SetItemTag(userName, pageName string, itemID string, tagKey strin
 	return nil
 }
 
-//DeleteItem removes permanently an item
+//DeleteItem moves an item to the trash of the owner of its page.
+//The item can be restored until it is purged from the trash.
 func (app App) DeleteItem(userName, pageName string, itemID string) error {
+	if err := app.checkScope(ScopeWRITEITEMS, "Delete item"); err != nil {
+		return err
+	}
+	if err := app.checkRole(userName, pageName, RoleEDITOR, "Delete item"); err != nil {
+		return err
+	}
+
+	return app.repository.RunInTransaction(func(repo Repository) error {
+		item, err := repo.GetItem(userName, pageName, itemID)
+		if err != nil {
+			return err
+		}
+
+		entry := TrashEntry{
+			ID:           generateID(),
+			UserName:     userName,
+			PageName:     pageName,
+			Kind:         TrashKindITEM,
+			DeletionDate: time.Now(),
+			DeletedBy:    app.CurrentUserName(),
+			Item:         item,
+		}
+		if err := repo.StoreTrashEntry(entry); err != nil {
+			return err
+		}
+		if err := repo.DeleteSearchDocument(userName, pageName, itemID); err != nil {
+			return err
+		}
+		return repo.DeleteItem(userName, pageName, itemID)
+	})
+}
+
+//memberRole returns the implicit role of the current user on the pages of userName:
+//RoleOWNER on its own pages and on the pages of the organisations it administrates,
+//RoleEDITOR on the pages of the other organisations it is member of.
+//Returns an empty role otherwise.
+func (app App) memberRole(userName string) (Role, error) {
 	currentUserName := app.CurrentUserName()
-	//We can only update owned pages
-	if len(currentUserName) == 0 || currentUserName != userName {
-		return NotAuthorizedError{"Delete page"}
+	if len(currentUserName) == 0 {
+		return "", nil
+	}
+	if currentUserName == userName {
+		return RoleOWNER, nil
 	}
 
-	//Delete item
-	err := app.repository.DeleteItem(userName, pageName, itemID)
+	membership, err := app.repository.GetMembership(userName, currentUserName)
+	if _, notFound := err.(NotInDatastoreError); notFound {
+		return "", nil
+	}
 	if err != nil {
-		return err
+		return "", err
+	}
+	if membership.IsOrgAdmin {
+		return RoleOWNER, nil
 	}
+	return RoleEDITOR, nil
+}
+
+//checkOwner verifies that the current user can administrate the pages of userName
+func (app App) checkOwner(userName string, operation string) error {
+	return app.checkMemberRole(userName, RoleOWNER, operation)
+}
 
+//checkMemberRole verifies that the current user has the required implicit role on the pages of userName
+func (app App) checkMemberRole(userName string, required Role, operation string) error {
+	role, err := app.memberRole(userName)
+	if err != nil {
+		return err
+	}
+	if !role.Allows(required) {
+		return NotAuthorizedError{operation}
+	}
 	return nil
 }
 
-//listItems get the list of items for a given page. No authorisation check (should be done before by the caller)
-func (app App) listItems(userName, pageName string, limit int) ([]Item, error) {
+//listItems get the list of items for a given page, in the order chosen for the page. No authorisation check (should be done before by the caller)
+func (app App) listItems(page Page, limit int, cursor string) ([]Item, string, error) {
 
 	//Get the items
-	items, err := app.repository.GetItemsFromPage(userName, pageName, limit)
+	items, next, err := app.repository.GetItemsFromPage(page.UserName, page.Name, page.ItemOrder, limit, cursor)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return items, nil
+	return items, next, nil
+}
+
+//ExportPage returns a page readable by the current user with all its items,
+//in the order chosen for the page
+func (app App) ExportPage(userName, pageName string) (Page, []Item, error) {
+	page, err := app.GetPage(userName, pageName)
+	if err != nil {
+		return Page{}, nil, err
+	}
+
+	items, _, err := app.listItems(page, -1, "")
+	if err != nil {
+		return Page{}, nil, err
+	}
+
+	return page, items, nil
 }
 
 //getItem retrieve a specific item. No authorisation check (should be done before by the caller)
@@ -596,7 +850,7 @@ func (app App) getItem(userName, pageName string, itemID string) (Item, error) {
 //StoreTemplate insert or update a template in database
 func (app App) StoreTemplate(tpl Template) error {
 
-	if !app.userInteractor.CurrentUserIsAdmin() {
+	if !app.CurrentUserIsAdmin() {
 		return NotAuthorizedError{"Store template"}
 	}
 
@@ -622,6 +876,9 @@ func (app App) UploadURL(destURL string) (string, error) {
 
 //StoreImage stores an uplaoded image in the datastore and associate it with the current user
 func (app App) StoreImage(r *http.Request, name string) error {
+	if err := app.checkScope(ScopeWRITEITEMS, "Store image"); err != nil {
+		return err
+	}
 	identity, err := app.CurrentIdentity()
 	if err != nil {
 		return err
@@ -637,6 +894,9 @@ func (app App) StoreImage(r *http.Request, name string) error {
 
 //RenameImage changes the name of an uploaded image
 func (app App) RenameImage(imgID string, newName string) error {
+	if err := app.checkScope(ScopeWRITEITEMS, "Rename image"); err != nil {
+		return err
+	}
 	identity, err := app.CurrentIdentity()
 	if err != nil {
 		return err
@@ -647,6 +907,9 @@ func (app App) RenameImage(imgID string, newName string) error {
 
 //DeleteImage delete an uploaded image
 func (app App) DeleteImage(imgID string) error {
+	if err := app.checkScope(ScopeWRITEITEMS, "Delete image"); err != nil {
+		return err
+	}
 	identity, err := app.CurrentIdentity()
 	if err != nil {
 		return err
@@ -657,6 +920,9 @@ func (app App) DeleteImage(imgID string) error {
 
 //Images retrieves the images associated with the current user
 func (app App) Images(limit int) ([]UploadInfo, error) {
+	if err := app.checkScope(ScopeREAD, "List images"); err != nil {
+		return nil, err
+	}
 	identity, err := app.CurrentIdentity()
 	if err != nil {
 		return nil, err
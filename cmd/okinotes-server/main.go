@@ -0,0 +1,340 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+//Command okinotes-server runs the okinotes webapp as a standalone HTTP server,
+//independently of appengine.
+//
+//Example, for a personal instance storing its data in a directory:
+//
+//	okinotes-server -addr :8080 -repository fs -fs-root ./data -auth single -user me
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/okinotes/okinotes"
+	"github.com/okinotes/okinotes/fsrepo"
+	"github.com/okinotes/okinotes/local"
+	"github.com/okinotes/okinotes/memory"
+	"github.com/okinotes/okinotes/sqlrepo"
+)
+
+var (
+	addr            = flag.String("addr", ":8080", "Address to listen on")
+	repositoryKind  = flag.String("repository", "memory", "Storage of the data: memory, sql or fs")
+	sqlDriver       = flag.String("sql-driver", "sqlite3", "Driver of the sql repository")
+	sqlDSN          = flag.String("sql-dsn", "okinotes.db", "Data source name of the sql repository")
+	fsRoot          = flag.String("fs-root", "data", "Root directory of the fs repository")
+	authKind        = flag.String("auth", "single", "Authentication of the users: single, proxy or sessions")
+	singleUser      = flag.String("user", "owner", "Identity of the user, with -auth single")
+	proxyHeader     = flag.String("proxy-header", "X-Forwarded-User", "Header holding the identity of the user, with -auth proxy")
+	proxyLogoutURL  = flag.String("proxy-logout", "", "Logout URL of the proxy, with -auth proxy")
+	sessionKeyFile  = flag.String("session-key-file", "session.key", "File holding the keys of the session cookies, created if needed, with -auth sessions")
+	secureCookie    = flag.Bool("secure-cookie", false, "Sends the session cookie only over HTTPS, with -auth sessions")
+	passwords       = flag.Bool("passwords", true, "Enables the login with a password, with -auth sessions")
+	registration    = flag.Bool("registration", false, "Allows anyone to create an account with a password, with -auth sessions")
+	createAccount   = flag.String("create-account", "", "Creates an account with this login and the password read from the standard input, then exits (-auth sessions)")
+	oidcProviders   = flag.String("oidc-providers", "", "JSON file listing the OpenID Connect providers, with -auth sessions")
+	baseURL         = flag.String("base-url", "http://localhost:8080", "External URL of the server, with -oidc-providers")
+	admins          = flag.String("admins", "", "Comma separated identities of the administrators, as provider:identity with -auth sessions (-auth single: any value makes the user an admin)")
+	uploadKind      = flag.String("upload", "none", "Storage of the uploaded images: none or disk")
+	uploadRoot      = flag.String("upload-root", "uploads", "Root directory of the uploaded files, with -upload disk")
+	maxUploadBytes  = flag.Int64("max-upload-bytes", local.DefaultMaxUploadBytes, "Maximum size of an uploaded file, with -upload disk")
+	linkPreviews    = flag.Bool("link-previews", true, "Fetches the pages linked by the items to preview them (title, description, image)")
+	snapshots       = flag.Bool("snapshots", true, "Archives a copy of the pages linked by the items, with -upload disk")
+	staticDir       = flag.String("static", "static", "Directory of the static files served under /static/")
+	resourcesDir    = flag.String("resources", "resources", "Directory of the resources (templates)")
+	logLevel        = flag.String("log-level", "info", "Minimum level of the logged messages")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, "Maximum duration of the graceful shutdown")
+	trashRetention  = flag.Duration("trash-retention", 30*24*time.Hour, "Duration after which the deleted pages and items are purged from the trash (0 keeps them forever)")
+	linkCheckAge    = flag.Duration("link-check-interval", 7*24*time.Hour, "Duration after which the links of the URL list pages are checked again (0 disables the checks)")
+)
+
+func main() {
+	flag.Parse()
+
+	level, err := local.ParseLogLevel(*logLevel)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if err := okinotes.LoadTemplates(filepath.Join(*resourcesDir, "templates", "*.tpl")); err != nil {
+		log.Fatalf("Cannot load templates: %v", err)
+	}
+
+	repository, err := openRepository()
+	if err != nil {
+		log.Fatalf("Cannot open repository: %v", err)
+	}
+	if closer, ok := repository.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	r := mux.NewRouter()
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir(*staticDir))))
+
+	userInteractor, err := userInteractorFactory(repository, r)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	f := local.AppFactory{
+		Repository:     repository,
+		UserInteractor: userInteractor,
+		LogInteractor:  local.NewLogger(level),
+	}
+	if *linkPreviews {
+		f.LinkInteractor = local.LinkPreviewer{}
+	}
+
+	switch *uploadKind {
+	case "none":
+	case "disk":
+		uploads, err := local.NewDiskUploads(*uploadRoot, "/uploads/")
+		if err != nil {
+			log.Fatalf("Cannot open uploads: %v", err)
+		}
+		uploads.MaxUploadBytes = *maxUploadBytes
+		f.UploadInteractor = uploads
+		r.PathPrefix("/uploads/").Handler(uploads)
+	default:
+		log.Fatalf("Unknown upload storage '%s'", *uploadKind)
+	}
+	if *snapshots && f.UploadInteractor != nil {
+		f.LinkFetcher = local.LinkPreviewer{}
+	}
+	if err := okinotes.RegisterAPIOnRouter(r.PathPrefix("/api").Subrouter(), f); err != nil {
+		log.Fatal(err)
+	}
+	if err := okinotes.RegisterPagesOnRouter(r, f); err != nil {
+		log.Fatal(err)
+	}
+
+	if *trashRetention > 0 {
+		uploads := f.UploadInteractor
+		if uploads == nil {
+			uploads = local.NoUploadInteractor{}
+		}
+		go purgeTrash(repository, uploads, *trashRetention, f.LogInteractor)
+	}
+	if *linkCheckAge > 0 {
+		go checkLinks(repository, local.LinkPreviewer{}, *linkCheckAge, f.LogInteractor)
+	}
+
+	srv := &http.Server{
+		Addr:    *addr,
+		Handler: r,
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+
+		log.Printf("Shutting down...")
+		ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("Shutdown: %v", err)
+		}
+	}()
+
+	log.Printf("Listening on %s", *addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		log.Fatal(err)
+	}
+	<-done
+}
+
+//trashPurgeInterval is the delay between two purges of the trash
+const trashPurgeInterval = time.Hour
+
+//purgeTrash periodically removes the pages and items deleted for longer than the retention period
+func purgeTrash(repository okinotes.Repository, uploads okinotes.UploadInteractor, retention time.Duration, logger okinotes.LogInteractor) {
+	for {
+		n, err := okinotes.PurgeTrash(repository, uploads, time.Now().Add(-retention))
+		if err != nil {
+			logger.Errorf("Cannot purge the trash: %v", err)
+		} else if n > 0 {
+			logger.Infof("Purged %d entries from the trash", n)
+		}
+		time.Sleep(trashPurgeInterval)
+	}
+}
+
+const (
+	//linkCheckInterval is the delay between two runs of the link checker
+	linkCheckInterval = time.Hour
+	//maxLinkChecks is the maximum number of links checked by a run of the link checker
+	maxLinkChecks = 500
+)
+
+//checkLinks periodically checks the links of the URL list pages not checked for longer than maxAge
+func checkLinks(repository okinotes.Repository, checker okinotes.LinkChecker, maxAge time.Duration, logger okinotes.LogInteractor) {
+	for {
+		n, err := okinotes.CheckLinks(repository, checker, time.Now().Add(-maxAge), maxLinkChecks)
+		if err != nil {
+			logger.Errorf("Cannot check the links: %v", err)
+		} else if n > 0 {
+			logger.Infof("Checked %d links", n)
+		}
+		time.Sleep(linkCheckInterval)
+	}
+}
+
+//openRepository opens the repository chosen by the command line flags
+func openRepository() (okinotes.Repository, error) {
+	switch *repositoryKind {
+	case "memory":
+		return memory.NewRepository(), nil
+	case "sql":
+		db, err := sql.Open(*sqlDriver, *sqlDSN)
+		if err != nil {
+			return nil, err
+		}
+		if *sqlDriver == "sqlite3" {
+			//sqlite does not support concurrent writers
+			db.SetMaxOpenConns(1)
+		}
+		return sqlrepo.NewRepository(db)
+	case "fs":
+		return fsrepo.NewRepository(*fsRoot)
+	}
+	return nil, fmt.Errorf("Unknown repository '%s'", *repositoryKind)
+}
+
+//userInteractorFactory returns the function creating the UserInteractor of a request,
+//as chosen by the command line flags. The pages of the authentication are registered on m.
+func userInteractorFactory(repository okinotes.Repository, m *mux.Router) (func(r *http.Request) okinotes.UserInteractor, error) {
+	var adminList []string
+	if len(*admins) > 0 {
+		adminList = strings.Split(*admins, ",")
+	}
+
+	switch *authKind {
+	case "single":
+		u := local.SingleUser{
+			Ident: okinotes.Ident{"local", *singleUser},
+			Admin: len(adminList) > 0,
+		}
+		return func(r *http.Request) okinotes.UserInteractor { return u }, nil
+	case "proxy":
+		return func(r *http.Request) okinotes.UserInteractor {
+			return local.ProxyUser{
+				Request: r,
+				Header:  *proxyHeader,
+				Admins:  adminList,
+				Logout:  *proxyLogoutURL,
+			}
+		}, nil
+	case "sessions":
+		hashKey, blockKey, err := readSessionKeys(*sessionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		sessions := local.NewSessions(repository, hashKey, blockKey)
+		sessions.SecureCookie = *secureCookie
+		for _, admin := range adminList {
+			ident := okinotes.Ident{local.AccountsProvider, admin}
+			if i := strings.Index(admin, ":"); i >= 0 {
+				ident = okinotes.Ident{admin[:i], admin[i+1:]}
+			}
+			sessions.Admins = append(sessions.Admins, ident)
+		}
+
+		if *passwords {
+			accounts := local.NewAccounts(sessions)
+			accounts.AllowRegistration = *registration
+		}
+		if len(*oidcProviders) > 0 {
+			if err := addOIDCProviders(local.NewOIDC(sessions, *baseURL), *oidcProviders); err != nil {
+				return nil, err
+			}
+		}
+		sessions.RegisterOnRouter(m)
+
+		if len(*createAccount) > 0 && sessions.Accounts != nil {
+			password, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && err != io.EOF {
+				return nil, err
+			}
+			if err := sessions.Accounts.Register(*createAccount, strings.TrimRight(password, "\r\n")); err != nil {
+				return nil, err
+			}
+			log.Printf("Account '%s' created", *createAccount)
+			os.Exit(0)
+		}
+
+		return sessions.UserInteractor, nil
+	}
+	return nil, fmt.Errorf("Unknown authentication '%s'", *authKind)
+}
+
+//addOIDCProviders adds the providers listed in a JSON file, such as:
+//
+//	[{"Name": "google", "Title": "Google", "Issuer": "https://accounts.google.com",
+//	  "ClientID": "...", "ClientSecret": "...", "Scopes": ["email"]}]
+func addOIDCProviders(o *local.OIDC, path string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var configs []local.OIDCProviderConfig
+	if err := json.Unmarshal(b, &configs); err != nil {
+		return fmt.Errorf("Invalid OIDC providers file '%s': %v", path, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	for _, config := range configs {
+		if err := o.AddProvider(ctx, config); err != nil {
+			return fmt.Errorf("Cannot add OIDC provider '%s': %v", config.Name, err)
+		}
+	}
+	return nil
+}
+
+//readSessionKeys reads the hash and block keys of the session cookies,
+//generating the file on first use
+func readSessionKeys(path string) ([]byte, []byte, error) {
+	const hashKeyLength, blockKeyLength = 64, 32
+
+	keys, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		keys = make([]byte, hashKeyLength+blockKeyLength)
+		if _, err := rand.Read(keys); err != nil {
+			return nil, nil, err
+		}
+		err = ioutil.WriteFile(path, keys, 0600)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(keys) != hashKeyLength+blockKeyLength {
+		return nil, nil, fmt.Errorf("Invalid session key file '%s'", path)
+	}
+	return keys[:hashKeyLength], keys[hashKeyLength:], nil
+}
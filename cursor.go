@@ -0,0 +1,234 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sort"
+	"strings"
+	"time"
+)
+
+//A cursor is an opaque string given back by PageQuery.GetAll, Repository.GetItemsFromPage and
+//Repository.FindSearchDocuments when more results are available. It is passed to the next call
+//to read the following results.
+//An empty cursor starts from the first result.
+//
+//The functions below allow Repository implementations without native cursors to resume
+//a listing after the last returned result, using the sort keys of this result.
+
+//ComparePages returns -1, 0 or +1 depending on whether a sorts before, with or after b
+//using the orders of a PageQuery. Ties are broken by owner and name, so that pages
+//are always listed in the same order.
+func ComparePages(orders []PageOrder, a, b Page) int {
+	for _, o := range orders {
+		if c := o.Compare(a, b); c != 0 {
+			return c
+		}
+	}
+	if c := strings.Compare(a.UserName, b.UserName); c != 0 {
+		return c
+	}
+	return strings.Compare(a.Name, b.Name)
+}
+
+//CompareItems returns -1, 0 or +1 depending on whether a is listed before, with or after b
+//by GetItemsFromPage in the given order. Ties are broken by the date of last modification
+//(for manual order), then by ID.
+func CompareItems(order ItemOrder, a, b Item) int {
+	switch order {
+	case ItemOrderMANUAL:
+		if c := strings.Compare(a.Position, b.Position); c != 0 {
+			return c
+		}
+	case ItemOrderCREATED:
+		if c := compareNewestFirst(a.CreationDate, b.CreationDate); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	}
+
+	if c := compareNewestFirst(a.LastModificationDate, b.LastModificationDate); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+//CompareSearchDocuments returns -1, 0 or +1 depending on whether a is listed before, with
+//or after b by FindSearchDocuments: the most recently modified first, ties being broken by
+//owner, page and item.
+func CompareSearchDocuments(a, b SearchDocument) int {
+	if c := compareNewestFirst(a.LastModificationDate, b.LastModificationDate); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.UserName, b.UserName); c != 0 {
+		return c
+	}
+	if c := strings.Compare(a.PageName, b.PageName); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ItemID, b.ItemID)
+}
+
+//compareNewestFirst compares two dates, the most recent first
+func compareNewestFirst(a, b time.Time) int {
+	switch {
+	case a.After(b):
+		return -1
+	case a.Before(b):
+		return 1
+	}
+	return 0
+}
+
+//NewPageCursor returns the cursor resuming a PageQuery after the given page
+func NewPageCursor(p Page) string {
+	//Tags cannot be used in a query
+	p.Tags = nil
+	return encodeCursor(p)
+}
+
+//ParsePageCursor returns the page after which a PageQuery resumes.
+//Only the fields usable in a PageQuery are set.
+func ParsePageCursor(cursor string) (Page, error) {
+	var p Page
+	err := decodeCursor(cursor, &p)
+	return p, err
+}
+
+//itemCursor holds the sort keys of an item
+type itemCursor struct {
+	ID                   string    `json:"id"`
+	CreationDate         time.Time `json:"c"`
+	LastModificationDate time.Time `json:"m"`
+	Position             string    `json:"p"`
+}
+
+//NewItemCursor returns the cursor resuming GetItemsFromPage after the given item
+func NewItemCursor(i Item) string {
+	return encodeCursor(itemCursor{i.ID, i.CreationDate, i.LastModificationDate, i.Position})
+}
+
+//ParseItemCursor returns the item after which GetItemsFromPage resumes.
+//Only the fields used by CompareItems are set.
+func ParseItemCursor(cursor string) (Item, error) {
+	var c itemCursor
+	if err := decodeCursor(cursor, &c); err != nil {
+		return Item{}, err
+	}
+	return Item{ID: c.ID, CreationDate: c.CreationDate, LastModificationDate: c.LastModificationDate, Position: c.Position}, nil
+}
+
+//searchDocumentCursor holds the sort keys of a search document
+type searchDocumentCursor struct {
+	UserName             string    `json:"u"`
+	PageName             string    `json:"p"`
+	ItemID               string    `json:"i"`
+	LastModificationDate time.Time `json:"m"`
+}
+
+//NewSearchDocumentCursor returns the cursor resuming FindSearchDocuments after the given document
+func NewSearchDocumentCursor(d SearchDocument) string {
+	return encodeCursor(searchDocumentCursor{d.UserName, d.PageName, d.ItemID, d.LastModificationDate})
+}
+
+//ParseSearchDocumentCursor returns the document after which FindSearchDocuments resumes.
+//Only the fields used by CompareSearchDocuments are set.
+func ParseSearchDocumentCursor(cursor string) (SearchDocument, error) {
+	var c searchDocumentCursor
+	if err := decodeCursor(cursor, &c); err != nil {
+		return SearchDocument{}, err
+	}
+	return SearchDocument{UserName: c.UserName, PageName: c.PageName, ItemID: c.ItemID, LastModificationDate: c.LastModificationDate}, nil
+}
+
+func encodeCursor(v interface{}) string {
+	b, _ := json.Marshal(v)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(cursor string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err == nil {
+		err = json.Unmarshal(b, v)
+	}
+	if err != nil {
+		return DataError{"cursor", "invalid cursor"}
+	}
+	return nil
+}
+
+//PaginatePages returns at most limit pages (all if limit is negative) after the cursor,
+//among pages sorted with ComparePages, and the cursor of the next pages if any.
+func PaginatePages(pages []Page, orders []PageOrder, cursor string, limit int) ([]Page, string, error) {
+	if len(cursor) > 0 {
+		after, err := ParsePageCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		start := sort.Search(len(pages), func(i int) bool {
+			return ComparePages(orders, after, pages[i]) < 0
+		})
+		pages = pages[start:]
+	}
+
+	if limit >= 0 && len(pages) > limit {
+		pages = pages[:limit]
+		if limit == 0 {
+			return pages, cursor, nil
+		}
+		return pages, NewPageCursor(pages[limit-1]), nil
+	}
+	return pages, "", nil
+}
+
+//PaginateItems returns at most limit items (all if limit is negative) after the cursor,
+//among items sorted with CompareItems in the given order, and the cursor of the next items if any.
+func PaginateItems(items []Item, order ItemOrder, cursor string, limit int) ([]Item, string, error) {
+	if len(cursor) > 0 {
+		after, err := ParseItemCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		start := sort.Search(len(items), func(i int) bool {
+			return CompareItems(order, after, items[i]) < 0
+		})
+		items = items[start:]
+	}
+
+	if limit >= 0 && len(items) > limit {
+		items = items[:limit]
+		if limit == 0 {
+			return items, cursor, nil
+		}
+		return items, NewItemCursor(items[limit-1]), nil
+	}
+	return items, "", nil
+}
+
+//PaginateSearchDocuments returns at most limit documents (all if limit is negative) after the cursor,
+//among documents sorted with CompareSearchDocuments, and the cursor of the next documents if any.
+func PaginateSearchDocuments(documents []SearchDocument, cursor string, limit int) ([]SearchDocument, string, error) {
+	if len(cursor) > 0 {
+		after, err := ParseSearchDocumentCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		start := sort.Search(len(documents), func(i int) bool {
+			return CompareSearchDocuments(after, documents[i]) < 0
+		})
+		documents = documents[start:]
+	}
+
+	if limit >= 0 && len(documents) > limit {
+		documents = documents[:limit]
+		if limit == 0 {
+			return documents, cursor, nil
+		}
+		return documents, NewSearchDocumentCursor(documents[limit-1]), nil
+	}
+	return documents, "", nil
+}
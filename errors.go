@@ -10,8 +10,8 @@ import (
 
 //DataError represents a validation error on data
 type DataError struct {
-	Field   string
-	Message string
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
 
 func (err DataError) Error() string {
@@ -0,0 +1,358 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"html"
+	"net/url"
+	"sort"
+	"time"
+
+	"golang.org/x/tools/blog/atom"
+)
+
+const (
+	//maxFeedEntries is the number of items listed by a feed
+	maxFeedEntries = 50
+	//maxFeedPages is the number of pages, the most recently modified, whose items are listed by a user or site feed
+	maxFeedPages = 20
+)
+
+//File names of a feed, in each format
+const (
+	FeedFileATOM = "atom.xml"
+	FeedFileRSS  = "rss.xml"
+	FeedFileJSON = "feed.json"
+)
+
+//Feed lists the recent items of a page, of the public pages of a user, or of all the public pages.
+//It is written in Atom, RSS 2.0 or JSON Feed 1.1; its URLs are relative to the site.
+type Feed struct {
+	ID      string //Permanent identifier
+	Title   string
+	Path    string //Directory of the files of the feed, ending with a slash
+	HTMLURL string //URL of the page presenting the same content
+	Author  string
+	Updated time.Time
+	Entries []FeedEntry
+}
+
+//FeedEntry is an item listed by a feed
+type FeedEntry struct {
+	ID          string
+	Title       string
+	URL         string //URL of the item in its page
+	ExternalURL string //URL linked by the item, if any
+	Author      string
+	ContentHTML string
+	Published   time.Time
+	Updated     time.Time
+}
+
+//PageFeed returns the feed of a page readable by the current user
+func (app App) PageFeed(userName, pageName string) (Feed, error) {
+	page, err := app.GetPage(userName, pageName)
+	if err != nil {
+		return Feed{}, err
+	}
+
+	f := Feed{
+		ID:      "okinotes:page:" + page.UserName + "/" + page.Name,
+		Title:   page.Title,
+		Path:    "/p/" + page.UserName + "/" + page.Name + "/",
+		HTMLURL: "/p/" + page.UserName + "/" + page.Name + ".html",
+		Author:  page.UserName,
+		Updated: page.LastModificationDate,
+	}
+	if len(f.Title) == 0 {
+		f.Title = page.Name
+	}
+	f.Entries, err = app.feedEntries([]Page{page})
+	return f, err
+}
+
+//UserFeed returns the feed of the public pages of a user or an organization
+func (app App) UserFeed(userName string) (Feed, error) {
+	found, err := app.repository.FindUser(userName)
+	if err != nil {
+		return Feed{}, err
+	}
+	if !found {
+		return Feed{}, NotInDatastoreError{"User", userName}
+	}
+
+	public, _, err := app.repository.NewPageQuery().User(userName).Filter("Policy =", PolicyPUBLIC).Order("-LastModificationDate").Limit(maxFeedPages).GetAll()
+	if err != nil {
+		return Feed{}, err
+	}
+
+	f := Feed{
+		ID:     "okinotes:user:" + userName,
+		Title:  userName,
+		Path:   "/p/" + userName + "/",
+		Author: userName,
+	}
+	if len(public) > 0 {
+		f.HTMLURL = "/p/" + userName + "/" + public[0].Name + ".html"
+		f.Updated = public[0].LastModificationDate
+	}
+	f.Entries, err = app.feedEntries(public)
+	return f, err
+}
+
+//SiteFeed returns the feed of the public pages of all the users
+func (app App) SiteFeed() (Feed, error) {
+	pages, _, err := app.ListPublicPages(maxFeedPages, "")
+	if err != nil {
+		return Feed{}, err
+	}
+
+	f := Feed{
+		ID:      "okinotes:site",
+		Title:   "Okinotes",
+		Path:    "/",
+		HTMLURL: "/index.html",
+		Author:  "Okinotes",
+	}
+	if len(pages) > 0 {
+		f.Updated = pages[0].LastModificationDate
+	}
+	f.Entries, err = app.feedEntries(pages)
+	return f, err
+}
+
+//feedEntries returns the most recently modified items of the pages
+func (app App) feedEntries(pages []Page) ([]FeedEntry, error) {
+	entries := []FeedEntry{}
+	for _, p := range pages {
+		items, _, err := app.repository.GetItemsFromPage(p.UserName, p.Name, ItemOrderMODIFIED, maxFeedEntries, "")
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range items {
+			entries = append(entries, newFeedEntry(p, i))
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool { return entries[i].Updated.After(entries[j].Updated) })
+	if len(entries) > maxFeedEntries {
+		entries = entries[:maxFeedEntries]
+	}
+	return entries, nil
+}
+
+//newFeedEntry describes an item of a page. The author is the source of the item,
+//or the owner of the page.
+func newFeedEntry(p Page, i Item) FeedEntry {
+	e := FeedEntry{
+		ID:          "okinotes:item:" + i.ID,
+		Title:       i.Title,
+		URL:         "/p/" + p.UserName + "/" + p.Name + ".html#" + i.ID,
+		ExternalURL: i.URL,
+		Author:      i.Source,
+		ContentHTML: string(i.HTMLContent),
+		Published:   i.CreationDate,
+		Updated:     i.LastModificationDate,
+	}
+	if len(e.Title) == 0 {
+		e.Title = p.Title
+	}
+	if len(e.Author) == 0 {
+		e.Author = p.UserName
+	}
+	if len(e.ContentHTML) == 0 && len(i.URL) > 0 {
+		text := i.Title
+		if len(text) == 0 {
+			text = i.URL
+		}
+		e.ContentHTML = `<p><a href="` + html.EscapeString(i.URL) + `">` + html.EscapeString(text) + `</a></p>`
+	}
+	return e
+}
+
+//updated returns the date of the last change of the feed
+func (f Feed) updated() time.Time {
+	updated := f.Updated
+	for _, e := range f.Entries {
+		if e.Updated.After(updated) {
+			updated = e.Updated
+		}
+	}
+	return updated
+}
+
+//absoluteURL makes a URL of the site absolute
+func absoluteURL(base *url.URL, ref string) string {
+	if len(ref) == 0 {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+//Atom writes the feed in Atom format, the URLs being relative to base
+func (f Feed) Atom(base *url.URL) ([]byte, error) {
+	feed := atom.Feed{
+		Title: f.Title,
+		ID:    f.ID,
+		Link: []atom.Link{
+			{Rel: "self", Href: absoluteURL(base, f.Path+FeedFileATOM), Type: "application/atom+xml"},
+		},
+		Updated: atom.Time(f.updated()),
+		Author:  &atom.Person{Name: f.Author},
+	}
+	if len(f.HTMLURL) > 0 {
+		feed.Link = append(feed.Link, atom.Link{Rel: "alternate", Href: absoluteURL(base, f.HTMLURL), Type: "text/html"})
+	}
+
+	for _, e := range f.Entries {
+		entry := atom.Entry{
+			Title: e.Title,
+			ID:    e.ID,
+			Link: []atom.Link{
+				{Rel: "alternate", Href: absoluteURL(base, e.URL), Type: "text/html"},
+			},
+			Published: atom.Time(e.Published),
+			Updated:   atom.Time(e.Updated),
+			Author:    &atom.Person{Name: e.Author},
+			Content:   &atom.Text{Type: "html", Body: e.ContentHTML},
+		}
+		if len(e.ExternalURL) > 0 {
+			entry.Link = append(entry.Link, atom.Link{Rel: "related", Href: e.ExternalURL})
+		}
+		feed.Entry = append(feed.Entry, &entry)
+	}
+
+	return marshalXML(feed)
+}
+
+//rssDocument is an RSS 2.0 feed, the author of the items being given by Dublin Core
+type rssDocument struct {
+	XMLName xml.Name `xml:"rss"`
+	Version string   `xml:"version,attr"`
+	AtomNS  string   `xml:"xmlns:atom,attr"`
+	DCNS    string   `xml:"xmlns:dc,attr"`
+	Channel struct {
+		Title         string `xml:"title"`
+		Link          string `xml:"link"`
+		Description   string `xml:"description"`
+		LastBuildDate string `xml:"lastBuildDate,omitempty"`
+		Self          struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+			Type string `xml:"type,attr"`
+		} `xml:"atom:link"`
+		Item []rssItem `xml:"item"`
+	} `xml:"channel"`
+}
+
+type rssItem struct {
+	Title       string `xml:"title"`
+	Link        string `xml:"link"`
+	Description string `xml:"description"`
+	Creator     string `xml:"dc:creator"`
+	GUID        struct {
+		IsPermaLink bool   `xml:"isPermaLink,attr"`
+		Value       string `xml:",chardata"`
+	} `xml:"guid"`
+	PubDate string `xml:"pubDate"`
+}
+
+//RSS writes the feed in RSS 2.0 format, the URLs being relative to base
+func (f Feed) RSS(base *url.URL) ([]byte, error) {
+	doc := rssDocument{Version: "2.0", AtomNS: "http://www.w3.org/2005/Atom", DCNS: "http://purl.org/dc/elements/1.1/"}
+	doc.Channel.Title = f.Title
+	doc.Channel.Link = absoluteURL(base, f.HTMLURL)
+	if len(doc.Channel.Link) == 0 {
+		doc.Channel.Link = absoluteURL(base, "/")
+	}
+	doc.Channel.Description = f.Title
+	if updated := f.updated(); !updated.IsZero() {
+		doc.Channel.LastBuildDate = updated.UTC().Format(time.RFC1123Z)
+	}
+	doc.Channel.Self.Href = absoluteURL(base, f.Path+FeedFileRSS)
+	doc.Channel.Self.Rel = "self"
+	doc.Channel.Self.Type = "application/rss+xml"
+
+	for _, e := range f.Entries {
+		item := rssItem{
+			Title:       e.Title,
+			Link:        absoluteURL(base, e.URL),
+			Description: e.ContentHTML,
+			Creator:     e.Author,
+			PubDate:     e.Published.UTC().Format(time.RFC1123Z),
+		}
+		item.GUID.Value = e.ID
+		doc.Channel.Item = append(doc.Channel.Item, item)
+	}
+
+	return marshalXML(doc)
+}
+
+//marshalXML writes an XML document with its declaration
+func marshalXML(v interface{}) ([]byte, error) {
+	b, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), b...), nil
+}
+
+//jsonFeed is a JSON Feed 1.1
+type jsonFeed struct {
+	Version     string          `json:"version"`
+	Title       string          `json:"title"`
+	HomePageURL string          `json:"home_page_url,omitempty"`
+	FeedURL     string          `json:"feed_url"`
+	Authors     []jsonFeedActor `json:"authors,omitempty"`
+	Items       []jsonFeedItem  `json:"items"`
+}
+
+type jsonFeedActor struct {
+	Name string `json:"name"`
+}
+
+type jsonFeedItem struct {
+	ID            string          `json:"id"`
+	URL           string          `json:"url"`
+	ExternalURL   string          `json:"external_url,omitempty"`
+	Title         string          `json:"title,omitempty"`
+	ContentHTML   string          `json:"content_html"`
+	DatePublished string          `json:"date_published"`
+	DateModified  string          `json:"date_modified"`
+	Authors       []jsonFeedActor `json:"authors,omitempty"`
+}
+
+//JSON writes the feed in JSON Feed 1.1 format, the URLs being relative to base
+func (f Feed) JSON(base *url.URL) ([]byte, error) {
+	feed := jsonFeed{
+		Version:     "https://jsonfeed.org/version/1.1",
+		Title:       f.Title,
+		HomePageURL: absoluteURL(base, f.HTMLURL),
+		FeedURL:     absoluteURL(base, f.Path+FeedFileJSON),
+		Authors:     []jsonFeedActor{{f.Author}},
+		Items:       []jsonFeedItem{},
+	}
+
+	for _, e := range f.Entries {
+		feed.Items = append(feed.Items, jsonFeedItem{
+			ID:            e.ID,
+			URL:           absoluteURL(base, e.URL),
+			ExternalURL:   e.ExternalURL,
+			Title:         e.Title,
+			ContentHTML:   e.ContentHTML,
+			DatePublished: e.Published.UTC().Format(time.RFC3339),
+			DateModified:  e.Updated.UTC().Format(time.RFC3339),
+			Authors:       []jsonFeedActor{{e.Author}},
+		})
+	}
+
+	return json.MarshalIndent(feed, "", "  ")
+}
@@ -0,0 +1,141 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/okinotes/okinotes"
+)
+
+func TestFeeds(t *testing.T) {
+	newApp := newTestSite(t, "user01", "user02").App
+	user01, user02 := newApp("user01"), newApp("user02")
+	for _, p := range []okinotes.Page{
+		{Name: "blog", Title: "Blog", Policy: okinotes.PolicyPUBLIC},
+		{Name: "diary", Title: "Diary"},
+	} {
+		if err := user01.CreatePage(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := user02.CreatePage(okinotes.Page{Name: "links", Title: "Links", Policy: okinotes.PolicyPUBLIC}); err != nil {
+		t.Fatal(err)
+	}
+
+	post, err := user01.CreateItem("user01", "blog", okinotes.Item{Title: "First post", Content: "Hello *world*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := user01.CreateItem("user01", "diary", okinotes.Item{Title: "Secret", Content: "Private"}); err != nil {
+		t.Fatal(err)
+	}
+	link, err := user02.CreateItem("user02", "links", okinotes.Item{URL: "http://example.com/?a=1&b=2", Source: "Example"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base, _ := url.Parse("https://notes.example.com/")
+
+	//Page feed
+	feed, err := user02.PageFeed("user01", "blog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Entries) != 1 || feed.Entries[0].Author != "user01" || feed.Entries[0].URL != "/p/user01/blog.html#"+post.ID {
+		t.Fatalf("Entries = %+v, wanted the post by user01", feed.Entries)
+	}
+	atom, err := feed.Atom(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<link rel="self" href="https://notes.example.com/p/user01/blog/atom.xml" type="application/atom+xml">`,
+		`<link rel="alternate" href="https://notes.example.com/p/user01/blog.html" type="text/html">`,
+		`<link rel="alternate" href="https://notes.example.com/p/user01/blog.html#` + post.ID + `" type="text/html">`,
+		`<name>user01</name>`,
+	} {
+		if !strings.Contains(string(atom), want) {
+			t.Errorf("Atom feed does not contain %s:\n%s", want, atom)
+		}
+	}
+	page, items, err := okinotes.ParseImport(okinotes.ImportFormatFEED, atom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Title != "Blog" || len(items) != 1 || items[0].Title != "First post" || !strings.Contains(items[0].Content, "<em>world</em>") {
+		t.Errorf("Atom feed read as %+v, %+v", page, items)
+	}
+	if _, err := user02.PageFeed("user01", "diary"); err == nil {
+		t.Errorf("PageFeed succeeded on a private page")
+	}
+
+	//User feed, without the private pages
+	feed, err = user02.UserFeed("user01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Entries) != 1 || feed.Entries[0].Title != "First post" {
+		t.Errorf("Entries = %+v, wanted the public post only", feed.Entries)
+	}
+	rss, err := feed.RSS(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`<rss version="2.0" xmlns:atom="http://www.w3.org/2005/Atom" xmlns:dc="http://purl.org/dc/elements/1.1/">`,
+		`<atom:link href="https://notes.example.com/p/user01/rss.xml" rel="self" type="application/rss+xml">`,
+		`<dc:creator>user01</dc:creator>`,
+		`<guid isPermaLink="false">okinotes:item:` + post.ID + `</guid>`,
+	} {
+		if !strings.Contains(string(rss), want) {
+			t.Errorf("RSS feed does not contain %s:\n%s", want, rss)
+		}
+	}
+	if _, items, err := okinotes.ParseImport(okinotes.ImportFormatFEED, rss); err != nil || len(items) != 1 || items[0].Source != "user01" {
+		t.Errorf("RSS feed read as %+v, %v", items, err)
+	}
+	if _, err := user02.UserFeed("unknown"); err == nil {
+		t.Errorf("UserFeed succeeded for an unknown user")
+	}
+
+	//Site feed, the most recent items first
+	feed, err = user01.SiteFeed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(feed.Entries) != 2 || feed.Entries[0].ID != "okinotes:item:"+link.ID {
+		t.Fatalf("Entries = %+v, wanted the link then the post", feed.Entries)
+	}
+	data, err := feed.JSON(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var jsonFeed struct {
+		Version string `json:"version"`
+		FeedURL string `json:"feed_url"`
+		Items   []struct {
+			URL         string `json:"url"`
+			ExternalURL string `json:"external_url"`
+			ContentHTML string `json:"content_html"`
+			Authors     []struct {
+				Name string `json:"name"`
+			} `json:"authors"`
+		} `json:"items"`
+	}
+	if err := json.Unmarshal(data, &jsonFeed); err != nil {
+		t.Fatal(err)
+	}
+	if jsonFeed.Version != "https://jsonfeed.org/version/1.1" || jsonFeed.FeedURL != "https://notes.example.com/feed.json" || len(jsonFeed.Items) != 2 {
+		t.Fatalf("JSON feed = %s", data)
+	}
+	item := jsonFeed.Items[0]
+	if item.ExternalURL != link.URL || item.Authors[0].Name != "Example" || item.ContentHTML != `<p><a href="http://example.com/?a=1&amp;b=2">http://example.com/?a=1&amp;b=2</a></p>` {
+		t.Errorf("Item = %+v, wanted the link from Example", item)
+	}
+}
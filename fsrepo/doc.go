@@ -0,0 +1,30 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package fsrepo contains an implementation of the okinotes repository storing
+// data as plain files, so that notes can be searched, diffed and versioned with
+// standard tools. The layout of the root directory is:
+//
+//	users/<user>/user.json
+//	users/<org>/members/<user>.json
+//	users/<user>/images/<key>.json
+//	users/<user>/trash/<id>.json
+//	users/<user>/pages/<page>/page.json
+//	users/<user>/pages/<page>/usages.json
+//	users/<user>/pages/<page>/items/<id>.md
+//	users/<user>/pages/<page>/revisions/<id>/<number>.json
+//	users/<user>/pages/<page>/permissions/<user>.json
+//	users/<user>/pages/<page>/search/<id>.json
+//	identities/<provider>/<identity>.json
+//	credentials/<login>.json
+//	tokens/<hash>.json
+//	templates/<id>.json
+//
+// Items are Markdown files: their content is preceded by a front matter holding
+// the other fields of the item.
+//
+// Writers are serialized with a lock on the root directory. Each file is replaced
+// atomically (write to a temporary file then rename), and transactions are
+// committed through a journal replayed at startup if a commit was interrupted.
+package fsrepo
@@ -0,0 +1,317 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package fsrepo
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	lockFileName    = ".lock"
+	journalFileName = ".journal"
+	tempFilePrefix  = ".tmp-"
+)
+
+//escapeName converts a user, page, item... name into a valid file name.
+//Characters other than letters, digits, '-', '_' and non leading '.' are percent-encoded.
+func escapeName(s string) string {
+	if len(s) == 0 {
+		return "%"
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_' || (c == '.' && i > 0) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+//unescapeName is the reverse of escapeName
+func unescapeName(s string) (string, error) {
+	if s == "%" {
+		return "", nil
+	}
+	return url.PathUnescape(s)
+}
+
+//change is a pending modification of a file, identified by its slash-separated path relative to the root
+type change struct {
+	Path   string `json:"path"`
+	Data   []byte `json:"data,omitempty"`
+	Delete bool   `json:"delete,omitempty"`
+}
+
+//store is the state shared by all the repositories working on the same root directory
+type store struct {
+	root string
+	mu   sync.Mutex //Serializes writers of this process
+	lock *os.File   //Serializes writers of all processes
+}
+
+func openStore(root string) (*store, error) {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return nil, err
+	}
+
+	lock, err := os.OpenFile(filepath.Join(root, lockFileName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &store{root: root, lock: lock}
+
+	//Finish any interrupted commit
+	if err := s.acquire(); err != nil {
+		lock.Close()
+		return nil, err
+	}
+	s.release()
+
+	return s, nil
+}
+
+func (s *store) path(rel string) string {
+	return filepath.Join(s.root, filepath.FromSlash(rel))
+}
+
+//acquire takes the write lock and replays the journal left by an interrupted commit
+func (s *store) acquire() error {
+	s.mu.Lock()
+	if err := lockFile(s.lock); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+
+	b, err := ioutil.ReadFile(s.path(journalFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err == nil {
+		var changes []change
+		if err = json.Unmarshal(b, &changes); err == nil {
+			err = s.apply(changes)
+		}
+	}
+	if err == nil {
+		err = os.Remove(s.path(journalFileName))
+	}
+	if err != nil {
+		s.release()
+		return err
+	}
+	return nil
+}
+
+//release releases the write lock taken by acquire
+func (s *store) release() {
+	unlockFile(s.lock)
+	s.mu.Unlock()
+}
+
+//commit applies all the changes. The write lock must be held.
+//When several files are modified, the changes are first written in a journal,
+//so that they can be completed by the next writer if the process is interrupted.
+func (s *store) commit(changes []change) error {
+	if len(changes) == 0 {
+		return nil
+	}
+	if len(changes) == 1 {
+		return s.apply(changes)
+	}
+
+	b, err := json.Marshal(changes)
+	if err != nil {
+		return err
+	}
+	if err := s.writeFile(journalFileName, b); err != nil {
+		return err
+	}
+	if err := s.apply(changes); err != nil {
+		return err
+	}
+	return os.Remove(s.path(journalFileName))
+}
+
+func (s *store) apply(changes []change) error {
+	for _, c := range changes {
+		var err error
+		if c.Delete {
+			err = s.removeFile(c.Path)
+		} else {
+			err = s.writeFile(c.Path, c.Data)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//writeFile atomically replaces the content of a file
+func (s *store) writeFile(rel string, data []byte) error {
+	path := s.path(rel)
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	f, err := ioutil.TempFile(dir, tempFilePrefix)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
+
+//removeFile removes a file, and its parent directories once empty
+func (s *store) removeFile(rel string) error {
+	path := s.path(rel)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	for dir := filepath.Dir(path); dir != s.root && strings.HasPrefix(dir, s.root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break //Not empty
+		}
+	}
+	return nil
+}
+
+//txn contains the changes of a transaction, by path
+type txn map[string]change
+
+//readFile returns the content of a file, as seen by the current transaction
+func (repo repository) readFile(rel string) ([]byte, error) {
+	if c, found := repo.tx[rel]; found {
+		if c.Delete {
+			return nil, os.ErrNotExist
+		}
+		return c.Data, nil
+	}
+	return ioutil.ReadFile(repo.s.path(rel))
+}
+
+//list returns the sorted names of the entries of a directory, as seen by the current transaction
+func (repo repository) list(dir string) ([]string, error) {
+	names := make(map[string]bool)
+
+	infos, err := ioutil.ReadDir(repo.s.path(dir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, info := range infos {
+		if !strings.HasPrefix(info.Name(), ".") {
+			names[info.Name()] = true
+		}
+	}
+
+	prefix := dir + "/"
+	for p, c := range repo.tx {
+		if !strings.HasPrefix(p, prefix) {
+			continue
+		}
+		rest := p[len(prefix):]
+		if i := strings.Index(rest, "/"); i >= 0 {
+			if !c.Delete {
+				names[rest[:i]] = true
+			}
+		} else if c.Delete {
+			delete(names, rest)
+		} else {
+			names[rest] = true
+		}
+	}
+
+	var l []string
+	for name := range names {
+		l = append(l, name)
+	}
+	sort.Strings(l)
+	return l, nil
+}
+
+//update runs f in the current transaction, or in a new one
+func (repo repository) update(f func(tx repository) error) error {
+	if repo.tx != nil {
+		return f(repo)
+	}
+
+	if err := repo.s.acquire(); err != nil {
+		return err
+	}
+	defer repo.s.release()
+
+	tx := repository{repo.s, make(txn)}
+	if err := f(tx); err != nil {
+		return err
+	}
+	return repo.s.commit(tx.changes())
+}
+
+//changes returns the changes of the transaction, sorted by path
+func (repo repository) changes() []change {
+	var changes []change
+	for _, c := range repo.tx {
+		changes = append(changes, c)
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+//put records the new content of a file. Must be called inside update.
+func (repo repository) put(rel string, data []byte) {
+	repo.tx[rel] = change{Path: rel, Data: data}
+}
+
+//remove records the deletion of a file. Must be called inside update.
+func (repo repository) remove(rel string) {
+	repo.tx[rel] = change{Path: rel, Delete: true}
+}
+
+//readJSON decodes the content of a JSON file into v
+func (repo repository) readJSON(rel string, v interface{}) error {
+	b, err := repo.readFile(rel)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+//putJSON records the new content of a JSON file. Must be called inside update.
+func (repo repository) putJSON(rel string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "\t")
+	if err != nil {
+		return err
+	}
+	repo.put(rel, append(b, '\n'))
+	return nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package fsrepo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/okinotes/okinotes"
+)
+
+const frontMatterDelimiter = "---"
+
+//encodeItem writes an item as a Markdown file with a front matter:
+//
+//	---
+//	id: "abc"
+//	kind: "note"
+//	...
+//	tags:
+//	  "status": "done"
+//	---
+//	Content of the item
+func encodeItem(i okinotes.Item) []byte {
+	var b bytes.Buffer
+
+	b.WriteString(frontMatterDelimiter + "\n")
+	fmt.Fprintf(&b, "id: %s\n", strconv.Quote(i.ID))
+	fmt.Fprintf(&b, "kind: %s\n", strconv.Quote(i.Kind))
+	fmt.Fprintf(&b, "title: %s\n", strconv.Quote(i.Title))
+	fmt.Fprintf(&b, "source: %s\n", strconv.Quote(i.Source))
+	fmt.Fprintf(&b, "url: %s\n", strconv.Quote(i.URL))
+	fmt.Fprintf(&b, "creationDate: %s\n", strconv.Quote(i.CreationDate.Format(time.RFC3339Nano)))
+	fmt.Fprintf(&b, "lastModificationDate: %s\n", strconv.Quote(i.LastModificationDate.Format(time.RFC3339Nano)))
+	if len(i.Position) > 0 {
+		fmt.Fprintf(&b, "position: %s\n", strconv.Quote(i.Position))
+	}
+	if len(i.Tags) > 0 {
+		b.WriteString("tags:\n")
+		for _, t := range i.Tags {
+			fmt.Fprintf(&b, "  %s: %s\n", strconv.Quote(t.Key), strconv.Quote(t.Value))
+		}
+	}
+	b.WriteString(frontMatterDelimiter + "\n")
+	b.WriteString(i.Content)
+
+	return b.Bytes()
+}
+
+//decodeItem reads an item written by encodeItem
+func decodeItem(data []byte) (okinotes.Item, error) {
+	var i okinotes.Item
+
+	r := bufio.NewReader(bytes.NewReader(data))
+	line, err := r.ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != frontMatterDelimiter {
+		return okinotes.Item{}, fmt.Errorf("Missing front matter")
+	}
+
+	inTags := false
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return okinotes.Item{}, fmt.Errorf("Unterminated front matter")
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == frontMatterDelimiter {
+			break
+		}
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		if inTags && strings.HasPrefix(line, " ") {
+			key, value, err := parseQuotedPair(strings.TrimSpace(line))
+			if err != nil {
+				return okinotes.Item{}, err
+			}
+			i.Tags.SetTag(key, value)
+			continue
+		}
+		inTags = false
+
+		sep := strings.Index(line, ":")
+		if sep < 0 {
+			return okinotes.Item{}, fmt.Errorf("Invalid front matter line '%s'", line)
+		}
+		key := strings.TrimSpace(line[:sep])
+		rawValue := strings.TrimSpace(line[sep+1:])
+		if key == "tags" && len(rawValue) == 0 {
+			inTags = true
+			continue
+		}
+		value, err := strconv.Unquote(rawValue)
+		if err != nil {
+			return okinotes.Item{}, fmt.Errorf("Invalid value for '%s': %v", key, err)
+		}
+
+		switch key {
+		case "id":
+			i.ID = value
+		case "kind":
+			i.Kind = value
+		case "title":
+			i.Title = value
+		case "source":
+			i.Source = value
+		case "url":
+			i.URL = value
+		case "creationDate":
+			i.CreationDate, err = time.Parse(time.RFC3339Nano, value)
+		case "lastModificationDate":
+			i.LastModificationDate, err = time.Parse(time.RFC3339Nano, value)
+		case "position":
+			i.Position = value
+		}
+		if err != nil {
+			return okinotes.Item{}, err
+		}
+	}
+
+	var content bytes.Buffer
+	if _, err := content.ReadFrom(r); err != nil {
+		return okinotes.Item{}, err
+	}
+	i.Content = content.String()
+	i.HTMLContent = okinotes.MarkdownToHTML(i.Content)
+
+	return i, nil
+}
+
+//parseQuotedPair parses a line such as `"key": "value"`
+func parseQuotedPair(s string) (string, string, error) {
+	quotedKey, err := strconv.QuotedPrefix(s)
+	if err != nil {
+		return "", "", err
+	}
+	key, err := strconv.Unquote(quotedKey)
+	if err != nil {
+		return "", "", err
+	}
+
+	rest := strings.TrimSpace(s[len(quotedKey):])
+	if !strings.HasPrefix(rest, ":") {
+		return "", "", fmt.Errorf("Invalid tag line '%s'", s)
+	}
+	value, err := strconv.Unquote(strings.TrimSpace(rest[1:]))
+	if err != nil {
+		return "", "", err
+	}
+	return key, value, nil
+}
@@ -0,0 +1,19 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package fsrepo
+
+import (
+	"os"
+)
+
+//lockFile does nothing on windows: writers are only serialized within the current process.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+//unlockFile releases the lock acquired with lockFile
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,981 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package fsrepo
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/okinotes/okinotes"
+)
+
+const itemExtension = ".md"
+
+type repository struct {
+	s  *store
+	tx txn //Changes of the current transaction, nil outside of a transaction
+}
+
+//NewRepository creates a repository storing its data in the given directory.
+//The directory is created if needed.
+func NewRepository(root string) (okinotes.Repository, error) {
+	s, err := openStore(root)
+	if err != nil {
+		return nil, err
+	}
+	return repository{s: s}, nil
+}
+
+//Close releases the resources used by the repository
+func (repo repository) Close() error {
+	return repo.s.lock.Close()
+}
+
+func userDir(userName string) string {
+	return "users/" + escapeName(userName)
+}
+func userPath(userName string) string {
+	return userDir(userName) + "/user.json"
+}
+func membersDir(orgName string) string {
+	return userDir(orgName) + "/members"
+}
+func membershipPath(orgName, userName string) string {
+	return membersDir(orgName) + "/" + escapeName(userName) + ".json"
+}
+func pageDir(userName, pageName string) string {
+	return userDir(userName) + "/pages/" + escapeName(pageName)
+}
+func pagePath(userName, pageName string) string {
+	return pageDir(userName, pageName) + "/page.json"
+}
+func usagesPath(userName, pageName string) string {
+	return pageDir(userName, pageName) + "/usages.json"
+}
+func permissionsDir(userName, pageName string) string {
+	return pageDir(userName, pageName) + "/permissions"
+}
+func permissionPath(userName, pageName, grantee string) string {
+	return permissionsDir(userName, pageName) + "/" + escapeName(grantee) + ".json"
+}
+func itemsDir(userName, pageName string) string {
+	return pageDir(userName, pageName) + "/items"
+}
+func itemPath(userName, pageName, itemID string) string {
+	return itemsDir(userName, pageName) + "/" + escapeName(itemID) + itemExtension
+}
+func revisionsDir(userName, pageName, itemID string) string {
+	return pageDir(userName, pageName) + "/revisions/" + escapeName(itemID)
+}
+func revisionPath(userName, pageName, itemID string, number int) string {
+	return revisionsDir(userName, pageName, itemID) + "/" + strconv.Itoa(number) + ".json"
+}
+func trashDir(userName string) string {
+	return userDir(userName) + "/trash"
+}
+func trashEntryPath(userName, entryID string) string {
+	return trashDir(userName) + "/" + escapeName(entryID) + ".json"
+}
+func searchDir(userName, pageName string) string {
+	return pageDir(userName, pageName) + "/search"
+}
+func searchDocumentPath(userName, pageName, itemID string) string {
+	return searchDir(userName, pageName) + "/" + escapeName(itemID) + ".json"
+}
+func imagesDir(userName string) string {
+	return userDir(userName) + "/images"
+}
+func imagePath(userName, imgID string) string {
+	return imagesDir(userName) + "/" + escapeName(imgID) + ".json"
+}
+func identityPath(ident okinotes.Ident) string {
+	return "identities/" + escapeName(ident.Provider) + "/" + escapeName(ident.Identity) + ".json"
+}
+func tokenPath(hash string) string {
+	return "tokens/" + escapeName(hash) + ".json"
+}
+func credentialsPath(login string) string {
+	return "credentials/" + escapeName(login) + ".json"
+}
+func templatePath(templateID string) string {
+	return "templates/" + escapeName(templateID) + ".json"
+}
+
+func (repo repository) RunInTransaction(f func(repo okinotes.Repository) error) error {
+	return repo.update(func(tx repository) error {
+		return f(tx)
+	})
+}
+
+func (repo repository) GetPage(userName string, pageName string) (okinotes.Page, error) {
+	var page okinotes.Page
+	err := repo.readJSON(pagePath(userName, pageName), &page)
+	if os.IsNotExist(err) {
+		return okinotes.Page{}, okinotes.NotInDatastoreError{"Page", pageName}
+	}
+	if err != nil {
+		return okinotes.Page{}, err
+	}
+	return page, nil
+}
+func (repo repository) StorePage(page okinotes.Page) error {
+	return repo.update(func(tx repository) error {
+		return tx.putJSON(pagePath(page.UserName, page.Name), page)
+	})
+}
+func (repo repository) DeletePage(userName string, pageName string) error {
+	return repo.update(func(tx repository) error {
+		tx.remove(pagePath(userName, pageName))
+		return nil
+	})
+}
+
+type pageQuery struct {
+	repo     repository
+	userName string
+	filters  []okinotes.PageFilter
+	orders   []okinotes.PageOrder
+	limit    int
+	cursor   string
+	err      error
+}
+
+func (repo repository) NewPageQuery() okinotes.PageQuery {
+	return &pageQuery{
+		repo:  repo,
+		limit: -1,
+	}
+}
+func (q *pageQuery) User(userName string) okinotes.PageQuery {
+	q.userName = userName
+	return q
+}
+func (q *pageQuery) Filter(filterStr string, value interface{}) okinotes.PageQuery {
+	f, err := okinotes.ParsePageFilter(filterStr, value)
+	if err != nil && q.err == nil {
+		q.err = err
+	}
+	q.filters = append(q.filters, f)
+	return q
+}
+func (q *pageQuery) Order(fieldName string) okinotes.PageQuery {
+	o, err := okinotes.ParsePageOrder(fieldName)
+	if err != nil && q.err == nil {
+		q.err = err
+	}
+	q.orders = append(q.orders, o)
+	return q
+}
+func (q *pageQuery) Limit(limit int) okinotes.PageQuery {
+	q.limit = limit
+	return q
+}
+func (q *pageQuery) Start(cursor string) okinotes.PageQuery {
+	q.cursor = cursor
+	return q
+}
+
+func (q *pageQuery) GetAll() ([]okinotes.Page, string, error) {
+	if q.err != nil {
+		return nil, "", q.err
+	}
+
+	var userDirs []string
+	if len(q.userName) > 0 {
+		userDirs = []string{userDir(q.userName)}
+	} else {
+		names, err := q.repo.list("users")
+		if err != nil {
+			return nil, "", err
+		}
+		for _, name := range names {
+			userDirs = append(userDirs, "users/"+name)
+		}
+	}
+
+	var pages []okinotes.Page
+	for _, dir := range userDirs {
+		names, err := q.repo.list(dir + "/pages")
+		if err != nil {
+			return nil, "", err
+		}
+		for _, name := range names {
+			var page okinotes.Page
+			err := q.repo.readJSON(dir+"/pages/"+name+"/page.json", &page)
+			if os.IsNotExist(err) {
+				continue //Deleted page with remaining items
+			}
+			if err != nil {
+				return nil, "", err
+			}
+
+			ok, err := q.match(page)
+			if err != nil {
+				return nil, "", err
+			}
+			if ok {
+				pages = append(pages, page)
+			}
+		}
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return okinotes.ComparePages(q.orders, pages[i], pages[j]) < 0
+	})
+	return okinotes.PaginatePages(pages, q.orders, q.cursor, q.limit)
+}
+func (q *pageQuery) match(p okinotes.Page) (bool, error) {
+	for _, f := range q.filters {
+		ok, err := f.Match(p)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (repo repository) GetItemsFromPage(userName string, pageName string, order okinotes.ItemOrder, limit int, cursor string) ([]okinotes.Item, string, error) {
+	dir := itemsDir(userName, pageName)
+	names, err := repo.list(dir)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var items []okinotes.Item
+	for _, name := range names {
+		if !strings.HasSuffix(name, itemExtension) {
+			continue
+		}
+		b, err := repo.readFile(dir + "/" + name)
+		if err != nil {
+			return nil, "", err
+		}
+		item, err := decodeItem(b)
+		if err != nil {
+			return nil, "", err
+		}
+		items = append(items, item)
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return okinotes.CompareItems(order, items[i], items[j]) < 0
+	})
+	return okinotes.PaginateItems(items, order, cursor, limit)
+}
+func (repo repository) DeleteItemsFromPage(userName string, pageName string) error {
+	return repo.update(func(tx repository) error {
+		dir := itemsDir(userName, pageName)
+		names, err := tx.list(dir)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			tx.remove(dir + "/" + name)
+		}
+		return nil
+	})
+}
+func (repo repository) FindItem(userName string, pageName string, itemID string) (bool, error) {
+	_, err := repo.readFile(itemPath(userName, pageName, itemID))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+func (repo repository) GetItem(userName string, pageName string, itemID string) (okinotes.Item, error) {
+	b, err := repo.readFile(itemPath(userName, pageName, itemID))
+	if os.IsNotExist(err) {
+		return okinotes.Item{}, okinotes.NotInDatastoreError{"Item", itemID}
+	}
+	if err != nil {
+		return okinotes.Item{}, err
+	}
+	return decodeItem(b)
+}
+func (repo repository) StoreItem(userName string, pageName string, i okinotes.Item) error {
+	return repo.update(func(tx repository) error {
+		tx.put(itemPath(userName, pageName, i.ID), encodeItem(i))
+		return nil
+	})
+}
+func (repo repository) DeleteItem(userName string, pageName string, itemID string) error {
+	return repo.update(func(tx repository) error {
+		tx.remove(itemPath(userName, pageName, itemID))
+		return nil
+	})
+}
+
+func (repo repository) GetRevisions(userName string, pageName string, itemID string) ([]okinotes.Revision, error) {
+	dir := revisionsDir(userName, pageName, itemID)
+	names, err := repo.list(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var revisions []okinotes.Revision
+	for _, name := range names {
+		var r okinotes.Revision
+		if err := repo.readJSON(dir+"/"+name, &r); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	sort.Slice(revisions, func(i, j int) bool {
+		return revisions[i].Number < revisions[j].Number
+	})
+	return revisions, nil
+}
+func (repo repository) GetRevision(userName string, pageName string, itemID string, number int) (okinotes.Revision, error) {
+	var r okinotes.Revision
+	err := repo.readJSON(revisionPath(userName, pageName, itemID, number), &r)
+	if os.IsNotExist(err) {
+		return okinotes.Revision{}, okinotes.NotInDatastoreError{"Revision", strconv.Itoa(number)}
+	}
+	if err != nil {
+		return okinotes.Revision{}, err
+	}
+	return r, nil
+}
+func (repo repository) StoreRevision(userName string, pageName string, r okinotes.Revision) error {
+	return repo.update(func(tx repository) error {
+		return tx.putJSON(revisionPath(userName, pageName, r.ItemID, r.Number), r)
+	})
+}
+func (repo repository) DeleteRevisions(userName string, pageName string, itemID string) error {
+	return repo.update(func(tx repository) error {
+		dir := revisionsDir(userName, pageName, itemID)
+		names, err := tx.list(dir)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			tx.remove(dir + "/" + name)
+		}
+		return nil
+	})
+}
+func (repo repository) DeleteRevisionsFromPage(userName string, pageName string) error {
+	return repo.update(func(tx repository) error {
+		dir := pageDir(userName, pageName) + "/revisions"
+		items, err := tx.list(dir)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			names, err := tx.list(dir + "/" + item)
+			if err != nil {
+				return err
+			}
+			for _, name := range names {
+				tx.remove(dir + "/" + item + "/" + name)
+			}
+		}
+		return nil
+	})
+}
+
+func (repo repository) readTrash(dir string, entries []okinotes.TrashEntry, match func(e okinotes.TrashEntry) bool) ([]okinotes.TrashEntry, error) {
+	names, err := repo.list(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		var e okinotes.TrashEntry
+		if err := repo.readJSON(dir+"/"+name, &e); err != nil {
+			return nil, err
+		}
+		if match(e) {
+			entries = append(entries, e)
+		}
+	}
+	return entries, nil
+}
+func sortTrash(entries []okinotes.TrashEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].DeletionDate.Equal(entries[j].DeletionDate) {
+			return entries[i].DeletionDate.After(entries[j].DeletionDate)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+}
+func (repo repository) GetTrash(userName string) ([]okinotes.TrashEntry, error) {
+	entries, err := repo.readTrash(trashDir(userName), nil, func(e okinotes.TrashEntry) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+	sortTrash(entries)
+	return entries, nil
+}
+func (repo repository) GetTrashEntry(userName string, entryID string) (okinotes.TrashEntry, error) {
+	var e okinotes.TrashEntry
+	err := repo.readJSON(trashEntryPath(userName, entryID), &e)
+	if os.IsNotExist(err) {
+		return okinotes.TrashEntry{}, okinotes.NotInDatastoreError{"Trash entry", entryID}
+	}
+	if err != nil {
+		return okinotes.TrashEntry{}, err
+	}
+	return e, nil
+}
+func (repo repository) GetExpiredTrash(before time.Time) ([]okinotes.TrashEntry, error) {
+	users, err := repo.list("users")
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []okinotes.TrashEntry
+	for _, user := range users {
+		entries, err = repo.readTrash("users/"+user+"/trash", entries, func(e okinotes.TrashEntry) bool {
+			return e.DeletionDate.Before(before)
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sortTrash(entries)
+	return entries, nil
+}
+func (repo repository) StoreTrashEntry(e okinotes.TrashEntry) error {
+	return repo.update(func(tx repository) error {
+		return tx.putJSON(trashEntryPath(e.UserName, e.ID), e)
+	})
+}
+func (repo repository) DeleteTrashEntry(userName string, entryID string) error {
+	return repo.update(func(tx repository) error {
+		tx.remove(trashEntryPath(userName, entryID))
+		return nil
+	})
+}
+
+func (repo repository) StoreSearchDocument(d okinotes.SearchDocument) error {
+	return repo.update(func(tx repository) error {
+		return tx.putJSON(searchDocumentPath(d.UserName, d.PageName, d.ItemID), d)
+	})
+}
+func (repo repository) DeleteSearchDocument(userName string, pageName string, itemID string) error {
+	return repo.update(func(tx repository) error {
+		tx.remove(searchDocumentPath(userName, pageName, itemID))
+		return nil
+	})
+}
+func (repo repository) DeleteSearchDocumentsFromPage(userName string, pageName string) error {
+	return repo.update(func(tx repository) error {
+		dir := searchDir(userName, pageName)
+		names, err := tx.list(dir)
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			tx.remove(dir + "/" + name)
+		}
+		return nil
+	})
+}
+func (repo repository) FindSearchDocuments(terms []string, limit int, cursor string) ([]okinotes.SearchDocument, string, error) {
+	users, err := repo.list("users")
+	if err != nil {
+		return nil, "", err
+	}
+
+	var documents []okinotes.SearchDocument
+	for _, user := range users {
+		pages, err := repo.list("users/" + user + "/pages")
+		if err != nil {
+			return nil, "", err
+		}
+		for _, page := range pages {
+			dir := "users/" + user + "/pages/" + page + "/search"
+			names, err := repo.list(dir)
+			if err != nil {
+				return nil, "", err
+			}
+			for _, name := range names {
+				var d okinotes.SearchDocument
+				if err := repo.readJSON(dir+"/"+name, &d); err != nil {
+					return nil, "", err
+				}
+				if okinotes.HasAllTerms(d, terms) {
+					documents = append(documents, d)
+				}
+			}
+		}
+	}
+
+	sort.Slice(documents, func(i, j int) bool {
+		return okinotes.CompareSearchDocuments(documents[i], documents[j]) < 0
+	})
+	return okinotes.PaginateSearchDocuments(documents, cursor, limit)
+}
+
+func (repo repository) GetPermission(pageUserName string, pageName string, userName string) (okinotes.Permission, error) {
+	var p okinotes.Permission
+	err := repo.readJSON(permissionPath(pageUserName, pageName, userName), &p)
+	if os.IsNotExist(err) {
+		return okinotes.Permission{}, okinotes.NotInDatastoreError{"Permission", userName}
+	}
+	if err != nil {
+		return okinotes.Permission{}, err
+	}
+	return p, nil
+}
+func (repo repository) GetPagePermissions(pageUserName string, pageName string) ([]okinotes.Permission, error) {
+	dir := permissionsDir(pageUserName, pageName)
+	names, err := repo.list(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var permissions []okinotes.Permission
+	for _, name := range names {
+		var p okinotes.Permission
+		if err := repo.readJSON(dir+"/"+name, &p); err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, p)
+	}
+	sort.Slice(permissions, func(i, j int) bool {
+		return permissions[i].UserName < permissions[j].UserName
+	})
+	return permissions, nil
+}
+func (repo repository) GetUserPermissions(userName string) ([]okinotes.Permission, error) {
+	users, err := repo.list("users")
+	if err != nil {
+		return nil, err
+	}
+
+	var permissions []okinotes.Permission
+	for _, user := range users {
+		pages, err := repo.list("users/" + user + "/pages")
+		if err != nil {
+			return nil, err
+		}
+		for _, page := range pages {
+			var p okinotes.Permission
+			err := repo.readJSON("users/"+user+"/pages/"+page+"/permissions/"+escapeName(userName)+".json", &p)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			permissions = append(permissions, p)
+		}
+	}
+	return permissions, nil
+}
+func (repo repository) StorePermission(p okinotes.Permission) error {
+	return repo.update(func(tx repository) error {
+		return tx.putJSON(permissionPath(p.PageUserName, p.PageName, p.UserName), p)
+	})
+}
+func (repo repository) DeletePermission(pageUserName string, pageName string, userName string) error {
+	return repo.update(func(tx repository) error {
+		tx.remove(permissionPath(pageUserName, pageName, userName))
+		return nil
+	})
+}
+
+func (repo repository) FindUser(userName string) (bool, error) {
+	_, err := repo.readFile(userPath(userName))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+func (repo repository) GetUser(ident okinotes.Ident) (okinotes.User, error) {
+	identity, err := repo.GetIdentity(ident)
+	if err != nil {
+		return okinotes.User{}, err
+	}
+
+	var user okinotes.User
+	err = repo.readJSON(userPath(identity.UserName), &user)
+	if os.IsNotExist(err) {
+		return okinotes.User{}, nil
+	}
+	if err != nil {
+		return okinotes.User{}, err
+	}
+	return user, nil
+}
+func (repo repository) GetUserByName(userName string) (okinotes.User, error) {
+	var user okinotes.User
+	err := repo.readJSON(userPath(userName), &user)
+	if os.IsNotExist(err) {
+		return okinotes.User{}, okinotes.NotInDatastoreError{"User", userName}
+	}
+	if err != nil {
+		return okinotes.User{}, err
+	}
+	return user, nil
+}
+func (repo repository) GetIdentity(ident okinotes.Ident) (okinotes.Identity, error) {
+	var identity okinotes.Identity
+	err := repo.readJSON(identityPath(ident), &identity)
+	if os.IsNotExist(err) {
+		return okinotes.Identity{}, okinotes.NotInDatastoreError{"Identity", ident.Identity}
+	}
+	if err != nil {
+		return okinotes.Identity{}, err
+	}
+	return identity, nil
+}
+func (repo repository) StoreUser(user okinotes.User) error {
+	return repo.update(func(tx repository) error {
+		return tx.putJSON(userPath(user.Name), user)
+	})
+}
+func (repo repository) StoreIdentity(identity okinotes.Identity) error {
+	return repo.update(func(tx repository) error {
+		return tx.putJSON(identityPath(identity.Ident), identity)
+	})
+}
+
+func (repo repository) GetIdentities(userName string) ([]okinotes.Identity, error) {
+	providers, err := repo.list("identities")
+	if err != nil {
+		return nil, err
+	}
+
+	var identities []okinotes.Identity
+	for _, provider := range providers {
+		names, err := repo.list("identities/" + provider)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			var identity okinotes.Identity
+			if err := repo.readJSON("identities/"+provider+"/"+name, &identity); err != nil {
+				return nil, err
+			}
+			if identity.UserName == userName {
+				identities = append(identities, identity)
+			}
+		}
+	}
+	return identities, nil
+}
+func (repo repository) DeleteIdentity(identity okinotes.Identity) error {
+	return repo.update(func(tx repository) error {
+		tx.remove(identityPath(identity.Ident))
+		return nil
+	})
+}
+
+func (repo repository) GetMembership(orgName string, userName string) (okinotes.Membership, error) {
+	var membership okinotes.Membership
+	err := repo.readJSON(membershipPath(orgName, userName), &membership)
+	if os.IsNotExist(err) {
+		return okinotes.Membership{}, okinotes.NotInDatastoreError{"Membership", userName}
+	}
+	if err != nil {
+		return okinotes.Membership{}, err
+	}
+	return membership, nil
+}
+func (repo repository) GetMembers(orgName string) ([]okinotes.Membership, error) {
+	names, err := repo.list(membersDir(orgName))
+	if err != nil {
+		return nil, err
+	}
+
+	var memberships []okinotes.Membership
+	for _, name := range names {
+		var membership okinotes.Membership
+		if err := repo.readJSON(membersDir(orgName)+"/"+name, &membership); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, membership)
+	}
+	sort.Slice(memberships, func(i, j int) bool {
+		return memberships[i].UserName < memberships[j].UserName
+	})
+	return memberships, nil
+}
+func (repo repository) GetMemberships(userName string) ([]okinotes.Membership, error) {
+	users, err := repo.list("users")
+	if err != nil {
+		return nil, err
+	}
+
+	var memberships []okinotes.Membership
+	for _, user := range users {
+		var membership okinotes.Membership
+		err := repo.readJSON("users/"+user+"/members/"+escapeName(userName)+".json", &membership)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, membership)
+	}
+	sort.Slice(memberships, func(i, j int) bool {
+		return memberships[i].OrgName < memberships[j].OrgName
+	})
+	return memberships, nil
+}
+func (repo repository) StoreMembership(membership okinotes.Membership) error {
+	return repo.update(func(tx repository) error {
+		return tx.putJSON(membershipPath(membership.OrgName, membership.UserName), membership)
+	})
+}
+func (repo repository) DeleteMembership(orgName string, userName string) error {
+	return repo.update(func(tx repository) error {
+		tx.remove(membershipPath(orgName, userName))
+		return nil
+	})
+}
+
+func (repo repository) GetCredentials(login string) (okinotes.Credentials, error) {
+	var credentials okinotes.Credentials
+	err := repo.readJSON(credentialsPath(login), &credentials)
+	if os.IsNotExist(err) {
+		return okinotes.Credentials{}, okinotes.NotInDatastoreError{"Credentials", login}
+	}
+	if err != nil {
+		return okinotes.Credentials{}, err
+	}
+	return credentials, nil
+}
+func (repo repository) StoreCredentials(credentials okinotes.Credentials) error {
+	return repo.update(func(tx repository) error {
+		return tx.putJSON(credentialsPath(credentials.Login), credentials)
+	})
+}
+
+func (repo repository) GetToken(hash string) (okinotes.Token, error) {
+	var token okinotes.Token
+	err := repo.readJSON(tokenPath(hash), &token)
+	if os.IsNotExist(err) {
+		return okinotes.Token{}, okinotes.NotInDatastoreError{"Token", hash}
+	}
+	if err != nil {
+		return okinotes.Token{}, err
+	}
+	return token, nil
+}
+func (repo repository) GetTokens(userName string, kind okinotes.TokenKind) ([]okinotes.Token, error) {
+	names, err := repo.list("tokens")
+	if err != nil {
+		return nil, err
+	}
+
+	var tokens []okinotes.Token
+	for _, name := range names {
+		var token okinotes.Token
+		if err := repo.readJSON("tokens/"+name, &token); err != nil {
+			return nil, err
+		}
+		if token.UserName == userName && token.Kind == kind {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].CreationDate.Before(tokens[j].CreationDate)
+	})
+	return tokens, nil
+}
+func (repo repository) StoreToken(token okinotes.Token) error {
+	return repo.update(func(tx repository) error {
+		return tx.putJSON(tokenPath(token.Hash), token)
+	})
+}
+func (repo repository) DeleteToken(hash string) error {
+	return repo.update(func(tx repository) error {
+		tx.remove(tokenPath(hash))
+		return nil
+	})
+}
+
+func (repo repository) GetImages(userName string, limit int) ([]okinotes.UploadInfo, error) {
+	dir := imagesDir(userName)
+	names, err := repo.list(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var imgs []okinotes.UploadInfo
+	for _, name := range names {
+		var img okinotes.UploadInfo
+		if err := repo.readJSON(dir+"/"+name, &img); err != nil {
+			return nil, err
+		}
+		imgs = append(imgs, img)
+	}
+
+	sort.Slice(imgs, func(i, j int) bool {
+		if imgs[i].Filename != imgs[j].Filename {
+			return imgs[i].Filename < imgs[j].Filename
+		}
+		return imgs[i].Key < imgs[j].Key
+	})
+
+	if limit >= 0 && len(imgs) > limit {
+		imgs = imgs[:limit]
+	}
+	return imgs, nil
+}
+func (repo repository) StoreImage(img okinotes.UploadInfo, userName string) error {
+	return repo.update(func(tx repository) error {
+		return tx.putJSON(imagePath(userName, img.Key), img)
+	})
+}
+func (repo repository) RenameImage(name string, imgID string, userName string) error {
+	return repo.update(func(tx repository) error {
+		var img okinotes.UploadInfo
+		err := tx.readJSON(imagePath(userName, imgID), &img)
+		if os.IsNotExist(err) {
+			return okinotes.NotInDatastoreError{"Image", imgID}
+		}
+		if err != nil {
+			return err
+		}
+		img.Filename = name
+		return tx.putJSON(imagePath(userName, imgID), img)
+	})
+}
+func (repo repository) DeleteImage(imgID string, userName string) error {
+	return repo.update(func(tx repository) error {
+		tx.remove(imagePath(userName, imgID))
+		return nil
+	})
+}
+
+//getUsages returns the keys of the images used by a page
+func (repo repository) getUsages(path string) ([]string, error) {
+	var keys []string
+	err := repo.readJSON(path, &keys)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return keys, err
+}
+
+func (repo repository) IsUsed(imgID string) (bool, error) {
+	users, err := repo.list("users")
+	if err != nil {
+		return false, err
+	}
+	for _, user := range users {
+		pages, err := repo.list("users/" + user + "/pages")
+		if err != nil {
+			return false, err
+		}
+		for _, page := range pages {
+			keys, err := repo.getUsages("users/" + user + "/pages/" + page + "/usages.json")
+			if err != nil {
+				return false, err
+			}
+			for _, key := range keys {
+				if key == imgID {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+func (repo repository) StoreUsage(userName string, pageName string, imgID string) error {
+	return repo.update(func(tx repository) error {
+		path := usagesPath(userName, pageName)
+		keys, err := tx.getUsages(path)
+		if err != nil {
+			return err
+		}
+		i := sort.SearchStrings(keys, imgID)
+		if i < len(keys) && keys[i] == imgID {
+			return nil
+		}
+		keys = append(keys[:i], append([]string{imgID}, keys[i:]...)...)
+		return tx.putJSON(path, keys)
+	})
+}
+func (repo repository) DeleteUsages(userName string, pageName string) error {
+	return repo.update(func(tx repository) error {
+		tx.remove(usagesPath(userName, pageName))
+		return nil
+	})
+}
+
+func (repo repository) GetTemplate(templateID string) (okinotes.Template, error) {
+	var template okinotes.Template
+	err := repo.readJSON(templatePath(templateID), &template)
+	if os.IsNotExist(err) {
+		return okinotes.Template{}, okinotes.NotInDatastoreError{"Template", templateID}
+	}
+	if err != nil {
+		return okinotes.Template{}, err
+	}
+	return template, nil
+}
+func (repo repository) GetAllTemplates() ([]okinotes.Template, error) {
+	names, err := repo.list("templates")
+	if err != nil {
+		return nil, err
+	}
+
+	var templates []okinotes.Template
+	for _, name := range names {
+		var template okinotes.Template
+		if err := repo.readJSON("templates/"+name, &template); err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].Name != templates[j].Name {
+			return templates[i].Name < templates[j].Name
+		}
+		return templates[i].ID < templates[j].ID
+	})
+	return templates, nil
+}
+func (repo repository) StoreTemplate(tpl okinotes.Template, generateID func() string) (string, error) {
+	err := repo.update(func(tx repository) error {
+		//Generate a new template id if not set
+		if len(tpl.ID) == 0 {
+			for {
+				tpl.ID = generateID()
+				_, err := tx.readFile(templatePath(tpl.ID))
+				if os.IsNotExist(err) {
+					break
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		oldTemplate, err := tx.GetTemplate(tpl.ID)
+		if _, notFound := err.(okinotes.NotInDatastoreError); notFound {
+			tpl.CreationDate = tpl.LastModificationDate
+		} else if err != nil {
+			return err
+		} else {
+			tpl.CreationDate = oldTemplate.CreationDate
+		}
+
+		return tx.putJSON(templatePath(tpl.ID), tpl)
+	})
+	return tpl.ID, err
+}
+func (repo repository) DeleteTemplate(templateID string) error {
+	return repo.update(func(tx repository) error {
+		tx.remove(templatePath(templateID))
+		return nil
+	})
+}
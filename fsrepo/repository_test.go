@@ -0,0 +1,97 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package fsrepo
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/okinotes/okinotes"
+	"github.com/okinotes/okinotes/repotest"
+)
+
+func TestRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) okinotes.Repository {
+		repo, err := NewRepository(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	})
+}
+
+func TestItemFile(t *testing.T) {
+	root := t.TempDir()
+	repo, err := NewRepository(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t0 := time.Date(2015, 3, 14, 15, 9, 26, 0, time.UTC)
+	in := okinotes.Item{
+		ID:                   "item01",
+		CreationDate:         t0,
+		LastModificationDate: t0.Add(time.Hour),
+		Kind:                 "todo",
+		Title:                "Title with \"quotes\": and colon",
+		Content:              "# Header\n\n---\nSome *markdown*\n",
+		URL:                  "http://example.com/",
+		Tags:                 okinotes.TagList{{"a:b", "multi\nline"}, {"status", "done"}},
+	}
+	if err := repo.StoreItem("user 01", "../page", in); err != nil {
+		t.Fatal(err)
+	}
+
+	//Names are escaped
+	path := filepath.Join(root, "users", "user%2001", "pages", "%2E.%2Fpage", "items", "item01.md")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := decodeItem(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out.HTMLContent = ""
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("decodeItem(encodeItem(%+v)) = %+v", in, out)
+	}
+}
+
+func TestJournalReplay(t *testing.T) {
+	root := t.TempDir()
+
+	//Simulate a commit interrupted after the journal was written
+	changes := []change{
+		{Path: userPath("user01"), Data: []byte(`{"Name": "user01"}`)},
+		{Path: userPath("user02"), Data: []byte(`{"Name": "user02"}`)},
+	}
+	b, err := json.Marshal(changes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(root, journalFileName), b, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := NewRepository(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"user01", "user02"} {
+		if found, _ := repo.FindUser(name); !found {
+			t.Errorf("FindUser(%s) = false after journal replay", name)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, journalFileName)); !os.IsNotExist(err) {
+		t.Errorf("journal still present after replay: %v", err)
+	}
+}
@@ -5,6 +5,7 @@
 package okinotes
 
 import (
+	"io"
 	"net/http"
 )
 
@@ -29,6 +30,22 @@ func (c templateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error
 	return allTemplates.ExecuteTemplate(w, c.Template, c.Data)
 }
 
+//snapshotHandler serves an archived page, which cannot load anything nor run scripts
+type snapshotHandler struct {
+	Content io.ReadCloser
+}
+
+func (c snapshotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	defer c.Content.Close()
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Content-Security-Policy", SnapshotPolicy)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	_, err := io.Copy(w, c.Content)
+	return err
+}
+
 type marshalHandler struct {
 	Marshal       func(v interface{}) ([]byte, error)
 	Data          interface{}
@@ -56,3 +73,35 @@ func (c marshalHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error
 	}
 	return nil
 }
+
+//streamHandler sends a file written while it is built, such as an archive
+type streamHandler struct {
+	Write         func(w io.Writer) error
+	MimeTypeValue string
+	FileNameValue string
+}
+
+func (c streamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) error {
+	return c.Write(&streamWriter{ResponseWriter: w, handler: c})
+}
+
+//streamWriter sets the headers of a streamHandler before the first bytes, so that
+//an error raised before anything is written is reported as usual
+type streamWriter struct {
+	http.ResponseWriter
+	handler streamHandler
+	started bool
+}
+
+func (s *streamWriter) Write(b []byte) (int, error) {
+	if !s.started {
+		s.started = true
+		if len(s.handler.MimeTypeValue) > 0 {
+			s.Header().Set("Content-Type", s.handler.MimeTypeValue)
+		}
+		if len(s.handler.FileNameValue) > 0 {
+			s.Header().Set("Content-Disposition", "attachment; filename=\""+s.handler.FileNameValue+"\";")
+		}
+	}
+	return s.ResponseWriter.Write(b)
+}
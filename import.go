@@ -0,0 +1,325 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+)
+
+//ImportReport describes the changes made by an import, or the changes it would make in a dry run
+type ImportReport struct {
+	Page          Page              `json:"page"`
+	DryRun        bool              `json:"dryRun"`
+	PageCreated   bool              `json:"pageCreated"`   //The page did not exist before the import
+	ItemsCreated  int               `json:"itemsCreated"`  //Items added to the page
+	ItemsReplaced int               `json:"itemsReplaced"` //Items of the page replaced by an item with the same ID
+	ItemsTrashed  int               `json:"itemsTrashed"`  //Items of the page missing from the import, moved to the trash
+	Errors        []ItemImportError `json:"errors"`
+}
+
+//ItemImportError describes why an item of an import is invalid
+type ItemImportError struct {
+	Index   int    `json:"index"` //Index of the item in the import, from 0
+	ItemID  string `json:"itemId"`
+	Message string `json:"message"`
+}
+
+func (err ItemImportError) Error() string {
+	return fmt.Sprintf("item %d (%s): %s", err.Index, err.ItemID, err.Message)
+}
+
+//ImportError is returned when some items of an import are invalid. Nothing is imported then.
+type ImportError struct {
+	Errors []ItemImportError `json:"errors"`
+}
+
+func (err ImportError) Error() string {
+	messages := make([]string, len(err.Errors))
+	for n, e := range err.Errors {
+		messages[n] = e.Error()
+	}
+	return fmt.Sprintf("Import rejected, %d invalid items: %s", len(err.Errors), strings.Join(messages, "; "))
+}
+
+//ImportPage creates the page userName/pageName, or replaces it if it exists, with the given
+//page settings and items, as exported in JSON. Items keep their IDs and dates. The items
+//of a replaced page missing from the import are moved to the trash.
+//Everything is validated first: if the page or any item is invalid nothing is imported, and
+//an ImportError reports each invalid item. In a dry run nothing is stored either, and the
+//report tells what the import would do.
+func (app App) ImportPage(userName, pageName string, page Page, items []Item, dryRun bool) (ImportReport, error) {
+	if err := app.checkScope(ScopeADMINPAGES, "Import page"); err != nil {
+		return ImportReport{}, err
+	}
+	if err := app.checkScope(ScopeWRITEITEMS, "Import page"); err != nil {
+		return ImportReport{}, err
+	}
+
+	_, err := app.repository.GetPage(userName, pageName)
+	switch err.(type) {
+	case nil:
+		err = app.checkRole(userName, pageName, RoleOWNER, "Import page")
+	case NotInDatastoreError:
+		err = app.checkMemberRole(userName, RoleEDITOR, "Import page")
+	}
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	//Validation
+	tNow := time.Now()
+	page.UserName = userName
+	page.Name = pageName
+	tpl, err := app.checkImportedPage(&page, tNow)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	report := ImportReport{Page: page, DryRun: dryRun}
+	items = append([]Item(nil), items...)
+	seen := make(map[string]bool)
+	for n := range items {
+		if err := checkImportedItem(&items[n], tpl, tNow); err != nil {
+			report.Errors = append(report.Errors, ItemImportError{n, items[n].ID, err.Error()})
+			continue
+		}
+		if seen[items[n].ID] {
+			report.Errors = append(report.Errors, ItemImportError{n, items[n].ID, "duplicate item ID"})
+		}
+		seen[items[n].ID] = true
+	}
+	if len(report.Errors) > 0 {
+		return report, ImportError{report.Errors}
+	}
+
+	//Items keep the order of the import when the page is ordered manually
+	if !validPositions(items) {
+		for n, p := range newPositions(len(items)) {
+			items[n].Position = p
+		}
+	}
+
+	err = app.repository.RunInTransaction(func(repo Repository) error {
+		//The counters are computed again when the transaction is retried
+		report.PageCreated = false
+		report.ItemsCreated = 0
+		report.ItemsReplaced = 0
+		report.ItemsTrashed = 0
+
+		oldItems := make(map[string]Item)
+		if _, err := repo.GetPage(userName, pageName); err == nil {
+			old, _, err := repo.GetItemsFromPage(userName, pageName, ItemOrderMODIFIED, -1, "")
+			if err != nil {
+				return err
+			}
+			for _, i := range old {
+				oldItems[i.ID] = i
+			}
+		} else if _, notFound := err.(NotInDatastoreError); notFound {
+			//The items of a deleted page are kept until it is purged
+			if _, trashed, err := findTrashedPage(repo, userName, pageName); err != nil {
+				return err
+			} else if trashed {
+				return DataError{"page name", "a deleted page with this name is in the trash"}
+			}
+			report.PageCreated = true
+		} else {
+			return err
+		}
+
+		for _, i := range items {
+			if _, found := oldItems[i.ID]; found {
+				report.ItemsReplaced++
+			} else {
+				report.ItemsCreated++
+			}
+		}
+		for id := range oldItems {
+			if !seen[id] {
+				report.ItemsTrashed++
+			}
+		}
+		if dryRun {
+			return nil
+		}
+
+		if err := repo.StorePage(page); err != nil {
+			return err
+		}
+		if err := repo.DeleteUsages(userName, pageName); err != nil {
+			return err
+		}
+		for _, t := range tpl.PageTags {
+			if imgID := page.Tags.Tag(t.Key); t.Kind == "imageId" && len(imgID) > 0 {
+				if err := repo.StoreUsage(userName, pageName, imgID); err != nil {
+					return err
+				}
+			}
+		}
+
+		for id, i := range oldItems {
+			if seen[id] {
+				continue
+			}
+			entry := TrashEntry{
+				ID:           generateID(),
+				UserName:     userName,
+				PageName:     pageName,
+				Kind:         TrashKindITEM,
+				DeletionDate: tNow,
+				DeletedBy:    app.CurrentUserName(),
+				Item:         i,
+			}
+			if err := repo.StoreTrashEntry(entry); err != nil {
+				return err
+			}
+			if err := repo.DeleteSearchDocument(userName, pageName, id); err != nil {
+				return err
+			}
+			if err := repo.DeleteItem(userName, pageName, id); err != nil {
+				return err
+			}
+		}
+
+		for _, i := range items {
+			var previous *Item
+			if old, found := oldItems[i.ID]; found {
+				previous = &old
+			}
+			if err := repo.StoreItem(userName, pageName, i); err != nil {
+				return err
+			}
+			if err := app.recordRevision(repo, userName, pageName, previous, i); err != nil {
+				return err
+			}
+			if err := repo.StoreSearchDocument(newSearchDocument(page, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return ImportReport{}, err
+	}
+	return report, nil
+}
+
+//checkImportedPage validates the settings of an imported page and sets the missing ones.
+//Returns the template of the page.
+func (app App) checkImportedPage(page *Page, tNow time.Time) (Template, error) {
+	if len(page.TemplateID) == 0 {
+		return Template{}, DataError{"template", "a template is required"}
+	}
+	tpl, err := app.repository.GetTemplate(page.TemplateID)
+	if _, notFound := err.(NotInDatastoreError); notFound {
+		return Template{}, DataError{"template", fmt.Sprintf("unknown template %s", page.TemplateID)}
+	}
+	if err != nil {
+		return Template{}, err
+	}
+
+	switch page.Policy {
+	case "":
+		page.Policy = PolicyPRIVATE
+	case PolicyPRIVATE, PolicyPUBLIC, PolicyUNLISTED:
+	default:
+		return Template{}, DataError{"policy", "must be one of PRIVATE, PUBLIC or UNLISTED"}
+	}
+	if len(page.ItemOrder) == 0 {
+		page.ItemOrder = ItemOrderMODIFIED
+	}
+	if err := checkItemOrder(page.ItemOrder); err != nil {
+		return Template{}, err
+	}
+
+	tags, err := checkImportedTags(page.Tags, tpl.PageTags)
+	if err != nil {
+		return Template{}, DataError{"page tags", err.Error()}
+	}
+	page.Tags = tags
+
+	if page.LastModificationDate.IsZero() {
+		page.LastModificationDate = tNow
+	}
+	if page.CreationDate.IsZero() || page.CreationDate.After(page.LastModificationDate) {
+		page.CreationDate = page.LastModificationDate
+	}
+	return tpl, nil
+}
+
+//checkImportedItem validates an imported item and sets its missing fields
+func checkImportedItem(i *Item, tpl Template, tNow time.Time) error {
+	if len(i.Content) == 0 && len(i.URL) == 0 {
+		return DataError{"item", "either content or URL must be provided"}
+	}
+
+	if len(i.ID) == 0 {
+		i.ID = generateID()
+	}
+	for _, r := range i.ID {
+		if !strings.ContainsRune(charsInID, r) {
+			return DataError{"item ID", "must contain only letters and digits"}
+		}
+	}
+
+	tags, err := checkImportedTags(i.Tags, tpl.ItemTags)
+	if err != nil {
+		return DataError{"item tags", err.Error()}
+	}
+	//The snapshots are uploads of the instance which took them
+	i.Tags = withoutSnapshot(tags)
+
+	switch {
+	case i.CreationDate.IsZero() && i.LastModificationDate.IsZero():
+		i.CreationDate = tNow
+		i.LastModificationDate = tNow
+	case i.CreationDate.IsZero():
+		i.CreationDate = i.LastModificationDate
+	case i.LastModificationDate.IsZero():
+		i.LastModificationDate = i.CreationDate
+	case i.LastModificationDate.Before(i.CreationDate):
+		return DataError{"item dates", "the item is modified before its creation"}
+	}
+
+	i.HTMLContent = template.HTML(markdownToHTML(i.Content))
+	return nil
+}
+
+//checkImportedTags verifies that the tags are described by the template, or hold the preview
+//of a link, and have distinct keys.
+//Returns the tags sorted by key.
+func checkImportedTags(tags TagList, descriptions TagDescriptionList) (TagList, error) {
+	var sorted TagList
+	for _, t := range tags {
+		//The preview of the URL of an item does not depend on the template
+		known := strings.HasPrefix(t.Key, linkTagPrefix)
+		for _, d := range descriptions {
+			if d.Key == t.Key {
+				known = true
+			}
+		}
+		if !known {
+			return nil, fmt.Errorf("tag %q is not defined by the template", t.Key)
+		}
+		if hasTag(sorted, t.Key) {
+			return nil, fmt.Errorf("tag %q is defined twice", t.Key)
+		}
+		sorted.SetTag(t.Key, t.Value)
+	}
+	return sorted, nil
+}
+
+//hasTag returns true if a tag list has the given key, even with an empty value
+func hasTag(tags TagList, key string) bool {
+	for _, t := range tags {
+		if t.Key == key {
+			return true
+		}
+	}
+	return false
+}
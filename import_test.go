@@ -0,0 +1,177 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/okinotes/okinotes"
+	"github.com/okinotes/okinotes/local"
+)
+
+func TestImportPage(t *testing.T) {
+	site := newTestSite(t, "user01")
+	site.Admin = true
+	repo, app := site.Repository, site.App("user01")
+	err := app.StoreTemplate(okinotes.Template{
+		ID:       "todolist",
+		PageTags: okinotes.TagDescriptionList{{Key: "title.color"}},
+		ItemTags: okinotes.TagDescriptionList{{Key: "status"}, {Key: "deadline"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	created := time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC)
+	modified := created.Add(time.Hour)
+	page := okinotes.Page{Title: "Imported", TemplateID: "todolist", Tags: okinotes.TagList{{"title.color", "#ff0000"}}}
+	items := []okinotes.Item{
+		{ID: "item1", CreationDate: created, LastModificationDate: modified, Content: "first", Tags: okinotes.TagList{{"status", "done"}}},
+		{ID: "item2", CreationDate: created, LastModificationDate: created, Content: "second"},
+	}
+
+	//A dry run stores nothing
+	report, err := app.ImportPage("user01", "page01", page, items, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.PageCreated || report.ItemsCreated != 2 {
+		t.Errorf("Dry run report = %+v, wanted the page and 2 items created", report)
+	}
+	if _, err := repo.GetPage("user01", "page01"); err == nil {
+		t.Errorf("Page stored by a dry run")
+	}
+
+	//The page is created with the items, keeping their IDs and dates
+	if _, err := app.ImportPage("user01", "page01", page, items, false); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := app.GetPage("user01", "page01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.Title != "Imported" || stored.Policy != okinotes.PolicyPRIVATE || stored.Tags.Tag("title.color") != "#ff0000" {
+		t.Errorf("Page = %+v after import", stored)
+	}
+	item, err := repo.GetItem("user01", "page01", "item1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !item.CreationDate.Equal(created) || !item.LastModificationDate.Equal(modified) || item.Tags.Tag("status") != "done" {
+		t.Errorf("Item = %+v after import, wanted its dates and tags kept", item)
+	}
+
+	//Invalid items are all reported, and nothing is imported
+	invalid := []okinotes.Item{
+		{ID: "item1", Content: "changed"},
+		{ID: "item3"},
+		{ID: "item4", Content: "unknown tag", Tags: okinotes.TagList{{"color", "red"}}},
+		{ID: "item1", Content: "duplicate"},
+		{ID: "../item", Content: "bad ID"},
+	}
+	report, err = app.ImportPage("user01", "page01", page, invalid, false)
+	importErr, ok := err.(okinotes.ImportError)
+	if !ok {
+		t.Fatalf("ImportPage error = %v, wanted an ImportError", err)
+	}
+	if len(importErr.Errors) != 4 || importErr.Errors[0].Index != 1 || importErr.Errors[2].Index != 3 {
+		t.Errorf("Errors = %+v, wanted items 1, 2, 3 and 4", importErr.Errors)
+	}
+	if len(report.Errors) != 4 {
+		t.Errorf("Report = %+v, wanted the invalid items", report)
+	}
+	if item, _ := repo.GetItem("user01", "page01", "item1"); item.Content != "first" {
+		t.Errorf("Item changed by a rejected import: %+v", item)
+	}
+
+	if _, err := app.ImportPage("user01", "page02", okinotes.Page{TemplateID: "unknown"}, nil, false); err == nil {
+		t.Errorf("ImportPage succeeded with an unknown template")
+	}
+	if _, err := app.ImportPage("user01", "page02", okinotes.Page{TemplateID: "todolist", Tags: okinotes.TagList{{"background", "img"}}}, nil, false); err == nil {
+		t.Errorf("ImportPage succeeded with a tag unknown to the template")
+	}
+
+	//Replacing the page moves the missing items to the trash
+	report, err = app.ImportPage("user01", "page01", page, []okinotes.Item{
+		{ID: "item1", CreationDate: created, LastModificationDate: modified, Content: "changed"},
+		{Content: "new"},
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.PageCreated || report.ItemsCreated != 1 || report.ItemsReplaced != 1 || report.ItemsTrashed != 1 {
+		t.Errorf("Report = %+v, wanted 1 item created, 1 replaced and 1 trashed", report)
+	}
+	trash, err := app.Trash("user01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].Item.ID != "item2" {
+		t.Errorf("Trash = %+v, wanted item2", trash)
+	}
+	revisions, err := app.ItemRevisions("user01", "page01", "item1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 {
+		t.Errorf("%d revisions of the replaced item, wanted 2", len(revisions))
+	}
+}
+
+func TestImportPageAPI(t *testing.T) {
+	site := newTestSite(t, "user01")
+	site.Admin = true
+	if err := site.App("user01").StoreTemplate(okinotes.Template{ID: "notes"}); err != nil {
+		t.Fatal(err)
+	}
+	f := local.AppFactory{
+		Repository: site.Repository,
+		UserInteractor: func(r *http.Request) okinotes.UserInteractor {
+			return local.SingleUser{Ident: okinotes.Ident{"local", "user01"}}
+		},
+		LogInteractor: local.NewLogger(local.LevelCritical),
+	}
+	m := mux.NewRouter()
+	if err := okinotes.RegisterAPIOnRouter(m, f); err != nil {
+		t.Fatal(err)
+	}
+
+	//The invalid items are returned with the status 400
+	body := `{"Page": {"Title": "Imported", "TemplateID": "notes"}, "Items": [{"ID": "item1", "Content": "valid"}, {"ID": "item2"}]}`
+	r := httptest.NewRequest("POST", "/users/user01/pages/page01/import", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Import of an invalid item: status %d, wanted %d", w.Code, http.StatusBadRequest)
+	}
+	var importErr okinotes.ImportError
+	if err := json.NewDecoder(w.Body).Decode(&importErr); err != nil {
+		t.Fatal(err)
+	}
+	if len(importErr.Errors) != 1 || importErr.Errors[0].ItemID != "item2" {
+		t.Errorf("Errors = %+v, wanted the error of item2", importErr.Errors)
+	}
+	if _, err := site.Repository.GetPage("user01", "page01"); err == nil {
+		t.Errorf("Page created by a rejected import")
+	}
+
+	//So are the other invalid data
+	r = httptest.NewRequest("GET", "/pages?limit=none", nil)
+	w = httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Listing with an invalid limit: status %d, wanted %d", w.Code, http.StatusBadRequest)
+	}
+	var dataErr okinotes.DataError
+	if err := json.NewDecoder(w.Body).Decode(&dataErr); err != nil || dataErr.Field != "limit" {
+		t.Errorf("Error = %+v, %v, wanted the invalid limit", dataErr, err)
+	}
+}
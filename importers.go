@@ -0,0 +1,401 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/tools/blog/atom"
+)
+
+//Formats of the files imported as a page
+const (
+	//ImportFormatJSON is the JSON export of a page
+	ImportFormatJSON = "json"
+	//ImportFormatBOOKMARKS is a bookmarks file in the Netscape format, exported by the browsers
+	ImportFormatBOOKMARKS = "bookmarks"
+	//ImportFormatFEED is an Atom or RSS 2.0 feed
+	ImportFormatFEED = "feed"
+	//ImportFormatOPML is an outline in the OPML format
+	ImportFormatOPML = "opml"
+	//ImportFormatMARKDOWN is a zip of Markdown files
+	ImportFormatMARKDOWN = "markdown"
+)
+
+//maxImportSize is the maximum size in bytes of an imported file, and of the files uncompressed from a zip
+const maxImportSize = 10000000
+
+//Templates of the pages read from other applications
+const (
+	bookmarksTemplateID = urlListTemplateID
+	notesTemplateID     = "blog2col"
+)
+
+//ParseImport reads a page and its items from a file in one of the import formats.
+//The result is given to ImportPage, which validates it.
+func ParseImport(format string, data []byte) (Page, []Item, error) {
+	if len(data) > maxImportSize {
+		return Page{}, nil, DataError{"file", fmt.Sprintf("must be smaller than %d bytes", maxImportSize)}
+	}
+
+	switch format {
+	case "", ImportFormatJSON:
+		var content pageJSONData
+		if err := json.Unmarshal(data, &content); err != nil {
+			return Page{}, nil, DataError{"file", err.Error()}
+		}
+		return content.Page, content.Items, nil
+	case ImportFormatBOOKMARKS:
+		return parseBookmarks(data)
+	case ImportFormatFEED:
+		return parseFeed(data)
+	case ImportFormatOPML:
+		return parseOPML(data)
+	case ImportFormatMARKDOWN:
+		return parseMarkdownZip(data)
+	}
+	return Page{}, nil, DataError{"format", "must be one of json, bookmarks, feed, opml or markdown"}
+}
+
+//parseBookmarks reads a Netscape bookmarks file. Each bookmark is a link item, whose
+//source is the path of its folder.
+func parseBookmarks(data []byte) (Page, []Item, error) {
+	page := Page{TemplateID: bookmarksTemplateID}
+	var items []Item
+
+	var folders []string
+	var folder string //Name of the last folder, opened by the next list
+	var text *string  //Text being read, if any
+	var description bool
+
+	z := html.NewTokenizer(bytes.NewReader(data))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			if len(items) == 0 && len(page.Title) == 0 {
+				return Page{}, nil, DataError{"file", "not a bookmarks file"}
+			}
+			page.Title = strings.TrimSpace(page.Title)
+			return page, items, nil
+
+		case html.TextToken:
+			if text != nil {
+				*text += string(z.Text())
+			}
+
+		case html.StartTagToken:
+			tag := z.Token()
+			text = nil
+			description = false
+			switch tag.Data {
+			case "title", "h1":
+				page.Title = ""
+				text = &page.Title
+			case "h3":
+				folder = ""
+				text = &folder
+			case "dl":
+				folders = append(folders, strings.TrimSpace(folder))
+				folder = ""
+			case "a":
+				i := Item{Kind: ItemKindLINK, Source: strings.Trim(strings.Join(folders, "/"), "/")}
+				for _, a := range tag.Attr {
+					switch a.Key {
+					case "href":
+						i.URL = a.Val
+					case "add_date":
+						i.CreationDate = unixDate(a.Val)
+					case "last_modified":
+						i.LastModificationDate = unixDate(a.Val)
+					}
+				}
+				if i.LastModificationDate.Before(i.CreationDate) {
+					i.LastModificationDate = i.CreationDate
+				}
+				items = append(items, i)
+				text = &items[len(items)-1].Title
+			case "dd":
+				if len(items) > 0 {
+					description = true
+					text = &items[len(items)-1].Content
+				}
+			}
+
+		case html.EndTagToken:
+			tag, _ := z.TagName()
+			switch string(tag) {
+			case "dl":
+				if len(folders) > 0 {
+					folders = folders[:len(folders)-1]
+				}
+			case "dd", "dt":
+			default:
+				//The description of a bookmark ends with the next tag
+				if !description {
+					text = nil
+				}
+			}
+			if len(items) > 0 {
+				last := &items[len(items)-1]
+				last.Title = strings.TrimSpace(last.Title)
+				last.Content = strings.TrimSpace(last.Content)
+			}
+		}
+	}
+}
+
+//unixDate converts a date in seconds since 1970, as written in bookmarks files
+func unixDate(s string) time.Time {
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(n, 0).UTC()
+}
+
+//rssFeed is an RSS 2.0 document
+type rssFeed struct {
+	XMLName xml.Name `xml:"rss"`
+	Channel struct {
+		Title string `xml:"title"`
+		Item  []struct {
+			Title       string `xml:"title"`
+			Link        string `xml:"link"`
+			Description string `xml:"description"`
+			Author      string `xml:"author"`
+			Creator     string `xml:"http://purl.org/dc/elements/1.1/ creator"`
+			PubDate     string `xml:"pubDate"`
+		} `xml:"item"`
+	} `xml:"channel"`
+}
+
+//parseFeed reads an Atom or RSS 2.0 feed. Each entry is a blog item.
+func parseFeed(data []byte) (Page, []Item, error) {
+	page := Page{TemplateID: notesTemplateID}
+	var items []Item
+
+	var feed atom.Feed
+	if err := xml.Unmarshal(data, &feed); err == nil {
+		page.Title = feed.Title
+		for _, e := range feed.Entry {
+			i := Item{Kind: ItemKindNOTE, Title: e.Title}
+			for _, l := range e.Link {
+				if l.Rel == "" || l.Rel == "alternate" {
+					i.URL = l.Href
+				}
+			}
+			for _, t := range []*atom.Text{e.Summary, e.Content} {
+				if t != nil && len(strings.TrimSpace(t.Body)) > 0 {
+					i.Content = strings.TrimSpace(t.Body)
+				}
+			}
+			if e.Author != nil {
+				i.Source = e.Author.Name
+			} else if feed.Author != nil {
+				i.Source = feed.Author.Name
+			}
+			i.CreationDate, _ = time.Parse(time.RFC3339, string(e.Published))
+			i.LastModificationDate, _ = time.Parse(time.RFC3339, string(e.Updated))
+			items = append(items, i)
+		}
+		return page, items, nil
+	}
+
+	var rss rssFeed
+	if err := xml.Unmarshal(data, &rss); err != nil {
+		return Page{}, nil, DataError{"file", "not an Atom or RSS feed"}
+	}
+	page.Title = rss.Channel.Title
+	for _, e := range rss.Channel.Item {
+		i := Item{
+			Kind:    ItemKindNOTE,
+			Title:   e.Title,
+			URL:     strings.TrimSpace(e.Link),
+			Content: strings.TrimSpace(e.Description),
+			Source:  e.Creator,
+		}
+		if len(i.Source) == 0 {
+			i.Source = e.Author
+		}
+		i.CreationDate = rssDate(e.PubDate)
+		items = append(items, i)
+	}
+	return page, items, nil
+}
+
+//rssDate parses the dates of RSS feeds, written as in RFC 822 with a few variations
+func rssDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range []string{time.RFC1123Z, time.RFC1123, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", time.RFC822Z, time.RFC822} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+//opmlOutline is an element of an OPML outline, with its children
+type opmlOutline struct {
+	Text     string        `xml:"text,attr"`
+	Title    string        `xml:"title,attr"`
+	Note     string        `xml:"_note,attr"`
+	URL      string        `xml:"url,attr"`
+	HTMLURL  string        `xml:"htmlUrl,attr"`
+	XMLURL   string        `xml:"xmlUrl,attr"`
+	Outlines []opmlOutline `xml:"outline"`
+}
+
+//parseOPML reads an OPML outline. Each top-level element is a note, whose content
+//is the list of its children, nested as in the outline.
+func parseOPML(data []byte) (Page, []Item, error) {
+	var opml struct {
+		XMLName xml.Name      `xml:"opml"`
+		Title   string        `xml:"head>title"`
+		Body    []opmlOutline `xml:"body>outline"`
+	}
+	if err := xml.Unmarshal(data, &opml); err != nil {
+		return Page{}, nil, DataError{"file", "not an OPML outline"}
+	}
+
+	page := Page{Title: opml.Title, TemplateID: notesTemplateID}
+	var items []Item
+	for _, o := range opml.Body {
+		i := Item{Kind: ItemKindNOTE, Title: o.text(), URL: o.url()}
+
+		var b bytes.Buffer
+		if len(o.Note) > 0 {
+			b.WriteString(o.Note + "\n\n")
+		}
+		writeOutlines(&b, o.Outlines, 0)
+		i.Content = strings.TrimSpace(b.String())
+
+		//A single line is the content of the note
+		if len(i.Content) == 0 && len(i.URL) == 0 {
+			i.Title, i.Content = "", i.Title
+		}
+		items = append(items, i)
+	}
+	return page, items, nil
+}
+
+//text returns the text of an element of an outline
+func (o opmlOutline) text() string {
+	if len(o.Text) > 0 {
+		return o.Text
+	}
+	return o.Title
+}
+
+//url returns the link of an element of an outline, if any
+func (o opmlOutline) url() string {
+	for _, u := range []string{o.HTMLURL, o.URL, o.XMLURL} {
+		if len(u) > 0 {
+			return u
+		}
+	}
+	return ""
+}
+
+//writeOutlines writes elements of an outline as a nested Markdown list
+func writeOutlines(b *bytes.Buffer, outlines []opmlOutline, depth int) {
+	for _, o := range outlines {
+		line := markdownLine(o.text())
+		if u := o.url(); len(u) > 0 {
+			line = fmt.Sprintf("[%s](%s)", escapeLinkText(line), markdownURL(u))
+		}
+		fmt.Fprintf(b, "%s- %s\n", strings.Repeat("  ", depth), line)
+		writeOutlines(b, o.Outlines, depth+1)
+	}
+}
+
+//parseMarkdownZip reads a zip of Markdown files. Each file is a note, titled by its first
+//heading or by its name, whose source is the folder of the file in the zip.
+func parseMarkdownZip(data []byte) (Page, []Item, error) {
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return Page{}, nil, DataError{"file", "not a zip file"}
+	}
+
+	files := append([]*zip.File(nil), z.File...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	page := Page{TemplateID: notesTemplateID}
+	var items []Item
+	var size uint64
+	for _, f := range files {
+		switch strings.ToLower(path.Ext(f.Name)) {
+		case ".md", ".markdown", ".txt":
+		default:
+			continue
+		}
+		size += f.UncompressedSize64
+		if size > maxImportSize {
+			return Page{}, nil, DataError{"file", fmt.Sprintf("the uncompressed files must be smaller than %d bytes", maxImportSize)}
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return Page{}, nil, DataError{"file", err.Error()}
+		}
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return Page{}, nil, DataError{"file", err.Error()}
+		}
+
+		name := path.Base(f.Name)
+		folder := path.Dir(f.Name)
+		if folder == "." {
+			folder = ""
+		}
+		i := Item{
+			Kind:                 ItemKindNOTE,
+			Title:                strings.TrimSuffix(name, path.Ext(name)),
+			Content:              strings.TrimSpace(string(content)),
+			Source:               folder,
+			LastModificationDate: f.Modified,
+		}
+
+		//A front matter or a first heading overrides the title
+		if rest := strings.TrimPrefix(i.Content, frontMatterDelimiter+"\n"); rest != i.Content {
+			if end := strings.Index(rest, "\n"+frontMatterDelimiter+"\n"); end >= 0 {
+				for _, line := range strings.Split(rest[:end], "\n") {
+					if value := strings.TrimPrefix(line, "title:"); value != line {
+						if t, err := strconv.Unquote(strings.TrimSpace(value)); err == nil {
+							i.Title = t
+						} else {
+							i.Title = strings.TrimSpace(value)
+						}
+					}
+				}
+				i.Content = strings.TrimSpace(rest[end+len(frontMatterDelimiter)+2:])
+			}
+		}
+		if strings.HasPrefix(i.Content, "# ") {
+			heading := strings.SplitN(i.Content, "\n", 2)
+			i.Title = strings.TrimSpace(heading[0][2:])
+			i.Content = ""
+			if len(heading) > 1 {
+				i.Content = strings.TrimSpace(heading[1])
+			}
+		}
+		items = append(items, i)
+	}
+	if len(items) == 0 {
+		return Page{}, nil, DataError{"file", "the zip contains no Markdown file"}
+	}
+	return page, items, nil
+}
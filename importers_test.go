@@ -0,0 +1,164 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/okinotes/okinotes"
+)
+
+const bookmarks = `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+    <DT><A HREF="http://golang.org/" ADD_DATE="1420070400">The Go Programming Language</A>
+    <DD>Go &amp; more
+    <DT><H3 ADD_DATE="1420070400">Tools</H3>
+    <DL><p>
+        <DT><A HREF="http://github.com/" ADD_DATE="1420070400" LAST_MODIFIED="1420156800">GitHub</A>
+    </DL><p>
+    <DT><A HREF="http://example.com/">Example</A>
+</DL><p>
+`
+
+const atomFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+  <title>Blog</title>
+  <author><name>Alice</name></author>
+  <entry>
+    <title>First post</title>
+    <link href="http://example.com/1"/>
+    <published>2015-01-01T10:00:00Z</published>
+    <updated>2015-01-02T10:00:00Z</updated>
+    <content type="html">&lt;p&gt;Hello&lt;/p&gt;</content>
+  </entry>
+</feed>
+`
+
+const rssFeed = `<?xml version="1.0"?>
+<rss version="2.0" xmlns:dc="http://purl.org/dc/elements/1.1/">
+  <channel>
+    <title>News</title>
+    <item>
+      <title>Story</title>
+      <link>http://example.com/story</link>
+      <description>A story</description>
+      <dc:creator>Bob</dc:creator>
+      <pubDate>Thu, 1 Jan 2015 10:00:00 +0000</pubDate>
+    </item>
+  </channel>
+</rss>
+`
+
+const opml = `<?xml version="1.0"?>
+<opml version="2.0">
+  <head><title>Outline</title></head>
+  <body>
+    <outline text="Projects">
+      <outline text="Okinotes" htmlUrl="http://example.com/okinotes">
+        <outline text="Import"/>
+      </outline>
+    </outline>
+    <outline text="A single line"/>
+  </body>
+</opml>
+`
+
+func TestParseImport(t *testing.T) {
+	day := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	page, items, err := okinotes.ParseImport(okinotes.ImportFormatBOOKMARKS, []byte(bookmarks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Title != "Bookmarks" || page.TemplateID != "urllist" || len(items) != 3 {
+		t.Fatalf("Bookmarks = %+v, %+v", page, items)
+	}
+	if items[0].URL != "http://golang.org/" || items[0].Title != "The Go Programming Language" || items[0].Content != "Go & more" || !items[0].CreationDate.Equal(day) {
+		t.Errorf("Bookmark = %+v", items[0])
+	}
+	if items[1].Source != "Tools" || !items[1].LastModificationDate.Equal(day.AddDate(0, 0, 1)) || items[2].Source != "" {
+		t.Errorf("Bookmarks = %+v, wanted the second one in the Tools folder", items)
+	}
+
+	page, items, err = okinotes.ParseImport(okinotes.ImportFormatFEED, []byte(atomFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Title != "Blog" || len(items) != 1 {
+		t.Fatalf("Atom feed = %+v, %+v", page, items)
+	}
+	if i := items[0]; i.Title != "First post" || i.URL != "http://example.com/1" || i.Content != "<p>Hello</p>" || i.Source != "Alice" || !i.CreationDate.Equal(day.Add(10*time.Hour)) {
+		t.Errorf("Atom entry = %+v", i)
+	}
+
+	page, items, err = okinotes.ParseImport(okinotes.ImportFormatFEED, []byte(rssFeed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Title != "News" || len(items) != 1 {
+		t.Fatalf("RSS feed = %+v, %+v", page, items)
+	}
+	if i := items[0]; i.Title != "Story" || i.URL != "http://example.com/story" || i.Source != "Bob" || !i.CreationDate.Equal(day.Add(10*time.Hour)) {
+		t.Errorf("RSS item = %+v", i)
+	}
+
+	page, items, err = okinotes.ParseImport(okinotes.ImportFormatOPML, []byte(opml))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Title != "Outline" || len(items) != 2 {
+		t.Fatalf("Outline = %+v, %+v", page, items)
+	}
+	if want := "- [Okinotes](http://example.com/okinotes)\n  - Import"; items[0].Title != "Projects" || items[0].Content != want {
+		t.Errorf("Outline note = %+v, wanted content %q", items[0], want)
+	}
+	if items[1].Title != "" || items[1].Content != "A single line" {
+		t.Errorf("Outline note = %+v, wanted the text as content", items[1])
+	}
+
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for name, content := range map[string]string{
+		"notes/b.md":   "# Heading\n\nBody of b",
+		"a.md":         "---\ntitle: \"From front matter\"\n---\n\nBody of a",
+		"image.png":    "not a note",
+		"notes/c.text": "not a note either",
+	} {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte(content))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_, items, err = okinotes.ParseImport(okinotes.ImportFormatMARKDOWN, b.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 {
+		t.Fatalf("Markdown notes = %+v, wanted 2", items)
+	}
+	if items[0].Title != "From front matter" || items[0].Content != "Body of a" {
+		t.Errorf("Markdown note = %+v", items[0])
+	}
+	if items[1].Title != "Heading" || items[1].Content != "Body of b" || items[1].Source != "notes" {
+		t.Errorf("Markdown note = %+v", items[1])
+	}
+
+	if _, _, err := okinotes.ParseImport(okinotes.ImportFormatOPML, []byte(bookmarks)); err == nil {
+		t.Errorf("ParseImport succeeded with bookmarks given as an outline")
+	}
+	if _, _, err := okinotes.ParseImport("csv", nil); err == nil {
+		t.Errorf("ParseImport succeeded with an unknown format")
+	}
+}
@@ -5,7 +5,9 @@
 package okinotes
 
 import (
+	"io"
 	"net/http"
+	"time"
 )
 
 //UserInteractor allows interactions with the User connected to the application
@@ -23,6 +25,25 @@ type UploadInteractor interface {
 	UploadInfo(req *http.Request, name string) (UploadInfo, error)
 	ImageURL(key string, secure bool, size int) (string, error)
 	Delete(key string) error
+
+	Open(key string) (io.ReadCloser, error)                                     //Reads the content of an uploaded file
+	Store(filename string, contentType string, r io.Reader) (UploadInfo, error) //Uploads a file from the application itself
+}
+
+//LinkInteractor allows previewing the web pages linked by the items
+type LinkInteractor interface {
+	Preview(url string) (LinkPreview, error)
+}
+
+//LinkChecker requests the URLs of the items, to find the broken links
+type LinkChecker interface {
+	Check(url string) LinkStatus //The date of the check is set by the caller
+}
+
+//LinkFetcher downloads the pages linked by the items and their assets, to archive them.
+//Fetch fails if the document is larger than maxBytes, or if it is not downloaded by the deadline.
+type LinkFetcher interface {
+	Fetch(url string, maxBytes int, deadline time.Time) (WebDocument, error)
 }
 
 //LogInteractor allows logging of application messages
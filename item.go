@@ -5,7 +5,9 @@
 package okinotes
 
 import (
+	"fmt"
 	"html/template"
+	"strings"
 	"time"
 )
 
@@ -16,6 +18,7 @@ type Item struct {
 
 	CreationDate         time.Time `json:"creationDate"`
 	LastModificationDate time.Time `json:"lastModificationDate"`
+	Position             string    `json:"position"` //Sort key of the item when the page is ordered manually
 
 	Kind        string        `json:"kind"`
 	Title       string        `json:"title"`
@@ -27,8 +30,62 @@ type Item struct {
 	Tags TagList `json:"tags"`
 }
 
-//Compute a markdown representation of the Item
+//Kinds of items
+const (
+	//ItemKindNOTE is a text, with an optional title
+	ItemKindNOTE = "note"
+	//ItemKindLINK is a bookmark: the URL, its title and a description in the content
+	ItemKindLINK = "link"
+	//ItemKindTODO is a task, done when its "status" tag is "done"
+	ItemKindTODO = "todo"
+	//ItemKindIMAGE is a picture located by the URL, with a caption in the content
+	ItemKindIMAGE = "image"
+)
+
+//String computes a markdown representation of the Item, depending on its kind.
+//Items of an unknown kind are written as notes.
 func (i Item) String() string {
-	//TODO: return should depends on i.Kind
-	return i.Title
+	var blocks []string
+	title := markdownLine(i.Title)
+
+	switch i.Kind {
+	case ItemKindLINK:
+		text := title
+		if len(text) == 0 {
+			text = markdownLine(i.URL)
+		}
+		blocks = append(blocks, fmt.Sprintf("[%s](%s)", escapeLinkText(text), markdownURL(i.URL)))
+	case ItemKindTODO:
+		check := " "
+		if i.Tags.Tag("status") == "done" {
+			check = "x"
+		}
+		task := fmt.Sprintf("- [%s] %s", check, title)
+		if deadline := i.Tags.Tag("deadline"); len(deadline) > 0 {
+			task += fmt.Sprintf(" (deadline: %s)", markdownLine(deadline))
+		}
+		blocks = append(blocks, task)
+	case ItemKindIMAGE:
+		blocks = append(blocks, fmt.Sprintf("![%s](%s)", escapeLinkText(title), markdownURL(i.URL)))
+	default:
+		if len(title) > 0 {
+			blocks = append(blocks, "## "+title)
+		}
+	}
+
+	if content := strings.TrimSpace(i.Content); len(content) > 0 {
+		if i.Kind == ItemKindTODO {
+			//The details of a task belong to its list item
+			content = "  " + strings.Replace(content, "\n", "\n  ", -1)
+		}
+		blocks = append(blocks, content)
+	}
+	if len(i.URL) > 0 && i.Kind != ItemKindLINK && i.Kind != ItemKindIMAGE {
+		blocks = append(blocks, "<"+i.URL+">")
+	}
+	if len(i.Source) > 0 {
+		blocks = append(blocks, "*Source: "+markdownLine(i.Source)+"*")
+	}
+
+	return strings.Join(blocks, "\n\n")
 }
@@ -0,0 +1,225 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//urlListTemplateID is the template of the pages whose links are checked
+const urlListTemplateID = "urllist"
+
+//Tags of the items holding the result of the last check of their URL.
+//They start with linkTagPrefix so that they are dropped when the URL changes.
+const (
+	linkTagSTATUS   = linkTagPrefix + "status"   //HTTP status code, 0 if the server could not be reached
+	linkTagREDIRECT = linkTagPrefix + "redirect" //Final URL, when the link is redirected
+	linkTagERROR    = linkTagPrefix + "error"    //Reason why the server could not be reached
+	linkTagCHECKED  = linkTagPrefix + "checked"  //Date of the check, in RFC 3339 format
+)
+
+//LinkStatus is the result of the check of a URL
+type LinkStatus struct {
+	StatusCode  int       `json:"statusCode"` //0 if the server could not be reached
+	RedirectURL string    `json:"redirectURL,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	Checked     time.Time `json:"checked"`
+}
+
+//Broken returns true if the link leads to an error
+func (s LinkStatus) Broken() bool {
+	return len(s.Error) > 0 || s.StatusCode == 0 || s.StatusCode >= 400
+}
+
+//LinkStatus returns the result of the last check of the URL of the item,
+//or false if it was never checked
+func (i Item) LinkStatus() (LinkStatus, bool) {
+	checked, err := time.Parse(time.RFC3339, i.Tags.Tag(linkTagCHECKED))
+	if err != nil {
+		return LinkStatus{}, false
+	}
+	code, _ := strconv.Atoi(i.Tags.Tag(linkTagSTATUS))
+	return LinkStatus{
+		StatusCode:  code,
+		RedirectURL: i.Tags.Tag(linkTagREDIRECT),
+		Error:       i.Tags.Tag(linkTagERROR),
+		Checked:     checked,
+	}, true
+}
+
+//BrokenLink returns true if the last check of the URL of the item failed
+func (i Item) BrokenLink() bool {
+	s, checked := i.LinkStatus()
+	return checked && s.Broken()
+}
+
+//setLinkStatus replaces the result of the previous check of the URL of the item
+func setLinkStatus(i *Item, s LinkStatus) {
+	var tags TagList
+	for _, t := range i.Tags {
+		switch t.Key {
+		case linkTagSTATUS, linkTagREDIRECT, linkTagERROR, linkTagCHECKED:
+		default:
+			tags = append(tags, t)
+		}
+	}
+	i.Tags = tags
+
+	i.Tags.SetTag(linkTagSTATUS, strconv.Itoa(s.StatusCode))
+	i.Tags.SetTag(linkTagCHECKED, s.Checked.UTC().Format(time.RFC3339))
+	if len(s.RedirectURL) > 0 {
+		i.Tags.SetTag(linkTagREDIRECT, s.RedirectURL)
+	}
+	if len(s.Error) > 0 {
+		i.Tags.SetTag(linkTagERROR, s.Error)
+	}
+}
+
+//FetchLinkStatus requests an HTTP or HTTPS URL with the given client, following the redirects.
+//The client is responsible for restricting the addresses it connects to.
+//The date of the check is not set.
+func FetchLinkStatus(client *http.Client, rawurl string) LinkStatus {
+	u, err := checkPreviewURL(rawurl)
+	if err != nil {
+		return LinkStatus{Error: err.Error()}
+	}
+
+	c := fetchClient(client)
+	resp, err := fetchLink(c, "HEAD", u.String())
+	if err == nil && (resp.StatusCode == http.StatusMethodNotAllowed || resp.StatusCode == http.StatusNotImplemented) {
+		//Some servers only answer to GET
+		resp, err = fetchLink(c, "GET", u.String())
+	}
+	if err != nil {
+		return LinkStatus{Error: err.Error()}
+	}
+
+	s := LinkStatus{StatusCode: resp.StatusCode}
+	if final := resp.Request.URL.String(); final != u.String() {
+		s.RedirectURL = final
+	}
+	return s
+}
+
+//fetchLink sends a request without reading the body of the response
+func fetchLink(c *http.Client, method string, url string) (*http.Response, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "okinotes link checker")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	return resp, nil
+}
+
+//CheckLinks checks the URLs of the items of the URL list pages which were not checked since
+//the given date, and stores the result in the tags of the items. At most limit links are
+//checked when limit is positive. It is meant to be run periodically by a background job.
+//Returns the number of checked links.
+func CheckLinks(repo Repository, checker LinkChecker, before time.Time, limit int) (int, error) {
+	pages, _, err := repo.NewPageQuery().Filter("TemplateID =", urlListTemplateID).GetAll()
+	if err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, page := range pages {
+		items, _, err := repo.GetItemsFromPage(page.UserName, page.Name, ItemOrderMODIFIED, -1, "")
+		if err != nil {
+			return n, err
+		}
+
+		for _, i := range items {
+			if len(strings.TrimSpace(i.URL)) == 0 {
+				continue
+			}
+			if s, checked := i.LinkStatus(); checked && s.Checked.After(before) {
+				continue
+			}
+			if limit > 0 && n >= limit {
+				return n, nil
+			}
+
+			s := checker.Check(i.URL)
+			s.Checked = time.Now()
+			n++
+
+			err := repo.RunInTransaction(func(repo Repository) error {
+				current, err := repo.GetItem(page.UserName, page.Name, i.ID)
+				if err != nil {
+					return err
+				}
+				if current.URL != i.URL {
+					return nil //Changed during the check
+				}
+				setLinkStatus(&current, s)
+				return repo.StoreItem(page.UserName, page.Name, current)
+			})
+			if _, deleted := err.(NotInDatastoreError); err != nil && !deleted {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+//LinkReport lists the broken links of a page
+type LinkReport struct {
+	Page      Page         `json:"page"`
+	Checked   int          `json:"checked"`   //Number of links checked at least once
+	Unchecked int          `json:"unchecked"` //Number of links not checked yet
+	Broken    []BrokenLink `json:"broken"`
+}
+
+//BrokenLink is an item whose link is broken
+type BrokenLink struct {
+	Item   Item       `json:"item"`
+	Status LinkStatus `json:"status"`
+}
+
+//LinkReport returns the broken links found by the last checks of the items of a page.
+//It is restricted to the owners of the page.
+func (app App) LinkReport(userName, pageName string) (LinkReport, error) {
+	if err := app.checkScope(ScopeREAD, "Report broken links"); err != nil {
+		return LinkReport{}, err
+	}
+	if err := app.checkRole(userName, pageName, RoleOWNER, "Report broken links"); err != nil {
+		return LinkReport{}, err
+	}
+
+	page, err := app.repository.GetPage(userName, pageName)
+	if err != nil {
+		return LinkReport{}, err
+	}
+	items, _, err := app.listItems(page, -1, "")
+	if err != nil {
+		return LinkReport{}, err
+	}
+
+	report := LinkReport{Page: page, Broken: []BrokenLink{}}
+	for _, i := range items {
+		if len(strings.TrimSpace(i.URL)) == 0 {
+			continue
+		}
+		s, checked := i.LinkStatus()
+		if !checked {
+			report.Unchecked++
+			continue
+		}
+		report.Checked++
+		if s.Broken() {
+			report.Broken = append(report.Broken, BrokenLink{i, s})
+		}
+	}
+	return report, nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/okinotes/okinotes"
+)
+
+//testLinkChecker returns the status of the URLs from a fixed list, and counts the checks
+type testLinkChecker struct {
+	statuses map[string]okinotes.LinkStatus
+	checks   int
+}
+
+func (c *testLinkChecker) Check(url string) okinotes.LinkStatus {
+	c.checks++
+	s, found := c.statuses[url]
+	if !found {
+		return okinotes.LinkStatus{Error: "no such host"}
+	}
+	return s
+}
+
+func TestCheckLinks(t *testing.T) {
+	site := newTestSite(t, "user01", "user02")
+	site.Admin = true
+	repo, newApp := site.Repository, site.App
+	owner, reader := newApp("user01"), newApp("user02")
+	for _, id := range []string{"urllist", "blog2col"} {
+		if err := owner.StoreTemplate(okinotes.Template{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := owner.CreatePage(okinotes.Page{Name: "links", TemplateID: "urllist", Policy: okinotes.PolicyPUBLIC}); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.CreatePage(okinotes.Page{Name: "notes", TemplateID: "blog2col"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var items []okinotes.Item
+	for _, i := range []okinotes.Item{
+		{URL: "http://example.com/ok", Tags: okinotes.TagList{{"status", "read"}}},
+		{URL: "http://example.com/moved"},
+		{URL: "http://example.com/missing"},
+		{URL: "http://unknown.example.com/"},
+		{Content: "No link"},
+	} {
+		item, err := owner.CreateItem("user01", "links", i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, item)
+	}
+	if _, err := owner.CreateItem("user01", "notes", okinotes.Item{URL: "http://example.com/missing"}); err != nil {
+		t.Fatal(err)
+	}
+
+	checker := &testLinkChecker{statuses: map[string]okinotes.LinkStatus{
+		"http://example.com/ok":      {StatusCode: 200},
+		"http://example.com/moved":   {StatusCode: 200, RedirectURL: "https://example.com/new"},
+		"http://example.com/missing": {StatusCode: 404},
+	}}
+
+	//The checks are spread over several runs
+	n, err := okinotes.CheckLinks(repo, checker, time.Now().Add(-time.Hour), 3)
+	if err != nil || n != 3 {
+		t.Fatalf("CheckLinks = %d, %v, wanted 3 links checked", n, err)
+	}
+	report, err := owner.LinkReport("user01", "links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 3 || report.Unchecked != 1 {
+		t.Errorf("Report = %+v, wanted 3 checked links and 1 unchecked", report)
+	}
+
+	n, err = okinotes.CheckLinks(repo, checker, time.Now().Add(-time.Hour), 3)
+	if err != nil || n != 1 || checker.checks != 4 {
+		t.Fatalf("CheckLinks = %d, %v, wanted the last link checked", n, err)
+	}
+
+	report, err = owner.LinkReport("user01", "links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Checked != 4 || report.Unchecked != 0 || len(report.Broken) != 2 {
+		t.Fatalf("Report = %+v, wanted 2 broken links out of 4", report)
+	}
+	if report.Broken[0].Status.StatusCode != 404 && report.Broken[1].Status.StatusCode != 404 {
+		t.Errorf("Broken = %+v, wanted the missing page", report.Broken)
+	}
+
+	moved, err := repo.GetItem("user01", "links", items[1].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s, checked := moved.LinkStatus(); !checked || s.RedirectURL != "https://example.com/new" || moved.BrokenLink() {
+		t.Errorf("Status = %+v, wanted a working redirected link", s)
+	}
+	ok, err := repo.GetItem("user01", "links", items[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok.Tags.Tag("status") != "read" || ok.Tags.Tag("link.status") != "200" {
+		t.Errorf("Tags = %+v, wanted the status of the link added", ok.Tags)
+	}
+
+	//Links checked recently are not checked again
+	n, err = okinotes.CheckLinks(repo, checker, time.Now().Add(-time.Hour), 0)
+	if err != nil || n != 0 {
+		t.Errorf("CheckLinks = %d, %v, wanted no link checked", n, err)
+	}
+
+	//The status is dropped when the URL changes, and the new URL is checked
+	fixed := items[2]
+	fixed.URL = "http://example.com/ok"
+	if _, err := owner.UpdateItem("user01", "links", fixed, false); err != nil {
+		t.Fatal(err)
+	}
+	report, err = owner.LinkReport("user01", "links")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Unchecked != 1 || len(report.Broken) != 1 {
+		t.Errorf("Report = %+v, wanted the fixed link unchecked", report)
+	}
+	n, err = okinotes.CheckLinks(repo, checker, time.Now().Add(-time.Hour), 0)
+	if err != nil || n != 1 {
+		t.Errorf("CheckLinks = %d, %v, wanted the fixed link checked", n, err)
+	}
+
+	if _, err := reader.LinkReport("user01", "links"); err == nil {
+		t.Errorf("LinkReport succeeded for a reader of the page")
+	}
+}
@@ -0,0 +1,273 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+)
+
+//LinkPreview describes a web page, from the metadata of its HTML
+type LinkPreview struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	SiteName    string `json:"siteName"`
+	Favicon     string `json:"favicon"` //Absolute URL of the icon of the site
+	Image       string `json:"image"`   //Absolute URL of the OpenGraph image
+}
+
+//linkTagPrefix starts the keys of the tags holding the preview of the URL of an item
+const linkTagPrefix = "link."
+
+//Tags of the items holding the preview of their URL
+const (
+	linkTagTITLE       = linkTagPrefix + "title"
+	linkTagDESCRIPTION = linkTagPrefix + "description"
+	linkTagSITENAME    = linkTagPrefix + "siteName"
+	linkTagFAVICON     = linkTagPrefix + "favicon"
+	linkTagIMAGE       = linkTagPrefix + "image"
+)
+
+const (
+	//PreviewTimeout is the maximum duration of the fetch of a linked page
+	PreviewTimeout = 5 * time.Second
+	//maxPreviewBytes is the maximum size in bytes read from a linked page
+	maxPreviewBytes = 1 << 20
+	//maxPreviewRedirects is the maximum number of redirects followed to fetch a linked page
+	maxPreviewRedirects = 5
+)
+
+//WithLinkInteractor returns a copy of the App previewing the URLs of the items with l.
+//Without LinkInteractor, the URLs are not previewed.
+func (app App) WithLinkInteractor(l LinkInteractor) App {
+	app.linkInteractor = l
+	return app
+}
+
+//FetchLinkPreview reads the preview of an HTTP or HTTPS URL with the given client.
+//Only the beginning of HTML pages is read. The client is responsible for restricting
+//the addresses it connects to. Without timeout, PreviewTimeout is used.
+func FetchLinkPreview(client *http.Client, rawurl string) (LinkPreview, error) {
+	u, err := checkPreviewURL(rawurl)
+	if err != nil {
+		return LinkPreview{}, err
+	}
+
+	c := fetchClient(client)
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return LinkPreview{}, err
+	}
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	req.Header.Set("User-Agent", "okinotes link preview")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return LinkPreview{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return LinkPreview{}, fmt.Errorf("Preview of %s: %s", u, resp.Status)
+	}
+	mediaType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return LinkPreview{}, fmt.Errorf("Preview of %s: not an HTML page (%s)", u, mediaType)
+	}
+
+	return ParseLinkPreview(io.LimitReader(resp.Body, maxPreviewBytes), resp.Request.URL), nil
+}
+
+//fetchClient returns a copy of client following a limited number of redirects to HTTP
+//and HTTPS URLs only, with PreviewTimeout as the default timeout
+func fetchClient(client *http.Client) *http.Client {
+	c := *client
+	if c.Timeout == 0 {
+		c.Timeout = PreviewTimeout
+	}
+	c.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		if len(via) >= maxPreviewRedirects {
+			return fmt.Errorf("more than %d redirects", maxPreviewRedirects)
+		}
+		_, err := checkPreviewURL(req.URL.String())
+		return err
+	}
+	return &c
+}
+
+//checkPreviewURL verifies that a URL can be previewed: only HTTP and HTTPS URLs are fetched
+func checkPreviewURL(rawurl string) (*url.URL, error) {
+	u, err := url.Parse(strings.TrimSpace(rawurl))
+	if err != nil {
+		return nil, DataError{"url", err.Error()}
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Hostname()) == 0 {
+		return nil, DataError{"url", "only HTTP and HTTPS links are previewed"}
+	}
+	return u, nil
+}
+
+//ParseLinkPreview reads the metadata of an HTML page located at pageURL, which resolves
+//the relative URLs. The OpenGraph properties are preferred to the title and the description
+//of the page. The icon of the site defaults to /favicon.ico.
+func ParseLinkPreview(r io.Reader, pageURL *url.URL) LinkPreview {
+	var p, og LinkPreview
+	var inTitle bool
+
+	resolve := func(ref string) string {
+		u, err := pageURL.Parse(strings.TrimSpace(ref))
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return ""
+		}
+		return u.String()
+	}
+
+	z := html.NewTokenizer(r)
+	for done := false; !done; {
+		switch z.Next() {
+		case html.ErrorToken:
+			done = true
+
+		case html.TextToken:
+			if inTitle {
+				p.Title += string(z.Text())
+			}
+
+		case html.EndTagToken:
+			tag, _ := z.TagName()
+			switch string(tag) {
+			case "title":
+				inTitle = false
+			case "head":
+				done = true //The metadata is in the head
+			}
+
+		case html.StartTagToken, html.SelfClosingTagToken:
+			t := z.Token()
+			attrs := make(map[string]string)
+			for _, a := range t.Attr {
+				attrs[a.Key] = a.Val
+			}
+			switch t.Data {
+			case "title":
+				inTitle = len(p.Title) == 0
+			case "meta":
+				key := attrs["property"]
+				if len(key) == 0 {
+					key = attrs["name"]
+				}
+				content := attrs["content"]
+				switch strings.ToLower(key) {
+				case "og:title":
+					og.Title = content
+				case "og:description":
+					og.Description = content
+				case "description":
+					p.Description = content
+				case "og:site_name":
+					og.SiteName = content
+				case "og:image", "og:image:url":
+					if len(og.Image) == 0 {
+						og.Image = resolve(content)
+					}
+				}
+			case "link":
+				for _, rel := range strings.Fields(strings.ToLower(attrs["rel"])) {
+					if rel == "icon" && len(p.Favicon) == 0 {
+						p.Favicon = resolve(attrs["href"])
+					}
+				}
+			case "body":
+				done = true
+			}
+		}
+	}
+
+	for _, field := range []struct {
+		value     *string
+		preferred string
+	}{
+		{&p.Title, og.Title},
+		{&p.Description, og.Description},
+		{&p.SiteName, og.SiteName},
+		{&p.Image, og.Image},
+	} {
+		if len(field.preferred) > 0 {
+			*field.value = field.preferred
+		}
+		*field.value = markdownLine(*field.value)
+	}
+	if len(p.Favicon) == 0 {
+		p.Favicon = resolve("/favicon.ico")
+	}
+	return p
+}
+
+//previewLink returns the preview of the URL of an item when this URL is new, or nil if there
+//is no new URL or no LinkInteractor, or if the URL cannot be previewed (the item is stored anyway).
+func (app App) previewLink(i Item, previous *Item) *LinkPreview {
+	if app.linkInteractor == nil || len(i.URL) == 0 || (previous != nil && previous.URL == i.URL) {
+		return nil
+	}
+	p, err := app.linkInteractor.Preview(i.URL)
+	if err != nil {
+		app.logInteractor.Warningf("Preview of %s failed: %v", i.URL, err)
+		return nil
+	}
+	return &p
+}
+
+//applyLinkPreview sets the link tags of an item from the preview of its URL. Without preview,
+//the link tags of the previous version of the item are kept if the URL did not change,
+//and removed otherwise. The title and the source of the item are set from the preview if empty.
+func applyLinkPreview(i *Item, previous *Item, preview *LinkPreview) {
+	if preview == nil && previous == nil {
+		return
+	}
+	if preview == nil && previous.URL == i.URL {
+		for _, t := range previous.Tags {
+			if strings.HasPrefix(t.Key, linkTagPrefix) && !hasTag(i.Tags, t.Key) {
+				i.Tags.SetTag(t.Key, t.Value)
+			}
+		}
+		return
+	}
+
+	var tags TagList
+	for _, t := range i.Tags {
+		if !strings.HasPrefix(t.Key, linkTagPrefix) {
+			tags = append(tags, t)
+		}
+	}
+	i.Tags = tags
+	if preview == nil {
+		return
+	}
+
+	for _, t := range []Tag{
+		{linkTagTITLE, preview.Title},
+		{linkTagDESCRIPTION, preview.Description},
+		{linkTagSITENAME, preview.SiteName},
+		{linkTagFAVICON, preview.Favicon},
+		{linkTagIMAGE, preview.Image},
+	} {
+		if len(t.Value) > 0 {
+			i.Tags.SetTag(t.Key, t.Value)
+		}
+	}
+	if len(i.Title) == 0 {
+		i.Title = preview.Title
+	}
+	if len(i.Source) == 0 {
+		i.Source = preview.SiteName
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/okinotes/okinotes"
+)
+
+//testLinkInteractor previews the URLs from a fixed list
+type testLinkInteractor map[string]okinotes.LinkPreview
+
+func (l testLinkInteractor) Preview(url string) (okinotes.LinkPreview, error) {
+	p, found := l[url]
+	if !found {
+		return okinotes.LinkPreview{}, errors.New("not found")
+	}
+	return p, nil
+}
+
+func TestLinkPreview(t *testing.T) {
+	site := newTestSite(t, "user01")
+	links := testLinkInteractor{
+		"http://example.com/a": {Title: "Page A", Description: "About A", SiteName: "Example", Favicon: "http://example.com/favicon.ico"},
+		"http://example.com/b": {Title: "Page B", Image: "http://example.com/b.png"},
+	}
+	app := site.App("user01").WithLinkInteractor(links)
+	if err := app.CreatePage(okinotes.Page{Name: "links"}); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := app.CreateItem("user01", "links", okinotes.Item{URL: "http://example.com/a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Title != "Page A" || item.Source != "Example" || item.Tags.Tag("link.description") != "About A" || item.Tags.Tag("link.favicon") != "http://example.com/favicon.ico" {
+		t.Errorf("Item = %+v, wanted the preview of page A", item)
+	}
+
+	//The preview is kept while the URL does not change
+	item.Content = "Changed"
+	item.Tags = okinotes.TagList{{"status", "done"}}
+	item, err = app.UpdateItem("user01", "links", item, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Tags.Tag("link.title") != "Page A" || item.Tags.Tag("status") != "done" {
+		t.Errorf("Tags = %+v, wanted the preview of page A kept", item.Tags)
+	}
+
+	item.URL = "http://example.com/b"
+	item, err = app.UpdateItem("user01", "links", item, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Title != "Page A" || item.Tags.Tag("link.title") != "Page B" || item.Tags.Tag("link.image") != "http://example.com/b.png" || item.Tags.Tag("link.description") != "" {
+		t.Errorf("Item = %+v, wanted the preview of page B, and the title kept", item)
+	}
+
+	//A link which cannot be previewed is stored without preview
+	item.URL = "http://example.com/unknown"
+	item, err = app.PutItem("user01", "links", item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tag := range item.Tags {
+		if tag.Key != "status" {
+			t.Errorf("Tag %s kept after a change of URL", tag.Key)
+		}
+	}
+
+	//Without LinkInteractor, items are stored as given
+	plain := site.App("user01")
+	item, err = plain.CreateItem("user01", "links", okinotes.Item{URL: "http://example.com/a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(item.Title) > 0 || len(item.Tags) > 0 {
+		t.Errorf("Item = %+v, wanted no preview", item)
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"golang.org/x/crypto/bcrypt"
+
+	"github.com/okinotes/okinotes"
+)
+
+//AccountsProvider is the provider of the identities managed by Accounts
+const AccountsProvider = "local"
+
+//MinPasswordLength is the minimum number of characters of a password
+const MinPasswordLength = 8
+
+var loginPattern = regexp.MustCompile(`^[a-z0-9_.\-]{3,64}$`)
+
+//Accounts is the login method based on a login and a password chosen by the users.
+//Password hashes are stored in the repository. The identity of a login is
+//Ident{AccountsProvider, login}.
+type Accounts struct {
+	Sessions          *Sessions
+	AllowRegistration bool
+}
+
+//NewAccounts enables the login with a password on the given sessions
+func NewAccounts(s *Sessions) *Accounts {
+	s.Accounts = &Accounts{Sessions: s}
+	return s.Accounts
+}
+
+func (a *Accounts) registerOnRouter(m *mux.Router) {
+	m.HandleFunc("/login.html", a.postLogin).Methods("POST")
+	m.HandleFunc("/register.html", a.getRegister).Methods("GET")
+	m.HandleFunc("/register.html", a.postRegister).Methods("POST")
+}
+
+//Register creates the credentials of a new login
+func (a *Accounts) Register(login string, password string) error {
+	if !loginPattern.MatchString(login) {
+		return okinotes.DataError{"login", "3 to 64 lower case letters, digits, '.', '_' or '-'"}
+	}
+	if len(password) < MinPasswordLength {
+		return okinotes.DataError{"password", "too short"}
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	credentials := okinotes.Credentials{
+		Login:        login,
+		PasswordHash: string(hash),
+		CreationDate: time.Now(),
+	}
+
+	return a.Sessions.Repository.RunInTransaction(func(repo okinotes.Repository) error {
+		_, err := repo.GetCredentials(login)
+		if err == nil {
+			return okinotes.DataError{"login", "already used"}
+		}
+		if _, notFound := err.(okinotes.NotInDatastoreError); !notFound {
+			return err
+		}
+		return repo.StoreCredentials(credentials)
+	})
+}
+
+//dummyHash is compared to the password of unknown logins,
+//so that they cannot be discovered by timing the login
+var dummyHash struct {
+	once sync.Once
+	hash []byte
+}
+
+//Authenticate checks the password of a login
+func (a *Accounts) Authenticate(login string, password string) (bool, error) {
+	credentials, err := a.Sessions.Repository.GetCredentials(login)
+	if _, notFound := err.(okinotes.NotInDatastoreError); notFound {
+		dummyHash.once.Do(func() {
+			dummyHash.hash, _ = bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+		})
+		bcrypt.CompareHashAndPassword(dummyHash.hash, []byte(password))
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(credentials.PasswordHash), []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,116 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/okinotes/okinotes"
+	"github.com/okinotes/okinotes/memory"
+)
+
+func newTestSessions() *Sessions {
+	return NewSessions(memory.NewRepository(), []byte("0123456789abcdef0123456789abcdef"), []byte("0123456789abcdef"))
+}
+
+func newTestAccounts() (*Accounts, *mux.Router) {
+	a := NewAccounts(newTestSessions())
+	a.AllowRegistration = true
+	m := mux.NewRouter()
+	a.Sessions.RegisterOnRouter(m)
+	return a, m
+}
+
+func postForm(m http.Handler, path string, values url.Values) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("POST", path, strings.NewReader(values.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, r)
+	return w
+}
+
+//identityOf returns the identity of a request sending the cookies set in w
+func identityOf(t *testing.T, s *Sessions, w *httptest.ResponseRecorder) okinotes.Ident {
+	r := httptest.NewRequest("GET", "/", nil)
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	ident, err := s.UserInteractor(r).CurrentIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ident
+}
+
+func TestAccounts(t *testing.T) {
+	a, m := newTestAccounts()
+
+	w := postForm(m, "/register.html", url.Values{"login": {"user01"}, "password": {"secret-password"}, "confirmation": {"secret-password"}, "dest": {"/index.html"}})
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/index.html" {
+		t.Fatalf("register: status %d, location %s", w.Code, w.Header().Get("Location"))
+	}
+	if ident := identityOf(t, a.Sessions, w); ident != (okinotes.Ident{"local", "user01"}) {
+		t.Errorf("identity after registration = %v", ident)
+	}
+
+	w = postForm(m, "/register.html", url.Values{"login": {"user01"}, "password": {"other-password"}, "confirmation": {"other-password"}})
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("register of an existing login: status %d", w.Code)
+	}
+
+	w = postForm(m, "/login.html", url.Values{"login": {"user01"}, "password": {"wrong-password"}})
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("login with wrong password: status %d", w.Code)
+	}
+	if ident := identityOf(t, a.Sessions, w); len(ident.Identity) != 0 {
+		t.Errorf("identity after failed login = %v", ident)
+	}
+
+	w = postForm(m, "/login.html", url.Values{"login": {"user01"}, "password": {"secret-password"}, "dest": {"//evil.example.com/"}})
+	if w.Code != http.StatusSeeOther || w.Header().Get("Location") != "/" {
+		t.Errorf("login: status %d, location %s", w.Code, w.Header().Get("Location"))
+	}
+	if ident := identityOf(t, a.Sessions, w); ident != (okinotes.Ident{"local", "user01"}) {
+		t.Errorf("identity after login = %v", ident)
+	}
+
+	w = postForm(m, "/logout.html", url.Values{})
+	if ident := identityOf(t, a.Sessions, w); len(ident.Identity) != 0 {
+		t.Errorf("identity after logout = %v", ident)
+	}
+}
+
+func TestAccountsAdmin(t *testing.T) {
+	a, _ := newTestAccounts()
+	a.Sessions.Admins = []okinotes.Ident{{"local", "admin"}}
+
+	for _, login := range []string{"admin", "user01", "user02"} {
+		if err := a.Register(login, "secret-password"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	a.Sessions.Repository.StoreUser(okinotes.User{Name: "user01", IsAdmin: true})
+	a.Sessions.Repository.StoreIdentity(okinotes.Identity{okinotes.Ident{"local", "user01"}, "user01"})
+	a.Sessions.Repository.StoreUser(okinotes.User{Name: "user02"})
+	a.Sessions.Repository.StoreIdentity(okinotes.Identity{okinotes.Ident{"local", "user02"}, "user02"})
+
+	for login, isAdmin := range map[string]bool{"admin": true, "user01": true, "user02": false} {
+		w := httptest.NewRecorder()
+		if err := a.Sessions.Start(w, okinotes.Ident{"local", login}); err != nil {
+			t.Fatal(err)
+		}
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(w.Result().Cookies()[0])
+		if got := a.Sessions.UserInteractor(r).CurrentUserIsAdmin(); got != isAdmin {
+			t.Errorf("CurrentUserIsAdmin for %s = %v, wanted %v", login, got, isAdmin)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/okinotes/okinotes"
+)
+
+//AppFactory is a factory for okinotes.App, based on services chosen by configuration
+type AppFactory struct {
+	Repository okinotes.Repository
+	//UserInteractor returns the interactor giving the user making the request
+	UserInteractor   func(r *http.Request) okinotes.UserInteractor
+	LogInteractor    okinotes.LogInteractor
+	UploadInteractor okinotes.UploadInteractor
+	LinkInteractor   okinotes.LinkInteractor //Optional, the URLs of the items are not previewed without it
+	LinkFetcher      okinotes.LinkFetcher    //Optional, the URLs of the items are not archived without it
+}
+
+//CreateApp creates a new okinotes.App for the given request
+func (f AppFactory) CreateApp(r *http.Request) (okinotes.App, error) {
+	if f.Repository == nil {
+		return okinotes.App{}, errors.New("No repository configured")
+	}
+	if f.UserInteractor == nil {
+		return okinotes.App{}, errors.New("No user interactor configured")
+	}
+
+	logInteractor := f.LogInteractor
+	if logInteractor == nil {
+		logInteractor = NewLogger(LevelInfo)
+	}
+	uploadInteractor := f.UploadInteractor
+	if uploadInteractor == nil {
+		uploadInteractor = NoUploadInteractor{}
+	}
+
+	app := okinotes.NewApp(f.Repository, f.UserInteractor(r), logInteractor, uploadInteractor)
+	if f.LinkInteractor != nil {
+		app = app.WithLinkInteractor(f.LinkInteractor)
+	}
+	if f.LinkFetcher != nil {
+		app = app.WithLinkFetcher(f.LinkFetcher)
+	}
+
+	return app, nil
+}
@@ -0,0 +1,429 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/draw"
+
+	"github.com/okinotes/okinotes"
+)
+
+const (
+	//DefaultMaxUploadBytes is the size limit of uploads when DiskUploads.MaxUploadBytes is not set
+	DefaultMaxUploadBytes = 10 << 20
+	//MaxThumbnailSize is the largest size of the resized images
+	MaxThumbnailSize = 1600
+	//MaxImagePixels is the largest number of pixels of the images which are resized
+	MaxImagePixels = 40 << 20
+)
+
+//keyPattern matches the keys returned by DiskUploads: content hash and reference
+var keyPattern = regexp.MustCompile(`^([0-9a-f]{64})-([0-9a-f]{16})$`)
+
+//DiskUploads is an UploadInteractor storing the uploaded files in a local directory:
+//
+//	blobs/<hh>/<hash>         content of the files, named by their SHA-256 hash
+//	refs/<hash>/<ref>         one empty file per upload of this content
+//	thumbnails/<hash>/<size>  cache of the resized images
+//	tmp/                      uploads in progress
+//
+//There is no upload service: the files are posted as multipart forms directly to
+//the destination URL. DiskUploads is also the http.Handler serving the files,
+//and must be registered under BaseURL.
+type DiskUploads struct {
+	Root           string
+	BaseURL        string //Path of the handler, ending with a '/'
+	MaxUploadBytes int64
+
+	mu sync.Mutex //Serializes the changes of the references
+}
+
+//NewDiskUploads creates a DiskUploads storing its files in root, and served under baseURL
+func NewDiskUploads(root string, baseURL string) (*DiskUploads, error) {
+	for _, dir := range []string{"blobs", "refs", "thumbnails", "tmp"} {
+		if err := os.MkdirAll(filepath.Join(root, dir), 0755); err != nil {
+			return nil, err
+		}
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return &DiskUploads{Root: root, BaseURL: baseURL}, nil
+}
+
+func (d *DiskUploads) blobPath(hash string) string {
+	return filepath.Join(d.Root, "blobs", hash[:2], hash)
+}
+func (d *DiskUploads) refsDir(hash string) string {
+	return filepath.Join(d.Root, "refs", hash)
+}
+func (d *DiskUploads) thumbnailPath(hash string, size int) string {
+	return filepath.Join(d.Root, "thumbnails", hash, strconv.Itoa(size))
+}
+
+//UploadURL returns destURL, as files are directly posted to the application
+func (d *DiskUploads) UploadURL(destURL string, maxUploadBytes int64) (string, error) {
+	return destURL, nil
+}
+
+//UploadInfo stores the file posted in the field name of a multipart form
+func (d *DiskUploads) UploadInfo(r *http.Request, name string) (okinotes.UploadInfo, error) {
+	maxUploadBytes := d.MaxUploadBytes
+	if maxUploadBytes <= 0 {
+		maxUploadBytes = DefaultMaxUploadBytes
+	}
+	if r.MultipartForm == nil {
+		r.Body = http.MaxBytesReader(nil, r.Body, maxUploadBytes)
+	}
+
+	file, header, err := r.FormFile(name)
+	if err == http.ErrMissingFile {
+		return okinotes.UploadInfo{}, okinotes.DataError{name, "No file uploaded"}
+	}
+	if err != nil {
+		return okinotes.UploadInfo{}, err
+	}
+	defer file.Close()
+
+	return d.store(header.Filename, file, maxUploadBytes, name)
+}
+
+//Store stores a file read from r. Its content type is detected from its content.
+func (d *DiskUploads) Store(filename string, contentType string, r io.Reader) (okinotes.UploadInfo, error) {
+	maxUploadBytes := d.MaxUploadBytes
+	if maxUploadBytes <= 0 {
+		maxUploadBytes = DefaultMaxUploadBytes
+	}
+	return d.store(filename, r, maxUploadBytes, "file")
+}
+
+//store copies a file in the blobs, and adds a reference to it.
+//field names the file in the errors.
+func (d *DiskUploads) store(filename string, file io.Reader, maxUploadBytes int64, field string) (okinotes.UploadInfo, error) {
+	//Copy to a temporary file while computing the hash
+	tmp, err := ioutil.TempFile(filepath.Join(d.Root, "tmp"), "upload-")
+	if err != nil {
+		return okinotes.UploadInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(file, maxUploadBytes+1))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return okinotes.UploadInfo{}, err
+	}
+	if size > maxUploadBytes {
+		return okinotes.UploadInfo{}, okinotes.DataError{field, fmt.Sprintf("File larger than %d bytes", maxUploadBytes)}
+	}
+	hash := hex.EncodeToString(h.Sum(nil))
+
+	contentType, err := detectContentType(tmp.Name())
+	if err != nil {
+		return okinotes.UploadInfo{}, err
+	}
+
+	ref, err := newRef()
+	if err != nil {
+		return okinotes.UploadInfo{}, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, err := os.Stat(d.blobPath(hash)); os.IsNotExist(err) {
+		if err := os.MkdirAll(filepath.Dir(d.blobPath(hash)), 0755); err != nil {
+			return okinotes.UploadInfo{}, err
+		}
+		if err := os.Rename(tmp.Name(), d.blobPath(hash)); err != nil {
+			return okinotes.UploadInfo{}, err
+		}
+	} else if err != nil {
+		return okinotes.UploadInfo{}, err
+	}
+
+	if err := os.MkdirAll(d.refsDir(hash), 0755); err != nil {
+		return okinotes.UploadInfo{}, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(d.refsDir(hash), ref), nil, 0644); err != nil {
+		return okinotes.UploadInfo{}, err
+	}
+
+	return okinotes.UploadInfo{
+		Key:          hash + "-" + ref,
+		ContentType:  contentType,
+		CreationTime: time.Now(),
+		Filename:     filename,
+		Size:         size,
+	}, nil
+}
+
+//ImageURL returns the URL of the file, served by the handler.
+//When size is positive, the image is resized so that its largest side is at most size pixels.
+func (d *DiskUploads) ImageURL(key string, secure bool, size int) (string, error) {
+	if !keyPattern.MatchString(key) {
+		return "", okinotes.NotInDatastoreError{"Upload", key}
+	}
+	url := d.BaseURL + key
+	if size > 0 {
+		url += "?s=" + strconv.Itoa(size)
+	}
+	return url, nil
+}
+
+//Open returns the content of a file
+func (d *DiskUploads) Open(key string) (io.ReadCloser, error) {
+	m := keyPattern.FindStringSubmatch(key)
+	if m == nil {
+		return nil, okinotes.NotInDatastoreError{"Upload", key}
+	}
+	if _, err := os.Stat(filepath.Join(d.refsDir(m[1]), m[2])); os.IsNotExist(err) {
+		return nil, okinotes.NotInDatastoreError{"Upload", key}
+	}
+	return os.Open(d.blobPath(m[1]))
+}
+
+//Delete removes a reference to a file. The file and its thumbnails are removed
+//with the last reference.
+func (d *DiskUploads) Delete(key string) error {
+	m := keyPattern.FindStringSubmatch(key)
+	if m == nil {
+		return okinotes.NotInDatastoreError{"Upload", key}
+	}
+	hash, ref := m[1], m[2]
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	err := os.Remove(filepath.Join(d.refsDir(hash), ref))
+	if os.IsNotExist(err) {
+		return okinotes.NotInDatastoreError{"Upload", key}
+	}
+	if err != nil {
+		return err
+	}
+
+	if os.Remove(d.refsDir(hash)) != nil {
+		return nil //Still referenced
+	}
+	if err := os.RemoveAll(filepath.Join(d.Root, "thumbnails", hash)); err != nil {
+		return err
+	}
+	return os.Remove(d.blobPath(hash))
+}
+
+//ServeHTTP serves the file identified by the last element of the path,
+//resized if the s parameter is given.
+func (d *DiskUploads) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m := keyPattern.FindStringSubmatch(r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:])
+	if m == nil {
+		http.NotFound(w, r)
+		return
+	}
+	hash := m[1]
+	if _, err := os.Stat(filepath.Join(d.refsDir(hash), m[2])); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	path := d.blobPath(hash)
+	contentType, err := detectContentType(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if s := r.FormValue("s"); len(s) > 0 && isResizable(contentType) {
+		size, err := strconv.Atoi(s)
+		if err != nil || size <= 0 {
+			http.Error(w, "Invalid size", http.StatusBadRequest)
+			return
+		}
+		if size > MaxThumbnailSize {
+			size = MaxThumbnailSize
+		}
+		path, err = d.thumbnail(hash, size)
+		if _, invalid := err.(okinotes.DataError); invalid {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if contentType, err = detectContentType(path); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	//Uploaded files are untrusted: only images are displayed by the browser
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if strings.HasPrefix(contentType, "image/") && contentType != "image/svg+xml" {
+		w.Header().Set("Content-Type", contentType)
+	} else {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	//Content is immutable for a given key
+	w.Header().Set("Cache-Control", "public, max-age=31536000")
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+//thumbnail returns the path of the resized image, generating it if needed
+func (d *DiskUploads) thumbnail(hash string, size int) (string, error) {
+	path := d.thumbnailPath(hash, size)
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	f, err := os.Open(d.blobPath(hash))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	b, err := resizeImage(f, size)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(filepath.Join(d.Root, "tmp"), "thumbnail-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(b)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+//resizeImage decodes a JPEG, PNG or GIF image (first frame only) and encodes it
+//in the same format, with its largest side reduced to size. Smaller images are not enlarged.
+//Images of more than MaxImagePixels are refused before being decoded.
+func resizeImage(r io.ReadSeeker, size int) ([]byte, error) {
+	config, _, err := image.DecodeConfig(r)
+	if err != nil {
+		return nil, err
+	}
+	if int64(config.Width)*int64(config.Height) > MaxImagePixels {
+		return nil, okinotes.DataError{"image", fmt.Sprintf("Image larger than %d pixels", MaxImagePixels)}
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	src, format, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	dst := src
+	if w > size || h > size {
+		if w >= h {
+			w, h = size, h*size/w
+		} else {
+			w, h = w*size/h, size
+		}
+		if w == 0 {
+			w = 1
+		}
+		if h == 0 {
+			h = 1
+		}
+		rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.CatmullRom.Scale(rgba, rgba.Bounds(), src, bounds, draw.Src, nil)
+		dst = rgba
+	}
+
+	var b bytes.Buffer
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&b, dst, &jpeg.Options{Quality: 85})
+	case "gif":
+		err = gif.Encode(&b, dst, nil)
+	default:
+		err = png.Encode(&b, dst)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+func isResizable(contentType string) bool {
+	return contentType == "image/jpeg" || contentType == "image/png" || contentType == "image/gif"
+}
+
+//detectContentType returns the content type of a file, based on its first bytes
+func detectContentType(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(f, buf)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(buf[:n]), nil
+}
+
+//newRef returns a random reference
+func newRef() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
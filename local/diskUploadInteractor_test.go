@@ -0,0 +1,172 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/png"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/okinotes/okinotes"
+)
+
+func newUploadRequest(t *testing.T, content []byte) *http.Request {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	part, err := w.CreateFormFile("file", "test.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(content)
+	w.Close()
+
+	r := httptest.NewRequest("POST", "/user/images.html", &body)
+	r.Header.Set("Content-Type", w.FormDataContentType())
+	return r
+}
+
+func testPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, x%height, color.RGBA{255, 0, 0, 255})
+	}
+	var b bytes.Buffer
+	if err := png.Encode(&b, img); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestDiskUploads(t *testing.T) {
+	root, err := ioutil.TempDir("", "okinotes-uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	d, err := NewDiskUploads(root, "/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := testPNG(t, 400, 200)
+	info1, err := d.UploadInfo(newUploadRequest(t, content), "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info1.ContentType != "image/png" || info1.Filename != "test.png" || info1.Size != int64(len(content)) {
+		t.Errorf("UploadInfo = %+v", info1)
+	}
+
+	//Same content is stored once, but with distinct keys
+	info2, err := d.UploadInfo(newUploadRequest(t, content), "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info1.Key == info2.Key || info1.Key[:64] != info2.Key[:64] {
+		t.Errorf("keys %s and %s, wanted same hash and distinct references", info1.Key, info2.Key)
+	}
+
+	//Resized image
+	url, err := d.ImageURL(info1.Key, false, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET %s: status %d", url, w.Code)
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := img.Bounds(); b.Dx() != 100 || b.Dy() != 50 {
+		t.Errorf("thumbnail size %dx%d, wanted 100x50", b.Dx(), b.Dy())
+	}
+
+	//The content is kept until the last reference is deleted
+	if err := d.Delete(info1.Key); err != nil {
+		t.Fatal(err)
+	}
+	url, _ = d.ImageURL(info2.Key, false, 0)
+	w = httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), content) {
+		t.Errorf("GET %s after deletion of another reference: status %d", url, w.Code)
+	}
+
+	if err := d.Delete(info2.Key); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(d.blobPath(info2.Key[:64])); !os.IsNotExist(err) {
+		t.Errorf("blob still exists after deletion of all references")
+	}
+	if _, ok := d.Delete(info2.Key).(okinotes.NotInDatastoreError); !ok {
+		t.Errorf("Delete of a deleted key did not return NotInDatastoreError")
+	}
+}
+
+func TestDiskUploadsMaxBytes(t *testing.T) {
+	root, err := ioutil.TempDir("", "okinotes-uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	d, err := NewDiskUploads(root, "/uploads/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.MaxUploadBytes = 100
+
+	if _, err := d.UploadInfo(newUploadRequest(t, testPNG(t, 400, 200)), "file"); err == nil {
+		t.Errorf("UploadInfo of a file larger than MaxUploadBytes succeeded")
+	}
+}
+
+func TestDiskUploadsMaxPixels(t *testing.T) {
+	root, err := ioutil.TempDir("", "okinotes-uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	d, err := NewDiskUploads(root, "/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//PNG header of a 100000x100000 image, without its pixels
+	ihdr := []byte("IHDR\x00\x01\x86\xa0\x00\x01\x86\xa0\x08\x02\x00\x00\x00")
+	var content bytes.Buffer
+	content.WriteString("\x89PNG\r\n\x1a\n")
+	binary.Write(&content, binary.BigEndian, uint32(len(ihdr)-4))
+	content.Write(ihdr)
+	binary.Write(&content, binary.BigEndian, crc32.ChecksumIEEE(ihdr))
+
+	info, err := d.UploadInfo(newUploadRequest(t, content.Bytes()), "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url, err := d.ImageURL(info.Key, false, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := httptest.NewRecorder()
+	d.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("GET %s: status %d, wanted %d", url, w.Code, http.StatusBadRequest)
+	}
+}
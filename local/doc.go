@@ -0,0 +1,7 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package local contains the implementation of the okinotes services allowing
+// to run the okinotes app on a standard server, independently of appengine.
+package local
@@ -0,0 +1,108 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+
+	"github.com/okinotes/okinotes"
+)
+
+//privateNetworks are the addresses not reachable by the previews: loopback, private,
+//link-local (cloud metadata services), shared, multicast and reserved ranges
+var privateNetworks = mustParseCIDRs(
+	"0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "127.0.0.0/8", "169.254.0.0/16", "172.16.0.0/12",
+	"192.0.0.0/24", "192.168.0.0/16", "198.18.0.0/15", "224.0.0.0/4", "240.0.0.0/4",
+	"::/128", "::1/128", "fc00::/7", "fe80::/10", "ff00::/8",
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, n)
+	}
+	return networks
+}
+
+//isPublicIP returns true if ip is not in a private network
+func isPublicIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	for _, n := range privateNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+//LinkPreviewer is a LinkInteractor, a LinkChecker and a LinkFetcher fetching the linked pages over HTTP.
+//Only public addresses are reached: they are checked once the names are resolved,
+//for each connection (including redirects), so that a name cannot lead to the private
+//network of the server.
+type LinkPreviewer struct {
+	Timeout              time.Duration //PreviewTimeout if not set
+	AllowPrivateNetworks bool          //Allows previewing the pages of the private networks, such as local tests servers
+}
+
+//Preview fetches the page at url and returns its metadata
+func (p LinkPreviewer) Preview(url string) (okinotes.LinkPreview, error) {
+	return okinotes.FetchLinkPreview(p.client(), url)
+}
+
+//Check requests url and returns its status, making LinkPreviewer a LinkChecker as well
+func (p LinkPreviewer) Check(url string) okinotes.LinkStatus {
+	return okinotes.FetchLinkStatus(p.client(), url)
+}
+
+//Fetch downloads url, making LinkPreviewer a LinkFetcher as well
+func (p LinkPreviewer) Fetch(url string, maxBytes int, deadline time.Time) (okinotes.WebDocument, error) {
+	return okinotes.FetchDocument(p.client(), url, maxBytes, deadline)
+}
+
+//client returns an HTTP client connecting to the allowed addresses only
+func (p LinkPreviewer) client() *http.Client {
+	timeout := p.Timeout
+	if timeout <= 0 {
+		timeout = okinotes.PreviewTimeout
+	}
+
+	dialer := &net.Dialer{Timeout: timeout, Control: p.control}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 nil, //A proxy would hide the address of the server
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			DisableKeepAlives:     true,
+		},
+	}
+}
+
+//control checks the address of each connection, after the name resolution
+func (p LinkPreviewer) control(network string, address string, c syscall.RawConn) error {
+	if p.AllowPrivateNetworks {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return fmt.Errorf("Connection to private address %s not allowed", host)
+	}
+	return nil
+}
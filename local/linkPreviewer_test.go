@@ -0,0 +1,169 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/okinotes/okinotes"
+)
+
+const previewedPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>Page title</title>
+<meta name="description" content="Page description">
+<meta property="og:title" content="OpenGraph &amp; title">
+<meta property="og:site_name" content="Example">
+<meta property="og:image" content="/img/cover.png">
+<link rel="shortcut icon" href="/static/icon.png">
+</head>
+<body><meta property="og:description" content="Ignored, out of the head"></body>
+</html>`
+
+func TestLinkPreviewer(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		fmt.Fprint(w, previewedPage)
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusFound)
+	})
+	mux.HandleFunc("/loop", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/loop", http.StatusFound)
+	})
+	mux.HandleFunc("/file", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "file:///etc/passwd", http.StatusFound)
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+	})
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><head><!--"+strings.Repeat("x", 2<<20)+"--><title>Too far</title></head></html>")
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	p := LinkPreviewer{Timeout: 200 * time.Millisecond, AllowPrivateNetworks: true}
+	for _, path := range []string{"/page", "/redirect"} {
+		preview, err := p.Preview(server.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := okinotes.LinkPreview{
+			Title:       "OpenGraph & title",
+			Description: "Page description",
+			SiteName:    "Example",
+			Favicon:     server.URL + "/static/icon.png",
+			Image:       server.URL + "/img/cover.png",
+		}
+		if preview != want {
+			t.Errorf("Preview(%s) = %+v, wanted %+v", path, preview, want)
+		}
+	}
+
+	preview, err := p.Preview(server.URL + "/large")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(preview.Title) > 0 {
+		t.Errorf("Preview read %q beyond the size limit", preview.Title)
+	}
+
+	for _, path := range []string{"/loop", "/file", "/image", "/slow", "/unknown"} {
+		if _, err := p.Preview(server.URL + path); err == nil {
+			t.Errorf("Preview(%s) succeeded", path)
+		}
+	}
+	if _, err := p.Preview("ftp://example.com/"); err == nil {
+		t.Errorf("Preview succeeded with an FTP URL")
+	}
+
+	//The private addresses are not reachable by default, even through a name
+	_, port, _ := net.SplitHostPort(server.Listener.Addr().String())
+	for _, host := range []string{server.Listener.Addr().String(), "localhost:" + port, "[::ffff:127.0.0.1]:" + port} {
+		if _, err := (LinkPreviewer{}).Preview("http://" + host + "/page"); err == nil {
+			t.Errorf("Preview of %s succeeded", host)
+		}
+	}
+
+	for _, test := range []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"10.1.2.3", false},
+		{"169.254.169.254", false},
+		{"::1", false},
+		{"fd00::1", false},
+		{"::ffff:192.168.0.1", false},
+	} {
+		if got := isPublicIP(net.ParseIP(test.ip)); got != test.public {
+			t.Errorf("isPublicIP(%s) = %v, wanted %v", test.ip, got, test.public)
+		}
+	}
+}
+
+func TestLinkPreviewerCheck(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/page", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	})
+	mux.HandleFunc("/redirect", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/page", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/get", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	p := LinkPreviewer{Timeout: time.Second, AllowPrivateNetworks: true}
+	for _, test := range []struct {
+		path     string
+		code     int
+		redirect string
+		broken   bool
+	}{
+		{"/page", 200, "", false},
+		{"/redirect", 200, server.URL + "/page", false},
+		{"/get", 200, "", false},
+		{"/unknown", 404, "", true},
+	} {
+		s := p.Check(server.URL + test.path)
+		if s.StatusCode != test.code || s.RedirectURL != test.redirect || s.Broken() != test.broken {
+			t.Errorf("Check(%s) = %+v, wanted %d redirected to %q", test.path, s, test.code, test.redirect)
+		}
+	}
+
+	if s := (LinkPreviewer{}).Check(server.URL + "/page"); !s.Broken() || len(s.Error) == 0 {
+		t.Errorf("Check = %+v, wanted the private address refused", s)
+	}
+
+	doc, err := p.Fetch(server.URL+"/redirect", 100, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.URL != server.URL+"/page" || string(doc.Content) != "ok" {
+		t.Errorf("Fetch = %+v, wanted the content of /page", doc)
+	}
+	if _, err := p.Fetch(server.URL+"/unknown", 100, time.Time{}); err == nil {
+		t.Errorf("Fetch succeeded with a missing page")
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+//LogLevel is the severity of a log message
+type LogLevel int
+
+const (
+	//LevelDebug is the level of Debugf messages
+	LevelDebug LogLevel = iota
+	//LevelInfo is the level of Infof messages
+	LevelInfo
+	//LevelWarning is the level of Warningf messages
+	LevelWarning
+	//LevelError is the level of Errorf messages
+	LevelError
+	//LevelCritical is the level of Criticalf messages
+	LevelCritical
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARNING", "ERROR", "CRITICAL"}
+
+func (l LogLevel) String() string {
+	if l < 0 || int(l) >= len(levelNames) {
+		return fmt.Sprintf("LogLevel(%d)", l)
+	}
+	return levelNames[l]
+}
+
+//ParseLogLevel returns the level with the given name (case insensitive)
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("Unknown log level '%s'", s)
+}
+
+//Logger is a LogInteractor writing the messages of at least Level to a log.Logger
+type Logger struct {
+	Logger *log.Logger
+	Level  LogLevel
+}
+
+//NewLogger creates a Logger writing to the standard error
+func NewLogger(level LogLevel) Logger {
+	return Logger{
+		Logger: log.New(os.Stderr, "", log.LstdFlags),
+		Level:  level,
+	}
+}
+
+func (l Logger) logf(level LogLevel, format string, args ...interface{}) {
+	if level < l.Level {
+		return
+	}
+	l.Logger.Printf("%-8s %s", level, fmt.Sprintf(format, args...))
+}
+
+//Debugf logs a message at Debug level
+func (l Logger) Debugf(format string, args ...interface{}) {
+	l.logf(LevelDebug, format, args...)
+}
+
+//Infof logs a message at Info level
+func (l Logger) Infof(format string, args ...interface{}) {
+	l.logf(LevelInfo, format, args...)
+}
+
+//Warningf logs a message at Warning level
+func (l Logger) Warningf(format string, args ...interface{}) {
+	l.logf(LevelWarning, format, args...)
+}
+
+//Errorf logs a message at Error level
+func (l Logger) Errorf(format string, args ...interface{}) {
+	l.logf(LevelError, format, args...)
+}
+
+//Criticalf logs a message at Critical level
+func (l Logger) Criticalf(format string, args ...interface{}) {
+	l.logf(LevelCritical, format, args...)
+}
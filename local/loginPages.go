@@ -0,0 +1,186 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/okinotes/okinotes"
+)
+
+//loginTemplates are the pages of the login methods. They do not depend on the
+//templates of the webapp, so that users can log in whatever the deployment.
+var loginTemplates = template.Must(template.New("login").Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}} - Okinotes</title>
+<link rel="stylesheet" href="/static/css/okinotes.css">
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Error}}<p class="error">{{.Error}}</p>{{end}}
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "login"}}{{template "header" .}}
+{{if .Accounts}}
+<form method="POST" action="/login.html">
+<input type="hidden" name="dest" value="{{.Dest}}">
+<p><label>Login <input type="text" name="login" value="{{.Login}}" autocomplete="username" required autofocus></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="current-password" required></label></p>
+<p><button type="submit">Log in</button></p>
+</form>
+{{if .AllowRegistration}}<p><a href="/register.html?dest={{.Dest}}">Create an account</a></p>{{end}}
+{{end}}
+{{if .Providers}}
+<ul class="providers">
+{{range .Providers}}<li><a href="/auth/{{.Name}}/login?dest={{$.Dest}}">Log in with {{.Title}}</a></li>
+{{end}}</ul>
+{{end}}
+{{template "footer" .}}{{end}}
+
+{{define "register"}}{{template "header" .}}
+<form method="POST" action="/register.html">
+<input type="hidden" name="dest" value="{{.Dest}}">
+<p><label>Login <input type="text" name="login" value="{{.Login}}" autocomplete="username" pattern="[a-z0-9_.\-]{3,64}" required autofocus></label></p>
+<p><label>Password <input type="password" name="password" autocomplete="new-password" minlength="{{.MinPasswordLength}}" required></label></p>
+<p><label>Confirm password <input type="password" name="confirmation" autocomplete="new-password" required></label></p>
+<p><button type="submit">Create account</button></p>
+</form>
+<p><a href="/login.html?dest={{.Dest}}">Log in with an existing account</a></p>
+{{template "footer" .}}{{end}}
+`))
+
+type loginPageData struct {
+	Title             string
+	Error             string
+	Dest              string
+	Login             string
+	Accounts          bool
+	AllowRegistration bool
+	MinPasswordLength int
+	Providers         []OIDCProviderConfig
+}
+
+func (s *Sessions) renderPage(w http.ResponseWriter, name string, status int, data loginPageData) {
+	if s.Accounts != nil {
+		data.Accounts = true
+		data.AllowRegistration = s.Accounts.AllowRegistration
+		data.MinPasswordLength = MinPasswordLength
+	}
+	if s.OIDC != nil {
+		for _, p := range s.OIDC.providers {
+			data.Providers = append(data.Providers, p.OIDCProviderConfig)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	loginTemplates.ExecuteTemplate(w, name, data)
+}
+
+//renderLogin renders the login page with an error message
+func (s *Sessions) renderLogin(w http.ResponseWriter, status int, dest string, message string) {
+	s.renderPage(w, "login", status, loginPageData{
+		Title: "Log in",
+		Error: message,
+		Dest:  dest,
+	})
+}
+
+func (s *Sessions) getLogin(w http.ResponseWriter, r *http.Request) {
+	s.renderPage(w, "login", http.StatusOK, loginPageData{
+		Title: "Log in",
+		Dest:  localURL(r.FormValue("dest")),
+	})
+}
+
+func (s *Sessions) logout(w http.ResponseWriter, r *http.Request) {
+	s.End(w)
+	http.Redirect(w, r, localURL(r.FormValue("dest")), http.StatusSeeOther)
+}
+
+func (a *Accounts) postLogin(w http.ResponseWriter, r *http.Request) {
+	login := r.FormValue("login")
+	dest := localURL(r.FormValue("dest"))
+
+	ok, err := a.Authenticate(login, r.FormValue("password"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !ok {
+		a.Sessions.renderPage(w, "login", http.StatusUnauthorized, loginPageData{
+			Title: "Log in",
+			Error: "Invalid login or password.",
+			Dest:  dest,
+			Login: login,
+		})
+		return
+	}
+
+	if err := a.Sessions.Start(w, okinotes.Ident{AccountsProvider, login}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, dest, http.StatusSeeOther)
+}
+
+func (a *Accounts) getRegister(w http.ResponseWriter, r *http.Request) {
+	if !a.AllowRegistration {
+		http.NotFound(w, r)
+		return
+	}
+	a.Sessions.renderPage(w, "register", http.StatusOK, loginPageData{
+		Title: "Create an account",
+		Dest:  localURL(r.FormValue("dest")),
+	})
+}
+
+func (a *Accounts) postRegister(w http.ResponseWriter, r *http.Request) {
+	if !a.AllowRegistration {
+		http.NotFound(w, r)
+		return
+	}
+
+	login := r.FormValue("login")
+	password := r.FormValue("password")
+	data := loginPageData{
+		Title: "Create an account",
+		Dest:  localURL(r.FormValue("dest")),
+		Login: login,
+	}
+
+	var err error
+	if password != r.FormValue("confirmation") {
+		err = okinotes.DataError{"password", "the confirmation does not match"}
+	} else {
+		err = a.Register(login, password)
+	}
+	if _, invalid := err.(okinotes.DataError); invalid {
+		data.Error = err.Error()
+		a.Sessions.renderPage(w, "register", http.StatusBadRequest, data)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	//The user is created by the first connection page of the webapp
+	if err := a.Sessions.Start(w, okinotes.Ident{AccountsProvider, login}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, data.Dest, http.StatusSeeOther)
+}
@@ -0,0 +1,227 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gorilla/mux"
+	"golang.org/x/oauth2"
+
+	"github.com/okinotes/okinotes"
+)
+
+const (
+	oidcStateCookieName = "okinotes-oidc"
+	//oidcStateMaxAge is the time allowed to log in on the provider, in seconds
+	oidcStateMaxAge = 10 * 60
+)
+
+var providerNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_\-]+$`)
+
+//OIDCProviderConfig is the configuration of an OpenID Connect provider
+type OIDCProviderConfig struct {
+	Name         string //Provider of the identities, and element of the URLs of the login pages
+	Title        string //Name displayed to the users
+	Issuer       string //URL of the provider, where its discovery document is published
+	ClientID     string
+	ClientSecret string
+	Scopes       []string //Requested in addition to "openid"
+}
+
+//OIDC is the login method based on OpenID Connect providers, using the
+//authorization code flow with PKCE. The identity of a user is
+//Ident{config.Name, subject of the ID token}.
+type OIDC struct {
+	Sessions   *Sessions
+	BaseURL    string       //External URL of the webapp, used to build the redirection URLs
+	HTTPClient *http.Client //Client used to contact the providers, http.DefaultClient if nil
+
+	providers []*oidcProvider
+}
+
+type oidcProvider struct {
+	OIDCProviderConfig
+
+	oauth2   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+//oidcState is the content of the cookie kept during the login on a provider
+type oidcState struct {
+	Provider string
+	State    string
+	Nonce    string
+	Verifier string
+	Dest     string
+}
+
+//NewOIDC enables the login with OpenID Connect providers on the given sessions
+func NewOIDC(s *Sessions, baseURL string) *OIDC {
+	s.OIDC = &OIDC{
+		Sessions: s,
+		BaseURL:  strings.TrimRight(baseURL, "/"),
+	}
+	return s.OIDC
+}
+
+func (o *OIDC) context(ctx context.Context) context.Context {
+	if o.HTTPClient == nil {
+		return ctx
+	}
+	return oidc.ClientContext(ctx, o.HTTPClient)
+}
+
+//AddProvider adds a provider, whose configuration is read from its discovery document
+func (o *OIDC) AddProvider(ctx context.Context, config OIDCProviderConfig) error {
+	if !providerNamePattern.MatchString(config.Name) || config.Name == AccountsProvider {
+		return okinotes.DataError{"provider name", fmt.Sprintf("'%s' is not allowed", config.Name)}
+	}
+	if o.provider(config.Name) != nil {
+		return okinotes.DataError{"provider name", fmt.Sprintf("'%s' already used", config.Name)}
+	}
+	if len(config.Title) == 0 {
+		config.Title = config.Name
+	}
+
+	provider, err := oidc.NewProvider(o.context(ctx), config.Issuer)
+	if err != nil {
+		return err
+	}
+
+	o.providers = append(o.providers, &oidcProvider{
+		OIDCProviderConfig: config,
+		oauth2: oauth2.Config{
+			ClientID:     config.ClientID,
+			ClientSecret: config.ClientSecret,
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  o.BaseURL + "/auth/" + config.Name + "/callback",
+			Scopes:       append([]string{oidc.ScopeOpenID}, config.Scopes...),
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: config.ClientID}),
+	})
+	return nil
+}
+
+func (o *OIDC) provider(name string) *oidcProvider {
+	for _, p := range o.providers {
+		if p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+func (o *OIDC) registerOnRouter(m *mux.Router) {
+	m.HandleFunc("/auth/{provider}/login", o.login).Methods("GET")
+	m.HandleFunc("/auth/{provider}/callback", o.callback).Methods("GET")
+}
+
+//randomString returns a random URL-safe string
+func randomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//login redirects to the authorization endpoint of the provider
+func (o *OIDC) login(w http.ResponseWriter, r *http.Request) {
+	p := o.provider(mux.Vars(r)["provider"])
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	state := oidcState{
+		Provider: p.Name,
+		Verifier: oauth2.GenerateVerifier(),
+		Dest:     localURL(r.FormValue("dest")),
+	}
+	var err error
+	if state.State, err = randomString(); err == nil {
+		state.Nonce, err = randomString()
+	}
+	if err == nil {
+		err = o.Sessions.setCookie(w, oidcStateCookieName, state, oidcStateMaxAge)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	url := p.oauth2.AuthCodeURL(state.State, oidc.Nonce(state.Nonce), oauth2.S256ChallengeOption(state.Verifier))
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+//callback handles the redirection from the provider, with the authorization code
+func (o *OIDC) callback(w http.ResponseWriter, r *http.Request) {
+	p := o.provider(mux.Vars(r)["provider"])
+	if p == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var state oidcState
+	err := o.Sessions.readCookie(r, oidcStateCookieName, &state, oidcStateMaxAge)
+	o.Sessions.deleteCookie(w, oidcStateCookieName)
+	if err != nil || state.Provider != p.Name || subtle.ConstantTimeCompare([]byte(state.State), []byte(r.FormValue("state"))) != 1 {
+		o.Sessions.renderLogin(w, http.StatusBadRequest, "/", "The login has expired, please retry.")
+		return
+	}
+	if e := r.FormValue("error"); len(e) > 0 {
+		o.Sessions.renderLogin(w, http.StatusUnauthorized, state.Dest, fmt.Sprintf("%s refused the login: %s", p.Title, e))
+		return
+	}
+
+	ident, err := o.exchange(r.Context(), p, r.FormValue("code"), state)
+	if err != nil {
+		o.Sessions.renderLogin(w, http.StatusUnauthorized, state.Dest, fmt.Sprintf("Login with %s failed: %v", p.Title, err))
+		return
+	}
+
+	if err := o.Sessions.Start(w, ident); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, state.Dest, http.StatusSeeOther)
+}
+
+//exchange gets the tokens corresponding to the authorization code, and returns
+//the identity given by the validated ID token
+func (o *OIDC) exchange(ctx context.Context, p *oidcProvider, code string, state oidcState) (okinotes.Ident, error) {
+	ctx = o.context(ctx)
+
+	token, err := p.oauth2.Exchange(ctx, code, oauth2.VerifierOption(state.Verifier))
+	if err != nil {
+		return okinotes.Ident{}, err
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return okinotes.Ident{}, fmt.Errorf("no ID token")
+	}
+
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return okinotes.Ident{}, err
+	}
+	if subtle.ConstantTimeCompare([]byte(idToken.Nonce), []byte(state.Nonce)) != 1 {
+		return okinotes.Ident{}, fmt.Errorf("invalid nonce")
+	}
+	if len(idToken.Subject) == 0 {
+		return okinotes.Ident{}, fmt.Errorf("no subject")
+	}
+
+	return okinotes.Ident{p.Name, idToken.Subject}, nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/gorilla/mux"
+
+	"github.com/okinotes/okinotes"
+)
+
+//testProvider is a minimal OpenID Connect provider, logging in a fixed subject
+type testProvider struct {
+	*httptest.Server
+	key      *rsa.PrivateKey
+	clientID string
+	subject  string
+
+	mu    sync.Mutex
+	codes map[string]url.Values //Parameters of the authorization request, by code
+}
+
+func newTestProvider(t *testing.T, clientID string, subject string) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProvider{key: key, clientID: clientID, subject: subject, codes: make(map[string]url.Values)}
+
+	m := http.NewServeMux()
+	m.HandleFunc("/.well-known/openid-configuration", p.discovery)
+	m.HandleFunc("/authorize", p.authorize)
+	m.HandleFunc("/token", p.token)
+	m.HandleFunc("/keys", p.keys)
+	p.Server = httptest.NewServer(m)
+	return p
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (p *testProvider) discovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]interface{}{
+		"issuer":                                p.URL,
+		"authorization_endpoint":                p.URL + "/authorize",
+		"token_endpoint":                        p.URL + "/token",
+		"jwks_uri":                              p.URL + "/keys",
+		"response_types_supported":              []string{"code"},
+		"subject_types_supported":               []string{"public"},
+		"id_token_signing_alg_values_supported": []string{"RS256"},
+		"code_challenge_methods_supported":      []string{"S256"},
+	})
+}
+
+func (p *testProvider) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != p.clientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" {
+		http.Error(w, "invalid request", http.StatusBadRequest)
+		return
+	}
+
+	code, _ := randomString()
+	p.mu.Lock()
+	p.codes[code] = q
+	p.mu.Unlock()
+
+	http.Redirect(w, r, q.Get("redirect_uri")+"?code="+code+"&state="+url.QueryEscape(q.Get("state")), http.StatusFound)
+}
+
+func (p *testProvider) token(w http.ResponseWriter, r *http.Request) {
+	code := r.FormValue("code")
+	p.mu.Lock()
+	q, found := p.codes[code]
+	delete(p.codes, code)
+	p.mu.Unlock()
+
+	//PKCE: the verifier must match the challenge of the authorization request
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !found || base64.RawURLEncoding.EncodeToString(sum[:]) != q.Get("code_challenge") || r.FormValue("redirect_uri") != q.Get("redirect_uri") {
+		w.WriteHeader(http.StatusBadRequest)
+		writeJSON(w, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: p.key}, (&jose.SignerOptions{}).WithHeader("kid", "key1"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   p.URL,
+		"sub":   p.subject,
+		"aud":   p.clientID,
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"nonce": q.Get("nonce"),
+	})
+	jws, err := signer.Sign(claims)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	idToken, _ := jws.CompactSerialize()
+
+	writeJSON(w, map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     idToken,
+	})
+}
+
+func (p *testProvider) keys(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, jose.JSONWebKeySet{Keys: []jose.JSONWebKey{
+		{Key: &p.key.PublicKey, KeyID: "key1", Algorithm: "RS256", Use: "sig"},
+	}})
+}
+
+func TestOIDC(t *testing.T) {
+	provider := newTestProvider(t, "okinotes", "subject01")
+	defer provider.Close()
+
+	s := newTestSessions()
+	m := mux.NewRouter()
+	m.HandleFunc("/index.html", func(w http.ResponseWriter, r *http.Request) {})
+	app := httptest.NewServer(m)
+	defer app.Close()
+
+	o := NewOIDC(s, app.URL)
+	err := o.AddProvider(context.Background(), OIDCProviderConfig{
+		Name:         "test",
+		Issuer:       provider.URL,
+		ClientID:     "okinotes",
+		ClientSecret: "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RegisterOnRouter(m)
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+	resp, err := client.Get(app.URL + "/auth/test/login?dest=/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Request.URL.Path != "/index.html" {
+		t.Fatalf("login ended on %s with status %d", resp.Request.URL, resp.StatusCode)
+	}
+
+	r := httptest.NewRequest("GET", app.URL+"/index.html", nil)
+	appURL, _ := url.Parse(app.URL)
+	for _, c := range jar.Cookies(appURL) {
+		r.AddCookie(c)
+	}
+	if ident := s.Current(r); ident != (okinotes.Ident{"test", "subject01"}) {
+		t.Errorf("identity after login = %v", ident)
+	}
+
+	//A callback without the state of the login is refused
+	resp, err = http.Get(app.URL + "/auth/test/callback?code=abc&state=xyz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("callback without state: status %d", resp.StatusCode)
+	}
+}
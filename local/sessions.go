@@ -0,0 +1,188 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/gorilla/securecookie"
+
+	"github.com/okinotes/okinotes"
+)
+
+const (
+	sessionCookieName = "okinotes-session"
+	//DefaultSessionDuration is the validity of a session when Sessions.Duration is not set
+	DefaultSessionDuration = 30 * 24 * time.Hour
+)
+
+//Sessions authenticates the users of the webapp with signed and encrypted cookies,
+//set by one of the enabled login methods: Accounts (login and password) and
+//OIDC (external identity providers).
+//The administrators are the users with the IsAdmin flag, and the identities listed in Admins.
+type Sessions struct {
+	Repository   okinotes.Repository
+	Admins       []okinotes.Ident
+	Duration     time.Duration
+	SecureCookie bool //Sends the cookies only over HTTPS
+
+	//Login methods, nil when disabled
+	Accounts *Accounts
+	OIDC     *OIDC
+
+	codec *securecookie.SecureCookie
+}
+
+//session is the content of the session cookie
+type session struct {
+	Ident okinotes.Ident
+}
+
+//NewSessions creates a Sessions. hashKey authenticates the cookies and
+//blockKey encrypts them: they must be random, and kept secret and stable
+//so that sessions survive a restart. hashKey should be 32 or 64 bytes long,
+//blockKey 16, 24 or 32 bytes long.
+func NewSessions(repository okinotes.Repository, hashKey []byte, blockKey []byte) *Sessions {
+	return &Sessions{
+		Repository: repository,
+		Duration:   DefaultSessionDuration,
+		codec:      securecookie.New(hashKey, blockKey),
+	}
+}
+
+//UserInteractor returns the UserInteractor of the given request
+func (s *Sessions) UserInteractor(r *http.Request) okinotes.UserInteractor {
+	return sessionUser{s, r}
+}
+
+//RegisterOnRouter registers the login and logout pages, and the pages of the enabled login methods
+func (s *Sessions) RegisterOnRouter(m *mux.Router) {
+	m.HandleFunc("/login.html", s.getLogin).Methods("GET")
+	m.HandleFunc("/logout.html", s.logout).Methods("GET", "POST")
+
+	if s.Accounts != nil {
+		s.Accounts.registerOnRouter(m)
+	}
+	if s.OIDC != nil {
+		s.OIDC.registerOnRouter(m)
+	}
+}
+
+func (s *Sessions) maxAge() int {
+	if s.Duration <= 0 {
+		return int(DefaultSessionDuration / time.Second)
+	}
+	return int(s.Duration / time.Second)
+}
+
+//setCookie sets a cookie holding v, signed and encrypted
+func (s *Sessions) setCookie(w http.ResponseWriter, name string, v interface{}, maxAge int) error {
+	value, err := s.codec.MaxAge(maxAge).Encode(name, v)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		MaxAge:   maxAge,
+		Secure:   s.SecureCookie,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return nil
+}
+
+//readCookie decodes into v the cookie set by setCookie
+func (s *Sessions) readCookie(r *http.Request, name string, v interface{}, maxAge int) error {
+	cookie, err := r.Cookie(name)
+	if err != nil {
+		return err
+	}
+	return s.codec.MaxAge(maxAge).Decode(name, cookie.Value, v)
+}
+
+//deleteCookie removes a cookie set by setCookie
+func (s *Sessions) deleteCookie(w http.ResponseWriter, name string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		Secure:   s.SecureCookie,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+//Start opens a session for the given identity
+func (s *Sessions) Start(w http.ResponseWriter, ident okinotes.Ident) error {
+	return s.setCookie(w, sessionCookieName, session{ident}, s.maxAge())
+}
+
+//End closes the current session
+func (s *Sessions) End(w http.ResponseWriter) {
+	s.deleteCookie(w, sessionCookieName)
+}
+
+//Current returns the identity of the session of the request.
+//Returns an empty identity if there is no valid session.
+func (s *Sessions) Current(r *http.Request) okinotes.Ident {
+	var sess session
+	if err := s.readCookie(r, sessionCookieName, &sess, s.maxAge()); err != nil {
+		return okinotes.Ident{}
+	}
+	return sess.Ident
+}
+
+//localURL returns destURL if it is a path of this site, "/" otherwise,
+//so that the login pages cannot be used to redirect to another site
+func localURL(destURL string) string {
+	u, err := url.Parse(destURL)
+	if err != nil || u.IsAbs() || len(u.Host) > 0 || !strings.HasPrefix(destURL, "/") || strings.HasPrefix(destURL, "//") || strings.HasPrefix(destURL, "/\\") {
+		return "/"
+	}
+	return destURL
+}
+
+//sessionUser is the UserInteractor of a request, for Sessions
+type sessionUser struct {
+	s *Sessions
+	r *http.Request
+}
+
+func (u sessionUser) CurrentIdentity() (okinotes.Ident, error) {
+	return u.s.Current(u.r), nil
+}
+
+func (u sessionUser) CurrentUserIsAdmin() bool {
+	ident := u.s.Current(u.r)
+	if len(ident.Identity) == 0 {
+		return false
+	}
+	for _, admin := range u.s.Admins {
+		if admin == ident {
+			return true
+		}
+	}
+
+	user, err := u.s.Repository.GetUser(ident)
+	if err != nil {
+		return false
+	}
+	return user.IsAdmin
+}
+
+func (u sessionUser) LoginURL(destURL string) (string, error) {
+	return "/login.html?dest=" + url.QueryEscape(destURL), nil
+}
+
+func (u sessionUser) LogoutURL(destURL string) (string, error) {
+	return "/logout.html?dest=" + url.QueryEscape(destURL), nil
+}
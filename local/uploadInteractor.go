@@ -0,0 +1,49 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/okinotes/okinotes"
+)
+
+//ErrUploadDisabled is returned by NoUploadInteractor
+var ErrUploadDisabled = errors.New("Uploads are disabled")
+
+//NoUploadInteractor is an UploadInteractor refusing all uploads
+type NoUploadInteractor struct{}
+
+//UploadURL returns ErrUploadDisabled
+func (i NoUploadInteractor) UploadURL(destURL string, maxUploadBytes int64) (string, error) {
+	return "", ErrUploadDisabled
+}
+
+//UploadInfo returns ErrUploadDisabled
+func (i NoUploadInteractor) UploadInfo(r *http.Request, name string) (okinotes.UploadInfo, error) {
+	return okinotes.UploadInfo{}, ErrUploadDisabled
+}
+
+//ImageURL returns ErrUploadDisabled
+func (i NoUploadInteractor) ImageURL(key string, secure bool, size int) (string, error) {
+	return "", ErrUploadDisabled
+}
+
+//Delete returns ErrUploadDisabled
+func (i NoUploadInteractor) Delete(key string) error {
+	return ErrUploadDisabled
+}
+
+//Open returns ErrUploadDisabled
+func (i NoUploadInteractor) Open(key string) (io.ReadCloser, error) {
+	return nil, ErrUploadDisabled
+}
+
+//Store returns ErrUploadDisabled
+func (i NoUploadInteractor) Store(filename string, contentType string, r io.Reader) (okinotes.UploadInfo, error) {
+	return okinotes.UploadInfo{}, ErrUploadDisabled
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package local
+
+import (
+	"net/http"
+
+	"github.com/okinotes/okinotes"
+)
+
+//SingleUser is a UserInteractor for personal instances: every request is made
+//by the same identity.
+type SingleUser struct {
+	Ident okinotes.Ident
+	Admin bool
+}
+
+//CurrentIdentity returns the configured identity
+func (u SingleUser) CurrentIdentity() (okinotes.Ident, error) {
+	return u.Ident, nil
+}
+
+//CurrentUserIsAdmin returns the configured admin flag
+func (u SingleUser) CurrentUserIsAdmin() bool {
+	return u.Admin
+}
+
+//LoginURL returns destURL, as the user is always logged in
+func (u SingleUser) LoginURL(destURL string) (string, error) {
+	return destURL, nil
+}
+
+//LogoutURL returns destURL, as the user cannot log out
+func (u SingleUser) LogoutURL(destURL string) (string, error) {
+	return destURL, nil
+}
+
+//ProxyUser is a UserInteractor trusting the identity set in a request header
+//by an authenticating reverse proxy. The proxy must remove this header from
+//the incoming requests.
+type ProxyUser struct {
+	Request *http.Request
+	//Header is the name of the header holding the identity
+	Header string
+	//Admins lists the identities of administrators
+	Admins []string
+	//LogoutURL is the logout ressource of the proxy, if any
+	Logout string
+}
+
+//ProxyProvider is the provider of the identities returned by ProxyUser
+const ProxyProvider = "proxy"
+
+//CurrentIdentity returns the identity given by the proxy.
+//An empty identity is returned when the header is not set.
+func (u ProxyUser) CurrentIdentity() (okinotes.Ident, error) {
+	identity := u.Request.Header.Get(u.Header)
+	if len(identity) == 0 {
+		return okinotes.Ident{}, nil
+	}
+	return okinotes.Ident{ProxyProvider, identity}, nil
+}
+
+//CurrentUserIsAdmin returns true if the current identity is listed in Admins
+func (u ProxyUser) CurrentUserIsAdmin() bool {
+	identity := u.Request.Header.Get(u.Header)
+	if len(identity) == 0 {
+		return false
+	}
+	for _, admin := range u.Admins {
+		if admin == identity {
+			return true
+		}
+	}
+	return false
+}
+
+//LoginURL returns destURL, as the login is handled by the proxy
+func (u ProxyUser) LoginURL(destURL string) (string, error) {
+	return destURL, nil
+}
+
+//LogoutURL returns the logout ressource of the proxy, or destURL if not configured
+func (u ProxyUser) LogoutURL(destURL string) (string, error) {
+	if len(u.Logout) == 0 {
+		return destURL, nil
+	}
+	return u.Logout, nil
+}
@@ -0,0 +1,85 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//frontMatterDelimiter starts and ends the metadata of a Markdown document
+const frontMatterDelimiter = "---"
+
+//itemSeparator is a thematic break written between the items of a page
+const itemSeparator = "* * *"
+
+//Markdown returns the page as a Markdown document: a front matter holding the metadata
+//and the tags of the page, followed by the title of the page and the given items
+func (p Page) Markdown(items []Item) string {
+	var b bytes.Buffer
+
+	b.WriteString(frontMatterDelimiter + "\n")
+	for _, field := range []struct {
+		key   string
+		value string
+	}{
+		{"userName", p.UserName},
+		{"name", p.Name},
+		{"title", p.Title},
+		{"creationDate", p.CreationDate.Format(time.RFC3339)},
+		{"lastModificationDate", p.LastModificationDate.Format(time.RFC3339)},
+		{"contentLicense", p.ContentLicense},
+		{"policy", string(p.Policy)},
+		{"templateID", p.TemplateID},
+		{"itemOrder", string(p.ItemOrder)},
+	} {
+		fmt.Fprintf(&b, "%s: %s\n", field.key, strconv.Quote(field.value))
+	}
+	if len(p.Tags) > 0 {
+		b.WriteString("tags:\n")
+		for _, t := range p.Tags {
+			fmt.Fprintf(&b, "  %s: %s\n", strconv.Quote(t.Key), strconv.Quote(t.Value))
+		}
+	}
+	b.WriteString(frontMatterDelimiter + "\n\n")
+
+	title := markdownLine(p.Title)
+	if len(title) == 0 {
+		title = markdownLine(p.Name)
+	}
+	b.WriteString("# " + title + "\n")
+
+	for n, i := range items {
+		switch {
+		case n == 0:
+			b.WriteString("\n")
+		case i.Kind == ItemKindTODO && items[n-1].Kind == ItemKindTODO:
+			//Consecutive tasks form a single list
+		default:
+			b.WriteString("\n" + itemSeparator + "\n\n")
+		}
+		b.WriteString(i.String() + "\n")
+	}
+
+	return b.String()
+}
+
+//markdownLine returns a text on a single line, for titles and list items
+func markdownLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+//escapeLinkText escapes the characters ending the text of a Markdown link
+func escapeLinkText(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "[", `\[`, "]", `\]`).Replace(s)
+}
+
+//markdownURL escapes the characters ending the destination of a Markdown link
+func markdownURL(s string) string {
+	return strings.NewReplacer(" ", "%20", "(", "%28", ")", "%29").Replace(strings.TrimSpace(s))
+}
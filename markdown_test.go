@@ -0,0 +1,95 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/okinotes/okinotes"
+)
+
+func TestItemMarkdown(t *testing.T) {
+	for _, test := range []struct {
+		item okinotes.Item
+		want string
+	}{
+		{
+			okinotes.Item{Kind: okinotes.ItemKindNOTE, Title: "Idea", Content: "Some *text*\n"},
+			"## Idea\n\nSome *text*",
+		},
+		{
+			okinotes.Item{Kind: okinotes.ItemKindLINK, Title: "Go [lang]", URL: "http://golang.org/a b", Content: "The site"},
+			"[Go \\[lang\\]](http://golang.org/a%20b)\n\nThe site",
+		},
+		{
+			okinotes.Item{Kind: okinotes.ItemKindLINK, URL: "http://golang.org"},
+			"[http://golang.org](http://golang.org)",
+		},
+		{
+			okinotes.Item{Kind: okinotes.ItemKindTODO, Title: "Buy\nmilk", Content: "At the\nshop", Tags: okinotes.TagList{{"deadline", "tomorrow"}, {"status", "done"}}},
+			"- [x] Buy milk (deadline: tomorrow)\n\n  At the\n  shop",
+		},
+		{
+			okinotes.Item{Kind: okinotes.ItemKindIMAGE, Title: "Cat", URL: "/images/cat", Source: "Me"},
+			"![Cat](/images/cat)\n\n*Source: Me*",
+		},
+		{
+			okinotes.Item{Content: "No kind", URL: "http://example.com"},
+			"No kind\n\n<http://example.com>",
+		},
+	} {
+		if got := test.item.String(); got != test.want {
+			t.Errorf("String() = %q, wanted %q", got, test.want)
+		}
+	}
+}
+
+func TestPageMarkdown(t *testing.T) {
+	t0 := time.Date(2015, 3, 14, 15, 9, 26, 0, time.UTC)
+	page := okinotes.Page{
+		UserName:             "user01",
+		Name:                 "page01",
+		Title:                "My \"page\"",
+		CreationDate:         t0,
+		LastModificationDate: t0,
+		Policy:               okinotes.PolicyPUBLIC,
+		TemplateID:           "todolist",
+		ItemOrder:            okinotes.ItemOrderMANUAL,
+		Tags:                 okinotes.TagList{{"title.color", "#ff0000"}},
+	}
+	items := []okinotes.Item{
+		{Kind: okinotes.ItemKindTODO, Title: "a"},
+		{Kind: okinotes.ItemKindTODO, Title: "b"},
+		{Kind: okinotes.ItemKindNOTE, Content: "c"},
+	}
+
+	want := `---
+userName: "user01"
+name: "page01"
+title: "My \"page\""
+creationDate: "2015-03-14T15:09:26Z"
+lastModificationDate: "2015-03-14T15:09:26Z"
+contentLicense: ""
+policy: "PUBLIC"
+templateID: "todolist"
+itemOrder: "MANUAL"
+tags:
+  "title.color": "#ff0000"
+---
+
+# My "page"
+
+- [ ] a
+- [ ] b
+
+* * *
+
+c
+`
+	if got := page.Markdown(items); got != want {
+		t.Errorf("Markdown() = %s, wanted %s", got, want)
+	}
+}
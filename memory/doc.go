@@ -0,0 +1,8 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package memory contains an in-memory implementation of the okinotes repository.
+// It is safe for concurrent use and allows running the okinotes app without any
+// external data store (tests, demos, ...).
+package memory
@@ -0,0 +1,979 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/okinotes/okinotes"
+)
+
+//ErrConcurrentTransaction is returned when a transaction cannot be committed
+//because of concurrent modifications, even after retrying.
+var ErrConcurrentTransaction = errors.New("Concurrent transaction")
+
+//transactionAttempts is the number of times a transaction is run before giving up
+const transactionAttempts = 3
+
+type membershipID struct {
+	orgName  string
+	userName string
+}
+
+type pageID struct {
+	userName string
+	pageName string
+}
+
+type itemRef struct {
+	pageID
+	itemID string
+}
+
+type trashEntryID struct {
+	userName string
+	entryID  string
+}
+
+type permissionID struct {
+	pageID
+	userName string
+}
+
+//data is a full state of the repository.
+//Values stored in the maps are never modified in place, allowing cheap copies.
+type data struct {
+	users       map[string]okinotes.User
+	identities  map[okinotes.Ident]okinotes.Identity
+	memberships map[membershipID]okinotes.Membership
+	credentials map[string]okinotes.Credentials
+	tokens      map[string]okinotes.Token
+	pages       map[pageID]okinotes.Page
+	items       map[pageID]map[string]okinotes.Item
+	revisions   map[itemRef][]okinotes.Revision
+	trash       map[trashEntryID]okinotes.TrashEntry
+	search      map[itemRef]okinotes.SearchDocument
+	images      map[string]map[string]okinotes.UploadInfo
+	usages      map[pageID]map[string]okinotes.Usage
+	permissions map[permissionID]okinotes.Permission
+	templates   map[string]okinotes.Template
+}
+
+func newData() *data {
+	return &data{
+		users:       make(map[string]okinotes.User),
+		identities:  make(map[okinotes.Ident]okinotes.Identity),
+		memberships: make(map[membershipID]okinotes.Membership),
+		credentials: make(map[string]okinotes.Credentials),
+		tokens:      make(map[string]okinotes.Token),
+		pages:       make(map[pageID]okinotes.Page),
+		items:       make(map[pageID]map[string]okinotes.Item),
+		revisions:   make(map[itemRef][]okinotes.Revision),
+		trash:       make(map[trashEntryID]okinotes.TrashEntry),
+		search:      make(map[itemRef]okinotes.SearchDocument),
+		images:      make(map[string]map[string]okinotes.UploadInfo),
+		usages:      make(map[pageID]map[string]okinotes.Usage),
+		permissions: make(map[permissionID]okinotes.Permission),
+		templates:   make(map[string]okinotes.Template),
+	}
+}
+
+//clone returns a snapshot of d that can be modified without affecting d
+func (d *data) clone() *data {
+	c := newData()
+	for k, v := range d.users {
+		c.users[k] = v
+	}
+	for k, v := range d.identities {
+		c.identities[k] = v
+	}
+	for k, v := range d.permissions {
+		c.permissions[k] = v
+	}
+	for k, v := range d.memberships {
+		c.memberships[k] = v
+	}
+	for k, v := range d.credentials {
+		c.credentials[k] = v
+	}
+	for k, v := range d.tokens {
+		c.tokens[k] = v
+	}
+	for k, v := range d.pages {
+		c.pages[k] = v
+	}
+	for k, m := range d.items {
+		c.items[k] = make(map[string]okinotes.Item, len(m))
+		for id, v := range m {
+			c.items[k][id] = v
+		}
+	}
+	for k, v := range d.revisions {
+		c.revisions[k] = v
+	}
+	for k, v := range d.trash {
+		c.trash[k] = v
+	}
+	for k, v := range d.search {
+		c.search[k] = v
+	}
+	for k, m := range d.images {
+		c.images[k] = make(map[string]okinotes.UploadInfo, len(m))
+		for id, v := range m {
+			c.images[k][id] = v
+		}
+	}
+	for k, m := range d.usages {
+		c.usages[k] = make(map[string]okinotes.Usage, len(m))
+		for id, v := range m {
+			c.usages[k][id] = v
+		}
+	}
+	for k, v := range d.templates {
+		c.templates[k] = v
+	}
+	return c
+}
+
+type store struct {
+	mu      sync.RWMutex
+	data    *data
+	version uint64
+}
+
+type repository struct {
+	s  *store
+	tx *data //Snapshot modified by the current transaction, nil outside of a transaction
+}
+
+//NewRepository creates a new empty in-memory repository.
+func NewRepository() okinotes.Repository {
+	return repository{
+		s: &store{data: newData()},
+	}
+}
+
+//read runs f on the current state
+func (repo repository) read(f func(d *data) error) error {
+	if repo.tx != nil {
+		return f(repo.tx)
+	}
+	repo.s.mu.RLock()
+	defer repo.s.mu.RUnlock()
+	return f(repo.s.data)
+}
+
+//write runs f on the current state, allowing modifications
+func (repo repository) write(f func(d *data) error) error {
+	if repo.tx != nil {
+		return f(repo.tx)
+	}
+	repo.s.mu.Lock()
+	defer repo.s.mu.Unlock()
+	repo.s.version++
+	return f(repo.s.data)
+}
+
+//RunInTransaction runs f on a snapshot of the repository. Modifications are
+//committed only if f succeeds and no other modification happened in the meantime,
+//otherwise the transaction is retried. Nested calls join the enclosing transaction.
+//A transaction must not be shared between goroutines.
+func (repo repository) RunInTransaction(f func(repo okinotes.Repository) error) error {
+	if repo.tx != nil {
+		return f(repo)
+	}
+
+	for i := 0; i < transactionAttempts; i++ {
+		repo.s.mu.RLock()
+		snapshot := repo.s.data.clone()
+		version := repo.s.version
+		repo.s.mu.RUnlock()
+
+		err := f(repository{repo.s, snapshot})
+		if err != nil {
+			return err //Rollback: the snapshot is dropped
+		}
+
+		repo.s.mu.Lock()
+		if repo.s.version == version {
+			repo.s.data = snapshot
+			repo.s.version++
+			repo.s.mu.Unlock()
+			return nil
+		}
+		repo.s.mu.Unlock()
+	}
+
+	return ErrConcurrentTransaction
+}
+
+func copyTags(l okinotes.TagList) okinotes.TagList {
+	if l == nil {
+		return nil
+	}
+	return append(okinotes.TagList(nil), l...)
+}
+func copyTagDescriptions(l okinotes.TagDescriptionList) okinotes.TagDescriptionList {
+	if l == nil {
+		return nil
+	}
+	return append(okinotes.TagDescriptionList(nil), l...)
+}
+func copyPage(p okinotes.Page) okinotes.Page {
+	p.Tags = copyTags(p.Tags)
+	return p
+}
+func copyItem(i okinotes.Item) okinotes.Item {
+	i.Tags = copyTags(i.Tags)
+	return i
+}
+func copyRevision(r okinotes.Revision) okinotes.Revision {
+	r.Tags = copyTags(r.Tags)
+	return r
+}
+func copyTrashEntry(e okinotes.TrashEntry) okinotes.TrashEntry {
+	e.Page = copyPage(e.Page)
+	e.Item = copyItem(e.Item)
+	return e
+}
+func copyTemplate(t okinotes.Template) okinotes.Template {
+	t.PageTags = copyTagDescriptions(t.PageTags)
+	t.ItemTags = copyTagDescriptions(t.ItemTags)
+	return t
+}
+
+func (repo repository) GetPage(userName string, pageName string) (okinotes.Page, error) {
+	var page okinotes.Page
+	err := repo.read(func(d *data) error {
+		p, found := d.pages[pageID{userName, pageName}]
+		if !found {
+			return okinotes.NotInDatastoreError{"Page", pageName}
+		}
+		page = copyPage(p)
+		return nil
+	})
+	return page, err
+}
+func (repo repository) StorePage(page okinotes.Page) error {
+	page = copyPage(page)
+	return repo.write(func(d *data) error {
+		d.pages[pageID{page.UserName, page.Name}] = page
+		return nil
+	})
+}
+func (repo repository) DeletePage(userName string, pageName string) error {
+	return repo.write(func(d *data) error {
+		delete(d.pages, pageID{userName, pageName})
+		return nil
+	})
+}
+
+type pageQuery struct {
+	repo     repository
+	userName string
+	filters  []okinotes.PageFilter
+	orders   []okinotes.PageOrder
+	limit    int
+	cursor   string
+	err      error
+}
+
+func (repo repository) NewPageQuery() okinotes.PageQuery {
+	return &pageQuery{
+		repo:  repo,
+		limit: -1,
+	}
+}
+func (q *pageQuery) User(userName string) okinotes.PageQuery {
+	q.userName = userName
+	return q
+}
+func (q *pageQuery) Filter(filterStr string, value interface{}) okinotes.PageQuery {
+	f, err := okinotes.ParsePageFilter(filterStr, value)
+	if err != nil && q.err == nil {
+		q.err = err
+	}
+	q.filters = append(q.filters, f)
+	return q
+}
+func (q *pageQuery) Order(fieldName string) okinotes.PageQuery {
+	o, err := okinotes.ParsePageOrder(fieldName)
+	if err != nil && q.err == nil {
+		q.err = err
+	}
+	q.orders = append(q.orders, o)
+	return q
+}
+func (q *pageQuery) Limit(limit int) okinotes.PageQuery {
+	q.limit = limit
+	return q
+}
+func (q *pageQuery) Start(cursor string) okinotes.PageQuery {
+	q.cursor = cursor
+	return q
+}
+
+func (q *pageQuery) GetAll() ([]okinotes.Page, string, error) {
+	if q.err != nil {
+		return nil, "", q.err
+	}
+
+	var pages []okinotes.Page
+	err := q.repo.read(func(d *data) error {
+		for id, p := range d.pages {
+			if len(q.userName) > 0 && id.userName != q.userName {
+				continue
+			}
+			ok, err := q.match(p)
+			if err != nil {
+				return err
+			}
+			if ok {
+				pages = append(pages, copyPage(p))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	sort.Slice(pages, func(i, j int) bool {
+		return okinotes.ComparePages(q.orders, pages[i], pages[j]) < 0
+	})
+	return okinotes.PaginatePages(pages, q.orders, q.cursor, q.limit)
+}
+func (q *pageQuery) match(p okinotes.Page) (bool, error) {
+	for _, f := range q.filters {
+		ok, err := f.Match(p)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (repo repository) GetItemsFromPage(userName string, pageName string, order okinotes.ItemOrder, limit int, cursor string) ([]okinotes.Item, string, error) {
+	var items []okinotes.Item
+	err := repo.read(func(d *data) error {
+		for _, i := range d.items[pageID{userName, pageName}] {
+			items = append(items, copyItem(i))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	sort.Slice(items, func(i, j int) bool {
+		return okinotes.CompareItems(order, items[i], items[j]) < 0
+	})
+	return okinotes.PaginateItems(items, order, cursor, limit)
+}
+func (repo repository) DeleteItemsFromPage(userName string, pageName string) error {
+	return repo.write(func(d *data) error {
+		delete(d.items, pageID{userName, pageName})
+		return nil
+	})
+}
+func (repo repository) FindItem(userName string, pageName string, itemID string) (bool, error) {
+	var found bool
+	err := repo.read(func(d *data) error {
+		_, found = d.items[pageID{userName, pageName}][itemID]
+		return nil
+	})
+	return found, err
+}
+func (repo repository) GetItem(userName string, pageName string, itemID string) (okinotes.Item, error) {
+	var item okinotes.Item
+	err := repo.read(func(d *data) error {
+		i, found := d.items[pageID{userName, pageName}][itemID]
+		if !found {
+			return okinotes.NotInDatastoreError{"Item", itemID}
+		}
+		item = copyItem(i)
+		return nil
+	})
+	return item, err
+}
+func (repo repository) StoreItem(userName string, pageName string, i okinotes.Item) error {
+	i = copyItem(i)
+	return repo.write(func(d *data) error {
+		id := pageID{userName, pageName}
+		if d.items[id] == nil {
+			d.items[id] = make(map[string]okinotes.Item)
+		}
+		d.items[id][i.ID] = i
+		return nil
+	})
+}
+func (repo repository) DeleteItem(userName string, pageName string, itemID string) error {
+	return repo.write(func(d *data) error {
+		delete(d.items[pageID{userName, pageName}], itemID)
+		return nil
+	})
+}
+
+func (repo repository) GetRevisions(userName string, pageName string, itemID string) ([]okinotes.Revision, error) {
+	var revisions []okinotes.Revision
+	err := repo.read(func(d *data) error {
+		for _, r := range d.revisions[itemRef{pageID{userName, pageName}, itemID}] {
+			revisions = append(revisions, copyRevision(r))
+		}
+		return nil
+	})
+	return revisions, err
+}
+func (repo repository) GetRevision(userName string, pageName string, itemID string, number int) (okinotes.Revision, error) {
+	var revision okinotes.Revision
+	err := repo.read(func(d *data) error {
+		for _, r := range d.revisions[itemRef{pageID{userName, pageName}, itemID}] {
+			if r.Number == number {
+				revision = copyRevision(r)
+				return nil
+			}
+		}
+		return okinotes.NotInDatastoreError{"Revision", strconv.Itoa(number)}
+	})
+	return revision, err
+}
+func (repo repository) StoreRevision(userName string, pageName string, r okinotes.Revision) error {
+	r = copyRevision(r)
+	return repo.write(func(d *data) error {
+		id := itemRef{pageID{userName, pageName}, r.ItemID}
+
+		//The slices are shared with the snapshots: always build a new one
+		var revisions []okinotes.Revision
+		for _, old := range d.revisions[id] {
+			if old.Number != r.Number {
+				revisions = append(revisions, old)
+			}
+		}
+		revisions = append(revisions, r)
+		sort.Slice(revisions, func(i, j int) bool {
+			return revisions[i].Number < revisions[j].Number
+		})
+		d.revisions[id] = revisions
+		return nil
+	})
+}
+func (repo repository) DeleteRevisions(userName string, pageName string, itemID string) error {
+	return repo.write(func(d *data) error {
+		delete(d.revisions, itemRef{pageID{userName, pageName}, itemID})
+		return nil
+	})
+}
+func (repo repository) DeleteRevisionsFromPage(userName string, pageName string) error {
+	return repo.write(func(d *data) error {
+		for id := range d.revisions {
+			if id.pageID == (pageID{userName, pageName}) {
+				delete(d.revisions, id)
+			}
+		}
+		return nil
+	})
+}
+
+func (repo repository) getTrash(match func(e okinotes.TrashEntry) bool) ([]okinotes.TrashEntry, error) {
+	var entries []okinotes.TrashEntry
+	err := repo.read(func(d *data) error {
+		for _, e := range d.trash {
+			if match(e) {
+				entries = append(entries, copyTrashEntry(e))
+			}
+		}
+		return nil
+	})
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].DeletionDate.Equal(entries[j].DeletionDate) {
+			return entries[i].DeletionDate.After(entries[j].DeletionDate)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries, err
+}
+func (repo repository) GetTrash(userName string) ([]okinotes.TrashEntry, error) {
+	return repo.getTrash(func(e okinotes.TrashEntry) bool {
+		return e.UserName == userName
+	})
+}
+func (repo repository) GetTrashEntry(userName string, entryID string) (okinotes.TrashEntry, error) {
+	var entry okinotes.TrashEntry
+	err := repo.read(func(d *data) error {
+		e, found := d.trash[trashEntryID{userName, entryID}]
+		if !found {
+			return okinotes.NotInDatastoreError{"Trash entry", entryID}
+		}
+		entry = copyTrashEntry(e)
+		return nil
+	})
+	return entry, err
+}
+func (repo repository) GetExpiredTrash(before time.Time) ([]okinotes.TrashEntry, error) {
+	return repo.getTrash(func(e okinotes.TrashEntry) bool {
+		return e.DeletionDate.Before(before)
+	})
+}
+func (repo repository) StoreTrashEntry(e okinotes.TrashEntry) error {
+	e = copyTrashEntry(e)
+	return repo.write(func(d *data) error {
+		d.trash[trashEntryID{e.UserName, e.ID}] = e
+		return nil
+	})
+}
+func (repo repository) DeleteTrashEntry(userName string, entryID string) error {
+	return repo.write(func(d *data) error {
+		delete(d.trash, trashEntryID{userName, entryID})
+		return nil
+	})
+}
+
+func (repo repository) StoreSearchDocument(doc okinotes.SearchDocument) error {
+	doc.Terms = append([]string(nil), doc.Terms...)
+	return repo.write(func(d *data) error {
+		d.search[itemRef{pageID{doc.UserName, doc.PageName}, doc.ItemID}] = doc
+		return nil
+	})
+}
+func (repo repository) DeleteSearchDocument(userName string, pageName string, itemID string) error {
+	return repo.write(func(d *data) error {
+		delete(d.search, itemRef{pageID{userName, pageName}, itemID})
+		return nil
+	})
+}
+func (repo repository) DeleteSearchDocumentsFromPage(userName string, pageName string) error {
+	return repo.write(func(d *data) error {
+		for id := range d.search {
+			if id.pageID == (pageID{userName, pageName}) {
+				delete(d.search, id)
+			}
+		}
+		return nil
+	})
+}
+func (repo repository) FindSearchDocuments(terms []string, limit int, cursor string) ([]okinotes.SearchDocument, string, error) {
+	var documents []okinotes.SearchDocument
+	err := repo.read(func(d *data) error {
+		for _, doc := range d.search {
+			if okinotes.HasAllTerms(doc, terms) {
+				doc.Terms = append([]string(nil), doc.Terms...)
+				documents = append(documents, doc)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	sort.Slice(documents, func(i, j int) bool {
+		return okinotes.CompareSearchDocuments(documents[i], documents[j]) < 0
+	})
+	return okinotes.PaginateSearchDocuments(documents, cursor, limit)
+}
+
+func (repo repository) GetPermission(pageUserName string, pageName string, userName string) (okinotes.Permission, error) {
+	var p okinotes.Permission
+	err := repo.read(func(d *data) error {
+		var found bool
+		p, found = d.permissions[permissionID{pageID{pageUserName, pageName}, userName}]
+		if !found {
+			return okinotes.NotInDatastoreError{"Permission", userName}
+		}
+		return nil
+	})
+	return p, err
+}
+func (repo repository) getPermissions(match func(p okinotes.Permission) bool) ([]okinotes.Permission, error) {
+	var permissions []okinotes.Permission
+	err := repo.read(func(d *data) error {
+		for _, p := range d.permissions {
+			if match(p) {
+				permissions = append(permissions, p)
+			}
+		}
+		return nil
+	})
+	sort.Slice(permissions, func(i, j int) bool {
+		a, b := permissions[i], permissions[j]
+		if a.PageUserName != b.PageUserName {
+			return a.PageUserName < b.PageUserName
+		}
+		if a.PageName != b.PageName {
+			return a.PageName < b.PageName
+		}
+		return a.UserName < b.UserName
+	})
+	return permissions, err
+}
+func (repo repository) GetPagePermissions(pageUserName string, pageName string) ([]okinotes.Permission, error) {
+	return repo.getPermissions(func(p okinotes.Permission) bool {
+		return p.PageUserName == pageUserName && p.PageName == pageName
+	})
+}
+func (repo repository) GetUserPermissions(userName string) ([]okinotes.Permission, error) {
+	return repo.getPermissions(func(p okinotes.Permission) bool {
+		return p.UserName == userName
+	})
+}
+func (repo repository) StorePermission(p okinotes.Permission) error {
+	return repo.write(func(d *data) error {
+		d.permissions[permissionID{pageID{p.PageUserName, p.PageName}, p.UserName}] = p
+		return nil
+	})
+}
+func (repo repository) DeletePermission(pageUserName string, pageName string, userName string) error {
+	return repo.write(func(d *data) error {
+		delete(d.permissions, permissionID{pageID{pageUserName, pageName}, userName})
+		return nil
+	})
+}
+
+func (repo repository) FindUser(userName string) (bool, error) {
+	var found bool
+	err := repo.read(func(d *data) error {
+		_, found = d.users[userName]
+		return nil
+	})
+	return found, err
+}
+func (repo repository) GetUser(ident okinotes.Ident) (okinotes.User, error) {
+	var user okinotes.User
+	err := repo.read(func(d *data) error {
+		identity, found := d.identities[ident]
+		if !found {
+			return okinotes.NotInDatastoreError{"Identity", ident.Identity}
+		}
+		user = d.users[identity.UserName]
+		return nil
+	})
+	return user, err
+}
+func (repo repository) GetUserByName(userName string) (okinotes.User, error) {
+	var user okinotes.User
+	err := repo.read(func(d *data) error {
+		var found bool
+		user, found = d.users[userName]
+		if !found {
+			return okinotes.NotInDatastoreError{"User", userName}
+		}
+		return nil
+	})
+	return user, err
+}
+func (repo repository) GetIdentity(ident okinotes.Ident) (okinotes.Identity, error) {
+	var identity okinotes.Identity
+	err := repo.read(func(d *data) error {
+		var found bool
+		identity, found = d.identities[ident]
+		if !found {
+			return okinotes.NotInDatastoreError{"Identity", ident.Identity}
+		}
+		return nil
+	})
+	return identity, err
+}
+func (repo repository) StoreUser(user okinotes.User) error {
+	return repo.write(func(d *data) error {
+		d.users[user.Name] = user
+		return nil
+	})
+}
+func (repo repository) StoreIdentity(identity okinotes.Identity) error {
+	return repo.write(func(d *data) error {
+		d.identities[identity.Ident] = identity
+		return nil
+	})
+}
+
+func (repo repository) GetIdentities(userName string) ([]okinotes.Identity, error) {
+	var identities []okinotes.Identity
+	err := repo.read(func(d *data) error {
+		for _, identity := range d.identities {
+			if identity.UserName == userName {
+				identities = append(identities, identity)
+			}
+		}
+		return nil
+	})
+	sort.Slice(identities, func(i, j int) bool {
+		if identities[i].Provider != identities[j].Provider {
+			return identities[i].Provider < identities[j].Provider
+		}
+		return identities[i].Identity < identities[j].Identity
+	})
+	return identities, err
+}
+func (repo repository) DeleteIdentity(identity okinotes.Identity) error {
+	return repo.write(func(d *data) error {
+		delete(d.identities, identity.Ident)
+		return nil
+	})
+}
+
+func (repo repository) GetMembership(orgName string, userName string) (okinotes.Membership, error) {
+	var membership okinotes.Membership
+	err := repo.read(func(d *data) error {
+		var found bool
+		membership, found = d.memberships[membershipID{orgName, userName}]
+		if !found {
+			return okinotes.NotInDatastoreError{"Membership", userName}
+		}
+		return nil
+	})
+	return membership, err
+}
+func (repo repository) GetMembers(orgName string) ([]okinotes.Membership, error) {
+	var memberships []okinotes.Membership
+	err := repo.read(func(d *data) error {
+		for _, m := range d.memberships {
+			if m.OrgName == orgName {
+				memberships = append(memberships, m)
+			}
+		}
+		return nil
+	})
+	sort.Slice(memberships, func(i, j int) bool {
+		return memberships[i].UserName < memberships[j].UserName
+	})
+	return memberships, err
+}
+func (repo repository) GetMemberships(userName string) ([]okinotes.Membership, error) {
+	var memberships []okinotes.Membership
+	err := repo.read(func(d *data) error {
+		for _, m := range d.memberships {
+			if m.UserName == userName {
+				memberships = append(memberships, m)
+			}
+		}
+		return nil
+	})
+	sort.Slice(memberships, func(i, j int) bool {
+		return memberships[i].OrgName < memberships[j].OrgName
+	})
+	return memberships, err
+}
+func (repo repository) StoreMembership(membership okinotes.Membership) error {
+	return repo.write(func(d *data) error {
+		d.memberships[membershipID{membership.OrgName, membership.UserName}] = membership
+		return nil
+	})
+}
+func (repo repository) DeleteMembership(orgName string, userName string) error {
+	return repo.write(func(d *data) error {
+		delete(d.memberships, membershipID{orgName, userName})
+		return nil
+	})
+}
+
+func (repo repository) GetCredentials(login string) (okinotes.Credentials, error) {
+	var credentials okinotes.Credentials
+	err := repo.read(func(d *data) error {
+		var found bool
+		credentials, found = d.credentials[login]
+		if !found {
+			return okinotes.NotInDatastoreError{"Credentials", login}
+		}
+		return nil
+	})
+	return credentials, err
+}
+func (repo repository) StoreCredentials(credentials okinotes.Credentials) error {
+	return repo.write(func(d *data) error {
+		d.credentials[credentials.Login] = credentials
+		return nil
+	})
+}
+
+func (repo repository) GetToken(hash string) (okinotes.Token, error) {
+	var token okinotes.Token
+	err := repo.read(func(d *data) error {
+		var found bool
+		token, found = d.tokens[hash]
+		if !found {
+			return okinotes.NotInDatastoreError{"Token", hash}
+		}
+		return nil
+	})
+	return token, err
+}
+func (repo repository) GetTokens(userName string, kind okinotes.TokenKind) ([]okinotes.Token, error) {
+	var tokens []okinotes.Token
+	err := repo.read(func(d *data) error {
+		for _, token := range d.tokens {
+			if token.UserName == userName && token.Kind == kind {
+				tokens = append(tokens, token)
+			}
+		}
+		return nil
+	})
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreationDate.Equal(tokens[j].CreationDate) {
+			return tokens[i].CreationDate.Before(tokens[j].CreationDate)
+		}
+		return tokens[i].Hash < tokens[j].Hash
+	})
+	return tokens, err
+}
+func (repo repository) StoreToken(token okinotes.Token) error {
+	token.Scopes = append([]okinotes.Scope(nil), token.Scopes...)
+	return repo.write(func(d *data) error {
+		d.tokens[token.Hash] = token
+		return nil
+	})
+}
+func (repo repository) DeleteToken(hash string) error {
+	return repo.write(func(d *data) error {
+		delete(d.tokens, hash)
+		return nil
+	})
+}
+
+func (repo repository) GetImages(userName string, limit int) ([]okinotes.UploadInfo, error) {
+	var imgs []okinotes.UploadInfo
+	err := repo.read(func(d *data) error {
+		for _, img := range d.images[userName] {
+			imgs = append(imgs, img)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(imgs, func(i, j int) bool {
+		if imgs[i].Filename != imgs[j].Filename {
+			return imgs[i].Filename < imgs[j].Filename
+		}
+		return imgs[i].Key < imgs[j].Key
+	})
+
+	if limit >= 0 && len(imgs) > limit {
+		imgs = imgs[:limit]
+	}
+	return imgs, nil
+}
+func (repo repository) StoreImage(img okinotes.UploadInfo, userName string) error {
+	return repo.write(func(d *data) error {
+		if d.images[userName] == nil {
+			d.images[userName] = make(map[string]okinotes.UploadInfo)
+		}
+		d.images[userName][img.Key] = img
+		return nil
+	})
+}
+func (repo repository) RenameImage(name string, imgID string, userName string) error {
+	return repo.write(func(d *data) error {
+		img, found := d.images[userName][imgID]
+		if !found {
+			return okinotes.NotInDatastoreError{"Image", imgID}
+		}
+		img.Filename = name
+		d.images[userName][imgID] = img
+		return nil
+	})
+}
+func (repo repository) DeleteImage(imgID string, userName string) error {
+	return repo.write(func(d *data) error {
+		delete(d.images[userName], imgID)
+		return nil
+	})
+}
+
+func (repo repository) IsUsed(imgID string) (bool, error) {
+	var used bool
+	err := repo.read(func(d *data) error {
+		for _, usages := range d.usages {
+			if _, found := usages[imgID]; found {
+				used = true
+				return nil
+			}
+		}
+		return nil
+	})
+	return used, err
+}
+func (repo repository) StoreUsage(userName string, pageName string, imgID string) error {
+	return repo.write(func(d *data) error {
+		id := pageID{userName, pageName}
+		if d.usages[id] == nil {
+			d.usages[id] = make(map[string]okinotes.Usage)
+		}
+		d.usages[id][imgID] = okinotes.Usage{UploadInfoKey: imgID}
+		return nil
+	})
+}
+func (repo repository) DeleteUsages(userName string, pageName string) error {
+	return repo.write(func(d *data) error {
+		delete(d.usages, pageID{userName, pageName})
+		return nil
+	})
+}
+
+func (repo repository) GetTemplate(templateID string) (okinotes.Template, error) {
+	var template okinotes.Template
+	err := repo.read(func(d *data) error {
+		t, found := d.templates[templateID]
+		if !found {
+			return okinotes.NotInDatastoreError{"Template", templateID}
+		}
+		template = copyTemplate(t)
+		return nil
+	})
+	return template, err
+}
+func (repo repository) GetAllTemplates() ([]okinotes.Template, error) {
+	var templates []okinotes.Template
+	err := repo.read(func(d *data) error {
+		for _, t := range d.templates {
+			templates = append(templates, copyTemplate(t))
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(templates, func(i, j int) bool {
+		if templates[i].Name != templates[j].Name {
+			return templates[i].Name < templates[j].Name
+		}
+		return templates[i].ID < templates[j].ID
+	})
+	return templates, nil
+}
+func (repo repository) StoreTemplate(tpl okinotes.Template, generateID func() string) (string, error) {
+	tpl = copyTemplate(tpl)
+	err := repo.write(func(d *data) error {
+		//Generate a new template id if not set
+		if len(tpl.ID) == 0 {
+			for {
+				tpl.ID = generateID()
+				if _, found := d.templates[tpl.ID]; !found {
+					break
+				}
+			}
+		}
+
+		if oldTemplate, found := d.templates[tpl.ID]; found {
+			tpl.CreationDate = oldTemplate.CreationDate
+		} else {
+			tpl.CreationDate = tpl.LastModificationDate
+		}
+
+		d.templates[tpl.ID] = tpl
+		return nil
+	})
+	return tpl.ID, err
+}
+func (repo repository) DeleteTemplate(templateID string) error {
+	return repo.write(func(d *data) error {
+		delete(d.templates, templateID)
+		return nil
+	})
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package memory
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/okinotes/okinotes"
+	"github.com/okinotes/okinotes/repotest"
+)
+
+func TestRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) okinotes.Repository {
+		return NewRepository()
+	})
+}
+
+func TestTransactionIsolation(t *testing.T) {
+	repo := NewRepository()
+
+	err := repo.RunInTransaction(func(tx okinotes.Repository) error {
+		if err := tx.StoreUser(okinotes.User{Name: "user01"}); err != nil {
+			return err
+		}
+		if found, _ := repo.FindUser("user01"); found {
+			t.Errorf("FindUser outside transaction = true before commit")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := repo.FindUser("user01"); !found {
+		t.Errorf("FindUser = false after commit")
+	}
+}
+
+func TestConcurrentTransactions(t *testing.T) {
+	repo := NewRepository()
+	if err := repo.StorePage(okinotes.Page{UserName: "user01", Name: "page01"}); err != nil {
+		t.Fatal(err)
+	}
+
+	//Each transaction appends a tag: lost updates would show as missing tags
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	committed := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := repo.RunInTransaction(func(tx okinotes.Repository) error {
+				page, err := tx.GetPage("user01", "page01")
+				if err != nil {
+					return err
+				}
+				page.Tags.SetTag(fmt.Sprintf("tag%02d", i), "x")
+				return tx.StorePage(page)
+			})
+			if err == nil {
+				mu.Lock()
+				committed++
+				mu.Unlock()
+			} else if err != ErrConcurrentTransaction {
+				t.Error(err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	page, err := repo.GetPage("user01", "page01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Tags) != committed {
+		t.Errorf("page has %d tags, wanted %d (one per committed transaction)", len(page.Tags), committed)
+	}
+}
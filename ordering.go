@@ -0,0 +1,234 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//An ItemOrder defines how the items of a page are listed
+type ItemOrder string
+
+const (
+	//ItemOrderMODIFIED lists the most recently modified items first. It is the order of the pages without setting.
+	ItemOrderMODIFIED ItemOrder = "MODIFIED"
+	//ItemOrderCREATED lists the most recently created items first
+	ItemOrderCREATED ItemOrder = "CREATED"
+	//ItemOrderMANUAL lists the items in the order chosen by the editors of the page
+	ItemOrderMANUAL ItemOrder = "MANUAL"
+)
+
+//checkItemOrder verifies that an order is known. The empty order is the default one.
+func checkItemOrder(order ItemOrder) error {
+	switch order {
+	case "", ItemOrderMODIFIED, ItemOrderCREATED, ItemOrderMANUAL:
+		return nil
+	}
+	return DataError{"item order", "must be one of MODIFIED, CREATED or MANUAL"}
+}
+
+//positionDigits are the digits of the positions of the items, in ascending order
+const positionDigits = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+//positionBetween returns a position sorting strictly between a and b, with a < b.
+//An empty a is before all the positions, and an empty b is after all the positions.
+//Positions never end with the first digit, so that there is always room before them.
+func positionBetween(a, b string) string {
+	if len(b) > 0 {
+		//Keep the common prefix, a being padded with the first digit
+		n := 0
+		for n < len(b) && positionDigit(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			if n > len(a) {
+				return b[:n] + positionBetween("", b[n:])
+			}
+			return b[:n] + positionBetween(a[n:], b[n:])
+		}
+	}
+
+	digitA := 0
+	if len(a) > 0 {
+		digitA = strings.IndexByte(positionDigits, a[0])
+	}
+	digitB := len(positionDigits)
+	if len(b) > 0 {
+		digitB = strings.IndexByte(positionDigits, b[0])
+	}
+	if digitB-digitA > 1 {
+		return string(positionDigits[(digitA+digitB+1)/2])
+	}
+
+	//Consecutive digits: the position is longer than a
+	if len(b) > 1 {
+		return b[:1]
+	}
+	if len(a) > 0 {
+		a = a[1:]
+	}
+	return string(positionDigits[digitA]) + positionBetween(a, "")
+}
+
+//positionDigit returns the digit n of a position, padded with the first digit
+func positionDigit(p string, n int) byte {
+	if n < len(p) {
+		return p[n]
+	}
+	return positionDigits[0]
+}
+
+//newPositions returns n increasing positions of the same length, evenly spaced
+func newPositions(n int) []string {
+	width := 1
+	for max := len(positionDigits); max < n; max *= len(positionDigits) {
+		width++
+	}
+
+	positions := make([]string, n)
+	for i := range positions {
+		p := strconv.FormatInt(int64(i), len(positionDigits))
+		//The last digit leaves room before and after each position
+		positions[i] = strings.Repeat("0", width-len(p)) + p + "i"
+	}
+	return positions
+}
+
+//validPositions returns true if all the items have distinct and well formed positions
+func validPositions(items []Item) bool {
+	positions := make([]string, len(items))
+	for n, i := range items {
+		if len(i.Position) == 0 || i.Position[len(i.Position)-1] == positionDigits[0] {
+			return false
+		}
+		for _, r := range i.Position {
+			if !strings.ContainsRune(positionDigits, r) {
+				return false
+			}
+		}
+		positions[n] = i.Position
+	}
+
+	sort.Strings(positions)
+	for n := 1; n < len(positions); n++ {
+		if positions[n] == positions[n-1] {
+			return false
+		}
+	}
+	return true
+}
+
+//positionItems gives new positions to the items of a page following the given order,
+//unless they all already have valid positions, in the given transaction.
+//Returns the items in manual order.
+func positionItems(repo Repository, userName string, pageName string, order ItemOrder) ([]Item, error) {
+	items, _, err := repo.GetItemsFromPage(userName, pageName, order, -1, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if !validPositions(items) {
+		for n, p := range newPositions(len(items)) {
+			items[n].Position = p
+			if err := repo.StoreItem(userName, pageName, items[n]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	sort.SliceStable(items, func(i, j int) bool {
+		return CompareItems(ItemOrderMANUAL, items[i], items[j]) < 0
+	})
+	return items, nil
+}
+
+//firstPosition returns the position of a new item added at the top of a page, in the given transaction
+func firstPosition(repo Repository, userName string, pageName string) (string, error) {
+	first, _, err := repo.GetItemsFromPage(userName, pageName, ItemOrderMANUAL, 1, "")
+	if err != nil {
+		return "", err
+	}
+	if len(first) == 0 || len(first[0].Position) == 0 {
+		return positionBetween("", ""), nil
+	}
+	return positionBetween("", first[0].Position), nil
+}
+
+//MoveItem moves an item of a page ordered manually just before another item (the target),
+//or just after it if after is true. Only the moved item is changed, except on the first move
+//of the items of a page without positions (created before the manual ordering was available).
+//Returns the moved item.
+func (app App) MoveItem(userName, pageName string, itemID string, targetID string, after bool) (Item, error) {
+	if err := app.checkScope(ScopeWRITEITEMS, "Move item"); err != nil {
+		return Item{}, err
+	}
+	if err := app.checkRole(userName, pageName, RoleEDITOR, "Move item"); err != nil {
+		return Item{}, err
+	}
+	if itemID == targetID {
+		return Item{}, DataError{"target", "an item cannot be moved next to itself"}
+	}
+
+	var moved Item
+	err := app.repository.RunInTransaction(func(repo Repository) error {
+		page, err := repo.GetPage(userName, pageName)
+		if err != nil {
+			return err
+		}
+		if page.ItemOrder != ItemOrderMANUAL {
+			return DataError{"page", "the items of the page are not ordered manually"}
+		}
+
+		items, err := positionItems(repo, userName, pageName, ItemOrderMANUAL)
+		if err != nil {
+			return err
+		}
+
+		//Locate the item and the target among the other items
+		found := false
+		var others []Item
+		for _, i := range items {
+			if i.ID == itemID {
+				moved = i
+				found = true
+			} else {
+				others = append(others, i)
+			}
+		}
+		if !found {
+			return NotInDatastoreError{"Item", itemID}
+		}
+		t := -1
+		for n, i := range others {
+			if i.ID == targetID {
+				t = n
+			}
+		}
+		if t < 0 {
+			return NotInDatastoreError{"Item", targetID}
+		}
+		if after {
+			t++
+		}
+
+		//The item takes place between others[t-1] and others[t]
+		var previous, next string
+		if t > 0 {
+			previous = others[t-1].Position
+		}
+		if t < len(others) {
+			next = others[t].Position
+		}
+		moved.Position = positionBetween(previous, next)
+
+		return repo.StoreItem(userName, pageName, moved)
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	return moved, nil
+}
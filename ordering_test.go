@@ -0,0 +1,103 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/okinotes/okinotes"
+)
+
+func TestItemOrder(t *testing.T) {
+	site := newTestSite(t, "user01")
+	repo, app := site.Repository, site.App("user01")
+
+	titles := func(pageName string, order okinotes.ItemOrder) string {
+		items, _, err := repo.GetItemsFromPage("user01", pageName, order, -1, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var titles []string
+		for _, i := range items {
+			titles = append(titles, i.Title)
+		}
+		return strings.Join(titles, ",")
+	}
+	create := func(pageName string, title string) okinotes.Item {
+		i, err := app.CreateItem("user01", pageName, okinotes.Item{Title: title, Content: title})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return i
+	}
+
+	//Switching a page to manual order keeps the current order of the items
+	if err := app.CreatePage(okinotes.Page{Name: "dated"}); err != nil {
+		t.Fatal(err)
+	}
+	x := create("dated", "x")
+	y := create("dated", "y")
+	if _, err := app.MoveItem("user01", "dated", x.ID, y.ID, false); err == nil {
+		t.Errorf("MoveItem succeeded on a page ordered by date")
+	}
+	if err := app.UpdatePage(okinotes.Page{UserName: "user01", Name: "dated", ItemOrder: okinotes.ItemOrderMANUAL}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.UpdateItem("user01", "dated", x, false); err != nil {
+		t.Fatal(err)
+	}
+	if got := titles("dated", okinotes.ItemOrderMANUAL); got != "y,x" {
+		t.Errorf("Items = %s after UpdateItem, wanted y,x", got)
+	}
+	if err := app.UpdatePage(okinotes.Page{UserName: "user01", Name: "dated", ItemOrder: "RANDOM"}, nil); err == nil {
+		t.Errorf("UpdatePage succeeded with an unknown item order")
+	}
+
+	//New items are added at the top, and moving an item changes only this item
+	if err := app.CreatePage(okinotes.Page{Name: "manual", ItemOrder: okinotes.ItemOrderMANUAL}); err != nil {
+		t.Fatal(err)
+	}
+	a := create("manual", "a")
+	b := create("manual", "b")
+	c := create("manual", "c")
+	if got := titles("manual", okinotes.ItemOrderMANUAL); got != "c,b,a" {
+		t.Fatalf("Items = %s, wanted c,b,a", got)
+	}
+
+	moved, err := app.MoveItem("user01", "manual", a.ID, c.ID, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved.Title != "a" || !moved.LastModificationDate.Equal(a.LastModificationDate) {
+		t.Errorf("MoveItem = %+v, wanted item a unchanged but its position", moved)
+	}
+	if _, err := app.MoveItem("user01", "manual", c.ID, b.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if got := titles("manual", okinotes.ItemOrderMANUAL); got != "a,b,c" {
+		t.Errorf("Items = %s after MoveItem, wanted a,b,c", got)
+	}
+
+	//Positions can always be found between two items
+	for n := 0; n < 50; n++ {
+		if _, err := app.MoveItem("user01", "manual", c.ID, b.ID, false); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := app.MoveItem("user01", "manual", b.ID, c.ID, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := titles("manual", okinotes.ItemOrderMANUAL); got != "a,b,c" {
+		t.Errorf("Items = %s after repeated moves, wanted a,b,c", got)
+	}
+	if got := titles("manual", okinotes.ItemOrderCREATED); got != "c,b,a" {
+		t.Errorf("Items = %s by creation date, wanted c,b,a", got)
+	}
+
+	if _, err := app.MoveItem("user01", "manual", a.ID, "unknown", false); err == nil {
+		t.Errorf("MoveItem succeeded with an unknown target")
+	}
+}
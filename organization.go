@@ -0,0 +1,175 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"regexp"
+)
+
+//orgNamePattern is the pattern of the names of organisations, sharing the namespace of users
+var orgNamePattern = regexp.MustCompile(`^[a-z0-9_\-]{3,}$`)
+
+//CreateOrganization creates an organisation, administrated by the current user
+func (app App) CreateOrganization(orgName string, fullName string) error {
+	identity, err := app.sessionIdentity("Create organization")
+	if err != nil {
+		return err
+	}
+
+	if !orgNamePattern.MatchString(orgName) {
+		return DataError{"organization name", "must contain at least 3 lowercase letters, digits, '_' or '-'"}
+	}
+	if len(fullName) == 0 {
+		fullName = orgName
+	}
+
+	org := User{
+		Name:     orgName,
+		Kind:     UserKindORG,
+		FullName: fullName,
+	}
+
+	return app.repository.RunInTransaction(func(repo Repository) error {
+		exists, err := repo.FindUser(orgName)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return DataError{"organization name", "already used"}
+		}
+
+		if err := repo.StoreUser(org); err != nil {
+			return err
+		}
+		return repo.StoreMembership(Membership{UserName: identity.UserName, OrgName: orgName, IsOrgAdmin: true})
+	})
+}
+
+//GetOrganization returns an organisation the current user is member of
+func (app App) GetOrganization(orgName string) (User, error) {
+	if _, err := app.membership(orgName, "Read organization"); err != nil {
+		return User{}, err
+	}
+
+	return app.repository.GetUserByName(orgName)
+}
+
+//Organizations returns the memberships of the current user
+func (app App) Organizations() ([]Membership, error) {
+	identity, err := app.sessionIdentity("List organizations")
+	if err != nil {
+		return nil, err
+	}
+
+	return app.repository.GetMemberships(identity.UserName)
+}
+
+//OrganizationMembers returns the members of an organisation the current user is member of
+func (app App) OrganizationMembers(orgName string) ([]Membership, error) {
+	if _, err := app.membership(orgName, "List members"); err != nil {
+		return nil, err
+	}
+
+	return app.repository.GetMembers(orgName)
+}
+
+//OrganizationPages returns the pages of an organisation the current user is member of
+func (app App) OrganizationPages(orgName string, limit int, cursor string) ([]Page, string, error) {
+	if _, err := app.membership(orgName, "List pages"); err != nil {
+		return nil, "", err
+	}
+
+	return app.repository.NewPageQuery().Filter("UserName=", orgName).Order("-LastModificationDate").Limit(limit).Start(cursor).GetAll()
+}
+
+//SetMember adds a user to an organisation, or changes its administrator flag.
+//Only the administrators of the organisation can manage its members.
+func (app App) SetMember(orgName string, userName string, isOrgAdmin bool) error {
+	if err := app.checkOrgAdmin(orgName, "Manage members"); err != nil {
+		return err
+	}
+
+	return app.repository.RunInTransaction(func(repo Repository) error {
+		user, err := repo.GetUserByName(userName)
+		if _, notFound := err.(NotInDatastoreError); notFound || (err == nil && user.Kind != UserKindUSER) {
+			return DataError{"user", "unknown user '" + userName + "'"}
+		}
+		if err != nil {
+			return err
+		}
+
+		if !isOrgAdmin {
+			if err := checkOtherAdmin(repo, orgName, userName); err != nil {
+				return err
+			}
+		}
+		return repo.StoreMembership(Membership{UserName: userName, OrgName: orgName, IsOrgAdmin: isOrgAdmin})
+	})
+}
+
+//RemoveMember removes a user from an organisation. Administrators of the organisation
+//can remove any member, other members can only leave the organisation.
+func (app App) RemoveMember(orgName string, userName string) error {
+	membership, err := app.membership(orgName, "Remove member")
+	if err != nil {
+		return err
+	}
+	if !membership.IsOrgAdmin && membership.UserName != userName {
+		return NotAuthorizedError{"Remove member"}
+	}
+
+	return app.repository.RunInTransaction(func(repo Repository) error {
+		if _, err := repo.GetMembership(orgName, userName); err != nil {
+			return err
+		}
+		if err := checkOtherAdmin(repo, orgName, userName); err != nil {
+			return err
+		}
+		return repo.DeleteMembership(orgName, userName)
+	})
+}
+
+//checkOtherAdmin verifies that the organisation keeps an administrator without userName
+func checkOtherAdmin(repo Repository, orgName string, userName string) error {
+	members, err := repo.GetMembers(orgName)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if m.IsOrgAdmin && m.UserName != userName {
+			return nil
+		}
+	}
+	return DataError{"member", "an organization must keep at least one administrator"}
+}
+
+//membership returns the membership of the current user in an organisation
+func (app App) membership(orgName string, operation string) (Membership, error) {
+	identity, err := app.sessionIdentity(operation)
+	if err != nil {
+		return Membership{}, err
+	}
+
+	membership, err := app.repository.GetMembership(orgName, identity.UserName)
+	if _, notFound := err.(NotInDatastoreError); notFound {
+		return Membership{}, NotAuthorizedError{operation}
+	}
+	if err != nil {
+		return Membership{}, err
+	}
+	return membership, nil
+}
+
+//checkOrgAdmin verifies that the current user is an administrator of the organisation
+func (app App) checkOrgAdmin(orgName string, operation string) error {
+	membership, err := app.membership(orgName, operation)
+	if err != nil {
+		return err
+	}
+	if !membership.IsOrgAdmin {
+		return NotAuthorizedError{operation}
+	}
+	return nil
+}
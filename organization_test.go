@@ -0,0 +1,76 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"testing"
+
+	"github.com/okinotes/okinotes"
+)
+
+func TestOrganizations(t *testing.T) {
+	admin, member, other := "user01", "user02", "user03"
+	newApp := newTestSite(t, admin, member, other).App
+
+	if err := newApp(admin).CreateOrganization("org01", "Organization 01"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newApp(member).CreateOrganization("user01", ""); err == nil {
+		t.Errorf("CreateOrganization with the name of a user succeeded")
+	}
+	if err := newApp(member).SetMember("org01", "user02", false); err == nil {
+		t.Errorf("SetMember by a non member succeeded")
+	}
+	if err := newApp(admin).SetMember("org01", "user02", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := newApp(admin).SetMember("org01", "user01", false); err == nil {
+		t.Errorf("SetMember removing the last administrator succeeded")
+	}
+
+	//Members create and edit the pages of the organization
+	if err := newApp(member).CreatePage(okinotes.Page{UserName: "org01", Name: "page01"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newApp(admin).CreateItem("org01", "page01", okinotes.Item{Content: "item"}); err != nil {
+		t.Errorf("CreateItem by a member: %v", err)
+	}
+	if _, err := newApp(other).GetPage("org01", "page01"); err == nil {
+		t.Errorf("GetPage of a private page by a non member succeeded")
+	}
+	if _, err := newApp(other).CreateItem("org01", "page01", okinotes.Item{Content: "item"}); err == nil {
+		t.Errorf("CreateItem by a non member succeeded")
+	}
+	if err := newApp(other).CreatePage(okinotes.Page{UserName: "org01", Name: "page02"}); err == nil {
+		t.Errorf("CreatePage by a non member succeeded")
+	}
+
+	//Only the administrators own them
+	if role, err := newApp(member).PageRole("org01", "page01"); err != nil || role != okinotes.RoleEDITOR {
+		t.Errorf("PageRole of a member = %s, %v, wanted %s", role, err, okinotes.RoleEDITOR)
+	}
+	if err := newApp(member).GrantRole("org01", "page01", "user03", okinotes.RoleVIEWER); err == nil {
+		t.Errorf("GrantRole by a member succeeded")
+	}
+	if err := newApp(admin).GrantRole("org01", "page01", "user03", okinotes.RoleVIEWER); err != nil {
+		t.Errorf("GrantRole by an administrator: %v", err)
+	}
+	if err := newApp(member).DeletePage("org01", "page01"); err == nil {
+		t.Errorf("DeletePage by a member succeeded")
+	}
+
+	if err := newApp(member).RemoveMember("org01", "user01"); err == nil {
+		t.Errorf("RemoveMember of another member by a non administrator succeeded")
+	}
+	if err := newApp(member).RemoveMember("org01", "user02"); err != nil {
+		t.Fatal(err)
+	}
+	if err := newApp(member).DeletePage("org01", "page01"); err == nil {
+		t.Errorf("DeletePage by a former member succeeded")
+	}
+	if memberships, _ := newApp(admin).Organizations(); len(memberships) != 1 || !memberships[0].IsOrgAdmin {
+		t.Errorf("Organizations = %+v", memberships)
+	}
+}
@@ -16,6 +16,8 @@ const (
 	PolicyPRIVATE Policy = "PRIVATE"
 	//PolicyPUBLIC means that anonymous user may access the data
 	PolicyPUBLIC Policy = "PUBLIC"
+	//PolicyUNLISTED means that anonymous user may access the data through a share link only
+	PolicyUNLISTED Policy = "UNLISTED"
 )
 
 //Page represents a collection of items. It belongs to a user
@@ -28,6 +30,7 @@ type Page struct {
 	ContentLicense       string    `json:"contentLicense"`
 	Policy               Policy    `json:"policy"`
 	TemplateID           string    `json:"templateID"`
+	ItemOrder            ItemOrder `json:"itemOrder"`
 	Tags                 TagList   `json:"tags"`
 }
 
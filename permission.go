@@ -0,0 +1,195 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+//Role represents the rights of a user on a page
+type Role string
+
+const (
+	//RoleOWNER can administrate, share and delete the page
+	RoleOWNER Role = "OWNER"
+	//RoleEDITOR can create, update and delete the items of the page
+	RoleEDITOR Role = "EDITOR"
+	//RoleVIEWER can read the page, even if private
+	RoleVIEWER Role = "VIEWER"
+)
+
+//roleRanks orders the roles, each role including the rights of the lower ones
+var roleRanks = map[Role]int{
+	RoleVIEWER: 1,
+	RoleEDITOR: 2,
+	RoleOWNER:  3,
+}
+
+//Allows returns true if the role includes the rights of the required role
+func (r Role) Allows(required Role) bool {
+	return roleRanks[r] > 0 && roleRanks[r] >= roleRanks[required]
+}
+
+//ParseRole validates a role name
+func ParseRole(s string) (Role, error) {
+	r := Role(s)
+	if _, found := roleRanks[r]; !found {
+		return "", DataError{"role", fmt.Sprintf("unknown role '%s'", s)}
+	}
+	return r, nil
+}
+
+//Permission grants a role on a page to a user.
+//The owner of the page and the administrators of the owning organisation have an implicit RoleOWNER,
+//the other members of the organisation an implicit RoleEDITOR.
+type Permission struct {
+	PageUserName string    `json:"pageUserName"`
+	PageName     string    `json:"pageName"`
+	UserName     string    `json:"userName"` //User receiving the role
+	Role         Role      `json:"role"`
+	CreationDate time.Time `json:"creationDate"`
+}
+
+//PageRole returns the role of the current user on a page.
+//Returns an empty role if the user has no right on the page.
+func (app App) PageRole(userName string, pageName string) (Role, error) {
+	currentUserName := app.CurrentUserName()
+	if len(currentUserName) == 0 {
+		return "", nil
+	}
+
+	role, err := app.memberRole(userName)
+	if err != nil {
+		return "", err
+	}
+	if role == RoleOWNER {
+		return role, nil
+	}
+
+	//A role granted on the page may extend the role of a member
+	p, err := app.repository.GetPermission(userName, pageName, currentUserName)
+	if _, notFound := err.(NotInDatastoreError); notFound {
+		return role, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if roleRanks[p.Role] > roleRanks[role] {
+		return p.Role, nil
+	}
+	return role, nil
+}
+
+//checkRole verifies that the current user has the required role on the page
+func (app App) checkRole(userName string, pageName string, required Role, operation string) error {
+	role, err := app.PageRole(userName, pageName)
+	if err != nil {
+		return err
+	}
+	if !role.Allows(required) {
+		return NotAuthorizedError{operation}
+	}
+	return nil
+}
+
+//PagePermissions returns the roles granted on a page
+func (app App) PagePermissions(userName string, pageName string) ([]Permission, error) {
+	if err := app.checkScope(ScopeADMINPAGES, "List permissions"); err != nil {
+		return nil, err
+	}
+	if err := app.checkRole(userName, pageName, RoleOWNER, "List permissions"); err != nil {
+		return nil, err
+	}
+
+	return app.repository.GetPagePermissions(userName, pageName)
+}
+
+//GrantRole gives a role on a page to another user, replacing its previous role
+func (app App) GrantRole(userName string, pageName string, grantee string, role Role) error {
+	if err := app.checkScope(ScopeADMINPAGES, "Share page"); err != nil {
+		return err
+	}
+	if err := app.checkRole(userName, pageName, RoleOWNER, "Share page"); err != nil {
+		return err
+	}
+	if _, found := roleRanks[role]; !found {
+		return DataError{"role", fmt.Sprintf("unknown role '%s'", role)}
+	}
+	if grantee == userName {
+		return DataError{"user", "the page already belongs to " + grantee}
+	}
+
+	return app.repository.RunInTransaction(func(repo Repository) error {
+		if _, err := repo.GetPage(userName, pageName); err != nil {
+			return err
+		}
+		user, err := repo.GetUserByName(grantee)
+		if _, notFound := err.(NotInDatastoreError); notFound || (err == nil && user.Kind != UserKindUSER) {
+			return DataError{"user", "unknown user '" + grantee + "'"}
+		}
+		if err != nil {
+			return err
+		}
+
+		p := Permission{
+			PageUserName: userName,
+			PageName:     pageName,
+			UserName:     grantee,
+			Role:         role,
+			CreationDate: time.Now(),
+		}
+		return repo.StorePermission(p)
+	})
+}
+
+//RevokeRole removes the role of a user on a page
+func (app App) RevokeRole(userName string, pageName string, grantee string) error {
+	if err := app.checkScope(ScopeADMINPAGES, "Share page"); err != nil {
+		return err
+	}
+	if err := app.checkRole(userName, pageName, RoleOWNER, "Share page"); err != nil {
+		return err
+	}
+
+	return app.repository.DeletePermission(userName, pageName, grantee)
+}
+
+//ListSharedPages returns the pages on which a role was granted to the current user,
+//the most recently modified first.
+//Returns the cursor of the next pages, if any.
+func (app App) ListSharedPages(limit int, cursor string) ([]Page, string, error) {
+	if err := app.checkScope(ScopeREAD, "List pages"); err != nil {
+		return nil, "", err
+	}
+	currentUserName := app.CurrentUserName()
+	if len(currentUserName) == 0 {
+		return nil, "", NotAuthorizedError{"List pages"}
+	}
+
+	permissions, err := app.repository.GetUserPermissions(currentUserName)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var pages []Page
+	for _, p := range permissions {
+		page, err := app.repository.GetPage(p.PageUserName, p.PageName)
+		if _, notFound := err.(NotInDatastoreError); notFound {
+			continue //Deleted in the meantime
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		pages = append(pages, page)
+	}
+	orders := []PageOrder{{Field: "LastModificationDate", Descending: true}}
+	sort.Slice(pages, func(i, j int) bool {
+		return ComparePages(orders, pages[i], pages[j]) < 0
+	})
+
+	return PaginatePages(pages, orders, cursor, limit)
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"testing"
+
+	"github.com/okinotes/okinotes"
+)
+
+func TestPermissions(t *testing.T) {
+	newApp := newTestSite(t, "owner", "editor", "viewer", "stranger").App
+
+	owner := newApp("owner")
+	if err := owner.CreatePage(okinotes.Page{Name: "page01"}); err != nil {
+		t.Fatal(err)
+	}
+	item, err := owner.CreateItem("owner", "page01", okinotes.Item{Content: "item"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.GrantRole("owner", "page01", "editor", okinotes.RoleEDITOR); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.GrantRole("owner", "page01", "viewer", okinotes.RoleVIEWER); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.GrantRole("owner", "page01", "unknown", okinotes.RoleVIEWER); err == nil {
+		t.Errorf("GrantRole to an unknown user succeeded")
+	}
+	if err := newApp("editor").GrantRole("owner", "page01", "stranger", okinotes.RoleVIEWER); err == nil {
+		t.Errorf("GrantRole by an editor succeeded")
+	}
+
+	for userName, wanted := range map[string]okinotes.Role{"owner": okinotes.RoleOWNER, "editor": okinotes.RoleEDITOR, "viewer": okinotes.RoleVIEWER, "stranger": ""} {
+		app := newApp(userName)
+		if role, err := app.PageRole("owner", "page01"); err != nil || role != wanted {
+			t.Errorf("PageRole of %s = %q, %v, wanted %q", userName, role, err, wanted)
+		}
+		_, err := app.GetPage("owner", "page01")
+		if canRead := err == nil; canRead != wanted.Allows(okinotes.RoleVIEWER) {
+			t.Errorf("GetPage by %s: %v", userName, err)
+		}
+		err = app.SetItemTag("owner", "page01", item.ID, "color", "red")
+		if canEdit := err == nil; canEdit != wanted.Allows(okinotes.RoleEDITOR) {
+			t.Errorf("SetItemTag by %s: %v", userName, err)
+		}
+	}
+
+	if pages, _, err := newApp("viewer").ListSharedPages(10, ""); err != nil || len(pages) != 1 || pages[0].Name != "page01" {
+		t.Errorf("ListSharedPages = %v, %v", pages, err)
+	}
+	if err := owner.CreatePage(okinotes.Page{Name: "page02"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.GrantRole("owner", "page02", "viewer", okinotes.RoleVIEWER); err != nil {
+		t.Fatal(err)
+	}
+	pages, next, err := newApp("viewer").ListSharedPages(1, "")
+	if err != nil || len(pages) != 1 || len(next) == 0 {
+		t.Fatalf("ListSharedPages with a limit = %v, %q, %v, wanted a cursor", pages, next, err)
+	}
+	if more, next, err := newApp("viewer").ListSharedPages(1, next); err != nil || len(more) != 1 || more[0].Name == pages[0].Name || len(next) != 0 {
+		t.Errorf("ListSharedPages after the cursor = %v, %q, %v, wanted the other page", more, next, err)
+	}
+	if err := owner.RevokeRole("owner", "page01", "viewer"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newApp("viewer").GetPage("owner", "page01"); err == nil {
+		t.Errorf("GetPage succeeded after RevokeRole")
+	}
+	if err := newApp("editor").DeleteItem("owner", "page01", item.ID); err != nil {
+		t.Errorf("DeleteItem by an editor: %v", err)
+	}
+	if err := newApp("editor").DeletePage("owner", "page01"); err == nil {
+		t.Errorf("DeletePage by an editor succeeded")
+	}
+}
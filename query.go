@@ -0,0 +1,186 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+//pageFields lists the fields of a Page usable in a PageQuery
+var pageFields = map[string]func(p Page) interface{}{
+	"UserName":             func(p Page) interface{} { return p.UserName },
+	"Name":                 func(p Page) interface{} { return p.Name },
+	"CreationDate":         func(p Page) interface{} { return p.CreationDate },
+	"LastModificationDate": func(p Page) interface{} { return p.LastModificationDate },
+	"Title":                func(p Page) interface{} { return p.Title },
+	"ContentLicense":       func(p Page) interface{} { return p.ContentLicense },
+	"Policy":               func(p Page) interface{} { return p.Policy },
+	"TemplateID":           func(p Page) interface{} { return p.TemplateID },
+}
+
+//filterOperators lists the operators allowed in PageQuery.Filter.
+//Longest operators first so that "<=" is not read as "<".
+var filterOperators = []string{"<=", ">=", "=", "<", ">"}
+
+//PageFilter is a condition on a field of a Page, as given to PageQuery.Filter.
+//It allows Repository implementations without a native query engine to
+//evaluate the datastore-style filters used by the App.
+type PageFilter struct {
+	Field    string
+	Operator string
+	Value    interface{}
+}
+
+//ParsePageFilter parses a filter string such as "Policy =" or "UserName=".
+func ParsePageFilter(filterStr string, value interface{}) (PageFilter, error) {
+	s := strings.TrimSpace(filterStr)
+
+	for _, op := range filterOperators {
+		if strings.HasSuffix(s, op) {
+			field := strings.TrimSpace(strings.TrimSuffix(s, op))
+			if _, ok := pageFields[field]; !ok {
+				return PageFilter{}, DataError{"filter", fmt.Sprintf("unknown page field '%s'", field)}
+			}
+			v, err := normalizeValue(value)
+			if err != nil {
+				return PageFilter{}, err
+			}
+			return PageFilter{field, op, v}, nil
+		}
+	}
+
+	return PageFilter{}, DataError{"filter", fmt.Sprintf("no operator in '%s'", filterStr)}
+}
+
+//Match returns true if the page satisfies the filter.
+func (f PageFilter) Match(p Page) (bool, error) {
+	c, err := compareValues(pageFields[f.Field](p), f.Value)
+	if err != nil {
+		return false, err
+	}
+
+	switch f.Operator {
+	case "=":
+		return c == 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	case ">=":
+		return c >= 0, nil
+	}
+	return false, DataError{"filter", fmt.Sprintf("unknown operator '%s'", f.Operator)}
+}
+
+//PageOrder is a sort order on a field of a Page, as given to PageQuery.Order.
+type PageOrder struct {
+	Field      string
+	Descending bool
+}
+
+//ParsePageOrder parses an order string such as "-LastModificationDate" or "Title".
+func ParsePageOrder(fieldName string) (PageOrder, error) {
+	s := strings.TrimSpace(fieldName)
+	o := PageOrder{}
+	if strings.HasPrefix(s, "-") {
+		o.Descending = true
+		s = strings.TrimSpace(s[1:])
+	}
+	if _, ok := pageFields[s]; !ok {
+		return PageOrder{}, DataError{"order", fmt.Sprintf("unknown page field '%s'", s)}
+	}
+	o.Field = s
+	return o, nil
+}
+
+//Compare returns -1, 0 or +1 depending on whether a sorts before, with or after b.
+func (o PageOrder) Compare(a, b Page) int {
+	c, err := compareValues(pageFields[o.Field](a), pageFields[o.Field](b))
+	if err != nil {
+		return 0
+	}
+	if o.Descending {
+		return -c
+	}
+	return c
+}
+
+//Value returns the value of the ordered field of a page
+func (o PageOrder) Value(p Page) interface{} {
+	return pageFields[o.Field](p)
+}
+
+//normalizeValue converts a filter value to one of the types handled by compareValues
+func normalizeValue(value interface{}) (interface{}, error) {
+	if t, ok := value.(time.Time); ok {
+		return t, nil
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int(), nil
+	case reflect.Bool:
+		return v.Bool(), nil
+	}
+	return nil, DataError{"filter", fmt.Sprintf("unsupported value type %T", value)}
+}
+
+//compareValues compares two values of the same (normalized) type
+func compareValues(a, b interface{}) (int, error) {
+	a, err := normalizeValue(a)
+	if err != nil {
+		return 0, err
+	}
+	b, err = normalizeValue(b)
+	if err != nil {
+		return 0, err
+	}
+
+	switch va := a.(type) {
+	case string:
+		if vb, ok := b.(string); ok {
+			return strings.Compare(va, vb), nil
+		}
+	case int64:
+		if vb, ok := b.(int64); ok {
+			switch {
+			case va < vb:
+				return -1, nil
+			case va > vb:
+				return 1, nil
+			}
+			return 0, nil
+		}
+	case bool:
+		if vb, ok := b.(bool); ok {
+			switch {
+			case va == vb:
+				return 0, nil
+			case !va:
+				return -1, nil
+			}
+			return 1, nil
+		}
+	case time.Time:
+		if vb, ok := b.(time.Time); ok {
+			switch {
+			case va.Before(vb):
+				return -1, nil
+			case va.After(vb):
+				return 1, nil
+			}
+			return 0, nil
+		}
+	}
+	return 0, DataError{"filter", fmt.Sprintf("cannot compare %T with %T", a, b)}
+}
@@ -6,6 +6,7 @@ package okinotes
 
 import (
 	"fmt"
+	"time"
 )
 
 //PageQuery allows querying multiple pages using conditions and ordering
@@ -14,8 +15,9 @@ type PageQuery interface {
 	Filter(filterStr string, value interface{}) PageQuery
 	Order(fieldName string) PageQuery
 	Limit(limit int) PageQuery
+	Start(cursor string) PageQuery //Resumes the query after the pages already read
 
-	GetAll() ([]Page, bool, error)
+	GetAll() ([]Page, string, error) //The returned cursor is empty if there are no more pages
 }
 
 //Repository is the interface allowing usage of any data store for Pages, Items and all other data
@@ -27,18 +29,58 @@ type Repository interface {
 	StorePage(page Page) error
 	DeletePage(userName string, pageName string) error
 
-	GetItemsFromPage(userName string, pageName string, limit int) ([]Item, error)
+	GetItemsFromPage(userName string, pageName string, order ItemOrder, limit int, cursor string) ([]Item, string, error)
 	DeleteItemsFromPage(userName string, pageName string) error
 	FindItem(userName string, pageName string, itemID string) (bool, error)
 	GetItem(userName string, pageName string, itemID string) (Item, error)
 	StoreItem(userName string, pageName string, i Item) error
 	DeleteItem(userName string, pageName string, itemID string) error
 
+	GetRevisions(userName string, pageName string, itemID string) ([]Revision, error)
+	GetRevision(userName string, pageName string, itemID string, number int) (Revision, error)
+	StoreRevision(userName string, pageName string, r Revision) error
+	DeleteRevisions(userName string, pageName string, itemID string) error
+	DeleteRevisionsFromPage(userName string, pageName string) error
+
+	GetTrash(userName string) ([]TrashEntry, error)
+	GetTrashEntry(userName string, entryID string) (TrashEntry, error)
+	GetExpiredTrash(before time.Time) ([]TrashEntry, error)
+	StoreTrashEntry(e TrashEntry) error
+	DeleteTrashEntry(userName string, entryID string) error
+
+	StoreSearchDocument(d SearchDocument) error
+	DeleteSearchDocument(userName string, pageName string, itemID string) error
+	DeleteSearchDocumentsFromPage(userName string, pageName string) error
+	FindSearchDocuments(terms []string, limit int, cursor string) ([]SearchDocument, string, error)
+
+	GetPermission(pageUserName string, pageName string, userName string) (Permission, error)
+	GetPagePermissions(pageUserName string, pageName string) ([]Permission, error)
+	GetUserPermissions(userName string) ([]Permission, error)
+	StorePermission(p Permission) error
+	DeletePermission(pageUserName string, pageName string, userName string) error
+
 	FindUser(userName string) (bool, error)
 	GetUser(ident Ident) (User, error)
+	GetUserByName(userName string) (User, error)
 	GetIdentity(ident Ident) (Identity, error)
 	StoreUser(user User) error
 	StoreIdentity(identity Identity) error
+	GetIdentities(userName string) ([]Identity, error)
+	DeleteIdentity(identity Identity) error
+
+	GetMembership(orgName string, userName string) (Membership, error)
+	GetMembers(orgName string) ([]Membership, error)
+	GetMemberships(userName string) ([]Membership, error)
+	StoreMembership(membership Membership) error
+	DeleteMembership(orgName string, userName string) error
+
+	GetCredentials(login string) (Credentials, error)
+	StoreCredentials(credentials Credentials) error
+
+	GetToken(hash string) (Token, error)
+	GetTokens(userName string, kind TokenKind) ([]Token, error)
+	StoreToken(token Token) error
+	DeleteToken(hash string) error
 
 	GetImages(userName string, limit int) ([]UploadInfo, error)
 	StoreImage(img UploadInfo, userName string) error
@@ -0,0 +1,812 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package repotest contains a test suite shared by the implementations of okinotes.Repository.
+package repotest
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/okinotes/okinotes"
+)
+
+//Run runs the whole suite. newRepository must return an empty repository at each call.
+func Run(t *testing.T, newRepository func(t *testing.T) okinotes.Repository) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, repo okinotes.Repository)
+	}{
+		{"Pages", testPages},
+		{"PageQuery", testPageQuery},
+		{"Items", testItems},
+		{"Revisions", testRevisions},
+		{"Trash", testTrash},
+		{"Search", testSearch},
+		{"Users", testUsers},
+		{"Memberships", testMemberships},
+		{"Permissions", testPermissions},
+		{"Tokens", testTokens},
+		{"Images", testImages},
+		{"Templates", testTemplates},
+		{"TransactionRollback", testTransactionRollback},
+	}
+
+	for _, test := range tests {
+		fn := test.fn
+		t.Run(test.name, func(t *testing.T) {
+			fn(t, newRepository(t))
+		})
+	}
+}
+
+func testPages(t *testing.T, repo okinotes.Repository) {
+	_, err := repo.GetPage("user01", "page01")
+	if _, notFound := err.(okinotes.NotInDatastoreError); !notFound {
+		t.Errorf("GetPage on empty repository returned %v, wanted NotInDatastoreError", err)
+	}
+
+	t0 := time.Date(2015, 3, 14, 15, 9, 26, 535897000, time.UTC)
+	page := okinotes.Page{
+		UserName:             "user01",
+		Name:                 "page01",
+		CreationDate:         t0,
+		LastModificationDate: t0,
+		Title:                "Page 01",
+		Policy:               okinotes.PolicyPUBLIC,
+		TemplateID:           "blog2col",
+		ItemOrder:            okinotes.ItemOrderMANUAL,
+		Tags:                 okinotes.TagList{{"a", "1"}},
+	}
+	if err := repo.StorePage(page); err != nil {
+		t.Fatal(err)
+	}
+	page.Tags[0].Value = "2" //Must not affect the stored page
+
+	out, err := repo.GetPage("user01", "page01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Title != "Page 01" || out.Policy != okinotes.PolicyPUBLIC || out.ItemOrder != okinotes.ItemOrderMANUAL || out.Tags.Tag("a") != "1" || !out.LastModificationDate.Equal(t0) {
+		t.Errorf("GetPage = %+v, wanted stored page", out)
+	}
+
+	out.Title = "Page 01 bis"
+	if err := repo.StorePage(out); err != nil {
+		t.Fatal(err)
+	}
+	if out, _ := repo.GetPage("user01", "page01"); out.Title != "Page 01 bis" {
+		t.Errorf("GetPage after update = %+v, wanted updated title", out)
+	}
+
+	if err := repo.DeletePage("user01", "page01"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetPage("user01", "page01"); err == nil {
+		t.Errorf("GetPage succeeded after DeletePage")
+	}
+}
+
+func testPageQuery(t *testing.T, repo okinotes.Repository) {
+	t0 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 10; i++ {
+		policy := okinotes.PolicyPRIVATE
+		if i%2 == 0 {
+			policy = okinotes.PolicyPUBLIC
+		}
+		userName := "user01"
+		if i >= 6 {
+			userName = "user02"
+		}
+		err := repo.StorePage(okinotes.Page{
+			UserName:             userName,
+			Name:                 fmt.Sprintf("page%02d", i),
+			Policy:               policy,
+			LastModificationDate: t0.Add(time.Duration(i) * time.Minute),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pages, cursor, err := repo.NewPageQuery().Filter("Policy =", okinotes.PolicyPUBLIC).Order("-LastModificationDate").Limit(3).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 3 || len(cursor) == 0 {
+		t.Fatalf("GetAll returned %d pages (cursor=%q), wanted 3 and a cursor", len(pages), cursor)
+	}
+	for i, want := range []string{"page08", "page06", "page04"} {
+		if pages[i].Name != want {
+			t.Errorf("pages[%d] = %s, wanted %s", i, pages[i].Name, want)
+		}
+	}
+
+	pages, cursor, err = repo.NewPageQuery().Filter("Policy =", okinotes.PolicyPUBLIC).Order("-LastModificationDate").Limit(3).Start(cursor).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 || len(cursor) > 0 || pages[0].Name != "page02" || pages[1].Name != "page00" {
+		t.Errorf("GetAll from cursor = %v (cursor=%q), wanted page02, page00 and no cursor", pages, cursor)
+	}
+
+	pages, cursor, err = repo.NewPageQuery().Filter("UserName=", "user02").Order("Name").Limit(10).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 4 || len(cursor) > 0 || pages[0].Name != "page06" {
+		t.Errorf("GetAll(user02) = %v (cursor=%q), wanted the 4 pages of user02", pages, cursor)
+	}
+
+	if _, _, err := repo.NewPageQuery().Start("not a cursor").GetAll(); err == nil {
+		t.Errorf("GetAll with an invalid cursor succeeded")
+	}
+
+	pages, _, err = repo.NewPageQuery().User("user01").Filter("LastModificationDate >=", t0.Add(4*time.Minute)).GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pages) != 2 {
+		t.Errorf("GetAll(user01, date) returned %d pages, wanted 2", len(pages))
+	}
+
+	if _, _, err := repo.NewPageQuery().Filter("Unknown =", "x").GetAll(); err == nil {
+		t.Errorf("GetAll with an unknown field succeeded")
+	}
+}
+
+func testItems(t *testing.T, repo okinotes.Repository) {
+	t0 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		err := repo.StoreItem("user01", "page01", okinotes.Item{
+			ID:                   fmt.Sprintf("item%d", i),
+			CreationDate:         t0,
+			LastModificationDate: t0.Add(time.Duration(i) * time.Second),
+			Position:             []string{"c", "a", "e", "b", "d"}[i],
+			Kind:                 "note",
+			Content:              fmt.Sprintf("Content %d", i),
+			Tags:                 okinotes.TagList{{"status", "new"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	items, cursor, err := repo.GetItemsFromPage("user01", "page01", okinotes.ItemOrderMODIFIED, 2, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 2 || items[0].ID != "item4" || items[1].ID != "item3" || len(cursor) == 0 {
+		t.Errorf("GetItemsFromPage = %v (cursor=%q), wanted item4, item3 and a cursor", items, cursor)
+	}
+	var ids []string
+	for len(cursor) > 0 {
+		items, cursor, err = repo.GetItemsFromPage("user01", "page01", okinotes.ItemOrderMODIFIED, 2, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, i := range items {
+			ids = append(ids, i.ID)
+		}
+	}
+	if strings.Join(ids, ",") != "item2,item1,item0" {
+		t.Errorf("GetItemsFromPage from cursors = %v, wanted item2, item1, item0", ids)
+	}
+
+	for _, test := range []struct {
+		order okinotes.ItemOrder
+		want  string
+	}{
+		{okinotes.ItemOrderMANUAL, "item1,item3,item0,item4,item2"},
+		{okinotes.ItemOrderCREATED, "item0,item1,item2,item3,item4"},
+	} {
+		ids = nil
+		cursor = ""
+		for {
+			items, cursor, err = repo.GetItemsFromPage("user01", "page01", test.order, 2, cursor)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, i := range items {
+				ids = append(ids, i.ID)
+			}
+			if len(cursor) == 0 {
+				break
+			}
+		}
+		if strings.Join(ids, ",") != test.want {
+			t.Errorf("GetItemsFromPage(%s) = %v, wanted %s", test.order, ids, test.want)
+		}
+	}
+
+	item, err := repo.GetItem("user01", "page01", "item2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.Content != "Content 2" || item.Tags.Tag("status") != "new" || !item.CreationDate.Equal(t0) {
+		t.Errorf("GetItem = %+v, wanted stored item", item)
+	}
+
+	if found, _ := repo.FindItem("user01", "page01", "item2"); !found {
+		t.Errorf("FindItem(item2) = false")
+	}
+	if found, _ := repo.FindItem("user01", "page02", "item2"); found {
+		t.Errorf("FindItem(page02, item2) = true")
+	}
+	if err := repo.DeleteItem("user01", "page01", "item2"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetItem("user01", "page01", "item2"); err == nil {
+		t.Errorf("GetItem succeeded after DeleteItem")
+	}
+
+	if err := repo.DeleteItemsFromPage("user01", "page01"); err != nil {
+		t.Fatal(err)
+	}
+	if items, _, _ := repo.GetItemsFromPage("user01", "page01", okinotes.ItemOrderMODIFIED, 10, ""); len(items) != 0 {
+		t.Errorf("GetItemsFromPage = %v after DeleteItemsFromPage", items)
+	}
+}
+
+func testRevisions(t *testing.T, repo okinotes.Repository) {
+	if _, err := repo.GetRevision("user01", "page01", "item01", 1); err == nil {
+		t.Errorf("GetRevision on empty repository succeeded")
+	}
+
+	date := time.Date(2015, 5, 1, 10, 0, 0, 0, time.UTC)
+	for _, r := range []okinotes.Revision{
+		{ItemID: "item01", Number: 2, Date: date.Add(time.Hour), Author: "user02", Title: "Second", Content: "a\nc"},
+		{ItemID: "item01", Number: 1, Date: date, Author: "user01", Title: "First", Content: "a\nb", Tags: okinotes.TagList{{"color", "red"}}},
+		{ItemID: "item02", Number: 1, Date: date, Author: "user01", Title: "Other"},
+	} {
+		if err := repo.StoreRevision("user01", "page01", r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := repo.GetRevision("user01", "page01", "item01", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.ItemID != "item01" || r.Author != "user01" || r.Content != "a\nb" || !r.Date.Equal(date) || r.Tags.Tag("color") != "red" {
+		t.Errorf("GetRevision = %+v", r)
+	}
+	revisions, err := repo.GetRevisions("user01", "page01", "item01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Number != 1 || revisions[1].Number != 2 {
+		t.Errorf("GetRevisions = %+v, wanted revisions 1 and 2", revisions)
+	}
+
+	if err := repo.DeleteRevisions("user01", "page01", "item01"); err != nil {
+		t.Fatal(err)
+	}
+	if revisions, _ := repo.GetRevisions("user01", "page01", "item01"); len(revisions) != 0 {
+		t.Errorf("GetRevisions = %+v after DeleteRevisions", revisions)
+	}
+	if revisions, _ := repo.GetRevisions("user01", "page01", "item02"); len(revisions) != 1 {
+		t.Errorf("DeleteRevisions removed the revisions of another item: %+v", revisions)
+	}
+
+	if err := repo.DeleteRevisionsFromPage("user01", "page01"); err != nil {
+		t.Fatal(err)
+	}
+	if revisions, _ := repo.GetRevisions("user01", "page01", "item02"); len(revisions) != 0 {
+		t.Errorf("GetRevisions = %+v after DeleteRevisionsFromPage", revisions)
+	}
+}
+
+func testTrash(t *testing.T, repo okinotes.Repository) {
+	if _, err := repo.GetTrashEntry("user01", "entry01"); err == nil {
+		t.Errorf("GetTrashEntry on empty repository succeeded")
+	}
+
+	date := time.Date(2015, 6, 1, 10, 0, 0, 0, time.UTC)
+	for _, e := range []okinotes.TrashEntry{
+		{ID: "entry01", UserName: "user01", PageName: "page01", Kind: okinotes.TrashKindPAGE, DeletionDate: date, DeletedBy: "user01",
+			Page: okinotes.Page{UserName: "user01", Name: "page01", Title: "Page", Tags: okinotes.TagList{{"color", "red"}}}},
+		{ID: "entry02", UserName: "user01", PageName: "page02", Kind: okinotes.TrashKindITEM, DeletionDate: date.Add(time.Hour), DeletedBy: "user02",
+			Item: okinotes.Item{ID: "item01", Title: "Item", Content: "content"}},
+		{ID: "entry03", UserName: "user02", PageName: "page01", Kind: okinotes.TrashKindPAGE, DeletionDate: date.Add(2 * time.Hour)},
+	} {
+		if err := repo.StoreTrashEntry(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e, err := repo.GetTrashEntry("user01", "entry01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Kind != okinotes.TrashKindPAGE || e.Page.Title != "Page" || e.Page.Tags.Tag("color") != "red" || !e.DeletionDate.Equal(date) {
+		t.Errorf("GetTrashEntry = %+v", e)
+	}
+	entries, err := repo.GetTrash("user01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ID != "entry02" || entries[0].Item.Content != "content" || entries[1].ID != "entry01" {
+		t.Errorf("GetTrash = %+v, wanted entry02 and entry01", entries)
+	}
+	entries, err = repo.GetExpiredTrash(date.Add(90 * time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].ID != "entry02" || entries[1].ID != "entry01" {
+		t.Errorf("GetExpiredTrash = %+v, wanted entry02 and entry01", entries)
+	}
+
+	if err := repo.DeleteTrashEntry("user01", "entry01"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetTrashEntry("user01", "entry01"); err == nil {
+		t.Errorf("GetTrashEntry succeeded after DeleteTrashEntry")
+	}
+	if entries, _ := repo.GetTrash("user01"); len(entries) != 1 {
+		t.Errorf("GetTrash = %+v after DeleteTrashEntry", entries)
+	}
+}
+
+func testSearch(t *testing.T, repo okinotes.Repository) {
+	date := time.Date(2015, 7, 1, 10, 0, 0, 0, time.UTC)
+	for _, d := range []okinotes.SearchDocument{
+		{UserName: "user01", PageName: "page01", ItemID: "item01", LastModificationDate: date, Title: "Go", Terms: []string{"go", "notes"}},
+		{UserName: "user01", PageName: "page01", ItemID: "item02", LastModificationDate: date.Add(time.Hour), Title: "Rust", Terms: []string{"notes", "rust"}},
+		{UserName: "user02", PageName: "page01", ItemID: "item01", LastModificationDate: date.Add(2 * time.Hour), Content: "go", Terms: []string{"go", "notes"}},
+	} {
+		if err := repo.StoreSearchDocument(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	documents, _, err := repo.FindSearchDocuments([]string{"notes"}, -1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(documents) != 3 {
+		t.Errorf("FindSearchDocuments(notes) = %+v, wanted 3 documents", documents)
+	}
+	documents, _, err = repo.FindSearchDocuments([]string{"notes", "go"}, -1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(documents) != 2 {
+		t.Errorf("FindSearchDocuments(notes, go) = %+v, wanted 2 documents", documents)
+	}
+	documents, _, err = repo.FindSearchDocuments([]string{"rust"}, -1, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(documents) != 1 || documents[0].Title != "Rust" || !documents[0].LastModificationDate.Equal(date.Add(time.Hour)) || len(documents[0].Terms) != 2 {
+		t.Errorf("FindSearchDocuments(rust) = %+v", documents)
+	}
+
+	//Pagination
+	var itemIDs []string
+	cursor := ""
+	for n := 0; n < 3; n++ {
+		documents, next, err := repo.FindSearchDocuments([]string{"notes"}, 2, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, d := range documents {
+			itemIDs = append(itemIDs, d.UserName+"/"+d.ItemID)
+		}
+		if len(next) == 0 {
+			break
+		}
+		cursor = next
+	}
+	if got, wanted := strings.Join(itemIDs, ","), "user02/item01,user01/item02,user01/item01"; got != wanted {
+		t.Errorf("FindSearchDocuments(notes) by 2 = %s, wanted %s", got, wanted)
+	}
+
+	//Updating a document replaces its terms
+	if err := repo.StoreSearchDocument(okinotes.SearchDocument{UserName: "user01", PageName: "page01", ItemID: "item02", Terms: []string{"python"}}); err != nil {
+		t.Fatal(err)
+	}
+	if documents, _, _ := repo.FindSearchDocuments([]string{"rust"}, -1, ""); len(documents) != 0 {
+		t.Errorf("FindSearchDocuments(rust) = %+v after update", documents)
+	}
+
+	if err := repo.DeleteSearchDocument("user02", "page01", "item01"); err != nil {
+		t.Fatal(err)
+	}
+	if documents, _, _ := repo.FindSearchDocuments([]string{"go"}, -1, ""); len(documents) != 1 {
+		t.Errorf("FindSearchDocuments(go) = %+v after DeleteSearchDocument", documents)
+	}
+	if err := repo.DeleteSearchDocumentsFromPage("user01", "page01"); err != nil {
+		t.Fatal(err)
+	}
+	if documents, _, _ := repo.FindSearchDocuments([]string{"notes"}, -1, ""); len(documents) != 0 {
+		t.Errorf("FindSearchDocuments(notes) = %+v after DeleteSearchDocumentsFromPage", documents)
+	}
+}
+
+func testUsers(t *testing.T, repo okinotes.Repository) {
+	ident := okinotes.Ident{Provider: "Google", Identity: "1234"}
+
+	if _, err := repo.GetIdentity(ident); err == nil {
+		t.Errorf("GetIdentity on empty repository succeeded")
+	}
+	if found, _ := repo.FindUser("user01"); found {
+		t.Errorf("FindUser on empty repository = true")
+	}
+
+	if err := repo.StoreUser(okinotes.User{Name: "user01", Kind: okinotes.UserKindUSER, FullName: "User 01", IsAdmin: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.StoreIdentity(okinotes.Identity{ident, "user01"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if found, _ := repo.FindUser("user01"); !found {
+		t.Errorf("FindUser = false after StoreUser")
+	}
+	identity, err := repo.GetIdentity(ident)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.UserName != "user01" {
+		t.Errorf("GetIdentity = %+v, wanted user01", identity)
+	}
+	user, err := repo.GetUser(ident)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Name != "user01" || user.FullName != "User 01" || !user.IsAdmin {
+		t.Errorf("GetUser = %+v, wanted user01", user)
+	}
+
+	ident2 := okinotes.Ident{Provider: "local", Identity: "login01"}
+	if err := repo.StoreIdentity(okinotes.Identity{ident2, "user01"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.StoreIdentity(okinotes.Identity{okinotes.Ident{"local", "login02"}, "user02"}); err != nil {
+		t.Fatal(err)
+	}
+	identities, err := repo.GetIdentities("user01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(identities) != 2 || identities[0].Ident != ident || identities[1].Ident != ident2 {
+		t.Errorf("GetIdentities = %+v, wanted %v and %v", identities, ident, ident2)
+	}
+	if err := repo.DeleteIdentity(okinotes.Identity{ident2, "user01"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetIdentity(ident2); err == nil {
+		t.Errorf("GetIdentity succeeded after DeleteIdentity")
+	}
+	if identities, _ := repo.GetIdentities("user01"); len(identities) != 1 {
+		t.Errorf("GetIdentities = %+v after DeleteIdentity", identities)
+	}
+
+	if _, err := repo.GetCredentials("login01"); err == nil {
+		t.Errorf("GetCredentials of unknown login succeeded")
+	}
+	credentials := okinotes.Credentials{"login01", "hash", time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC)}
+	if err := repo.StoreCredentials(credentials); err != nil {
+		t.Fatal(err)
+	}
+	c, err := repo.GetCredentials("login01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Login != credentials.Login || c.PasswordHash != credentials.PasswordHash || !c.CreationDate.Equal(credentials.CreationDate) {
+		t.Errorf("GetCredentials = %+v, wanted %+v", c, credentials)
+	}
+}
+
+func testMemberships(t *testing.T, repo okinotes.Repository) {
+	if _, err := repo.GetUserByName("org01"); err == nil {
+		t.Errorf("GetUserByName on empty repository succeeded")
+	}
+	if err := repo.StoreUser(okinotes.User{Name: "org01", Kind: okinotes.UserKindORG, FullName: "Org 01"}); err != nil {
+		t.Fatal(err)
+	}
+	org, err := repo.GetUserByName("org01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if org.Kind != okinotes.UserKindORG || org.FullName != "Org 01" {
+		t.Errorf("GetUserByName = %+v, wanted org01", org)
+	}
+
+	if _, err := repo.GetMembership("org01", "user01"); err == nil {
+		t.Errorf("GetMembership on empty repository succeeded")
+	}
+	for _, m := range []okinotes.Membership{
+		{UserName: "user02", OrgName: "org01"},
+		{UserName: "user01", OrgName: "org01", IsOrgAdmin: true},
+		{UserName: "user01", OrgName: "org02"},
+	} {
+		if err := repo.StoreMembership(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := repo.GetMembership("org01", "user01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.UserName != "user01" || m.OrgName != "org01" || !m.IsOrgAdmin {
+		t.Errorf("GetMembership = %+v", m)
+	}
+	members, err := repo.GetMembers("org01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 2 || members[0].UserName != "user01" || members[1].UserName != "user02" {
+		t.Errorf("GetMembers = %+v, wanted user01 and user02", members)
+	}
+	memberships, err := repo.GetMemberships("user01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(memberships) != 2 || memberships[0].OrgName != "org01" || memberships[1].OrgName != "org02" {
+		t.Errorf("GetMemberships = %+v, wanted org01 and org02", memberships)
+	}
+
+	if err := repo.DeleteMembership("org01", "user02"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetMembership("org01", "user02"); err == nil {
+		t.Errorf("GetMembership succeeded after DeleteMembership")
+	}
+	if members, _ := repo.GetMembers("org01"); len(members) != 1 {
+		t.Errorf("GetMembers = %+v after DeleteMembership", members)
+	}
+}
+
+func testPermissions(t *testing.T, repo okinotes.Repository) {
+	if _, err := repo.GetPermission("user01", "page01", "user02"); err == nil {
+		t.Errorf("GetPermission on empty repository succeeded")
+	}
+
+	date := time.Date(2015, 4, 1, 10, 0, 0, 0, time.UTC)
+	for _, p := range []okinotes.Permission{
+		{PageUserName: "user01", PageName: "page01", UserName: "user03", Role: okinotes.RoleVIEWER, CreationDate: date},
+		{PageUserName: "user01", PageName: "page01", UserName: "user02", Role: okinotes.RoleEDITOR, CreationDate: date},
+		{PageUserName: "user01", PageName: "page02", UserName: "user02", Role: okinotes.RoleVIEWER, CreationDate: date},
+	} {
+		if err := repo.StorePermission(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	p, err := repo.GetPermission("user01", "page01", "user02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Role != okinotes.RoleEDITOR || p.UserName != "user02" || p.PageName != "page01" || !p.CreationDate.Equal(date) {
+		t.Errorf("GetPermission = %+v", p)
+	}
+	permissions, err := repo.GetPagePermissions("user01", "page01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permissions) != 2 || permissions[0].UserName != "user02" || permissions[1].UserName != "user03" {
+		t.Errorf("GetPagePermissions = %+v, wanted user02 and user03", permissions)
+	}
+	permissions, err = repo.GetUserPermissions("user02")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(permissions) != 2 {
+		t.Errorf("GetUserPermissions = %+v, wanted page01 and page02", permissions)
+	}
+
+	if err := repo.DeletePermission("user01", "page01", "user02"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetPermission("user01", "page01", "user02"); err == nil {
+		t.Errorf("GetPermission succeeded after DeletePermission")
+	}
+	if permissions, _ := repo.GetUserPermissions("user02"); len(permissions) != 1 {
+		t.Errorf("GetUserPermissions = %+v after DeletePermission", permissions)
+	}
+}
+
+func testTokens(t *testing.T, repo okinotes.Repository) {
+	if _, err := repo.GetToken("hash01"); err == nil {
+		t.Errorf("GetToken on empty repository succeeded")
+	}
+
+	token := okinotes.Token{
+		Hash:         "hash01",
+		Kind:         okinotes.TokenKindLINK,
+		UserName:     "user01",
+		CreationDate: time.Date(2015, 3, 1, 10, 0, 0, 0, time.UTC),
+	}
+	if err := repo.StoreToken(token); err != nil {
+		t.Fatal(err)
+	}
+	got, err := repo.GetToken("hash01")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Kind != token.Kind || got.UserName != token.UserName || !got.CreationDate.Equal(token.CreationDate) || !got.ExpirationDate.IsZero() {
+		t.Errorf("GetToken = %+v, wanted %+v", got, token)
+	}
+
+	access := okinotes.Token{
+		Hash:         "hash02",
+		Kind:         okinotes.TokenKindACCESS,
+		UserName:     "user01",
+		CreationDate: time.Date(2015, 3, 2, 10, 0, 0, 0, time.UTC),
+		ID:           "token02",
+		Name:         "Backup script",
+		Scopes:       []okinotes.Scope{okinotes.ScopeREAD, okinotes.ScopeWRITEITEMS},
+		LastUseDate:  time.Date(2015, 3, 3, 10, 0, 0, 0, time.UTC),
+	}
+	if err := repo.StoreToken(access); err != nil {
+		t.Fatal(err)
+	}
+	tokens, err := repo.GetTokens("user01", okinotes.TokenKindACCESS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 1 || tokens[0].ID != access.ID || tokens[0].Name != access.Name || !tokens[0].HasScope(okinotes.ScopeWRITEITEMS) || tokens[0].HasScope(okinotes.ScopeADMINPAGES) || !tokens[0].LastUseDate.Equal(access.LastUseDate) {
+		t.Errorf("GetTokens(ACCESS) = %+v, wanted [%+v]", tokens, access)
+	}
+	if tokens, err := repo.GetTokens("user02", okinotes.TokenKindACCESS); err != nil || len(tokens) != 0 {
+		t.Errorf("GetTokens of another user = %+v, %v", tokens, err)
+	}
+
+	share := okinotes.Token{
+		Hash:         "hash03",
+		Kind:         okinotes.TokenKindSHARE,
+		UserName:     "user01",
+		CreationDate: time.Date(2015, 3, 4, 10, 0, 0, 0, time.UTC),
+		ID:           "token03",
+		PageName:     "page01",
+	}
+	if err := repo.StoreToken(share); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := repo.GetToken("hash03"); err != nil || got.PageName != "page01" || got.Kind != okinotes.TokenKindSHARE {
+		t.Errorf("GetToken(SHARE) = %+v, %v", got, err)
+	}
+
+	if err := repo.DeleteToken("hash01"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetToken("hash01"); err == nil {
+		t.Errorf("GetToken succeeded after DeleteToken")
+	}
+}
+
+func testImages(t *testing.T, repo okinotes.Repository) {
+	for i, name := range []string{"b.png", "a.png", "c.png"} {
+		img := okinotes.UploadInfo{Key: fmt.Sprintf("key%d", i), ContentType: "image/png", Filename: name, Size: 42}
+		if err := repo.StoreImage(img, "user01"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	imgs, err := repo.GetImages("user01", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 2 || imgs[0].Filename != "a.png" || imgs[1].Filename != "b.png" {
+		t.Errorf("GetImages = %v, wanted a.png, b.png", imgs)
+	}
+
+	if err := repo.RenameImage("d.png", "key1", "user01"); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DeleteImage("key0", "user01"); err != nil {
+		t.Fatal(err)
+	}
+	imgs, err = repo.GetImages("user01", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imgs) != 2 || imgs[0].Filename != "c.png" || imgs[1].Filename != "d.png" {
+		t.Errorf("GetImages = %v, wanted c.png, d.png", imgs)
+	}
+
+	if used, _ := repo.IsUsed("key1"); used {
+		t.Errorf("IsUsed(key1) = true before StoreUsage")
+	}
+	if err := repo.StoreUsage("user01", "page01", "key1"); err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := repo.IsUsed("key1"); !used {
+		t.Errorf("IsUsed(key1) = false after StoreUsage")
+	}
+	if err := repo.DeleteUsages("user01", "page01"); err != nil {
+		t.Fatal(err)
+	}
+	if used, _ := repo.IsUsed("key1"); used {
+		t.Errorf("IsUsed(key1) = true after DeleteUsages")
+	}
+}
+
+func testTemplates(t *testing.T, repo okinotes.Repository) {
+	t0 := time.Date(2015, 1, 1, 0, 0, 0, 0, time.UTC)
+	ids := []string{"1", "2", "3"}
+	generateID := func() string {
+		id := ids[0]
+		ids = ids[1:]
+		return id
+	}
+
+	id, err := repo.StoreTemplate(okinotes.Template{
+		Name:                 "TODO list",
+		LastModificationDate: t0,
+		ItemTags:             okinotes.TagDescriptionList{{"status", "Status", "_status", "The status", "new"}},
+	}, generateID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "1" {
+		t.Errorf("StoreTemplate = %s, wanted generated id", id)
+	}
+	if _, err := repo.StoreTemplate(okinotes.Template{ID: "blog", Name: "Blog", LastModificationDate: t0}, generateID); err != nil {
+		t.Fatal(err)
+	}
+	//Update keeps the creation date
+	if _, err := repo.StoreTemplate(okinotes.Template{ID: "blog", Name: "Blog", LastModificationDate: t0.Add(time.Hour)}, generateID); err != nil {
+		t.Fatal(err)
+	}
+
+	tpl, err := repo.GetTemplate("blog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tpl.CreationDate.Equal(t0) || !tpl.LastModificationDate.Equal(t0.Add(time.Hour)) {
+		t.Errorf("GetTemplate = %+v, wanted creation date kept", tpl)
+	}
+
+	tpls, err := repo.GetAllTemplates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tpls) != 2 || tpls[0].Name != "Blog" || len(tpls[1].ItemTags) != 1 {
+		t.Errorf("GetAllTemplates = %+v, wanted Blog then TODO list", tpls)
+	}
+
+	if err := repo.DeleteTemplate("blog"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetTemplate("blog"); err == nil {
+		t.Errorf("GetTemplate succeeded after DeleteTemplate")
+	}
+}
+
+func testTransactionRollback(t *testing.T, repo okinotes.Repository) {
+	errAbort := errors.New("abort")
+	err := repo.RunInTransaction(func(tx okinotes.Repository) error {
+		if err := tx.StoreUser(okinotes.User{Name: "user01"}); err != nil {
+			return err
+		}
+		//Modifications are visible inside the transaction
+		if found, _ := tx.FindUser("user01"); !found {
+			t.Errorf("FindUser inside transaction = false")
+		}
+		return errAbort
+	})
+	if err != errAbort {
+		t.Errorf("RunInTransaction = %v, wanted %v", err, errAbort)
+	}
+	if found, _ := repo.FindUser("user01"); found {
+		t.Errorf("FindUser = true after rollback")
+	}
+
+	err = repo.RunInTransaction(func(tx okinotes.Repository) error {
+		return tx.StoreUser(okinotes.User{Name: "user01"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found, _ := repo.FindUser("user01"); !found {
+		t.Errorf("FindUser = false after commit")
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"strings"
+	"time"
+)
+
+//Revision is an immutable version of an item, recorded each time the item is changed
+type Revision struct {
+	ItemID string    `json:"itemId"`
+	Number int       `json:"number"` //Numbers of the revisions of an item start at 1
+	Date   time.Time `json:"date"`
+	Author string    `json:"author"` //Name of the user having made the change. Empty if unknown.
+
+	Kind    string  `json:"kind"`
+	Title   string  `json:"title"`
+	Content string  `datastore:",noindex" json:"content"`
+	Source  string  `json:"source"`
+	URL     string  `json:"url"`
+	Tags    TagList `json:"tags"`
+}
+
+//DiffOp is the kind of change of a line in a diff
+type DiffOp string
+
+const (
+	//DiffEQUAL is a line present in both revisions
+	DiffEQUAL DiffOp = " "
+	//DiffINSERT is a line added by the newer revision
+	DiffINSERT DiffOp = "+"
+	//DiffDELETE is a line removed by the newer revision
+	DiffDELETE DiffOp = "-"
+)
+
+//DiffLine is a line of the diff between two revisions
+type DiffLine struct {
+	Op   DiffOp `json:"op"`
+	Text string `json:"text"`
+}
+
+//recordRevision stores a new revision of an item, in the given transaction.
+//If the item has no revision yet (it was created before the history was kept),
+//the previous version is recorded first so that it can still be restored.
+func (app App) recordRevision(repo Repository, userName string, pageName string, previous *Item, i Item) error {
+	revisions, err := repo.GetRevisions(userName, pageName, i.ID)
+	if err != nil {
+		return err
+	}
+
+	number := 1
+	if len(revisions) > 0 {
+		number = revisions[len(revisions)-1].Number + 1
+	} else if previous != nil {
+		if err := repo.StoreRevision(userName, pageName, newRevision(*previous, number, "")); err != nil {
+			return err
+		}
+		number++
+	}
+
+	return repo.StoreRevision(userName, pageName, newRevision(i, number, app.CurrentUserName()))
+}
+
+//newRevision returns the revision recording the given state of an item
+func newRevision(i Item, number int, author string) Revision {
+	return Revision{
+		ItemID:  i.ID,
+		Number:  number,
+		Date:    i.LastModificationDate,
+		Author:  author,
+		Kind:    i.Kind,
+		Title:   i.Title,
+		Content: i.Content,
+		Source:  i.Source,
+		URL:     i.URL,
+		Tags:    i.Tags,
+	}
+}
+
+//ItemRevisions returns the revisions of an item, the oldest first
+func (app App) ItemRevisions(userName string, pageName string, itemID string) ([]Revision, error) {
+	//Check the read permission
+	if _, err := app.GetPage(userName, pageName); err != nil {
+		return nil, err
+	}
+
+	return app.repository.GetRevisions(userName, pageName, itemID)
+}
+
+//GetRevision returns a revision of an item
+func (app App) GetRevision(userName string, pageName string, itemID string, number int) (Revision, error) {
+	//Check the read permission
+	if _, err := app.GetPage(userName, pageName); err != nil {
+		return Revision{}, err
+	}
+
+	return app.repository.GetRevision(userName, pageName, itemID, number)
+}
+
+//DiffRevisions returns the line by line differences of the content of an item
+//between two of its revisions
+func (app App) DiffRevisions(userName string, pageName string, itemID string, from int, to int) ([]DiffLine, error) {
+	fromRevision, err := app.GetRevision(userName, pageName, itemID, from)
+	if err != nil {
+		return nil, err
+	}
+	toRevision, err := app.repository.GetRevision(userName, pageName, itemID, to)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffLines(splitLines(fromRevision.Content), splitLines(toRevision.Content)), nil
+}
+
+//RestoreRevision replaces an item by one of its previous revisions.
+//The restoration is itself recorded as a new revision.
+func (app App) RestoreRevision(userName string, pageName string, itemID string, number int) (Item, error) {
+	if err := app.checkScope(ScopeWRITEITEMS, "Restore item"); err != nil {
+		return Item{}, err
+	}
+	if err := app.checkRole(userName, pageName, RoleEDITOR, "Restore item"); err != nil {
+		return Item{}, err
+	}
+
+	r, err := app.repository.GetRevision(userName, pageName, itemID, number)
+	if err != nil {
+		return Item{}, err
+	}
+
+	i := Item{
+		ID:      itemID,
+		Kind:    r.Kind,
+		Title:   r.Title,
+		Content: r.Content,
+		Source:  r.Source,
+		URL:     r.URL,
+		Tags:    r.Tags,
+	}
+	return app.UpdateItem(userName, pageName, i, true)
+}
+
+//splitLines returns the lines of a text, ignoring the final line break
+func splitLines(s string) []string {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = strings.TrimSuffix(s, "\n")
+	if len(s) == 0 {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+//maxDiffEdits bounds the search of the shortest edit script: texts differing by more
+//lines are reported as replaced from the first to the last differing line
+const maxDiffEdits = 1000
+
+//diffLines computes a shortest edit script from a to b, with the linear space variant
+//of the Myers algorithm
+func diffLines(a []string, b []string) []DiffLine {
+	d := differ{a: a, b: b}
+	d.compare(0, len(a), 0, len(b))
+	return d.diff
+}
+
+//differ builds the edit script between two lists of lines
+type differ struct {
+	a, b []string
+	diff []DiffLine
+}
+
+//compare appends the edit script from a[aLo:aHi] to b[bLo:bHi]
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	//Common prefix and suffix
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.diff = append(d.diff, DiffLine{DiffEQUAL, d.a[aLo]})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && d.a[aHi-suffix-1] == d.b[bHi-suffix-1] {
+		suffix++
+	}
+	aHi -= suffix
+	bHi -= suffix
+
+	if x, y, found := d.middleSnake(aLo, aHi, bLo, bHi); found {
+		d.compare(aLo, x, bLo, y)
+		d.compare(x, aHi, y, bHi)
+	} else {
+		for _, line := range d.a[aLo:aHi] {
+			d.diff = append(d.diff, DiffLine{DiffDELETE, line})
+		}
+		for _, line := range d.b[bLo:bHi] {
+			d.diff = append(d.diff, DiffLine{DiffINSERT, line})
+		}
+	}
+
+	for _, line := range d.a[aHi : aHi+suffix] {
+		d.diff = append(d.diff, DiffLine{DiffEQUAL, line})
+	}
+}
+
+//middleSnake searches a shortest edit script from a[aLo:aHi] to b[bLo:bHi] from both
+//ends at once, and returns the point where the two searches meet. Returns false if
+//the lines have nothing in common, or differ by more than maxDiffEdits lines.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (int, int, bool) {
+	a, b := d.a[aLo:aHi], d.b[bLo:bHi]
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return 0, 0, false
+	}
+
+	//forward[k] and backward[k] are the furthest x reached on diagonal k = x - y
+	//from the start and from the end of the texts
+	maxD := (n + m + 1) / 2
+	if maxD > maxDiffEdits {
+		maxD = maxDiffEdits
+	}
+	offset := maxD + 1
+	forward := make([]int, 2*offset+1)
+	backward := make([]int, 2*offset+1)
+	for i := range forward {
+		forward[i], backward[i] = -1, -1
+	}
+	forward[offset+1], backward[offset+1] = 0, 0
+
+	delta := n - m
+	odd := delta%2 != 0
+	for e := 0; e < maxD; e++ {
+		for k := -e; k <= e; k += 2 {
+			var x int
+			if k == -e || (k != e && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+			if x < 0 || y < 0 || x > n || y > m {
+				continue
+			}
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+			//Overlap with the backward search, on the reversed diagonal
+			if rk := delta - k; odd && rk >= -(e-1) && rk <= e-1 && backward[offset+rk] >= 0 && x >= n-backward[offset+rk] {
+				return d.split(aLo, bLo, n, m, x, y)
+			}
+		}
+		for k := -e; k <= e; k += 2 {
+			var x int
+			if k == -e || (k != e && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+			if x < 0 || y < 0 || x > n || y > m {
+				continue
+			}
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+			if fk := delta - k; !odd && fk >= -e && fk <= e && forward[offset+fk] >= 0 && forward[offset+fk] >= n-x {
+				return d.split(aLo, bLo, n, m, forward[offset+fk], forward[offset+fk]-fk)
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+//split returns the point (x, y) of the texts of sizes n and m starting at aLo and bLo,
+//unless the texts would not be split by it
+func (d *differ) split(aLo, bLo, n, m, x, y int) (int, int, bool) {
+	if (x == 0 && y == 0) || (x == n && y == m) {
+		return 0, 0, false
+	}
+	return aLo + x, bLo + y, true
+}
@@ -0,0 +1,136 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/okinotes/okinotes"
+)
+
+func TestRevisions(t *testing.T) {
+	newApp := newTestSite(t, "owner", "editor", "viewer").App
+
+	owner := newApp("owner")
+	if err := owner.CreatePage(okinotes.Page{Name: "page01"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.GrantRole("owner", "page01", "editor", okinotes.RoleEDITOR); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.GrantRole("owner", "page01", "viewer", okinotes.RoleVIEWER); err != nil {
+		t.Fatal(err)
+	}
+
+	item, err := owner.CreateItem("owner", "page01", okinotes.Item{Title: "v1", Content: "a\nb\nc"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	item.Title = "v2"
+	item.Content = "a\nB\nc\nd"
+	if _, err := newApp("editor").UpdateItem("owner", "page01", item, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.SetItemTag("owner", "page01", item.ID, "color", "red"); err != nil {
+		t.Fatal(err)
+	}
+
+	revisions, err := newApp("viewer").ItemRevisions("owner", "page01", item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 {
+		t.Fatalf("ItemRevisions = %+v, wanted 3 revisions", revisions)
+	}
+	if revisions[0].Title != "v1" || revisions[1].Author != "editor" || revisions[2].Tags.Tag("color") != "red" || len(revisions[1].Tags) != 0 {
+		t.Errorf("ItemRevisions = %+v", revisions)
+	}
+
+	diff, err := newApp("viewer").DiffRevisions("owner", "page01", item.ID, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wanted := []okinotes.DiffLine{
+		{okinotes.DiffEQUAL, "a"},
+		{okinotes.DiffDELETE, "b"},
+		{okinotes.DiffINSERT, "B"},
+		{okinotes.DiffEQUAL, "c"},
+		{okinotes.DiffINSERT, "d"},
+	}
+	if !reflect.DeepEqual(diff, wanted) {
+		t.Errorf("DiffRevisions = %v, wanted %v", diff, wanted)
+	}
+
+	if _, err := newApp("viewer").RestoreRevision("owner", "page01", item.ID, 1); err == nil {
+		t.Errorf("RestoreRevision by a viewer succeeded")
+	}
+	restored, err := newApp("editor").RestoreRevision("owner", "page01", item.ID, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored.Title != "v1" || restored.Content != "a\nb\nc" || len(restored.Tags) != 0 {
+		t.Errorf("RestoreRevision = %+v", restored)
+	}
+	if revisions, _ := owner.ItemRevisions("owner", "page01", item.ID); len(revisions) != 4 || revisions[3].Title != "v1" {
+		t.Errorf("ItemRevisions = %+v after RestoreRevision", revisions)
+	}
+}
+
+func TestLargeRevisionsDiff(t *testing.T) {
+	owner := newTestSite(t, "owner").App("owner")
+	if err := owner.CreatePage(okinotes.Page{Name: "page01"}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := func(prefix string, changed int) string {
+		var b strings.Builder
+		for i := 0; i < 20000; i++ {
+			if i == changed {
+				fmt.Fprintf(&b, "changed\n")
+			} else {
+				fmt.Fprintf(&b, "%s%d\n", prefix, i)
+			}
+		}
+		return b.String()
+	}
+	item, err := owner.CreateItem("owner", "page01", okinotes.Item{Content: lines("a", -1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, content := range []string{lines("a", 10000), lines("b", -1)} {
+		item.Content = content
+		if item, err = owner.UpdateItem("owner", "page01", item, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	//A change in the middle of the content
+	diff, err := owner.DiffRevisions("owner", "page01", item.ID, 1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var changes []okinotes.DiffLine
+	for _, l := range diff {
+		if l.Op != okinotes.DiffEQUAL {
+			changes = append(changes, l)
+		}
+	}
+	wanted := []okinotes.DiffLine{{okinotes.DiffDELETE, "a10000"}, {okinotes.DiffINSERT, "changed"}}
+	if len(diff) != 20001 || !reflect.DeepEqual(changes, wanted) {
+		t.Errorf("DiffRevisions has %d lines and changes %v, wanted 20001 lines and changes %v", len(diff), changes, wanted)
+	}
+
+	//Contents without common lines are replaced
+	diff, err = owner.DiffRevisions("owner", "page01", item.ID, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 40000 || diff[19999].Op != okinotes.DiffDELETE || diff[20000].Op != okinotes.DiffINSERT {
+		t.Errorf("DiffRevisions has %d lines, wanted the 20000 lines deleted then inserted", len(diff))
+	}
+}
@@ -0,0 +1,345 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"bytes"
+	"html/template"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+//searchCandidates is the maximum number of readable documents kept from the index for a search, before ranking.
+//The index is read by batches of this size.
+const searchCandidates = 1000
+
+//snippetLength is the approximate length in bytes of the extract of the content shown in the results
+const snippetLength = 200
+
+//SearchDocument is the indexed form of an item
+type SearchDocument struct {
+	UserName             string    `json:"userName"`
+	PageName             string    `json:"pageName"`
+	ItemID               string    `json:"itemId"`
+	LastModificationDate time.Time `json:"lastModificationDate"`
+
+	PageTitle string `datastore:",noindex" json:"pageTitle"`
+	Title     string `datastore:",noindex" json:"title"`
+	Content   string `datastore:",noindex" json:"content"`
+	URL       string `datastore:",noindex" json:"url"`
+
+	Terms []string `json:"terms"` //Sorted distinct normalized words of all the fields
+}
+
+//SearchResult is an item matching a search
+type SearchResult struct {
+	UserName             string        `json:"userName"`
+	PageName             string        `json:"pageName"`
+	PageTitle            template.HTML `json:"pageTitle"` //Highlighted HTML
+	ItemID               string        `json:"itemId"`
+	Title                template.HTML `json:"title"`   //Highlighted HTML
+	Snippet              template.HTML `json:"snippet"` //Highlighted extract of the content
+	URL                  string        `json:"url"`
+	LastModificationDate time.Time     `json:"lastModificationDate"`
+	Score                int           `json:"score"`
+}
+
+//searchTerms splits a text into lowercase words
+func searchTerms(s string) []string {
+	var terms []string
+	for _, w := range wordSpans(s) {
+		terms = append(terms, strings.ToLower(s[w.start:w.end]))
+	}
+	return terms
+}
+
+//span locates a word in a text, by byte offsets
+type span struct {
+	start, end int
+}
+
+//wordSpans returns the positions of the words (runs of letters and digits) of a text
+func wordSpans(s string) []span {
+	var spans []span
+	start := -1
+	for i, r := range s {
+		isWord := unicode.IsLetter(r) || unicode.IsNumber(r)
+		if isWord && start < 0 {
+			start = i
+		} else if !isWord && start >= 0 {
+			spans = append(spans, span{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		spans = append(spans, span{start, len(s)})
+	}
+	return spans
+}
+
+//HasAllTerms returns true if the document contains all the terms.
+//It allows Repository implementations without a native index to match the documents.
+func HasAllTerms(d SearchDocument, terms []string) bool {
+	for _, t := range terms {
+		i := sort.SearchStrings(d.Terms, t)
+		if i == len(d.Terms) || d.Terms[i] != t {
+			return false
+		}
+	}
+	return true
+}
+
+//newSearchDocument returns the indexed form of an item of a page
+func newSearchDocument(page Page, i Item) SearchDocument {
+	d := SearchDocument{
+		UserName:             page.UserName,
+		PageName:             page.Name,
+		ItemID:               i.ID,
+		LastModificationDate: i.LastModificationDate,
+		PageTitle:            page.Title,
+		Title:                i.Title,
+		Content:              i.Content,
+		URL:                  i.URL,
+	}
+
+	seen := make(map[string]bool)
+	for _, field := range []string{d.PageTitle, d.Title, d.Content, d.URL} {
+		for _, t := range searchTerms(field) {
+			if !seen[t] {
+				seen[t] = true
+				d.Terms = append(d.Terms, t)
+			}
+		}
+	}
+	sort.Strings(d.Terms)
+	return d
+}
+
+//indexItem updates the search index with a new version of an item, in the given transaction
+func indexItem(repo Repository, userName string, pageName string, i Item) error {
+	page, err := repo.GetPage(userName, pageName)
+	if err != nil {
+		return err
+	}
+	return repo.StoreSearchDocument(newSearchDocument(page, i))
+}
+
+//indexPage updates the search index with all the items of a page, after a change of the page
+func indexPage(repo Repository, page Page) error {
+	items, _, err := repo.GetItemsFromPage(page.UserName, page.Name, ItemOrderMODIFIED, -1, "")
+	if err != nil {
+		return err
+	}
+	for _, i := range items {
+		if err := repo.StoreSearchDocument(newSearchDocument(page, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Search returns the items matching all the words of the query, among the pages
+//readable by the current user (its pages, the pages shared with it and the public pages).
+//Results are ranked by relevance, matches in titles weighing more than matches in the content.
+func (app App) Search(query string, limit int) ([]SearchResult, error) {
+	if err := app.checkScope(ScopeREAD, "Search"); err != nil {
+		return nil, err
+	}
+
+	terms := make(map[string]bool)
+	var distinctTerms []string
+	for _, t := range searchTerms(query) {
+		if !terms[t] {
+			terms[t] = true
+			distinctTerms = append(distinctTerms, t)
+		}
+	}
+	if len(distinctTerms) == 0 {
+		return nil, DataError{"query", "must contain at least one word"}
+	}
+
+	//Keep only the documents of the pages readable by the current user: the index holds
+	//the documents of all the users, so it is read until enough of them are readable
+	readable := make(map[pageID]bool)
+	var results []SearchResult
+	cursor := ""
+	for {
+		documents, next, err := app.repository.FindSearchDocuments(distinctTerms, searchCandidates, cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, d := range documents {
+			id := pageID{d.UserName, d.PageName}
+			canRead, checked := readable[id]
+			if !checked {
+				_, err := app.GetPage(d.UserName, d.PageName)
+				switch err.(type) {
+				case nil:
+					canRead = true
+				case NotAuthorizedError, NotInDatastoreError:
+					canRead = false
+				default:
+					return nil, err
+				}
+				readable[id] = canRead
+			}
+			if canRead && len(results) < searchCandidates {
+				results = append(results, newSearchResult(d, terms))
+			}
+		}
+
+		if len(next) == 0 || len(results) >= searchCandidates {
+			break
+		}
+		cursor = next
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].LastModificationDate.After(results[j].LastModificationDate)
+	})
+
+	if limit >= 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results, nil
+}
+
+//pageID identifies a page
+type pageID struct {
+	userName string
+	pageName string
+}
+
+//newSearchResult ranks and highlights a document matching the terms
+func newSearchResult(d SearchDocument, terms map[string]bool) SearchResult {
+	score := 0
+	for _, field := range []struct {
+		text    string
+		weight  int
+		maxHits int
+	}{
+		{d.Title, 5, 3},
+		{d.PageTitle, 3, 3},
+		{d.URL, 2, 3},
+		{d.Content, 1, 10},
+	} {
+		hits := 0
+		for _, t := range searchTerms(field.text) {
+			if terms[t] && hits < field.maxHits {
+				hits++
+			}
+		}
+		score += field.weight * hits
+	}
+
+	return SearchResult{
+		UserName:             d.UserName,
+		PageName:             d.PageName,
+		PageTitle:            highlight(d.PageTitle, terms, 0),
+		ItemID:               d.ItemID,
+		Title:                highlight(d.Title, terms, 0),
+		Snippet:              highlight(d.Content, terms, snippetLength),
+		URL:                  d.URL,
+		LastModificationDate: d.LastModificationDate,
+		Score:                score,
+	}
+}
+
+//highlight escapes a text and marks the words matching the terms.
+//If maxLength is positive, only an extract of about maxLength bytes around the first match is kept.
+func highlight(s string, terms map[string]bool, maxLength int) template.HTML {
+	spans := wordSpans(s)
+	start, end := 0, len(s)
+
+	if maxLength > 0 && len(s) > maxLength {
+		first := 0
+		for i, w := range spans {
+			if terms[strings.ToLower(s[w.start:w.end])] {
+				first = i
+				break
+			}
+		}
+		if len(spans) > 0 {
+			//Start a few words before the first match
+			matchStart := spans[first].start
+			for first > 0 && spans[first-1].start >= matchStart-maxLength/4 {
+				first--
+			}
+			start = spans[first].start
+		}
+		end = start + maxLength
+		if end > len(s) {
+			end = len(s)
+		}
+		for end < len(s) && !utf8.RuneStart(s[end]) {
+			end--
+		}
+		//Do not cut a word
+		for _, w := range spans {
+			if w.start > start && w.start < end && w.end > end {
+				end = w.start
+				break
+			}
+		}
+	}
+
+	var b bytes.Buffer
+	if start > 0 {
+		b.WriteString("…")
+	}
+	pos := start
+	for _, w := range spans {
+		if w.start < start || w.end > end {
+			continue
+		}
+		b.WriteString(template.HTMLEscapeString(s[pos:w.start]))
+		word := template.HTMLEscapeString(s[w.start:w.end])
+		if terms[strings.ToLower(s[w.start:w.end])] {
+			b.WriteString("<mark>" + word + "</mark>")
+		} else {
+			b.WriteString(word)
+		}
+		pos = w.end
+	}
+	if pos < end {
+		b.WriteString(template.HTMLEscapeString(s[pos:end]))
+	}
+	if end < len(s) {
+		b.WriteString("…")
+	}
+	return template.HTML(b.String())
+}
+
+//RebuildSearchIndex indexes again all the items of all the pages.
+//It is needed for the items stored before the search was available.
+func (app App) RebuildSearchIndex() (int, error) {
+	if !app.CurrentUserIsAdmin() {
+		return 0, NotAuthorizedError{"Rebuild search index"}
+	}
+
+	pages, _, err := app.repository.NewPageQuery().GetAll()
+	if err != nil {
+		return 0, err
+	}
+	for _, page := range pages {
+		err := app.repository.RunInTransaction(func(repo Repository) error {
+			if err := repo.DeleteSearchDocumentsFromPage(page.UserName, page.Name); err != nil {
+				return err
+			}
+			return indexPage(repo, page)
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(pages), nil
+}
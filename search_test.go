@@ -0,0 +1,100 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/okinotes/okinotes"
+)
+
+func TestSearch(t *testing.T) {
+	site := newTestSite(t, "user01", "user02")
+	newApp := site.App
+
+	user01 := newApp("user01")
+	user02 := newApp("user02")
+	if err := user01.CreatePage(okinotes.Page{Name: "private", Title: "Recipes"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := user02.CreatePage(okinotes.Page{Name: "public", Title: "Links", Policy: okinotes.PolicyPUBLIC}); err != nil {
+		t.Fatal(err)
+	}
+	if err := user02.CreatePage(okinotes.Page{Name: "private", Title: "Secrets"}); err != nil {
+		t.Fatal(err)
+	}
+
+	inContent, err := user01.CreateItem("user01", "private", okinotes.Item{Title: "Pie", Content: "A <b>crust</b> with apples"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	inTitle, err := user01.CreateItem("user01", "private", okinotes.Item{Title: "Apples", Content: "Varieties"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := user02.CreateItem("user02", "public", okinotes.Item{Title: "Orchard", URL: "http://example.com/apples"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := user02.CreateItem("user02", "private", okinotes.Item{Content: "apples"}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := user01.Search("Apples", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("Search = %+v, wanted the 2 items of user01 and the public item of user02", results)
+	}
+	if results[0].ItemID != inTitle.ID {
+		t.Errorf("Search ranked %+v first, wanted the match in the title", results[0])
+	}
+	for _, r := range results {
+		if r.ItemID == inContent.ID && r.Snippet != "A &lt;b&gt;crust&lt;/b&gt; with <mark>apples</mark>" {
+			t.Errorf("Snippet = %q", r.Snippet)
+		}
+	}
+
+	if results, _ := user01.Search("apples recipes", 10); len(results) != 2 {
+		t.Errorf("Search with the page title = %+v, wanted the 2 items of user01", results)
+	}
+	if results, _ := user01.Search("apples crust", 10); len(results) != 1 || results[0].ItemID != inContent.ID {
+		t.Errorf("Search with 2 words = %+v, wanted the item matching both", results)
+	}
+	if _, err := user01.Search("  ", 10); err == nil {
+		t.Errorf("Search without words succeeded")
+	}
+
+	//The index follows the changes of the items and pages
+	inContent.Content = "A crust with pears"
+	if _, err := user01.UpdateItem("user01", "private", inContent, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := user01.DeleteItem("user01", "private", inTitle.ID); err != nil {
+		t.Fatal(err)
+	}
+	if results, _ := user01.Search("apples recipes", 10); len(results) != 0 {
+		t.Errorf("Search = %+v after UpdateItem and DeleteItem", results)
+	}
+	if err := user01.UpdatePage(okinotes.Page{UserName: "user01", Name: "private", Title: "Desserts"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if results, _ := user01.Search("desserts", 10); len(results) != 1 || results[0].PageTitle != "<mark>Desserts</mark>" {
+		t.Errorf("Search = %+v after UpdatePage", results)
+	}
+
+	//The more recent documents of the other users do not hide the readable ones
+	for i := 0; i < 1000; i++ {
+		d := okinotes.SearchDocument{UserName: "user02", PageName: "private", ItemID: fmt.Sprint(i), LastModificationDate: time.Now(), Terms: []string{"pears"}}
+		if err := site.Repository.StoreSearchDocument(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if results, _ := user01.Search("pears", 10); len(results) != 1 || results[0].ItemID != inContent.ID {
+		t.Errorf("Search = %+v, wanted the item of user01 behind the items of user02", results)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"time"
+)
+
+//WithShareSecret returns an App giving read access to the unlisted page shared
+//by the link of the given secret, in addition to the rights of the current user.
+func (app App) WithShareSecret(secret string) App {
+	app.shareSecret = secret
+	return app
+}
+
+//checkShareSecret verifies that the share secret of the App gives access to the page
+func (app App) checkShareSecret(page Page) (bool, error) {
+	if len(app.shareSecret) == 0 {
+		return false, nil
+	}
+
+	token, err := app.repository.GetToken(hashSecret(app.shareSecret))
+	if _, notFound := err.(NotInDatastoreError); notFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	valid := token.Kind == TokenKindSHARE && !token.IsExpired(time.Now()) &&
+		token.UserName == page.UserName && token.PageName == page.Name
+	return valid, nil
+}
+
+//ShareLinks returns the share links of a page
+func (app App) ShareLinks(userName string, pageName string) ([]Token, error) {
+	if err := app.checkScope(ScopeADMINPAGES, "List share links"); err != nil {
+		return nil, err
+	}
+	if err := app.checkRole(userName, pageName, RoleOWNER, "List share links"); err != nil {
+		return nil, err
+	}
+
+	return getShareLinks(app.repository, userName, pageName)
+}
+
+//getShareLinks returns the share links of a page
+func getShareLinks(repo Repository, userName string, pageName string) ([]Token, error) {
+	tokens, err := repo.GetTokens(userName, TokenKindSHARE)
+	if err != nil {
+		return nil, err
+	}
+
+	var links []Token
+	for _, token := range tokens {
+		if token.PageName == pageName {
+			links = append(links, token)
+		}
+	}
+	return links, nil
+}
+
+//CreateShareLink creates a share link giving read access to an unlisted page.
+//If rotate is true, the existing share links of the page are revoked in the same transaction.
+//The returned secret cannot be retrieved later as only its hash is stored.
+//A zero expiration date creates a link that never expires.
+func (app App) CreateShareLink(userName string, pageName string, expirationDate time.Time, rotate bool) (string, Token, error) {
+	if err := app.checkScope(ScopeADMINPAGES, "Share page"); err != nil {
+		return "", Token{}, err
+	}
+	if err := app.checkRole(userName, pageName, RoleOWNER, "Share page"); err != nil {
+		return "", Token{}, err
+	}
+
+	now := time.Now()
+	if !expirationDate.IsZero() && !expirationDate.After(now) {
+		return "", Token{}, DataError{"expiration date", "must be in the future"}
+	}
+
+	secret, err := generateSecret()
+	if err != nil {
+		return "", Token{}, err
+	}
+
+	token := Token{
+		Hash:           hashSecret(secret),
+		Kind:           TokenKindSHARE,
+		UserName:       userName,
+		CreationDate:   now,
+		ExpirationDate: expirationDate,
+		ID:             generateID(),
+		PageName:       pageName,
+	}
+
+	err = app.repository.RunInTransaction(func(repo Repository) error {
+		page, err := repo.GetPage(userName, pageName)
+		if err != nil {
+			return err
+		}
+		if page.Policy != PolicyUNLISTED {
+			return DataError{"policy", "only unlisted pages can be shared"}
+		}
+
+		previous, err := getShareLinks(repo, userName, pageName)
+		if err != nil {
+			return err
+		}
+
+		if err := repo.StoreToken(token); err != nil {
+			return err
+		}
+		if rotate {
+			for _, link := range previous {
+				if err := repo.DeleteToken(link.Hash); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return "", Token{}, err
+	}
+
+	return secret, token, nil
+}
+
+//RevokeShareLink deletes a share link of a page
+func (app App) RevokeShareLink(userName string, pageName string, tokenID string) error {
+	if err := app.checkScope(ScopeADMINPAGES, "Share page"); err != nil {
+		return err
+	}
+	if err := app.checkRole(userName, pageName, RoleOWNER, "Share page"); err != nil {
+		return err
+	}
+
+	links, err := getShareLinks(app.repository, userName, pageName)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		if link.ID == tokenID {
+			return app.repository.DeleteToken(link.Hash)
+		}
+	}
+	return NotInDatastoreError{"Share link", tokenID}
+}
@@ -0,0 +1,115 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/okinotes/okinotes"
+)
+
+func TestShareLinks(t *testing.T) {
+	site := newTestSite(t, "owner")
+	owner, anonymous := site.App("owner"), site.App("")
+
+	for _, name := range []string{"page01", "page02"} {
+		if err := owner.CreatePage(okinotes.Page{Name: name, Policy: okinotes.PolicyUNLISTED}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pages, _, _ := anonymous.ListPublicPages(10, ""); len(pages) != 0 {
+		t.Errorf("ListPublicPages = %v, wanted no unlisted page", pages)
+	}
+
+	secret, _, err := owner.CreateShareLink("owner", "page01", time.Time{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := anonymous.CreateShareLink("owner", "page01", time.Time{}, false); err == nil {
+		t.Errorf("CreateShareLink by anonymous succeeded")
+	}
+	if _, err := anonymous.GetPage("owner", "page01"); err == nil {
+		t.Errorf("GetPage of an unlisted page without share link succeeded")
+	}
+	if _, err := anonymous.WithShareSecret(secret).GetPage("owner", "page01"); err != nil {
+		t.Errorf("GetPage with share link: %v", err)
+	}
+	if _, err := anonymous.WithShareSecret(secret).GetPage("owner", "page02"); err == nil {
+		t.Errorf("GetPage of another page with share link succeeded")
+	}
+
+	//Rotation revokes the previous links
+	rotated, token, err := owner.CreateShareLink("owner", "page01", time.Now().Add(time.Hour), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := anonymous.WithShareSecret(secret).GetPage("owner", "page01"); err == nil {
+		t.Errorf("GetPage with a rotated share link succeeded")
+	}
+	if links, err := owner.ShareLinks("owner", "page01"); err != nil || len(links) != 1 || links[0].ID != token.ID {
+		t.Errorf("ShareLinks = %v, %v", links, err)
+	}
+	if err := owner.RevokeShareLink("owner", "page01", token.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := anonymous.WithShareSecret(rotated).GetPage("owner", "page01"); err == nil {
+		t.Errorf("GetPage with a revoked share link succeeded")
+	}
+
+	//Only the unlisted pages can be shared
+	for _, policy := range []okinotes.Policy{okinotes.PolicyPRIVATE, okinotes.PolicyPUBLIC} {
+		name := "page_" + string(policy)
+		if err := owner.CreatePage(okinotes.Page{Name: name, Policy: policy}); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := owner.CreateShareLink("owner", name, time.Time{}, false); err == nil {
+			t.Errorf("CreateShareLink of a %s page succeeded", policy)
+		} else if _, isDataError := err.(okinotes.DataError); !isDataError {
+			t.Errorf("CreateShareLink of a %s page = %v, wanted a DataError", policy, err)
+		}
+	}
+}
+
+//failingDeleteRepository is a repository where the tokens cannot be deleted
+type failingDeleteRepository struct {
+	okinotes.Repository
+}
+
+func (repo failingDeleteRepository) RunInTransaction(f func(repo okinotes.Repository) error) error {
+	return repo.Repository.RunInTransaction(func(tx okinotes.Repository) error {
+		return f(failingDeleteRepository{tx})
+	})
+}
+
+func (repo failingDeleteRepository) DeleteToken(hash string) error {
+	return errors.New("DeleteToken failed")
+}
+
+func TestShareLinkRotationIsAtomic(t *testing.T) {
+	site := newTestSite(t, "owner")
+	owner, anonymous := site.App("owner"), site.App("")
+
+	if err := owner.CreatePage(okinotes.Page{Name: "page01", Policy: okinotes.PolicyUNLISTED}); err != nil {
+		t.Fatal(err)
+	}
+	secret, token, err := owner.CreateShareLink("owner", "page01", time.Time{}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//A failed rotation keeps the previous link, and only it
+	site.Repository = failingDeleteRepository{site.Repository}
+	if _, _, err := site.App("owner").CreateShareLink("owner", "page01", time.Time{}, true); err == nil {
+		t.Fatal("CreateShareLink succeeded while the tokens cannot be deleted")
+	}
+	if links, err := owner.ShareLinks("owner", "page01"); err != nil || len(links) != 1 || links[0].ID != token.ID {
+		t.Errorf("ShareLinks = %v, %v after a failed rotation, wanted the previous link only", links, err)
+	}
+	if _, err := anonymous.WithShareSecret(secret).GetPage("owner", "page01"); err != nil {
+		t.Errorf("GetPage with the previous share link after a failed rotation: %v", err)
+	}
+}
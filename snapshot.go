@@ -0,0 +1,570 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+//Tags of the items holding the snapshot of the page linked by their URL.
+//They are set by the App only: the snapshot is an upload, which must belong to the item.
+const (
+	linkTagSNAPSHOT = linkTagPrefix + "snapshot" //Key of the uploaded snapshot
+	linkTagARCHIVED = linkTagPrefix + "archived" //Date of the snapshot, in RFC 3339 format
+)
+
+const (
+	//maxFetchBytes is the maximum size in bytes of a document fetched for a snapshot
+	maxFetchBytes = 5 << 20
+	//maxSnapshotBytes is the maximum size in bytes of a snapshot, with its inlined assets
+	maxSnapshotBytes = 10 << 20
+	//maxSnapshotAssets is the maximum number of images, style sheets and fonts fetched for a snapshot
+	maxSnapshotAssets = 50
+	//snapshotTimeout is the maximum duration of the fetch of a page and of its assets for a snapshot
+	snapshotTimeout = 15 * time.Second
+)
+
+//SnapshotPolicy is the Content-Security-Policy of the snapshots: they are served from the
+//application, so nothing but the inlined assets is loaded, and nothing runs.
+const SnapshotPolicy = "default-src 'none'; img-src data:; style-src 'unsafe-inline' data:; font-src data:; sandbox"
+
+//WebDocument is a file downloaded from the web
+type WebDocument struct {
+	URL         string //Final URL, after the redirects
+	ContentType string
+	Content     []byte
+}
+
+//WithLinkFetcher returns a copy of the App archiving the pages linked by the items with f.
+//Without LinkFetcher, no snapshot is taken.
+func (app App) WithLinkFetcher(f LinkFetcher) App {
+	app.linkFetcher = f
+	return app
+}
+
+//FetchDocument downloads an HTTP or HTTPS URL with the given client, up to maxBytes, and
+//before the deadline if it is set.
+//The client is responsible for restricting the addresses it connects to.
+func FetchDocument(client *http.Client, rawurl string, maxBytes int, deadline time.Time) (WebDocument, error) {
+	u, err := checkPreviewURL(rawurl)
+	if err != nil {
+		return WebDocument{}, err
+	}
+	c := fetchClient(client)
+	if !deadline.IsZero() {
+		remaining := deadline.Sub(time.Now())
+		if remaining <= 0 {
+			return WebDocument{}, fmt.Errorf("Fetch of %s: deadline exceeded", u)
+		}
+		if remaining < c.Timeout {
+			c.Timeout = remaining
+		}
+	}
+
+	req, err := http.NewRequest("GET", u.String(), nil)
+	if err != nil {
+		return WebDocument{}, err
+	}
+	req.Header.Set("User-Agent", "okinotes archiver")
+
+	resp, err := c.Do(req)
+	if err != nil {
+		return WebDocument{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return WebDocument{}, fmt.Errorf("Fetch of %s: %s", u, resp.Status)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, int64(maxBytes)+1))
+	if err != nil {
+		return WebDocument{}, err
+	}
+	if len(content) > maxBytes {
+		return WebDocument{}, fmt.Errorf("Fetch of %s: more than %d bytes", u, maxBytes)
+	}
+
+	return WebDocument{resp.Request.URL.String(), resp.Header.Get("Content-Type"), content}, nil
+}
+
+//HasSnapshot returns true if a snapshot of the page linked by the item was taken
+func (i Item) HasSnapshot() bool {
+	return len(i.Tags.Tag(linkTagSNAPSHOT)) > 0
+}
+
+//withoutSnapshot returns the tags without the snapshot tags
+func withoutSnapshot(tags TagList) TagList {
+	var result TagList
+	for _, t := range tags {
+		if t.Key != linkTagSNAPSHOT && t.Key != linkTagARCHIVED {
+			result = append(result, t)
+		}
+	}
+	return result
+}
+
+//keepSnapshot sets the snapshot tags of an item from its previous version if the URL did
+//not change, ignoring the values given by the user
+func keepSnapshot(i *Item, previous *Item) {
+	i.Tags = withoutSnapshot(i.Tags)
+	if previous == nil || previous.URL != i.URL {
+		return
+	}
+	for _, key := range []string{linkTagSNAPSHOT, linkTagARCHIVED} {
+		if value := previous.Tags.Tag(key); len(value) > 0 {
+			i.Tags.SetTag(key, value)
+		}
+	}
+}
+
+//Snapshot opens the snapshot of the page linked by an item, to be served with SnapshotPolicy
+func (app App) Snapshot(userName, pageName, itemID string) (io.ReadCloser, error) {
+	//Check the read permission
+	if _, err := app.GetPage(userName, pageName); err != nil {
+		return nil, err
+	}
+
+	i, err := app.repository.GetItem(userName, pageName, itemID)
+	if err != nil {
+		return nil, err
+	}
+	if !i.HasSnapshot() {
+		return nil, NotInDatastoreError{"Snapshot", itemID}
+	}
+	return app.uploadInteractor.Open(i.Tags.Tag(linkTagSNAPSHOT))
+}
+
+//ArchiveItem takes a new snapshot of the page linked by an item, replacing the previous one.
+//Returns the updated item.
+func (app App) ArchiveItem(userName, pageName, itemID string) (Item, error) {
+	if err := app.checkScope(ScopeWRITEITEMS, "Archive item"); err != nil {
+		return Item{}, err
+	}
+	if err := app.checkRole(userName, pageName, RoleEDITOR, "Archive item"); err != nil {
+		return Item{}, err
+	}
+	if app.linkFetcher == nil {
+		return Item{}, DataError{"snapshot", "the snapshots are disabled"}
+	}
+
+	i, err := app.repository.GetItem(userName, pageName, itemID)
+	if err != nil {
+		return Item{}, err
+	}
+	if len(i.URL) == 0 {
+		return Item{}, DataError{"url", "the item has no URL"}
+	}
+	return app.archiveItem(userName, pageName, i)
+}
+
+//archiveLink takes a snapshot of the URL of a stored item when this URL is new, and deletes
+//the snapshot of the previous URL. The item is kept without snapshot if the page cannot be archived.
+//Returns the item with its snapshot.
+func (app App) archiveLink(userName, pageName string, i Item, previous *Item) Item {
+	if previous != nil && previous.URL == i.URL {
+		return i
+	}
+	if previous != nil {
+		app.deleteSnapshot(previous.Tags.Tag(linkTagSNAPSHOT))
+	}
+	if app.linkFetcher == nil || len(i.URL) == 0 {
+		return i
+	}
+
+	archived, err := app.archiveItem(userName, pageName, i)
+	if err != nil {
+		app.logInteractor.Warningf("Snapshot of %s failed: %v", i.URL, err)
+		return i
+	}
+	return archived
+}
+
+//archiveItem stores a snapshot of the page linked by an item, and sets the snapshot tags of
+//the item if its URL did not change in the meantime
+func (app App) archiveItem(userName, pageName string, i Item) (Item, error) {
+	tNow := time.Now()
+	content, err := archivePage(app.linkFetcher, i.URL, tNow)
+	if err != nil {
+		return Item{}, err
+	}
+	upload, err := app.uploadInteractor.Store("snapshot.html", "text/html", bytes.NewReader(content))
+	if err != nil {
+		return Item{}, err
+	}
+
+	var replaced string
+	err = app.repository.RunInTransaction(func(repo Repository) error {
+		current, err := repo.GetItem(userName, pageName, i.ID)
+		if err != nil {
+			return err
+		}
+		if current.URL != i.URL {
+			return DataError{"url", "the URL changed during the snapshot"}
+		}
+
+		replaced = current.Tags.Tag(linkTagSNAPSHOT)
+		current.Tags.SetTag(linkTagSNAPSHOT, upload.Key)
+		current.Tags.SetTag(linkTagARCHIVED, tNow.UTC().Format(time.RFC3339))
+		i = current
+		return repo.StoreItem(userName, pageName, current)
+	})
+	if err != nil {
+		app.deleteSnapshot(upload.Key)
+		return Item{}, err
+	}
+
+	app.deleteSnapshot(replaced)
+	return i, nil
+}
+
+//deleteSnapshot removes a snapshot which is not used anymore, logging the failures
+func (app App) deleteSnapshot(key string) {
+	if len(key) == 0 {
+		return
+	}
+	if err := app.uploadInteractor.Delete(key); err != nil {
+		app.logInteractor.Warningf("Cannot delete snapshot %s: %v", key, err)
+	}
+}
+
+//archivePage fetches an HTML page and returns a self-contained copy of it: scripts,
+//frames and plugins are removed, and images, style sheets and fonts are inlined as data URIs.
+//The page and its assets are fetched within snapshotTimeout.
+func archivePage(fetcher LinkFetcher, rawurl string, date time.Time) ([]byte, error) {
+	deadline := time.Now().Add(snapshotTimeout)
+	doc, err := fetcher.Fetch(rawurl, maxFetchBytes, deadline)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, params, _ := mime.ParseMediaType(doc.ContentType)
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" {
+		return nil, fmt.Errorf("Snapshot of %s: not an HTML page (%s)", rawurl, mediaType)
+	}
+	base, err := url.Parse(doc.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	//Without scripts, the content of noscript elements is displayed
+	root, err := html.ParseWithOptions(bytes.NewReader(doc.Content), html.ParseOptionEnableScripting(false))
+	if err != nil {
+		return nil, err
+	}
+	if href := findBase(root); len(href) > 0 {
+		if u, err := base.Parse(href); err == nil {
+			base = u
+		}
+	}
+
+	b := snapshotBuilder{fetcher: fetcher, deadline: deadline, assets: make(map[string]string)}
+	b.rewrite(root, base)
+	if head := findElement(root, "head"); head != nil {
+		//The charset given by the HTTP header is lost, the snapshot being served as text/html
+		if charset := params["charset"]; len(charset) > 0 && !hasCharset(head) {
+			head.InsertBefore(newElement("meta", "charset", charset), head.FirstChild)
+		}
+		head.AppendChild(newElement("meta", "name", "okinotes:archived-from", "content", doc.URL))
+		head.AppendChild(newElement("meta", "name", "okinotes:archived-on", "content", date.UTC().Format(time.RFC3339)))
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, root); err != nil {
+		return nil, err
+	}
+	if buf.Len() > maxSnapshotBytes {
+		return nil, fmt.Errorf("Snapshot of %s: more than %d bytes", rawurl, maxSnapshotBytes)
+	}
+	return buf.Bytes(), nil
+}
+
+//snapshotBuilder rewrites an archived page, inlining its assets
+type snapshotBuilder struct {
+	fetcher  LinkFetcher
+	deadline time.Time         //End of the fetches of the assets
+	assets   map[string]string //Data URIs of the fetched assets by URL, empty if they cannot be fetched
+	fetched  int               //Number of fetched assets
+	size     int               //Total size of the fetched assets
+}
+
+//removedElements are the elements running code or loading other documents
+var removedElements = map[string]bool{
+	"script": true, "iframe": true, "frame": true, "frameset": true, "object": true, "embed": true,
+	"applet": true, "base": true, "template": true, "portal": true, "source": true, "track": true,
+}
+
+//imageAttributes are the attributes locating an image, inlined in the snapshot
+var imageAttributes = map[string]bool{"src": true, "poster": true, "background": true}
+
+//linkAttributes are the attributes locating another document, made absolute in the snapshot
+var linkAttributes = map[string]bool{"href": true, "action": true, "formaction": true, "cite": true, "longdesc": true}
+
+//rewrite removes the active content of the children of n, and inlines their assets
+func (b *snapshotBuilder) rewrite(n *html.Node, base *url.URL) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		switch {
+		case c.Type == html.CommentNode:
+			n.RemoveChild(c) //Conditional comments may hold scripts
+		case c.Type != html.ElementNode:
+		case b.rewriteElement(c, base):
+			b.rewrite(c, base)
+		default:
+			n.RemoveChild(c)
+		}
+		c = next
+	}
+}
+
+//rewriteElement rewrites an element and its attributes. Returns false if it must be removed.
+func (b *snapshotBuilder) rewriteElement(n *html.Node, base *url.URL) bool {
+	if removedElements[n.Data] {
+		return false
+	}
+
+	switch n.Data {
+	case "noscript":
+		//Its content is displayed, the scripts being removed
+		n.Data, n.DataAtom = "div", atom.Div
+	case "meta":
+		switch strings.ToLower(attr(n, "http-equiv")) {
+		case "", "content-type":
+		default:
+			return false //Refresh, cookies...
+		}
+	case "link":
+		rel := strings.Fields(strings.ToLower(attr(n, "rel")))
+		switch {
+		case hasWord(rel, "stylesheet"):
+			css, cssURL, ok := b.fetchStyleSheet(attr(n, "href"), base)
+			if !ok {
+				return false
+			}
+			n.Data, n.DataAtom, n.Attr = "style", atom.Style, nil
+			n.AppendChild(&html.Node{Type: html.TextNode, Data: b.inlineCSS(css, cssURL)})
+			return true
+		case hasWord(rel, "icon"):
+			data := b.dataURI(attr(n, "href"), base, isImage)
+			if len(data) == 0 {
+				return false
+			}
+			n.Attr = []html.Attribute{{Key: "rel", Val: "icon"}, {Key: "href", Val: data}}
+			return true
+		default:
+			return false //Preloads, manifests...
+		}
+	case "style":
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			if c.Type == html.TextNode {
+				c.Data = b.inlineCSS(c.Data, base)
+			}
+		}
+	}
+
+	var attrs []html.Attribute
+	for _, a := range n.Attr {
+		key := strings.ToLower(a.Key)
+		value := strings.TrimSpace(a.Val)
+		switch {
+		case strings.HasPrefix(key, "on") || key == "srcset" || key == "integrity" || key == "nonce" || key == "ping":
+			continue
+		case key == "style":
+			a.Val = b.inlineCSS(a.Val, base)
+		case imageAttributes[key] && (n.Data == "img" || key != "src" || (n.Data == "input" && strings.EqualFold(attr(n, "type"), "image"))):
+			if a.Val = b.dataURI(value, base, isImage); len(a.Val) == 0 {
+				continue
+			}
+		case key == "src" || key == "data":
+			continue //Media and plugins are not archived
+		case linkAttributes[key] && len(a.Namespace) == 0:
+			u, err := base.Parse(value)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "mailto") {
+				continue
+			}
+			if len(u.Fragment) > 0 && strings.HasPrefix(value, "#") {
+				a.Val = value //Anchors of the page itself
+			} else {
+				a.Val = u.String()
+			}
+		case strings.HasSuffix(key, "href"):
+			continue //Links of SVG elements
+		}
+		attrs = append(attrs, a)
+	}
+	n.Attr = attrs
+	return true
+}
+
+//cssURLs matches the references of the style sheets, and cssImports their import rules
+var (
+	cssURLs    = regexp.MustCompile(`(?i)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)'"]*))\s*\)`)
+	cssImports = regexp.MustCompile(`(?i)@import[^;]*;?`)
+)
+
+//inlineCSS replaces the references of a style sheet located at base by data URIs.
+//Imported style sheets are removed.
+func (b *snapshotBuilder) inlineCSS(css string, base *url.URL) string {
+	css = cssImports.ReplaceAllString(css, "")
+	css = cssURLs.ReplaceAllStringFunc(css, func(ref string) string {
+		m := cssURLs.FindStringSubmatch(ref)
+		data := b.dataURI(strings.TrimSpace(m[1]+m[2]+m[3]), base, isCSSAsset)
+		if len(data) == 0 {
+			data = "data:,"
+		}
+		return `url("` + data + `")`
+	})
+	//The style sheet is written in a style element, which it must not close
+	return strings.Replace(css, "</", `<\/`, -1)
+}
+
+//fetchStyleSheet fetches a style sheet. Returns its content and its final URL.
+func (b *snapshotBuilder) fetchStyleSheet(ref string, base *url.URL) (string, *url.URL, bool) {
+	doc, ok := b.fetch(ref, base)
+	if !ok {
+		return "", nil, false
+	}
+	mediaType, _, _ := mime.ParseMediaType(doc.ContentType)
+	u, err := url.Parse(doc.URL)
+	if mediaType != "text/css" || err != nil {
+		return "", nil, false
+	}
+	return string(doc.Content), u, true
+}
+
+//dataURI fetches an asset and returns it as a data URI, or an empty string if it cannot be
+//fetched or is not of an accepted type
+func (b *snapshotBuilder) dataURI(ref string, base *url.URL, accept func(mediaType string) bool) string {
+	if strings.HasPrefix(ref, "data:") {
+		header := strings.SplitN(strings.TrimPrefix(ref, "data:"), ",", 2)[0]
+		if accept(strings.ToLower(strings.TrimSpace(strings.SplitN(header, ";", 2)[0]))) {
+			return ref
+		}
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if data, found := b.assets[u.String()]; found {
+		return data
+	}
+
+	data := ""
+	if doc, ok := b.fetch(ref, base); ok {
+		mediaType, _, _ := mime.ParseMediaType(doc.ContentType)
+		if accept(mediaType) {
+			data = "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(doc.Content)
+		}
+	}
+	b.assets[u.String()] = data
+	return data
+}
+
+//fetch downloads an asset within the limits of the snapshot: the assets are not fetched
+//anymore once their total size reaches maxSnapshotBytes, or after the deadline
+func (b *snapshotBuilder) fetch(ref string, base *url.URL) (WebDocument, bool) {
+	remaining := maxSnapshotBytes - b.size
+	if b.fetched >= maxSnapshotAssets || remaining <= 0 || !time.Now().Before(b.deadline) {
+		return WebDocument{}, false
+	}
+	u, err := base.Parse(ref)
+	if err != nil || len(ref) == 0 {
+		return WebDocument{}, false
+	}
+	if remaining > maxFetchBytes {
+		remaining = maxFetchBytes
+	}
+	b.fetched++
+	doc, err := b.fetcher.Fetch(u.String(), remaining, b.deadline)
+	if err != nil || len(doc.Content) > remaining {
+		return WebDocument{}, false
+	}
+	b.size += len(doc.Content)
+	return doc, true
+}
+
+//isImage accepts the images, except SVG which may hold scripts
+func isImage(mediaType string) bool {
+	return strings.HasPrefix(mediaType, "image/") && mediaType != "image/svg+xml"
+}
+
+//isCSSAsset accepts the images and the fonts referenced by the style sheets
+func isCSSAsset(mediaType string) bool {
+	return isImage(mediaType) || strings.HasPrefix(mediaType, "font/") ||
+		strings.HasPrefix(mediaType, "application/font-") || strings.HasPrefix(mediaType, "application/x-font-")
+}
+
+//attr returns the value of an attribute of an element
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if strings.EqualFold(a.Key, key) && len(a.Namespace) == 0 {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+//hasWord returns true if words contains w
+func hasWord(words []string, w string) bool {
+	for _, word := range words {
+		if word == w {
+			return true
+		}
+	}
+	return false
+}
+
+//findElement returns the first element of the tree with the given name
+func findElement(n *html.Node, name string) *html.Node {
+	if n.Type == html.ElementNode && n.Data == name {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := findElement(c, name); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+//findBase returns the base URL declared by the page, if any
+func findBase(root *html.Node) string {
+	if base := findElement(root, "base"); base != nil {
+		return strings.TrimSpace(attr(base, "href"))
+	}
+	return ""
+}
+
+//hasCharset returns true if the head declares the encoding of the page
+func hasCharset(head *html.Node) bool {
+	for c := head.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode && c.Data == "meta" &&
+			(len(attr(c, "charset")) > 0 || strings.EqualFold(attr(c, "http-equiv"), "content-type")) {
+			return true
+		}
+	}
+	return false
+}
+
+//newElement returns an element with the given attributes, as key, value pairs
+func newElement(name string, attrs ...string) *html.Node {
+	n := &html.Node{Type: html.ElementNode, Data: name}
+	for i := 0; i+1 < len(attrs); i += 2 {
+		n.Attr = append(n.Attr, html.Attribute{Key: attrs[i], Val: attrs[i+1]})
+	}
+	return n
+}
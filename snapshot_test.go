@@ -0,0 +1,246 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/okinotes/okinotes"
+	"github.com/okinotes/okinotes/local"
+)
+
+//testLinkFetcher serves the documents from a fixed list
+type testLinkFetcher map[string]okinotes.WebDocument
+
+func (f testLinkFetcher) Fetch(url string, maxBytes int, deadline time.Time) (okinotes.WebDocument, error) {
+	doc, found := f[url]
+	if !found {
+		return okinotes.WebDocument{}, errors.New("not found")
+	}
+	if len(doc.Content) > maxBytes {
+		return okinotes.WebDocument{}, errors.New("too large")
+	}
+	if len(doc.URL) == 0 {
+		doc.URL = url
+	}
+	return doc, nil
+}
+
+const archivedPage = `<!DOCTYPE html>
+<html>
+<head>
+<title>Archived</title>
+<meta http-equiv="refresh" content="0; url=http://example.com/elsewhere">
+<link rel="stylesheet" href="style.css">
+<link rel="preload" href="/app.js">
+<script src="/app.js"></script>
+<style>body { background: url('/img/bg.png') }</style>
+</head>
+<body onload="track()">
+<h1 style="background-image: url(/img/missing.png)">Saved content</h1>
+<noscript><p>Enable scripts</p></noscript>
+<img src="img/photo.png" srcset="img/photo-2x.png 2x" alt="Photo">
+<a href="/about.html">About</a> <a href="#top">Top</a> <a href="javascript:alert(1)">Run</a>
+<iframe src="http://ads.example.com/"></iframe>
+<!--[if IE]><script>alert(1)</script><![endif]-->
+</body>
+</html>`
+
+func TestSnapshot(t *testing.T) {
+	root, err := ioutil.TempDir("", "okinotes-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	uploads, err := local.NewDiskUploads(root, "/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	site := testLinkFetcher{
+		"http://example.com/page.html":     {ContentType: "text/html; charset=iso-8859-1", Content: []byte(archivedPage)},
+		"http://example.com/style.css":     {ContentType: "text/css", Content: []byte(`@import "more.css"; h1 { font-family: x; src: url("/fonts/x.woff2") } </style>`)},
+		"http://example.com/fonts/x.woff2": {ContentType: "font/woff2", Content: []byte("font")},
+		"http://example.com/img/bg.png":    {ContentType: "image/png", Content: []byte("background")},
+		"http://example.com/img/photo.png": {ContentType: "image/png", Content: []byte("photo")},
+		"http://example.com/other.html":    {ContentType: "text/html", Content: []byte("<p>Other page</p>")},
+		"http://example.com/data.json":     {ContentType: "application/json", Content: []byte("{}")},
+	}
+	instance := newTestSite(t, "user01")
+	instance.Uploads = uploads
+	plain := instance.App("user01")
+	app := plain.WithLinkFetcher(site)
+	if err := app.CreatePage(okinotes.Page{Name: "links"}); err != nil {
+		t.Fatal(err)
+	}
+
+	readSnapshot := func(itemID string) string {
+		r, err := app.Snapshot("user01", "links", itemID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer r.Close()
+		content, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(content)
+	}
+
+	item, err := app.CreateItem("user01", "links", okinotes.Item{URL: "http://example.com/page.html"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !item.HasSnapshot() {
+		t.Fatalf("Item = %+v, wanted a snapshot", item)
+	}
+
+	//The original site disappears
+	for url := range site {
+		delete(site, url)
+	}
+	snapshot := readSnapshot(item.ID)
+	for _, want := range []string{
+		"Saved content",
+		`<meta charset="iso-8859-1"/>`,
+		`<meta name="okinotes:archived-from" content="http://example.com/page.html"/>`,
+		"url(\"data:image/png;base64,YmFja2dyb3VuZA==\")",
+		"url(\"data:font/woff2;base64,Zm9udA==\")",
+		`<\/style>`,
+		`src="data:image/png;base64,cGhvdG8="`,
+		`<div><p>Enable scripts</p></div>`,
+		`href="http://example.com/about.html"`,
+		`href="#top"`,
+	} {
+		if !strings.Contains(snapshot, want) {
+			t.Errorf("Snapshot does not contain %s:\n%s", want, snapshot)
+		}
+	}
+	for _, unwanted := range []string{"<script", "app.js", "onload", "refresh", "srcset", "@import", "javascript:", "iframe", "ads.example.com", "missing.png", "<!--"} {
+		if strings.Contains(snapshot, unwanted) {
+			t.Errorf("Snapshot contains %s:\n%s", unwanted, snapshot)
+		}
+	}
+
+	//The snapshot is kept while the URL does not change, and cannot be set by the users
+	item.Content = "Changed"
+	item.Tags.SetTag("link.snapshot", "someone-else-s-upload")
+	item, err = app.PutItem("user01", "links", item)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if readSnapshot(item.ID) != snapshot {
+		t.Errorf("Snapshot changed with the content of the item")
+	}
+	if err := app.SetItemTag("user01", "links", item.ID, "link.snapshot", "someone-else-s-upload"); err == nil {
+		t.Errorf("SetItemTag succeeded with the snapshot tag")
+	}
+	previousKey := item.Tags.Tag("link.snapshot")
+
+	//A new URL replaces the snapshot
+	site["http://example.com/other.html"] = okinotes.WebDocument{ContentType: "text/html", Content: []byte("<p>Other page</p>")}
+	item.URL = "http://example.com/other.html"
+	item, err = app.UpdateItem("user01", "links", item, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(readSnapshot(item.ID), "Other page") {
+		t.Errorf("Snapshot of the new URL not taken")
+	}
+	if r, err := uploads.Open(previousKey); err == nil {
+		r.Close()
+		t.Errorf("Snapshot of the previous URL not deleted")
+	}
+
+	//Only HTML pages are archived
+	site["http://example.com/data.json"] = okinotes.WebDocument{ContentType: "application/json", Content: []byte("{}")}
+	data, err := app.CreateItem("user01", "links", okinotes.Item{URL: "http://example.com/data.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data.HasSnapshot() {
+		t.Errorf("Snapshot taken of a JSON document")
+	}
+	if _, err := app.Snapshot("user01", "links", data.ID); err == nil {
+		t.Errorf("Snapshot found for an item without snapshot")
+	}
+	if _, err := app.ArchiveItem("user01", "links", data.ID); err == nil {
+		t.Errorf("ArchiveItem succeeded with a JSON document")
+	}
+
+	//The snapshot is taken again on demand
+	site["http://example.com/other.html"] = okinotes.WebDocument{ContentType: "text/html", Content: []byte("<p>Updated page</p>")}
+	if _, err := app.ArchiveItem("user01", "links", item.ID); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(readSnapshot(item.ID), "Updated page") {
+		t.Errorf("Snapshot not updated")
+	}
+
+	//Without LinkFetcher, the items are stored without snapshot
+	item, err = plain.CreateItem("user01", "links", okinotes.Item{URL: "http://example.com/other.html"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.HasSnapshot() {
+		t.Errorf("Snapshot taken without LinkFetcher")
+	}
+	if _, err := plain.ArchiveItem("user01", "links", item.ID); err == nil {
+		t.Errorf("ArchiveItem succeeded without LinkFetcher")
+	}
+}
+
+//recordingLinkFetcher records the limits of the fetches
+type recordingLinkFetcher struct {
+	testLinkFetcher
+	maxBytes  []int
+	deadlines []time.Time
+}
+
+func (f *recordingLinkFetcher) Fetch(url string, maxBytes int, deadline time.Time) (okinotes.WebDocument, error) {
+	f.maxBytes = append(f.maxBytes, maxBytes)
+	f.deadlines = append(f.deadlines, deadline)
+	return f.testLinkFetcher.Fetch(url, maxBytes, deadline)
+}
+
+func TestSnapshotLimits(t *testing.T) {
+	//The images of the page fill the size of a snapshot after 3 of them
+	page := "<p>Images</p>"
+	site := testLinkFetcher{}
+	for n, size := range []int{3 << 20, 5 << 20, 2 << 20, 1 << 20} {
+		page += fmt.Sprintf(`<img src="/img%d.png">`, n)
+		site[fmt.Sprintf("http://example.com/img%d.png", n)] = okinotes.WebDocument{ContentType: "image/png", Content: make([]byte, size)}
+	}
+	site["http://example.com/page.html"] = okinotes.WebDocument{ContentType: "text/html", Content: []byte(page)}
+	fetcher := &recordingLinkFetcher{testLinkFetcher: site}
+
+	app := newTestSite(t, "user01").App("user01").WithLinkFetcher(fetcher)
+	if err := app.CreatePage(okinotes.Page{Name: "links"}); err != nil {
+		t.Fatal(err)
+	}
+	started := time.Now()
+	if _, err := app.CreateItem("user01", "links", okinotes.Item{URL: "http://example.com/page.html"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fetcher.maxBytes) != 4 {
+		t.Fatalf("%d fetches, wanted the page and 3 images", len(fetcher.maxBytes))
+	}
+	if fetcher.maxBytes[3] != 2<<20 {
+		t.Errorf("Fetch of the third image limited to %d bytes, wanted the remaining size", fetcher.maxBytes[3])
+	}
+	for _, deadline := range fetcher.deadlines {
+		if !deadline.Equal(fetcher.deadlines[0]) || deadline.Before(started) || deadline.After(started.Add(time.Minute)) {
+			t.Errorf("Deadlines = %v, wanted one deadline for the snapshot", fetcher.deadlines)
+			break
+		}
+	}
+}
@@ -0,0 +1,9 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+// Package sqlrepo contains an implementation of the okinotes repository on top of database/sql.
+//
+// The SQL used is supported by SQLite (3.24 or later), which is the reference database
+// for this package. Any driver can be used, provided it accepts '?' placeholders.
+package sqlrepo
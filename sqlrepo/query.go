@@ -0,0 +1,237 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlrepo
+
+import (
+	"strings"
+	"time"
+
+	"github.com/okinotes/okinotes"
+)
+
+//pageFieldColumns maps the fields of okinotes.Page to the columns of the pages table
+var pageFieldColumns = map[string]string{
+	"UserName":             "user_name",
+	"Name":                 "name",
+	"CreationDate":         "creation_date",
+	"LastModificationDate": "last_modification_date",
+	"Title":                "title",
+	"ContentLicense":       "content_license",
+	"Policy":               "policy",
+	"TemplateID":           "template_id",
+}
+
+//pageQuery translates the datastore-style conditions of okinotes.PageQuery to SQL
+type pageQuery struct {
+	repo   repository
+	where  []string
+	args   []interface{}
+	orders []string
+	keys   []okinotes.PageOrder //Parsed orders
+	limit  int
+	cursor string
+	err    error
+}
+
+func (repo repository) NewPageQuery() okinotes.PageQuery {
+	return &pageQuery{
+		repo:  repo,
+		limit: -1,
+	}
+}
+func (q *pageQuery) User(userName string) okinotes.PageQuery {
+	q.where = append(q.where, "user_name = ?")
+	q.args = append(q.args, userName)
+	return q
+}
+func (q *pageQuery) Filter(filterStr string, value interface{}) okinotes.PageQuery {
+	f, err := okinotes.ParsePageFilter(filterStr, value)
+	if err != nil {
+		if q.err == nil {
+			q.err = err
+		}
+		return q
+	}
+
+	q.where = append(q.where, pageFieldColumns[f.Field]+" "+f.Operator+" ?")
+	q.args = append(q.args, columnValue(f.Value))
+	return q
+}
+func (q *pageQuery) Order(fieldName string) okinotes.PageQuery {
+	o, err := okinotes.ParsePageOrder(fieldName)
+	if err != nil {
+		if q.err == nil {
+			q.err = err
+		}
+		return q
+	}
+
+	order := pageFieldColumns[o.Field]
+	if o.Descending {
+		order += " DESC"
+	}
+	q.orders = append(q.orders, order)
+	q.keys = append(q.keys, o)
+	return q
+}
+func (q *pageQuery) Limit(limit int) okinotes.PageQuery {
+	q.limit = limit
+	return q
+}
+func (q *pageQuery) Start(cursor string) okinotes.PageQuery {
+	q.cursor = cursor
+	return q
+}
+
+func (q *pageQuery) GetAll() ([]okinotes.Page, string, error) {
+	if q.err != nil {
+		return nil, "", q.err
+	}
+
+	where := q.where
+	args := q.args
+	if len(q.cursor) > 0 {
+		cond, condArgs, err := q.after(q.cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		where = append(where, cond)
+		args = append(args, condArgs...)
+	}
+
+	query := "SELECT " + pageColumns + " FROM pages"
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	//Keep a deterministic order
+	query += " ORDER BY " + strings.Join(append(q.orders, "user_name", "name"), ", ")
+
+	if q.limit >= 0 {
+		//Limit is set to requested value +1 in order to know if there is more data available
+		query += " LIMIT ?"
+		args = append(args, q.limit+1)
+	}
+
+	rows, err := q.repo.q.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var pages []okinotes.Page
+	for rows.Next() {
+		page, err := scanPage(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		pages = append(pages, page)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if q.limit >= 0 && len(pages) > q.limit {
+		pages = pages[:q.limit]
+		if q.limit == 0 {
+			return pages, q.cursor, nil
+		}
+		return pages, okinotes.NewPageCursor(pages[q.limit-1]), nil
+	}
+	return pages, "", nil
+}
+
+//after returns the condition selecting the pages listed after the page of a cursor,
+//that is the pages with greater sort keys in the order of the ORDER BY clause
+func (q *pageQuery) after(cursor string) (string, []interface{}, error) {
+	page, err := okinotes.ParsePageCursor(cursor)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var orders []okinotes.PageOrder
+	orders = append(orders, q.keys...)
+	orders = append(orders, okinotes.PageOrder{Field: "UserName"}, okinotes.PageOrder{Field: "Name"})
+
+	var keys []sortKey
+	for _, o := range orders {
+		keys = append(keys, sortKey{pageFieldColumns[o.Field], o.Descending, columnValue(o.Value(page))})
+	}
+	cond, args := keysetCondition(keys)
+	return cond, args, nil
+}
+
+//sortKey is a column of an ORDER BY clause, with its value in the row after which a listing resumes
+type sortKey struct {
+	column     string
+	descending bool
+	value      interface{}
+}
+
+//keysetCondition returns the condition selecting the rows sorted after the values of the keys
+func keysetCondition(keys []sortKey) (string, []interface{}) {
+	var conds []string
+	var args []interface{}
+	for i, k := range keys {
+		var terms []string
+		for _, previous := range keys[:i] {
+			terms = append(terms, previous.column+" = ?")
+			args = append(args, previous.value)
+		}
+		if k.descending {
+			terms = append(terms, k.column+" < ?")
+		} else {
+			terms = append(terms, k.column+" > ?")
+		}
+		args = append(args, k.value)
+		conds = append(conds, "("+strings.Join(terms, " AND ")+")")
+	}
+	return "(" + strings.Join(conds, " OR ") + ")", args
+}
+
+//orderBy returns the ORDER BY clause sorting on the keys
+func orderBy(keys []sortKey) string {
+	var columns []string
+	for _, k := range keys {
+		if k.descending {
+			columns = append(columns, k.column+" DESC")
+		} else {
+			columns = append(columns, k.column)
+		}
+	}
+	return " ORDER BY " + strings.Join(columns, ", ")
+}
+
+//itemSortKeys returns the columns sorting the items in the given order, with their values for an item
+func itemSortKeys(order okinotes.ItemOrder, i okinotes.Item) []sortKey {
+	var keys []sortKey
+	switch order {
+	case okinotes.ItemOrderMANUAL:
+		keys = append(keys, sortKey{"position", false, i.Position})
+	case okinotes.ItemOrderCREATED:
+		return []sortKey{{"creation_date", true, formatTime(i.CreationDate)}, {"id", false, i.ID}}
+	}
+	return append(keys, sortKey{"last_modification_date", true, formatTime(i.LastModificationDate)}, sortKey{"id", false, i.ID})
+}
+
+//searchDocumentSortKeys returns the columns sorting the search documents, with their values for a document
+func searchDocumentSortKeys(d okinotes.SearchDocument) []sortKey {
+	return []sortKey{
+		{"d.last_modification_date", true, formatTime(d.LastModificationDate)},
+		{"d.user_name", false, d.UserName},
+		{"d.page_name", false, d.PageName},
+		{"d.item_id", false, d.ItemID},
+	}
+}
+
+//columnValue converts a value of a field of a page to the type stored in the pages table
+func columnValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case time.Time:
+		return formatTime(v)
+	case okinotes.Policy:
+		return string(v)
+	}
+	return v
+}
@@ -0,0 +1,987 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlrepo
+
+import (
+	"database/sql"
+	"encoding/json"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/okinotes/okinotes"
+)
+
+//timeFormat is the format of dates stored in the database.
+//Dates are stored in UTC with a fixed width, so that they can be compared as strings.
+const timeFormat = "2006-01-02T15:04:05.000000000Z07:00"
+
+const (
+	pageColumns     = "user_name, name, creation_date, last_modification_date, title, content_license, policy, template_id, item_order, tags"
+	itemColumns     = "id, creation_date, last_modification_date, position, kind, title, content, html_content, source, url, tags"
+	imageColumns    = "upload_key, content_type, creation_time, filename, size"
+	templateColumns = "id, creation_date, last_modification_date, name, file, page_tags, item_tags"
+)
+
+//querier is implemented by both *sql.DB and *sql.Tx
+type querier interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+//scanner is implemented by both *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+type repository struct {
+	db *sql.DB
+	q  querier
+	tx *sql.Tx //Current transaction, nil outside of a transaction
+}
+
+//NewRepository creates a repository storing its data in the given database.
+//The schema of the database is created or updated if needed.
+func NewRepository(db *sql.DB) (okinotes.Repository, error) {
+	if err := Migrate(db); err != nil {
+		return nil, err
+	}
+	return repository{db, db, nil}, nil
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(timeFormat, s)
+}
+
+func encodeJSON(v interface{}) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
+func decodeJSON(s string, v interface{}) error {
+	return json.Unmarshal([]byte(s), v)
+}
+
+//upsertSQL builds an insert statement replacing the existing row with the same keys
+func upsertSQL(table string, keys []string, columns []string) string {
+	all := append(append([]string(nil), keys...), columns...)
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(all)), ", ")
+
+	var sets []string
+	for _, c := range columns {
+		sets = append(sets, c+" = excluded."+c)
+	}
+
+	return "INSERT INTO " + table + " (" + strings.Join(all, ", ") + ") VALUES (" + placeholders + ")" +
+		" ON CONFLICT (" + strings.Join(keys, ", ") + ") DO UPDATE SET " + strings.Join(sets, ", ")
+}
+
+func (repo repository) RunInTransaction(f func(repo okinotes.Repository) error) error {
+	if repo.tx != nil {
+		return f(repo)
+	}
+
+	tx, err := repo.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	err = f(repository{repo.db, tx, tx})
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func scanPage(s scanner) (okinotes.Page, error) {
+	var page okinotes.Page
+	var creationDate, lastModificationDate, policy, itemOrder, tags string
+
+	err := s.Scan(&page.UserName, &page.Name, &creationDate, &lastModificationDate, &page.Title, &page.ContentLicense, &policy, &page.TemplateID, &itemOrder, &tags)
+	if err != nil {
+		return okinotes.Page{}, err
+	}
+
+	page.Policy = okinotes.Policy(policy)
+	page.ItemOrder = okinotes.ItemOrder(itemOrder)
+	if page.CreationDate, err = parseTime(creationDate); err != nil {
+		return okinotes.Page{}, err
+	}
+	if page.LastModificationDate, err = parseTime(lastModificationDate); err != nil {
+		return okinotes.Page{}, err
+	}
+	if err = decodeJSON(tags, &page.Tags); err != nil {
+		return okinotes.Page{}, err
+	}
+	return page, nil
+}
+
+func (repo repository) GetPage(userName string, pageName string) (okinotes.Page, error) {
+	row := repo.q.QueryRow("SELECT "+pageColumns+" FROM pages WHERE user_name = ? AND name = ?", userName, pageName)
+
+	page, err := scanPage(row)
+	if err == sql.ErrNoRows {
+		return okinotes.Page{}, okinotes.NotInDatastoreError{"Page", pageName}
+	}
+	if err != nil {
+		return okinotes.Page{}, err
+	}
+	return page, nil
+}
+func (repo repository) StorePage(page okinotes.Page) error {
+	tags, err := encodeJSON(page.Tags)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.q.Exec(upsertSQL("pages",
+		[]string{"user_name", "name"},
+		[]string{"creation_date", "last_modification_date", "title", "content_license", "policy", "template_id", "item_order", "tags"}),
+		page.UserName, page.Name, formatTime(page.CreationDate), formatTime(page.LastModificationDate),
+		page.Title, page.ContentLicense, string(page.Policy), page.TemplateID, string(page.ItemOrder), tags)
+	return err
+}
+func (repo repository) DeletePage(userName string, pageName string) error {
+	_, err := repo.q.Exec("DELETE FROM pages WHERE user_name = ? AND name = ?", userName, pageName)
+	return err
+}
+
+func scanItem(s scanner) (okinotes.Item, error) {
+	var item okinotes.Item
+	var creationDate, lastModificationDate, htmlContent, tags string
+
+	err := s.Scan(&item.ID, &creationDate, &lastModificationDate, &item.Position, &item.Kind, &item.Title, &item.Content, &htmlContent, &item.Source, &item.URL, &tags)
+	if err != nil {
+		return okinotes.Item{}, err
+	}
+
+	item.HTMLContent = template.HTML(htmlContent)
+	if item.CreationDate, err = parseTime(creationDate); err != nil {
+		return okinotes.Item{}, err
+	}
+	if item.LastModificationDate, err = parseTime(lastModificationDate); err != nil {
+		return okinotes.Item{}, err
+	}
+	if err = decodeJSON(tags, &item.Tags); err != nil {
+		return okinotes.Item{}, err
+	}
+	return item, nil
+}
+
+func (repo repository) GetItemsFromPage(userName string, pageName string, order okinotes.ItemOrder, limit int, cursor string) ([]okinotes.Item, string, error) {
+	query := "SELECT " + itemColumns + " FROM items WHERE user_name = ? AND page_name = ?"
+	args := []interface{}{userName, pageName}
+	if len(cursor) > 0 {
+		after, err := okinotes.ParseItemCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		cond, condArgs := keysetCondition(itemSortKeys(order, after))
+		query += " AND " + cond
+		args = append(args, condArgs...)
+	}
+	query += orderBy(itemSortKeys(order, okinotes.Item{}))
+	if limit >= 0 {
+		//Limit is set to requested value +1 in order to know if there is more data available
+		query += " LIMIT ?"
+		args = append(args, limit+1)
+	}
+
+	rows, err := repo.q.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var items []okinotes.Item
+	for rows.Next() {
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		items = append(items, item)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if limit >= 0 && len(items) > limit {
+		items = items[:limit]
+		if limit == 0 {
+			return items, cursor, nil
+		}
+		return items, okinotes.NewItemCursor(items[limit-1]), nil
+	}
+	return items, "", nil
+}
+func (repo repository) DeleteItemsFromPage(userName string, pageName string) error {
+	_, err := repo.q.Exec("DELETE FROM items WHERE user_name = ? AND page_name = ?", userName, pageName)
+	return err
+}
+func (repo repository) FindItem(userName string, pageName string, itemID string) (bool, error) {
+	var one int
+	err := repo.q.QueryRow("SELECT 1 FROM items WHERE user_name = ? AND page_name = ? AND id = ?", userName, pageName, itemID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+func (repo repository) GetItem(userName string, pageName string, itemID string) (okinotes.Item, error) {
+	row := repo.q.QueryRow("SELECT "+itemColumns+" FROM items WHERE user_name = ? AND page_name = ? AND id = ?", userName, pageName, itemID)
+
+	item, err := scanItem(row)
+	if err == sql.ErrNoRows {
+		return okinotes.Item{}, okinotes.NotInDatastoreError{"Item", itemID}
+	}
+	if err != nil {
+		return okinotes.Item{}, err
+	}
+	return item, nil
+}
+func (repo repository) StoreItem(userName string, pageName string, i okinotes.Item) error {
+	tags, err := encodeJSON(i.Tags)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.q.Exec(upsertSQL("items",
+		[]string{"user_name", "page_name", "id"},
+		[]string{"creation_date", "last_modification_date", "position", "kind", "title", "content", "html_content", "source", "url", "tags"}),
+		userName, pageName, i.ID, formatTime(i.CreationDate), formatTime(i.LastModificationDate), i.Position,
+		i.Kind, i.Title, i.Content, string(i.HTMLContent), i.Source, i.URL, tags)
+	return err
+}
+func (repo repository) DeleteItem(userName string, pageName string, itemID string) error {
+	_, err := repo.q.Exec("DELETE FROM items WHERE user_name = ? AND page_name = ? AND id = ?", userName, pageName, itemID)
+	return err
+}
+
+func scanRevision(s scanner) (okinotes.Revision, error) {
+	var r okinotes.Revision
+	var date, tags string
+	err := s.Scan(&r.ItemID, &r.Number, &date, &r.Author, &r.Kind, &r.Title, &r.Content, &r.Source, &r.URL, &tags)
+	if err != nil {
+		return okinotes.Revision{}, err
+	}
+	if r.Date, err = parseTime(date); err != nil {
+		return okinotes.Revision{}, err
+	}
+	if err = decodeJSON(tags, &r.Tags); err != nil {
+		return okinotes.Revision{}, err
+	}
+	return r, nil
+}
+
+const revisionColumns = "item_id, number, date, author, kind, title, content, source, url, tags"
+
+func (repo repository) GetRevisions(userName string, pageName string, itemID string) ([]okinotes.Revision, error) {
+	rows, err := repo.q.Query("SELECT "+revisionColumns+" FROM revisions WHERE user_name = ? AND page_name = ? AND item_id = ? ORDER BY number", userName, pageName, itemID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []okinotes.Revision
+	for rows.Next() {
+		r, err := scanRevision(rows)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+func (repo repository) GetRevision(userName string, pageName string, itemID string, number int) (okinotes.Revision, error) {
+	r, err := scanRevision(repo.q.QueryRow("SELECT "+revisionColumns+" FROM revisions WHERE user_name = ? AND page_name = ? AND item_id = ? AND number = ?", userName, pageName, itemID, number))
+	if err == sql.ErrNoRows {
+		return okinotes.Revision{}, okinotes.NotInDatastoreError{"Revision", strconv.Itoa(number)}
+	}
+	return r, err
+}
+func (repo repository) StoreRevision(userName string, pageName string, r okinotes.Revision) error {
+	tags, err := encodeJSON(r.Tags)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.q.Exec(upsertSQL("revisions",
+		[]string{"user_name", "page_name", "item_id", "number"},
+		[]string{"date", "author", "kind", "title", "content", "source", "url", "tags"}),
+		userName, pageName, r.ItemID, r.Number, formatTime(r.Date), r.Author,
+		r.Kind, r.Title, r.Content, r.Source, r.URL, tags)
+	return err
+}
+func (repo repository) DeleteRevisions(userName string, pageName string, itemID string) error {
+	_, err := repo.q.Exec("DELETE FROM revisions WHERE user_name = ? AND page_name = ? AND item_id = ?", userName, pageName, itemID)
+	return err
+}
+func (repo repository) DeleteRevisionsFromPage(userName string, pageName string) error {
+	_, err := repo.q.Exec("DELETE FROM revisions WHERE user_name = ? AND page_name = ?", userName, pageName)
+	return err
+}
+
+func scanTrashEntry(s scanner) (okinotes.TrashEntry, error) {
+	var e okinotes.TrashEntry
+	var kind, deletionDate, page, item string
+	err := s.Scan(&e.UserName, &e.ID, &e.PageName, &kind, &deletionDate, &e.DeletedBy, &page, &item)
+	if err != nil {
+		return okinotes.TrashEntry{}, err
+	}
+	e.Kind = okinotes.TrashKind(kind)
+	if e.DeletionDate, err = parseTime(deletionDate); err != nil {
+		return okinotes.TrashEntry{}, err
+	}
+	if err = decodeJSON(page, &e.Page); err != nil {
+		return okinotes.TrashEntry{}, err
+	}
+	if err = decodeJSON(item, &e.Item); err != nil {
+		return okinotes.TrashEntry{}, err
+	}
+	return e, nil
+}
+
+const trashColumns = "user_name, id, page_name, kind, deletion_date, deleted_by, page, item"
+
+func (repo repository) queryTrash(query string, args ...interface{}) ([]okinotes.TrashEntry, error) {
+	rows, err := repo.q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []okinotes.TrashEntry
+	for rows.Next() {
+		e, err := scanTrashEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+func (repo repository) GetTrash(userName string) ([]okinotes.TrashEntry, error) {
+	return repo.queryTrash("SELECT "+trashColumns+" FROM trash WHERE user_name = ? ORDER BY deletion_date DESC, id", userName)
+}
+func (repo repository) GetTrashEntry(userName string, entryID string) (okinotes.TrashEntry, error) {
+	e, err := scanTrashEntry(repo.q.QueryRow("SELECT "+trashColumns+" FROM trash WHERE user_name = ? AND id = ?", userName, entryID))
+	if err == sql.ErrNoRows {
+		return okinotes.TrashEntry{}, okinotes.NotInDatastoreError{"Trash entry", entryID}
+	}
+	return e, err
+}
+func (repo repository) GetExpiredTrash(before time.Time) ([]okinotes.TrashEntry, error) {
+	return repo.queryTrash("SELECT "+trashColumns+" FROM trash WHERE deletion_date < ? ORDER BY deletion_date DESC, id", formatTime(before))
+}
+func (repo repository) StoreTrashEntry(e okinotes.TrashEntry) error {
+	page, err := encodeJSON(e.Page)
+	if err != nil {
+		return err
+	}
+	item, err := encodeJSON(e.Item)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.q.Exec(upsertSQL("trash", []string{"user_name", "id"}, []string{"page_name", "kind", "deletion_date", "deleted_by", "page", "item"}),
+		e.UserName, e.ID, e.PageName, string(e.Kind), formatTime(e.DeletionDate), e.DeletedBy, page, item)
+	return err
+}
+func (repo repository) DeleteTrashEntry(userName string, entryID string) error {
+	_, err := repo.q.Exec("DELETE FROM trash WHERE user_name = ? AND id = ?", userName, entryID)
+	return err
+}
+
+const searchDocumentColumns = "d.user_name, d.page_name, d.item_id, d.last_modification_date, d.page_title, d.title, d.content, d.url, d.terms"
+
+func scanSearchDocument(s scanner) (okinotes.SearchDocument, error) {
+	var d okinotes.SearchDocument
+	var lastModificationDate, terms string
+	err := s.Scan(&d.UserName, &d.PageName, &d.ItemID, &lastModificationDate, &d.PageTitle, &d.Title, &d.Content, &d.URL, &terms)
+	if err != nil {
+		return okinotes.SearchDocument{}, err
+	}
+	if d.LastModificationDate, err = parseTime(lastModificationDate); err != nil {
+		return okinotes.SearchDocument{}, err
+	}
+	if err = decodeJSON(terms, &d.Terms); err != nil {
+		return okinotes.SearchDocument{}, err
+	}
+	return d, nil
+}
+
+func (repo repository) StoreSearchDocument(d okinotes.SearchDocument) error {
+	terms, err := encodeJSON(d.Terms)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.q.Exec(upsertSQL("search_documents",
+		[]string{"user_name", "page_name", "item_id"},
+		[]string{"last_modification_date", "page_title", "title", "content", "url", "terms"}),
+		d.UserName, d.PageName, d.ItemID, formatTime(d.LastModificationDate), d.PageTitle, d.Title, d.Content, d.URL, terms)
+	if err != nil {
+		return err
+	}
+
+	_, err = repo.q.Exec("DELETE FROM search_terms WHERE user_name = ? AND page_name = ? AND item_id = ?", d.UserName, d.PageName, d.ItemID)
+	if err != nil {
+		return err
+	}
+	for _, t := range d.Terms {
+		_, err = repo.q.Exec("INSERT INTO search_terms (term, user_name, page_name, item_id) VALUES (?, ?, ?, ?)", t, d.UserName, d.PageName, d.ItemID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+func (repo repository) DeleteSearchDocument(userName string, pageName string, itemID string) error {
+	_, err := repo.q.Exec("DELETE FROM search_terms WHERE user_name = ? AND page_name = ? AND item_id = ?", userName, pageName, itemID)
+	if err != nil {
+		return err
+	}
+	_, err = repo.q.Exec("DELETE FROM search_documents WHERE user_name = ? AND page_name = ? AND item_id = ?", userName, pageName, itemID)
+	return err
+}
+func (repo repository) DeleteSearchDocumentsFromPage(userName string, pageName string) error {
+	_, err := repo.q.Exec("DELETE FROM search_terms WHERE user_name = ? AND page_name = ?", userName, pageName)
+	if err != nil {
+		return err
+	}
+	_, err = repo.q.Exec("DELETE FROM search_documents WHERE user_name = ? AND page_name = ?", userName, pageName)
+	return err
+}
+func (repo repository) FindSearchDocuments(terms []string, limit int, cursor string) ([]okinotes.SearchDocument, string, error) {
+	if len(terms) == 0 {
+		return nil, "", nil
+	}
+
+	var args []interface{}
+	for _, t := range terms {
+		args = append(args, t)
+	}
+	args = append(args, len(terms))
+
+	query := "SELECT " + searchDocumentColumns + " FROM search_documents d JOIN (" +
+		"SELECT user_name, page_name, item_id FROM search_terms WHERE term IN (?" + strings.Repeat(", ?", len(terms)-1) + ") " +
+		"GROUP BY user_name, page_name, item_id HAVING COUNT(*) = ?" +
+		") m ON d.user_name = m.user_name AND d.page_name = m.page_name AND d.item_id = m.item_id"
+	if len(cursor) > 0 {
+		after, err := okinotes.ParseSearchDocumentCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		cond, condArgs := keysetCondition(searchDocumentSortKeys(after))
+		query += " WHERE " + cond
+		args = append(args, condArgs...)
+	}
+	query += orderBy(searchDocumentSortKeys(okinotes.SearchDocument{}))
+	if limit >= 0 {
+		//Limit is set to requested value +1 in order to know if there is more data available
+		query += " LIMIT ?"
+		args = append(args, limit+1)
+	}
+
+	rows, err := repo.q.Query(query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	var documents []okinotes.SearchDocument
+	for rows.Next() {
+		d, err := scanSearchDocument(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		documents = append(documents, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	if limit >= 0 && len(documents) > limit {
+		documents = documents[:limit]
+		if limit == 0 {
+			return documents, cursor, nil
+		}
+		return documents, okinotes.NewSearchDocumentCursor(documents[limit-1]), nil
+	}
+	return documents, "", nil
+}
+
+func scanPermission(s scanner) (okinotes.Permission, error) {
+	var p okinotes.Permission
+	var role, creationDate string
+	err := s.Scan(&p.PageUserName, &p.PageName, &p.UserName, &role, &creationDate)
+	if err != nil {
+		return okinotes.Permission{}, err
+	}
+	p.Role = okinotes.Role(role)
+	if p.CreationDate, err = parseTime(creationDate); err != nil {
+		return okinotes.Permission{}, err
+	}
+	return p, nil
+}
+
+const permissionColumns = "page_user_name, page_name, user_name, role, creation_date"
+
+func (repo repository) GetPermission(pageUserName string, pageName string, userName string) (okinotes.Permission, error) {
+	p, err := scanPermission(repo.q.QueryRow("SELECT "+permissionColumns+" FROM permissions WHERE page_user_name = ? AND page_name = ? AND user_name = ?", pageUserName, pageName, userName))
+	if err == sql.ErrNoRows {
+		return okinotes.Permission{}, okinotes.NotInDatastoreError{"Permission", userName}
+	}
+	return p, err
+}
+func (repo repository) queryPermissions(query string, args ...interface{}) ([]okinotes.Permission, error) {
+	rows, err := repo.q.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions []okinotes.Permission
+	for rows.Next() {
+		p, err := scanPermission(rows)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, p)
+	}
+	return permissions, rows.Err()
+}
+func (repo repository) GetPagePermissions(pageUserName string, pageName string) ([]okinotes.Permission, error) {
+	return repo.queryPermissions("SELECT "+permissionColumns+" FROM permissions WHERE page_user_name = ? AND page_name = ? ORDER BY user_name", pageUserName, pageName)
+}
+func (repo repository) GetUserPermissions(userName string) ([]okinotes.Permission, error) {
+	return repo.queryPermissions("SELECT "+permissionColumns+" FROM permissions WHERE user_name = ? ORDER BY page_user_name, page_name", userName)
+}
+func (repo repository) StorePermission(p okinotes.Permission) error {
+	_, err := repo.q.Exec(upsertSQL("permissions", []string{"page_user_name", "page_name", "user_name"}, []string{"role", "creation_date"}),
+		p.PageUserName, p.PageName, p.UserName, string(p.Role), formatTime(p.CreationDate))
+	return err
+}
+func (repo repository) DeletePermission(pageUserName string, pageName string, userName string) error {
+	_, err := repo.q.Exec("DELETE FROM permissions WHERE page_user_name = ? AND page_name = ? AND user_name = ?", pageUserName, pageName, userName)
+	return err
+}
+
+func (repo repository) FindUser(userName string) (bool, error) {
+	var one int
+	err := repo.q.QueryRow("SELECT 1 FROM users WHERE name = ?", userName).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+func (repo repository) GetUser(ident okinotes.Ident) (okinotes.User, error) {
+	identity, err := repo.GetIdentity(ident)
+	if err != nil {
+		return okinotes.User{}, err
+	}
+
+	var user okinotes.User
+	var kind string
+	err = repo.q.QueryRow("SELECT name, kind, full_name, is_admin FROM users WHERE name = ?", identity.UserName).Scan(&user.Name, &kind, &user.FullName, &user.IsAdmin)
+	if err == sql.ErrNoRows {
+		return okinotes.User{}, nil
+	}
+	if err != nil {
+		return okinotes.User{}, err
+	}
+	user.Kind = okinotes.UserKind(kind)
+	return user, nil
+}
+func (repo repository) GetUserByName(userName string) (okinotes.User, error) {
+	var user okinotes.User
+	var kind string
+	err := repo.q.QueryRow("SELECT name, kind, full_name, is_admin FROM users WHERE name = ?", userName).Scan(&user.Name, &kind, &user.FullName, &user.IsAdmin)
+	if err == sql.ErrNoRows {
+		return okinotes.User{}, okinotes.NotInDatastoreError{"User", userName}
+	}
+	if err != nil {
+		return okinotes.User{}, err
+	}
+	user.Kind = okinotes.UserKind(kind)
+	return user, nil
+}
+func (repo repository) GetIdentity(ident okinotes.Ident) (okinotes.Identity, error) {
+	identity := okinotes.Identity{Ident: ident}
+	err := repo.q.QueryRow("SELECT user_name FROM identities WHERE provider = ? AND identity = ?", ident.Provider, ident.Identity).Scan(&identity.UserName)
+	if err == sql.ErrNoRows {
+		return okinotes.Identity{}, okinotes.NotInDatastoreError{"Identity", ident.Identity}
+	}
+	if err != nil {
+		return okinotes.Identity{}, err
+	}
+	return identity, nil
+}
+func (repo repository) StoreUser(user okinotes.User) error {
+	_, err := repo.q.Exec(upsertSQL("users", []string{"name"}, []string{"kind", "full_name", "is_admin"}),
+		user.Name, string(user.Kind), user.FullName, user.IsAdmin)
+	return err
+}
+func (repo repository) StoreIdentity(identity okinotes.Identity) error {
+	_, err := repo.q.Exec(upsertSQL("identities", []string{"provider", "identity"}, []string{"user_name"}),
+		identity.Provider, identity.Identity, identity.UserName)
+	return err
+}
+
+func (repo repository) GetIdentities(userName string) ([]okinotes.Identity, error) {
+	rows, err := repo.q.Query("SELECT provider, identity, user_name FROM identities WHERE user_name = ? ORDER BY provider, identity", userName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var identities []okinotes.Identity
+	for rows.Next() {
+		var identity okinotes.Identity
+		if err := rows.Scan(&identity.Provider, &identity.Identity, &identity.UserName); err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	return identities, rows.Err()
+}
+func (repo repository) DeleteIdentity(identity okinotes.Identity) error {
+	_, err := repo.q.Exec("DELETE FROM identities WHERE provider = ? AND identity = ?", identity.Provider, identity.Identity)
+	return err
+}
+
+func (repo repository) GetMembership(orgName string, userName string) (okinotes.Membership, error) {
+	membership := okinotes.Membership{OrgName: orgName, UserName: userName}
+	err := repo.q.QueryRow("SELECT is_org_admin FROM memberships WHERE org_name = ? AND user_name = ?", orgName, userName).Scan(&membership.IsOrgAdmin)
+	if err == sql.ErrNoRows {
+		return okinotes.Membership{}, okinotes.NotInDatastoreError{"Membership", userName}
+	}
+	if err != nil {
+		return okinotes.Membership{}, err
+	}
+	return membership, nil
+}
+func (repo repository) getMemberships(where string, arg string, order string) ([]okinotes.Membership, error) {
+	rows, err := repo.q.Query("SELECT org_name, user_name, is_org_admin FROM memberships WHERE "+where+" = ? ORDER BY "+order, arg)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var memberships []okinotes.Membership
+	for rows.Next() {
+		var membership okinotes.Membership
+		if err := rows.Scan(&membership.OrgName, &membership.UserName, &membership.IsOrgAdmin); err != nil {
+			return nil, err
+		}
+		memberships = append(memberships, membership)
+	}
+	return memberships, rows.Err()
+}
+func (repo repository) GetMembers(orgName string) ([]okinotes.Membership, error) {
+	return repo.getMemberships("org_name", orgName, "user_name")
+}
+func (repo repository) GetMemberships(userName string) ([]okinotes.Membership, error) {
+	return repo.getMemberships("user_name", userName, "org_name")
+}
+func (repo repository) StoreMembership(membership okinotes.Membership) error {
+	_, err := repo.q.Exec(upsertSQL("memberships", []string{"org_name", "user_name"}, []string{"is_org_admin"}),
+		membership.OrgName, membership.UserName, membership.IsOrgAdmin)
+	return err
+}
+func (repo repository) DeleteMembership(orgName string, userName string) error {
+	_, err := repo.q.Exec("DELETE FROM memberships WHERE org_name = ? AND user_name = ?", orgName, userName)
+	return err
+}
+
+func (repo repository) GetCredentials(login string) (okinotes.Credentials, error) {
+	credentials := okinotes.Credentials{Login: login}
+	var creationDate string
+	err := repo.q.QueryRow("SELECT password_hash, creation_date FROM credentials WHERE login = ?", login).Scan(&credentials.PasswordHash, &creationDate)
+	if err == sql.ErrNoRows {
+		return okinotes.Credentials{}, okinotes.NotInDatastoreError{"Credentials", login}
+	}
+	if err != nil {
+		return okinotes.Credentials{}, err
+	}
+	if credentials.CreationDate, err = parseTime(creationDate); err != nil {
+		return okinotes.Credentials{}, err
+	}
+	return credentials, nil
+}
+func (repo repository) StoreCredentials(credentials okinotes.Credentials) error {
+	_, err := repo.q.Exec(upsertSQL("credentials", []string{"login"}, []string{"password_hash", "creation_date"}),
+		credentials.Login, credentials.PasswordHash, formatTime(credentials.CreationDate))
+	return err
+}
+
+//formatOptionalTime stores a zero time as an empty string
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return formatTime(t)
+}
+func parseOptionalTime(s string) (time.Time, error) {
+	if len(s) == 0 {
+		return time.Time{}, nil
+	}
+	return parseTime(s)
+}
+
+const tokenColumns = "hash, kind, user_name, creation_date, expiration_date, id, name, scopes, last_use_date, page_name"
+
+func scanToken(s scanner) (okinotes.Token, error) {
+	var token okinotes.Token
+	var kind, creationDate, expirationDate, scopes, lastUseDate string
+	err := s.Scan(&token.Hash, &kind, &token.UserName, &creationDate, &expirationDate, &token.ID, &token.Name, &scopes, &lastUseDate, &token.PageName)
+	if err != nil {
+		return okinotes.Token{}, err
+	}
+	token.Kind = okinotes.TokenKind(kind)
+	if token.CreationDate, err = parseTime(creationDate); err != nil {
+		return okinotes.Token{}, err
+	}
+	if token.ExpirationDate, err = parseOptionalTime(expirationDate); err != nil {
+		return okinotes.Token{}, err
+	}
+	if token.LastUseDate, err = parseOptionalTime(lastUseDate); err != nil {
+		return okinotes.Token{}, err
+	}
+	if err := decodeJSON(scopes, &token.Scopes); err != nil {
+		return okinotes.Token{}, err
+	}
+	return token, nil
+}
+
+func (repo repository) GetToken(hash string) (okinotes.Token, error) {
+	token, err := scanToken(repo.q.QueryRow("SELECT "+tokenColumns+" FROM tokens WHERE hash = ?", hash))
+	if err == sql.ErrNoRows {
+		return okinotes.Token{}, okinotes.NotInDatastoreError{"Token", hash}
+	}
+	return token, err
+}
+func (repo repository) GetTokens(userName string, kind okinotes.TokenKind) ([]okinotes.Token, error) {
+	rows, err := repo.q.Query("SELECT "+tokenColumns+" FROM tokens WHERE user_name = ? AND kind = ? ORDER BY creation_date, hash", userName, string(kind))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []okinotes.Token
+	for rows.Next() {
+		token, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+func (repo repository) StoreToken(token okinotes.Token) error {
+	scopes, err := encodeJSON(token.Scopes)
+	if err != nil {
+		return err
+	}
+	_, err = repo.q.Exec(upsertSQL("tokens", []string{"hash"}, []string{"kind", "user_name", "creation_date", "expiration_date", "id", "name", "scopes", "last_use_date", "page_name"}),
+		token.Hash, string(token.Kind), token.UserName, formatTime(token.CreationDate), formatOptionalTime(token.ExpirationDate),
+		token.ID, token.Name, scopes, formatOptionalTime(token.LastUseDate), token.PageName)
+	return err
+}
+func (repo repository) DeleteToken(hash string) error {
+	_, err := repo.q.Exec("DELETE FROM tokens WHERE hash = ?", hash)
+	return err
+}
+
+func scanImage(s scanner) (okinotes.UploadInfo, error) {
+	var img okinotes.UploadInfo
+	var creationTime string
+
+	err := s.Scan(&img.Key, &img.ContentType, &creationTime, &img.Filename, &img.Size)
+	if err != nil {
+		return okinotes.UploadInfo{}, err
+	}
+	if img.CreationTime, err = parseTime(creationTime); err != nil {
+		return okinotes.UploadInfo{}, err
+	}
+	return img, nil
+}
+
+func (repo repository) GetImages(userName string, limit int) ([]okinotes.UploadInfo, error) {
+	rows, err := repo.q.Query("SELECT "+imageColumns+" FROM images WHERE user_name = ? ORDER BY filename, upload_key LIMIT ?", userName, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var imgs []okinotes.UploadInfo
+	for rows.Next() {
+		img, err := scanImage(rows)
+		if err != nil {
+			return nil, err
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs, rows.Err()
+}
+func (repo repository) StoreImage(img okinotes.UploadInfo, userName string) error {
+	_, err := repo.q.Exec(upsertSQL("images",
+		[]string{"user_name", "upload_key"},
+		[]string{"content_type", "creation_time", "filename", "size"}),
+		userName, img.Key, img.ContentType, formatTime(img.CreationTime), img.Filename, img.Size)
+	return err
+}
+func (repo repository) RenameImage(name string, imgID string, userName string) error {
+	res, err := repo.q.Exec("UPDATE images SET filename = ? WHERE user_name = ? AND upload_key = ?", name, userName, imgID)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return okinotes.NotInDatastoreError{"Image", imgID}
+	}
+	return nil
+}
+func (repo repository) DeleteImage(imgID string, userName string) error {
+	_, err := repo.q.Exec("DELETE FROM images WHERE user_name = ? AND upload_key = ?", userName, imgID)
+	return err
+}
+
+func (repo repository) IsUsed(imgID string) (bool, error) {
+	var one int
+	err := repo.q.QueryRow("SELECT 1 FROM usages WHERE upload_key = ? LIMIT 1", imgID).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+func (repo repository) StoreUsage(userName string, pageName string, imgID string) error {
+	_, err := repo.q.Exec("INSERT INTO usages (user_name, page_name, upload_key) VALUES (?, ?, ?) ON CONFLICT DO NOTHING", userName, pageName, imgID)
+	return err
+}
+func (repo repository) DeleteUsages(userName string, pageName string) error {
+	_, err := repo.q.Exec("DELETE FROM usages WHERE user_name = ? AND page_name = ?", userName, pageName)
+	return err
+}
+
+func scanTemplate(s scanner) (okinotes.Template, error) {
+	var tpl okinotes.Template
+	var creationDate, lastModificationDate, pageTags, itemTags string
+
+	err := s.Scan(&tpl.ID, &creationDate, &lastModificationDate, &tpl.Name, &tpl.File, &pageTags, &itemTags)
+	if err != nil {
+		return okinotes.Template{}, err
+	}
+	if tpl.CreationDate, err = parseTime(creationDate); err != nil {
+		return okinotes.Template{}, err
+	}
+	if tpl.LastModificationDate, err = parseTime(lastModificationDate); err != nil {
+		return okinotes.Template{}, err
+	}
+	if err = decodeJSON(pageTags, &tpl.PageTags); err != nil {
+		return okinotes.Template{}, err
+	}
+	if err = decodeJSON(itemTags, &tpl.ItemTags); err != nil {
+		return okinotes.Template{}, err
+	}
+	return tpl, nil
+}
+
+func (repo repository) GetTemplate(templateID string) (okinotes.Template, error) {
+	row := repo.q.QueryRow("SELECT "+templateColumns+" FROM templates WHERE id = ?", templateID)
+
+	tpl, err := scanTemplate(row)
+	if err == sql.ErrNoRows {
+		return okinotes.Template{}, okinotes.NotInDatastoreError{"Template", templateID}
+	}
+	if err != nil {
+		return okinotes.Template{}, err
+	}
+	return tpl, nil
+}
+func (repo repository) GetAllTemplates() ([]okinotes.Template, error) {
+	rows, err := repo.q.Query("SELECT " + templateColumns + " FROM templates ORDER BY name, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var templates []okinotes.Template
+	for rows.Next() {
+		tpl, err := scanTemplate(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, tpl)
+	}
+	return templates, rows.Err()
+}
+func (repo repository) StoreTemplate(tpl okinotes.Template, generateID func() string) (string, error) {
+	pageTags, err := encodeJSON(tpl.PageTags)
+	if err != nil {
+		return "", err
+	}
+	itemTags, err := encodeJSON(tpl.ItemTags)
+	if err != nil {
+		return "", err
+	}
+
+	err = repo.RunInTransaction(func(txRepo okinotes.Repository) error {
+		tx := txRepo.(repository)
+
+		//Generate a new template id if not set
+		if len(tpl.ID) == 0 {
+			for {
+				tpl.ID = generateID()
+				if _, err := tx.GetTemplate(tpl.ID); err != nil {
+					if _, notFound := err.(okinotes.NotInDatastoreError); notFound {
+						break
+					}
+					return err
+				}
+			}
+		}
+
+		oldTemplate, err := tx.GetTemplate(tpl.ID)
+		if _, notFound := err.(okinotes.NotInDatastoreError); notFound {
+			tpl.CreationDate = tpl.LastModificationDate
+		} else if err != nil {
+			return err
+		} else {
+			tpl.CreationDate = oldTemplate.CreationDate
+		}
+
+		_, err = tx.q.Exec(upsertSQL("templates",
+			[]string{"id"},
+			[]string{"creation_date", "last_modification_date", "name", "file", "page_tags", "item_tags"}),
+			tpl.ID, formatTime(tpl.CreationDate), formatTime(tpl.LastModificationDate), tpl.Name, tpl.File, pageTags, itemTags)
+		return err
+	})
+
+	return tpl.ID, err
+}
+func (repo repository) DeleteTemplate(templateID string) error {
+	_, err := repo.q.Exec("DELETE FROM templates WHERE id = ?", templateID)
+	return err
+}
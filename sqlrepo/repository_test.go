@@ -0,0 +1,57 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlrepo
+
+import (
+	"database/sql"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/okinotes/okinotes"
+	"github.com/okinotes/okinotes/repotest"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//Each connection to ":memory:" is a distinct database
+	db.SetMaxOpenConns(1)
+	return db
+}
+
+func TestRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) okinotes.Repository {
+		db := openTestDB(t)
+		repo, err := NewRepository(db)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	//Migrating an up to date database does nothing
+	if err := Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	version, err := SchemaVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != len(migrations) {
+		t.Errorf("SchemaVersion = %d, wanted %d", version, len(migrations))
+	}
+}
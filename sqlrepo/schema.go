@@ -0,0 +1,258 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package sqlrepo
+
+import (
+	"database/sql"
+)
+
+//migrations contains the statements creating or updating the database schema.
+//migrations[i] upgrades the schema from version i to version i+1.
+//Existing migrations must never be modified: append a new one instead.
+var migrations = [][]string{
+	//Version 1: initial schema
+	{
+		`CREATE TABLE users (
+			name      TEXT NOT NULL PRIMARY KEY,
+			kind      TEXT NOT NULL,
+			full_name TEXT NOT NULL
+		)`,
+		`CREATE TABLE identities (
+			provider  TEXT NOT NULL,
+			identity  TEXT NOT NULL,
+			user_name TEXT NOT NULL,
+			PRIMARY KEY (provider, identity)
+		)`,
+		`CREATE INDEX identities_user ON identities (user_name)`,
+		`CREATE TABLE pages (
+			user_name              TEXT NOT NULL,
+			name                   TEXT NOT NULL,
+			creation_date          TEXT NOT NULL,
+			last_modification_date TEXT NOT NULL,
+			title                  TEXT NOT NULL,
+			content_license        TEXT NOT NULL,
+			policy                 TEXT NOT NULL,
+			template_id            TEXT NOT NULL,
+			tags                   TEXT NOT NULL,
+			PRIMARY KEY (user_name, name)
+		)`,
+		`CREATE INDEX pages_policy ON pages (policy, last_modification_date)`,
+		`CREATE INDEX pages_user ON pages (user_name, last_modification_date)`,
+		`CREATE TABLE items (
+			user_name              TEXT NOT NULL,
+			page_name              TEXT NOT NULL,
+			id                     TEXT NOT NULL,
+			creation_date          TEXT NOT NULL,
+			last_modification_date TEXT NOT NULL,
+			kind                   TEXT NOT NULL,
+			title                  TEXT NOT NULL,
+			content                TEXT NOT NULL,
+			html_content           TEXT NOT NULL,
+			source                 TEXT NOT NULL,
+			url                    TEXT NOT NULL,
+			tags                   TEXT NOT NULL,
+			PRIMARY KEY (user_name, page_name, id)
+		)`,
+		`CREATE INDEX items_date ON items (user_name, page_name, last_modification_date)`,
+		`CREATE TABLE images (
+			user_name     TEXT NOT NULL,
+			upload_key    TEXT NOT NULL,
+			content_type  TEXT NOT NULL,
+			creation_time TEXT NOT NULL,
+			filename      TEXT NOT NULL,
+			size          INTEGER NOT NULL,
+			PRIMARY KEY (user_name, upload_key)
+		)`,
+		`CREATE TABLE usages (
+			user_name  TEXT NOT NULL,
+			page_name  TEXT NOT NULL,
+			upload_key TEXT NOT NULL,
+			PRIMARY KEY (user_name, page_name, upload_key)
+		)`,
+		`CREATE INDEX usages_key ON usages (upload_key)`,
+		`CREATE TABLE templates (
+			id                     TEXT NOT NULL PRIMARY KEY,
+			creation_date          TEXT NOT NULL,
+			last_modification_date TEXT NOT NULL,
+			name                   TEXT NOT NULL,
+			file                   TEXT NOT NULL,
+			page_tags              TEXT NOT NULL,
+			item_tags              TEXT NOT NULL
+		)`,
+	},
+	//Version 2: admin flag and local credentials
+	{
+		`ALTER TABLE users ADD COLUMN is_admin BOOLEAN NOT NULL DEFAULT FALSE`,
+		`CREATE TABLE credentials (
+			login         TEXT NOT NULL PRIMARY KEY,
+			password_hash TEXT NOT NULL,
+			creation_date TEXT NOT NULL
+		)`,
+	},
+	//Version 3: tokens
+	{
+		`CREATE TABLE tokens (
+			hash            TEXT NOT NULL PRIMARY KEY,
+			kind            TEXT NOT NULL,
+			user_name       TEXT NOT NULL,
+			creation_date   TEXT NOT NULL,
+			expiration_date TEXT NOT NULL
+		)`,
+		`CREATE INDEX tokens_user ON tokens (user_name, kind)`,
+	},
+	//Version 4: personal access tokens
+	{
+		`ALTER TABLE tokens ADD COLUMN id TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE tokens ADD COLUMN name TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE tokens ADD COLUMN scopes TEXT NOT NULL DEFAULT '[]'`,
+		`ALTER TABLE tokens ADD COLUMN last_use_date TEXT NOT NULL DEFAULT ''`,
+	},
+	//Version 5: organisations
+	{
+		`CREATE TABLE memberships (
+			org_name     TEXT NOT NULL,
+			user_name    TEXT NOT NULL,
+			is_org_admin BOOLEAN NOT NULL,
+			PRIMARY KEY (org_name, user_name)
+		)`,
+		`CREATE INDEX memberships_user ON memberships (user_name)`,
+	},
+	//Version 6: per-page permissions
+	{
+		`CREATE TABLE permissions (
+			page_user_name TEXT NOT NULL,
+			page_name      TEXT NOT NULL,
+			user_name      TEXT NOT NULL,
+			role           TEXT NOT NULL,
+			creation_date  TEXT NOT NULL,
+			PRIMARY KEY (page_user_name, page_name, user_name)
+		)`,
+		`CREATE INDEX permissions_user ON permissions (user_name)`,
+	},
+	//Version 7: share links
+	{
+		`ALTER TABLE tokens ADD COLUMN page_name TEXT NOT NULL DEFAULT ''`,
+	},
+	//Version 8: item revisions
+	{
+		`CREATE TABLE revisions (
+			user_name TEXT NOT NULL,
+			page_name TEXT NOT NULL,
+			item_id   TEXT NOT NULL,
+			number    INTEGER NOT NULL,
+			date      TEXT NOT NULL,
+			author    TEXT NOT NULL,
+			kind      TEXT NOT NULL,
+			title     TEXT NOT NULL,
+			content   TEXT NOT NULL,
+			source    TEXT NOT NULL,
+			url       TEXT NOT NULL,
+			tags      TEXT NOT NULL,
+			PRIMARY KEY (user_name, page_name, item_id, number)
+		)`,
+	},
+	//Version 9: trash
+	{
+		`CREATE TABLE trash (
+			user_name     TEXT NOT NULL,
+			id            TEXT NOT NULL,
+			page_name     TEXT NOT NULL,
+			kind          TEXT NOT NULL,
+			deletion_date TEXT NOT NULL,
+			deleted_by    TEXT NOT NULL,
+			page          TEXT NOT NULL,
+			item          TEXT NOT NULL,
+			PRIMARY KEY (user_name, id)
+		)`,
+		`CREATE INDEX trash_date ON trash (deletion_date)`,
+	},
+	//Version 10: search index
+	{
+		`CREATE TABLE search_documents (
+			user_name              TEXT NOT NULL,
+			page_name              TEXT NOT NULL,
+			item_id                TEXT NOT NULL,
+			last_modification_date TEXT NOT NULL,
+			page_title             TEXT NOT NULL,
+			title                  TEXT NOT NULL,
+			content                TEXT NOT NULL,
+			url                    TEXT NOT NULL,
+			terms                  TEXT NOT NULL,
+			PRIMARY KEY (user_name, page_name, item_id)
+		)`,
+		`CREATE TABLE search_terms (
+			term      TEXT NOT NULL,
+			user_name TEXT NOT NULL,
+			page_name TEXT NOT NULL,
+			item_id   TEXT NOT NULL,
+			PRIMARY KEY (term, user_name, page_name, item_id)
+		)`,
+		`CREATE INDEX search_terms_item ON search_terms (user_name, page_name, item_id)`,
+	},
+	//Version 11: manual ordering of items
+	{
+		`ALTER TABLE pages ADD COLUMN item_order TEXT NOT NULL DEFAULT ''`,
+		`ALTER TABLE items ADD COLUMN position TEXT NOT NULL DEFAULT ''`,
+		`CREATE INDEX items_position ON items (user_name, page_name, position)`,
+	},
+}
+
+//SchemaVersion returns the version of the schema of the given database.
+//Returns 0 for an empty database.
+func SchemaVersion(db *sql.DB) (int, error) {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_version (version INTEGER NOT NULL)`)
+	if err != nil {
+		return 0, err
+	}
+
+	var version int
+	err = db.QueryRow(`SELECT version FROM schema_version`).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+//Migrate creates or updates the schema of the given database to the latest version.
+//Each migration is applied in its own transaction.
+func Migrate(db *sql.DB) error {
+	version, err := SchemaVersion(db)
+	if err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		for _, stmt := range migrations[version] {
+			if _, err := tx.Exec(stmt); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+
+		if version == 0 {
+			_, err = tx.Exec(`INSERT INTO schema_version (version) VALUES (?)`, version+1)
+		} else {
+			_, err = tx.Exec(`UPDATE schema_version SET version = ?`, version+1)
+		}
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,379 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"time"
+)
+
+//takeoutVersion is the version of the format of the takeout archives
+const takeoutVersion = 1
+
+//takeoutManifestName is the name of the manifest in a takeout archive
+const takeoutManifestName = "manifest.json"
+
+//maxTakeoutSize is the maximum size in bytes of an imported takeout archive
+const maxTakeoutSize = 500000000
+
+//maxTakeoutManifestSize is the maximum size in bytes of the manifest of an imported takeout archive
+const maxTakeoutManifestSize = 100000000
+
+//TakeoutManifest describes the content of a takeout archive: a zip holding this manifest,
+//in JSON, and the content of the uploaded images.
+type TakeoutManifest struct {
+	Version    int            `json:"version"`
+	ExportDate time.Time      `json:"exportDate"`
+	User       User           `json:"user"`
+	Identities []Ident        `json:"identities"` //Identities linked to the user, to be linked again after an import
+	Templates  []TemplateRef  `json:"templates"`  //Templates used by the pages
+	Pages      []TakeoutPage  `json:"pages"`
+	Images     []TakeoutImage `json:"images"`
+}
+
+//TemplateRef identifies a template
+type TemplateRef struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+//TakeoutPage is a page of a takeout archive, with all its items in the order of the page
+type TakeoutPage struct {
+	Page  Page   `json:"page"`
+	Items []Item `json:"items"`
+}
+
+//TakeoutImage is an uploaded image of a takeout archive
+type TakeoutImage struct {
+	UploadInfo
+	File string `json:"file"` //Name of the content in the archive
+}
+
+//TakeoutReport describes what has been restored from a takeout archive
+type TakeoutReport struct {
+	UserName   string              `json:"userName"` //Name of the user in the archive
+	Pages      []TakeoutPageReport `json:"pages"`
+	Images     int                 `json:"images"`
+	Identities []Ident             `json:"identities"` //Identities of the archive, to be linked again to the user
+}
+
+//TakeoutPageReport describes a restored page
+type TakeoutPageReport struct {
+	Name       string `json:"name"`       //Name of the page in the archive
+	ImportedAs string `json:"importedAs"` //Name of the restored page, different when the name was used
+	Items      int    `json:"items"`
+}
+
+//ExportAccount writes a takeout archive of the current user: all its pages and their items,
+//the templates they use, the uploaded images and the identities of the user.
+//Pages of the organisations, permissions, revisions and the trash are not exported.
+func (app App) ExportAccount(w io.Writer) error {
+	identity, err := app.sessionIdentity("Export account")
+	if err != nil {
+		return err
+	}
+	user, err := app.repository.GetUserByName(identity.UserName)
+	if err != nil {
+		return err
+	}
+
+	manifest := TakeoutManifest{
+		Version:    takeoutVersion,
+		ExportDate: time.Now(),
+		User:       user,
+	}
+
+	identities, err := app.repository.GetIdentities(user.Name)
+	if err != nil {
+		return err
+	}
+	for _, i := range identities {
+		manifest.Identities = append(manifest.Identities, i.Ident)
+	}
+
+	pages, _, err := app.repository.NewPageQuery().Filter("UserName=", user.Name).GetAll()
+	if err != nil {
+		return err
+	}
+	templates := make(map[string]bool)
+	for _, page := range pages {
+		items, _, err := app.repository.GetItemsFromPage(page.UserName, page.Name, page.ItemOrder, -1, "")
+		if err != nil {
+			return err
+		}
+		manifest.Pages = append(manifest.Pages, TakeoutPage{page, items})
+
+		if templates[page.TemplateID] {
+			continue
+		}
+		templates[page.TemplateID] = true
+		tpl, err := app.repository.GetTemplate(page.TemplateID)
+		if _, notFound := err.(NotInDatastoreError); notFound {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		manifest.Templates = append(manifest.Templates, TemplateRef{tpl.ID, tpl.Name})
+	}
+
+	images, err := app.repository.GetImages(user.Name, -1)
+	if err != nil {
+		return err
+	}
+	for n, img := range images {
+		manifest.Images = append(manifest.Images, TakeoutImage{img, fmt.Sprintf("images/%d", n)})
+	}
+
+	z := zip.NewWriter(w)
+	f, err := z.Create(takeoutManifestName)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(manifest); err != nil {
+		return err
+	}
+
+	for _, img := range manifest.Images {
+		if err := app.exportImage(z, img); err != nil {
+			return err
+		}
+	}
+	return z.Close()
+}
+
+//exportImage copies the content of an uploaded image in a takeout archive
+func (app App) exportImage(z *zip.Writer, img TakeoutImage) error {
+	r, err := app.uploadInteractor.Open(img.Key)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	f, err := z.CreateHeader(&zip.FileHeader{Name: img.File, Method: zip.Store})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	return err
+}
+
+//ImportAccount restores a takeout archive in the account of the current user, which can
+//have another name than the user of the archive when this name was taken on this instance.
+//References to the pages of the archived user are changed to the current user.
+//Pages keep their names, unless the current user already has a page with the same name.
+//All the pages are validated before anything is stored, then they are all stored in one
+//transaction: if the import fails, nothing is restored. Identities are not linked, as
+//logging in with each of them is required: they are listed in the report.
+func (app App) ImportAccount(data []byte) (TakeoutReport, error) {
+	identity, err := app.sessionIdentity("Import account")
+	if err != nil {
+		return TakeoutReport{}, err
+	}
+	userName := identity.UserName
+
+	if len(data) > maxTakeoutSize {
+		return TakeoutReport{}, DataError{"archive", fmt.Sprintf("must be smaller than %d bytes", maxTakeoutSize)}
+	}
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return TakeoutReport{}, DataError{"archive", "not a zip file"}
+	}
+	files := make(map[string]*zip.File)
+	for _, f := range z.File {
+		files[f.Name] = f
+	}
+
+	var manifest TakeoutManifest
+	if err := readTakeoutManifest(files[takeoutManifestName], &manifest); err != nil {
+		return TakeoutReport{}, err
+	}
+	oldName := manifest.User.Name
+	report := TakeoutReport{UserName: oldName, Identities: manifest.Identities}
+
+	//Pages are renamed when needed, then validated
+	used := make(map[string]bool)
+	for n := range manifest.Pages {
+		p := &manifest.Pages[n]
+		name, err := app.freePageName(userName, p.Page.Name, used)
+		if err != nil {
+			return TakeoutReport{}, err
+		}
+		used[name] = true
+		report.Pages = append(report.Pages, TakeoutPageReport{p.Page.Name, name, len(p.Items)})
+
+		renameUser(p, oldName, userName)
+		if _, err := app.ImportPage(userName, name, p.Page, p.Items, true); err != nil {
+			return TakeoutReport{}, fmt.Errorf("Page %s: %v", p.Page.Name, err)
+		}
+	}
+
+	//Images get new keys. Their content cannot be stored in the transaction: it is
+	//deleted if the import fails.
+	var images []UploadInfo
+	defer func() {
+		if images != nil {
+			app.deleteUploads(images)
+		}
+	}()
+	keys := make(map[string]string)
+	for _, img := range manifest.Images {
+		f := files[img.File]
+		if f == nil {
+			return TakeoutReport{}, DataError{"archive", fmt.Sprintf("missing image %s", img.File)}
+		}
+		info, err := app.importImage(f, img.UploadInfo)
+		if err != nil {
+			return TakeoutReport{}, err
+		}
+		images = append(images, info)
+		keys[img.Key] = info.Key
+	}
+	for n := range manifest.Pages {
+		renameImages(&manifest.Pages[n], keys)
+	}
+
+	err = app.repository.RunInTransaction(func(repo Repository) error {
+		txApp := app
+		txApp.repository = repo
+
+		for _, info := range images {
+			if err := repo.StoreImage(info, userName); err != nil {
+				return err
+			}
+		}
+		for n, p := range manifest.Pages {
+			if _, err := txApp.ImportPage(userName, report.Pages[n].ImportedAs, p.Page, p.Items, false); err != nil {
+				return fmt.Errorf("Page %s: %v", p.Page.Name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return TakeoutReport{}, err
+	}
+	report.Images = len(images)
+	images = nil //The uploads are kept
+	return report, nil
+}
+
+//readTakeoutManifest decodes the manifest of a takeout archive
+func readTakeoutManifest(f *zip.File, manifest *TakeoutManifest) error {
+	if f == nil {
+		return DataError{"archive", "missing " + takeoutManifestName}
+	}
+	r, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	b, err := ioutil.ReadAll(io.LimitReader(r, maxTakeoutManifestSize+1))
+	if err != nil {
+		return err
+	}
+	if len(b) > maxTakeoutManifestSize {
+		return DataError{"archive", "manifest too large"}
+	}
+	if err := json.Unmarshal(b, manifest); err != nil {
+		return DataError{"archive", err.Error()}
+	}
+	if manifest.Version != takeoutVersion {
+		return DataError{"archive", fmt.Sprintf("unsupported version %d", manifest.Version)}
+	}
+	return nil
+}
+
+//freePageName returns the name of a restored page: its name, or the first name
+//followed by a number which is neither used by the user nor already given to another page.
+func (app App) freePageName(userName string, pageName string, used map[string]bool) (string, error) {
+	for n := 1; ; n++ {
+		name := pageName
+		if n > 1 {
+			name = fmt.Sprintf("%s-%d", pageName, n)
+		}
+		if used[name] {
+			continue
+		}
+
+		_, err := app.repository.GetPage(userName, name)
+		if err == nil {
+			continue
+		}
+		if _, notFound := err.(NotInDatastoreError); !notFound {
+			return "", err
+		}
+		_, trashed, err := findTrashedPage(app.repository, userName, name)
+		if err != nil {
+			return "", err
+		}
+		if !trashed {
+			return name, nil
+		}
+	}
+}
+
+//importImage stores the content of an image of a takeout archive. The image is not
+//recorded in the repository.
+func (app App) importImage(f *zip.File, img UploadInfo) (UploadInfo, error) {
+	r, err := f.Open()
+	if err != nil {
+		return UploadInfo{}, err
+	}
+	defer r.Close()
+
+	return app.uploadInteractor.Store(img.Filename, img.ContentType, r)
+}
+
+//deleteUploads deletes the content of the images of a failed import
+func (app App) deleteUploads(images []UploadInfo) {
+	for _, info := range images {
+		if err := app.uploadInteractor.Delete(info.Key); err != nil {
+			app.logInteractor.Errorf("Import account failed to delete upload %s: %v", info.Key, err)
+		}
+	}
+}
+
+//renameUser changes the links to the pages of a user in the items of a page
+func renameUser(p *TakeoutPage, oldName string, newName string) {
+	if oldName == newName || len(oldName) == 0 {
+		return
+	}
+	r := strings.NewReplacer("/p/"+oldName+"/", "/p/"+newName+"/")
+	for n := range p.Items {
+		p.Items[n].Content = r.Replace(p.Items[n].Content)
+		p.Items[n].URL = r.Replace(p.Items[n].URL)
+	}
+}
+
+//renameImages changes the keys of the images used by a page and its items
+func renameImages(p *TakeoutPage, keys map[string]string) {
+	if len(keys) == 0 {
+		return
+	}
+	var pairs []string
+	for oldKey, newKey := range keys {
+		pairs = append(pairs, "/images/"+oldKey, "/images/"+newKey)
+	}
+	r := strings.NewReplacer(pairs...)
+
+	for n, t := range p.Page.Tags {
+		if newKey, found := keys[t.Value]; found {
+			p.Page.Tags[n].Value = newKey
+		}
+	}
+	for n := range p.Items {
+		p.Items[n].Content = r.Replace(p.Items[n].Content)
+		p.Items[n].URL = r.Replace(p.Items[n].URL)
+	}
+}
@@ -0,0 +1,199 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/okinotes/okinotes"
+	"github.com/okinotes/okinotes/local"
+)
+
+func TestTakeout(t *testing.T) {
+	root, err := ioutil.TempDir("", "okinotes-takeout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	template := okinotes.Template{
+		ID:       "blog2col",
+		Name:     "Micro-blog",
+		PageTags: okinotes.TagDescriptionList{{Key: "background", Kind: "imageId"}},
+	}
+	newInstance := func(name string, userNames ...string) (okinotes.Repository, *local.DiskUploads, func(string) okinotes.App) {
+		uploads, err := local.NewDiskUploads(root+"/"+name, "/uploads")
+		if err != nil {
+			t.Fatal(err)
+		}
+		site := newTestSite(t, userNames...)
+		site.Uploads, site.Admin = uploads, true
+		if err := site.App("").StoreTemplate(template); err != nil {
+			t.Fatal(err)
+		}
+		return site.Repository, uploads, site.App
+	}
+
+	//The account of alice on the first instance
+	repo, uploads, newApp := newInstance("first", "alice")
+	alice := newApp("alice")
+	img, err := uploads.Store("cat.png", "image/png", strings.NewReader("image content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.StoreImage(img, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := alice.CreatePage(okinotes.Page{Name: "notes", Title: "Notes", TemplateID: "blog2col", Tags: okinotes.TagList{{"background", img.Key}}}); err != nil {
+		t.Fatal(err)
+	}
+	item, err := alice.CreateItem("alice", "notes", okinotes.Item{Content: "See [my cat](/images/" + img.Key + ") and [more](/p/alice/notes.html)"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var archive bytes.Buffer
+	if err := alice.ExportAccount(&archive); err != nil {
+		t.Fatal(err)
+	}
+
+	//On the second instance, the name alice is taken and alice2 already has a page named notes
+	repo, uploads, newApp = newInstance("second", "alice", "alice2")
+	alice2 := newApp("alice2")
+	if err := alice2.CreatePage(okinotes.Page{Name: "notes", TemplateID: "blog2col"}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := alice2.ImportAccount(archive.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.UserName != "alice" || report.Images != 1 || len(report.Pages) != 1 || report.Pages[0].ImportedAs != "notes-2" {
+		t.Fatalf("Report = %+v, wanted the page notes imported as notes-2 and one image", report)
+	}
+	if len(report.Identities) != 1 || report.Identities[0].Identity != "alice" {
+		t.Errorf("Identities = %+v, wanted the identity of alice", report.Identities)
+	}
+
+	page, err := alice2.GetPage("alice2", "notes-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	images, err := repo.GetImages("alice2", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(images) != 1 || images[0].Filename != "cat.png" {
+		t.Fatalf("Images = %+v, wanted cat.png", images)
+	}
+	newKey := images[0].Key
+	if page.Title != "Notes" || page.Tags.Tag("background") != newKey {
+		t.Errorf("Page = %+v, wanted the background %s", page, newKey)
+	}
+	r, err := uploads.Open(newKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadAll(r)
+	r.Close()
+	if err != nil || string(content) != "image content" {
+		t.Errorf("Image content = %q, %v", content, err)
+	}
+
+	imported, err := repo.GetItem("alice2", "notes-2", item.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "See [my cat](/images/" + newKey + ") and [more](/p/alice2/notes.html)"; imported.Content != want {
+		t.Errorf("Content = %q, wanted %q", imported.Content, want)
+	}
+	if !imported.CreationDate.Equal(item.CreationDate) {
+		t.Errorf("CreationDate = %v, wanted %v", imported.CreationDate, item.CreationDate)
+	}
+
+	if _, err := alice2.ImportAccount([]byte("not a zip")); err == nil {
+		t.Errorf("ImportAccount succeeded with an invalid archive")
+	}
+
+	//Nothing is kept from a failed import, not even the images stored before the failure
+	broken := brokenTakeout(t, archive.Bytes())
+	files := countFiles(t, root+"/second")
+	if _, err := alice2.ImportAccount(broken); err == nil {
+		t.Fatalf("ImportAccount succeeded with a missing image")
+	}
+	if n := countFiles(t, root+"/second"); n != files {
+		t.Errorf("%d uploaded files after a failed import, wanted %d", n, files)
+	}
+	if _, err := alice2.GetPage("alice2", "notes-3"); err == nil {
+		t.Errorf("Page imported by a failed import")
+	}
+	if images, _ := repo.GetImages("alice2", -1); len(images) != 1 {
+		t.Errorf("Images = %+v after a failed import, wanted only cat.png", images)
+	}
+}
+
+//brokenTakeout returns a copy of a takeout archive whose manifest lists an image missing
+//after the existing ones
+func brokenTakeout(t *testing.T, archive []byte) []byte {
+	z, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for _, f := range z.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		dst, err := w.Create(f.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if f.Name == "manifest.json" {
+			var manifest okinotes.TakeoutManifest
+			if err := json.NewDecoder(r).Decode(&manifest); err != nil {
+				t.Fatal(err)
+			}
+			missing := okinotes.TakeoutImage{UploadInfo: manifest.Images[0].UploadInfo, File: "images/missing"}
+			missing.Key = "missing"
+			manifest.Images = append(manifest.Images, missing)
+			err = json.NewEncoder(dst).Encode(manifest)
+		} else {
+			_, err = io.Copy(dst, r)
+		}
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+//countFiles returns the number of files under a directory
+func countFiles(t *testing.T, root string) int {
+	n := 0
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			n++
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
@@ -0,0 +1,83 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"fmt"
+	"time"
+)
+
+//TokenKind represents the operation allowed by a token
+type TokenKind string
+
+const (
+	//TokenKindLINK tokens allow linking a new identity to a user
+	TokenKindLINK TokenKind = "LINK"
+	//TokenKindACCESS are personal access tokens, allowing scripts to use the API
+	TokenKindACCESS TokenKind = "ACCESS"
+	//TokenKindSHARE are share links, giving read access to an unlisted page
+	TokenKindSHARE TokenKind = "SHARE"
+)
+
+//Scope represents the operations allowed by an access token
+type Scope string
+
+const (
+	//ScopeREAD allows reading the pages and items
+	ScopeREAD Scope = "read"
+	//ScopeWRITEITEMS allows creating, updating and deleting items
+	ScopeWRITEITEMS Scope = "write:items"
+	//ScopeADMINPAGES allows creating, administrating and deleting pages
+	ScopeADMINPAGES Scope = "admin:pages"
+)
+
+//AllScopes lists the known scopes
+var AllScopes = []Scope{ScopeREAD, ScopeWRITEITEMS, ScopeADMINPAGES}
+
+//ParseScope validates a scope name
+func ParseScope(s string) (Scope, error) {
+	for _, scope := range AllScopes {
+		if string(scope) == s {
+			return scope, nil
+		}
+	}
+	return "", DataError{"scope", fmt.Sprintf("unknown scope '%s'", s)}
+}
+
+//Token is a secret given to a user, allowing an operation on its behalf.
+//Only a hash of the secret is stored, the secret itself is known only by the user.
+type Token struct {
+	Hash           string //Uniquely identify the token
+	Kind           TokenKind
+	UserName       string
+	CreationDate   time.Time
+	ExpirationDate time.Time //Zero if the token never expires
+
+	//Access and share tokens only
+	ID string //Public identifier, allowing to revoke the token
+
+	//Access tokens only
+	Name        string
+	Scopes      []Scope
+	LastUseDate time.Time
+
+	//Share tokens only
+	PageName string //Page of UserName shared by the token
+}
+
+//HasScope returns true if the token allows the given scope
+func (t Token) HasScope(scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+//IsExpired returns true if the token has expired at the given time
+func (t Token) IsExpired(now time.Time) bool {
+	return !t.ExpirationDate.IsZero() && !now.Before(t.ExpirationDate)
+}
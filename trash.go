@@ -0,0 +1,263 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes
+
+import (
+	"time"
+)
+
+//TrashKind is the kind of content of a TrashEntry
+type TrashKind string
+
+const (
+	//TrashKindPAGE is a deleted page. Its items, revisions and permissions are kept in place until it is purged.
+	TrashKindPAGE TrashKind = "PAGE"
+	//TrashKindITEM is a deleted item. Its revisions are kept in place until it is purged.
+	TrashKindITEM TrashKind = "ITEM"
+)
+
+//TrashEntry is a page or an item deleted by a user, that can be restored until it is purged
+type TrashEntry struct {
+	ID           string    `json:"id"`
+	UserName     string    `json:"userName"` //Owner of the trash, which is the owner of the deleted page
+	PageName     string    `json:"pageName"`
+	Kind         TrashKind `json:"kind"`
+	DeletionDate time.Time `json:"deletionDate"`
+	DeletedBy    string    `json:"deletedBy"`
+
+	Page Page `json:"page"` //Deleted page, for TrashKindPAGE
+	Item Item `json:"item"` //Deleted item, for TrashKindITEM
+}
+
+//findTrashedPage returns the entry of a deleted page of the trash
+func findTrashedPage(repo Repository, userName string, pageName string) (TrashEntry, bool, error) {
+	entries, err := repo.GetTrash(userName)
+	if err != nil {
+		return TrashEntry{}, false, err
+	}
+	for _, e := range entries {
+		if e.Kind == TrashKindPAGE && e.PageName == pageName {
+			return e, true, nil
+		}
+	}
+	return TrashEntry{}, false, nil
+}
+
+//Trash returns the pages and items deleted from the pages of a user (or of an organisation),
+//the most recently deleted first
+func (app App) Trash(userName string) ([]TrashEntry, error) {
+	if err := app.checkScope(ScopeREAD, "List trash"); err != nil {
+		return nil, err
+	}
+	if err := app.checkOwner(userName, "List trash"); err != nil {
+		return nil, err
+	}
+
+	return app.repository.GetTrash(userName)
+}
+
+//RestoreTrashEntry restores a deleted page or item.
+//An item can only be restored if its page has not been deleted.
+func (app App) RestoreTrashEntry(userName string, entryID string) error {
+	entry, err := app.trashEntry(userName, entryID, "Restore")
+	if err != nil {
+		return err
+	}
+
+	return app.repository.RunInTransaction(func(repo Repository) error {
+		switch entry.Kind {
+		case TrashKindPAGE:
+			_, err := repo.GetPage(userName, entry.PageName)
+			if err == nil {
+				return DataError{"page", "a page named '" + entry.PageName + "' already exists"}
+			}
+			if _, notFound := err.(NotInDatastoreError); !notFound {
+				return err
+			}
+			if err := repo.StorePage(entry.Page); err != nil {
+				return err
+			}
+			if err := indexPage(repo, entry.Page); err != nil {
+				return err
+			}
+		case TrashKindITEM:
+			_, err := repo.GetPage(userName, entry.PageName)
+			if _, notFound := err.(NotInDatastoreError); notFound {
+				return DataError{"page", "the page '" + entry.PageName + "' must be restored first"}
+			}
+			if err != nil {
+				return err
+			}
+			found, err := repo.FindItem(userName, entry.PageName, entry.Item.ID)
+			if err != nil {
+				return err
+			}
+			if found {
+				return DataError{"item", "an item with the same ID already exists"}
+			}
+			if err := repo.StoreItem(userName, entry.PageName, entry.Item); err != nil {
+				return err
+			}
+			if err := indexItem(repo, userName, entry.PageName, entry.Item); err != nil {
+				return err
+			}
+		}
+		return repo.DeleteTrashEntry(userName, entryID)
+	})
+}
+
+//PurgeTrashEntry removes permanently a deleted page or item
+func (app App) PurgeTrashEntry(userName string, entryID string) error {
+	entry, err := app.trashEntry(userName, entryID, "Purge")
+	if err != nil {
+		return err
+	}
+
+	return purgeTrashEntry(app.repository, app.uploadInteractor, entry)
+}
+
+//trashEntry returns an entry of the trash, if the current user is allowed to restore or purge it
+func (app App) trashEntry(userName string, entryID string, operation string) (TrashEntry, error) {
+	entry, err := app.repository.GetTrashEntry(userName, entryID)
+	if err != nil {
+		return TrashEntry{}, err
+	}
+
+	if entry.Kind == TrashKindPAGE {
+		if err := app.checkScope(ScopeADMINPAGES, operation+" page"); err != nil {
+			return TrashEntry{}, err
+		}
+		if err := app.checkOwner(userName, operation+" page"); err != nil {
+			return TrashEntry{}, err
+		}
+	} else {
+		if err := app.checkScope(ScopeWRITEITEMS, operation+" item"); err != nil {
+			return TrashEntry{}, err
+		}
+		if err := app.checkRole(userName, entry.PageName, RoleEDITOR, operation+" item"); err != nil {
+			return TrashEntry{}, err
+		}
+	}
+	return entry, nil
+}
+
+//PurgeTrash removes permanently the pages and items deleted before the given date,
+//with the snapshots of their links stored in uploads.
+//It is meant to be run periodically by a background job.
+//Returns the number of purged entries.
+func PurgeTrash(repo Repository, uploads UploadInteractor, before time.Time) (int, error) {
+	entries, err := repo.GetExpiredTrash(before)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, entry := range entries {
+		if err := purgeTrashEntry(repo, uploads, entry); err != nil {
+			return i, err
+		}
+	}
+	return len(entries), nil
+}
+
+//purgeTrashEntry removes permanently a deleted page, with all the associated content
+//(items, snapshots, revisions, image usages, permissions and share links), or a deleted
+//item with its snapshot and revisions
+func purgeTrashEntry(repo Repository, uploads UploadInteractor, entry TrashEntry) error {
+	userName, pageName := entry.UserName, entry.PageName
+
+	if entry.Kind == TrashKindITEM {
+		if err := purgeSnapshot(uploads, entry.Item); err != nil {
+			return err
+		}
+		if err := repo.DeleteRevisions(userName, pageName, entry.Item.ID); err != nil {
+			return err
+		}
+		return repo.DeleteTrashEntry(userName, entry.ID)
+	}
+
+	//The uploads cannot be deleted in the same transaction as the page: each step can be
+	//run again, and the trash entry is deleted last, so that an interrupted purge is
+	//completed by the next one
+
+	//Delete items, their snapshots and their history
+	items, _, err := repo.GetItemsFromPage(userName, pageName, ItemOrderMODIFIED, -1, "")
+	if err != nil {
+		return err
+	}
+	for _, i := range items {
+		if err := purgeSnapshot(uploads, i); err != nil {
+			return err
+		}
+	}
+	err = repo.DeleteItemsFromPage(userName, pageName)
+	if err != nil {
+		return err
+	}
+	err = repo.DeleteRevisionsFromPage(userName, pageName)
+	if err != nil {
+		return err
+	}
+
+	//Release the images used by the page
+	err = repo.DeleteUsages(userName, pageName)
+	if err != nil {
+		return err
+	}
+
+	//Delete permissions
+	permissions, err := repo.GetPagePermissions(userName, pageName)
+	if err != nil {
+		return err
+	}
+	for _, p := range permissions {
+		err = repo.DeletePermission(userName, pageName, p.UserName)
+		if err != nil {
+			return err
+		}
+	}
+
+	//Delete share links
+	links, err := getShareLinks(repo, userName, pageName)
+	if err != nil {
+		return err
+	}
+	for _, link := range links {
+		err = repo.DeleteToken(link.Hash)
+		if err != nil {
+			return err
+		}
+	}
+
+	//Delete the items of the page moved to the trash before the page
+	entries, err := repo.GetTrash(userName)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Kind == TrashKindITEM && e.PageName == pageName {
+			if err := purgeSnapshot(uploads, e.Item); err != nil {
+				return err
+			}
+			err = repo.DeleteTrashEntry(userName, e.ID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return repo.DeleteTrashEntry(userName, entry.ID)
+}
+
+//purgeSnapshot deletes the snapshot of the link of a purged item, if not already deleted
+func purgeSnapshot(uploads UploadInteractor, i Item) error {
+	if !i.HasSnapshot() {
+		return nil
+	}
+	err := uploads.Delete(i.Tags.Tag(linkTagSNAPSHOT))
+	if _, notFound := err.(NotInDatastoreError); notFound {
+		return nil
+	}
+	return err
+}
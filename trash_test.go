@@ -0,0 +1,168 @@
+// Copyright 2015 Simon HEGE. All rights reserved.
+// Use of this source code is governed by a MIT-style
+// license that can be found in the LICENSE file.
+
+package okinotes_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/okinotes/okinotes"
+	"github.com/okinotes/okinotes/local"
+)
+
+func TestTrash(t *testing.T) {
+	site := newTestSite(t, "owner", "editor")
+	repo, newApp := site.Repository, site.App
+
+	owner := newApp("owner")
+	editor := newApp("editor")
+	if err := owner.CreatePage(okinotes.Page{Name: "page01"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.GrantRole("owner", "page01", "editor", okinotes.RoleEDITOR); err != nil {
+		t.Fatal(err)
+	}
+	item, err := owner.CreateItem("owner", "page01", okinotes.Item{Content: "item"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Items
+	if err := editor.DeleteItem("owner", "page01", item.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetItem("owner", "page01", item.ID); err == nil {
+		t.Errorf("GetItem succeeded after DeleteItem")
+	}
+	if _, err := editor.Trash("owner"); err == nil {
+		t.Errorf("Trash of another user succeeded")
+	}
+	entries, err := owner.Trash("owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Kind != okinotes.TrashKindITEM || entries[0].Item.ID != item.ID || entries[0].DeletedBy != "editor" {
+		t.Fatalf("Trash = %+v, wanted the deleted item", entries)
+	}
+	if err := editor.RestoreTrashEntry("owner", entries[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetItem("owner", "page01", item.ID); err != nil {
+		t.Errorf("GetItem after RestoreTrashEntry: %v", err)
+	}
+
+	//Pages
+	if err := editor.DeletePage("owner", "page01"); err == nil {
+		t.Errorf("DeletePage by an editor succeeded")
+	}
+	if err := owner.DeletePage("owner", "page01"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := owner.GetPage("owner", "page01"); err == nil {
+		t.Errorf("GetPage succeeded after DeletePage")
+	}
+	if pages, _, err := owner.ListOwnedPages(10, ""); err != nil || len(pages) != 0 {
+		t.Errorf("ListOwnedPages = %v, %v after DeletePage", pages, err)
+	}
+	if err := owner.CreatePage(okinotes.Page{Name: "page01"}); err == nil {
+		t.Errorf("CreatePage with the name of a deleted page succeeded")
+	}
+	entries, err = owner.Trash("owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Kind != okinotes.TrashKindPAGE {
+		t.Fatalf("Trash = %+v, wanted the deleted page", entries)
+	}
+	if err := owner.RestoreTrashEntry("owner", entries[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.GetItem("owner", "page01", item.ID); err != nil {
+		t.Errorf("GetItem after restoring the page: %v", err)
+	}
+
+	//Purge
+	if err := owner.DeletePage("owner", "page01"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := okinotes.PurgeTrash(repo, site.Uploads, time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Errorf("PurgeTrash of recent entries = %d, %v", n, err)
+	}
+	if n, err := okinotes.PurgeTrash(repo, site.Uploads, time.Now().Add(time.Hour)); err != nil || n != 1 {
+		t.Errorf("PurgeTrash = %d, %v, wanted 1 entry", n, err)
+	}
+	if entries, _ := owner.Trash("owner"); len(entries) != 0 {
+		t.Errorf("Trash = %+v after PurgeTrash", entries)
+	}
+	if revisions, _ := repo.GetRevisions("owner", "page01", item.ID); len(revisions) != 0 {
+		t.Errorf("GetRevisions = %+v after PurgeTrash", revisions)
+	}
+	if err := owner.CreatePage(okinotes.Page{Name: "page01"}); err != nil {
+		t.Errorf("CreatePage after PurgeTrash: %v", err)
+	}
+	if _, err := repo.GetItem("owner", "page01", item.ID); err == nil {
+		t.Errorf("Items of a purged page are still present")
+	}
+}
+
+func TestPurgeTrashUploads(t *testing.T) {
+	root, err := ioutil.TempDir("", "okinotes-trash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	uploads, err := local.NewDiskUploads(root, "/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	site := newTestSite(t, "owner")
+	site.Uploads = uploads
+	repo := site.Repository
+	owner := site.App("owner").WithLinkFetcher(testLinkFetcher{
+		"http://example.com/": {ContentType: "text/html", Content: []byte("<p>Linked page</p>")},
+	})
+	if err := owner.CreatePage(okinotes.Page{Name: "page01"}); err != nil {
+		t.Fatal(err)
+	}
+	var items []okinotes.Item
+	var snapshots []string
+	for i := 0; i < 2; i++ {
+		item, err := owner.CreateItem("owner", "page01", okinotes.Item{URL: "http://example.com/"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !item.HasSnapshot() {
+			t.Fatalf("Item = %+v, wanted a snapshot", item)
+		}
+		items = append(items, item)
+		snapshots = append(snapshots, item.Tags.Tag("link.snapshot"))
+	}
+	if err := repo.StoreUsage("owner", "page01", "image01"); err != nil {
+		t.Fatal(err)
+	}
+
+	//The item deleted before the page is purged with it
+	if err := owner.DeleteItem("owner", "page01", items[1].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := owner.DeletePage("owner", "page01"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := okinotes.PurgeTrash(repo, uploads, time.Now().Add(time.Hour)); err != nil || n != 2 {
+		t.Fatalf("PurgeTrash = %d, %v, wanted 2 entries", n, err)
+	}
+	for _, key := range snapshots {
+		if r, err := uploads.Open(key); err == nil {
+			r.Close()
+			t.Errorf("Snapshot %s not deleted by PurgeTrash", key)
+		}
+	}
+	if used, err := repo.IsUsed("image01"); err != nil || used {
+		t.Errorf("IsUsed = %v, %v after PurgeTrash, wanted the image released", used, err)
+	}
+}
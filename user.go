@@ -4,6 +4,10 @@
 
 package okinotes
 
+import (
+	"time"
+)
+
 //UserKind represents the kind of a user
 type UserKind string
 
@@ -19,6 +23,7 @@ type User struct {
 	Name     string //Uniquely identify the user
 	Kind     UserKind
 	FullName string
+	IsAdmin  bool
 }
 
 //Ident is an identity from a third party provider
@@ -34,6 +39,13 @@ type Identity struct {
 	UserName string
 }
 
+//Credentials are the login and password of an identity managed by the application itself
+type Credentials struct {
+	Login        string
+	PasswordHash string
+	CreationDate time.Time
+}
+
 //Membership indicates the membership of a user into an organisation.
 type Membership struct {
 	UserName string
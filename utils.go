@@ -5,6 +5,11 @@
 package okinotes
 
 import (
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"html/template"
 	"math/rand"
 	"time"
 
@@ -30,6 +35,21 @@ func generateID() string {
 	return string(b)
 }
 
+//generateSecret returns a random string suitable for tokens given to the users
+func generateSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := cryptorand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+//hashSecret returns the hash of a secret, under which it is stored
+func hashSecret(secret string) string {
+	h := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(h[:])
+}
+
 func markdownToHTML(input string) string {
 
 	// set up the HTML renderer
@@ -54,6 +74,12 @@ func markdownToHTML(input string) string {
 	return string(html)
 }
 
+//MarkdownToHTML returns the sanitized HTML rendering of a markdown text,
+//as stored in Item.HTMLContent.
+func MarkdownToHTML(input string) template.HTML {
+	return template.HTML(markdownToHTML(input))
+}
+
 func convertToTimeAgo(t time.Time) string {
 	return timeago.English.Format(t)
 }
@@ -6,27 +6,45 @@ package okinotes
 
 import (
 	"encoding/json"
-	"encoding/xml"
 	"errors"
 	"fmt"
 	"html/template"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gorilla/mux"
-	"golang.org/x/tools/blog/atom"
 )
 
 var allTemplates *template.Template
 
+var templateFuncs = template.FuncMap{
+	//"title": strings.Title,
+	//"cssColor": cssColor,
+	"timeago": convertToTimeAgo,
+}
+
 func init() {
-	funcMap := template.FuncMap{
-		//"title": strings.Title,
-		//"cssColor": cssColor,
-		"timeago": convertToTimeAgo,
+	//Missing templates are reported when rendering a page, so that the package
+	//remains usable from another working directory (tests, tools, ...)
+	allTemplates = template.New("allTemplates").Funcs(templateFuncs)
+	LoadTemplates("resources/templates/*.tpl")
+}
+
+//LoadTemplates replaces the templates used to render the pages of the webapp
+//by the files matching the given pattern. Must be called before serving requests.
+func LoadTemplates(pattern string) error {
+	t, err := template.New("allTemplates").Funcs(templateFuncs).ParseGlob(pattern)
+	if err != nil {
+		return err
 	}
-	allTemplates = template.Must(template.New("allTemplates").Funcs(funcMap).ParseGlob("resources/templates/*.tpl"))
+	allTemplates = t
+	return nil
 }
 
 //RegisterPagesOnRouter initializes the router for the pages of the webapp
@@ -43,20 +61,45 @@ func RegisterPagesOnRouter(m *mux.Router, f AppFactory) error {
 			"/roadmap.html":          makeStaticPageHandler("roadmap", f),
 			//User images
 			"/user/images.html": makePageHandler(pageImages, f),
+			//Account settings
+			"/account.html":                    makePageHandler(pageAccount, f),
+			"/account/identities/confirm.html": makePageHandler(pageIdentityLinkGet, f),
+			"/account/takeout.zip":             makePageHandler(pageTakeoutExport, f),
+			//Organizations
+			"/orgs.html":           makePageHandler(pageOrganizations, f),
+			"/orgs/{orgName}.html": makePageHandler(pageOrganization, f),
+			//Trash
+			"/trash.html": makePageHandler(pageTrash, f),
+			//Search
+			"/search.html": makePageHandler(pageSearch, f),
 			//Page administration
 			"/administrate.html":    makePageHandler(pageAdminGet, f),
 			"/change_template.html": makePageHandler(pageChangeTemplateGet, f),
 			"/delete.html":          makePageHandler(pageDeleteGet, f),
+			"/share.html":           makePageHandler(pageShareGet, f),
 			"/newItem.html":         makePageHandler(pageNewItemGet, f),
 			"/editItem.html":        makePageHandler(pageEditItemGet, f),
 			"/deleteItem.html":      makePageHandler(pageDeleteItemGet, f),
+			"/itemHistory.html":     makePageHandler(pageItemHistoryGet, f),
 			"/importPage.html":      makePageHandler(pageImportPageGet, f),
 			//Pages
 			"/p/{userName}/{pageName}.html":                       makePageHandler(pagePage, f),
+			"/p/{userName}/{pageName}.md":                         makePageHandler(markdownPage, f),
+			"/p/{userName}/{pageName}/links.html":                 makePageHandler(pageLinkReport, f),
+			"/p/{userName}/{pageName}/items/{itemID}/snapshot":    makePageHandler(pageSnapshot, f),
 			"/p/{userName}/{pageName}/offline.html":               makePageHandler(offlinePage, f),
 			"/p/{userName}/{pageName}/cache.manifest":             makePageHandler(cacheManifestPage, f),
-			"/p/{userName}/{pageName}/atom.xml":                   makePageHandler(xmlPage, f),
+			"/p/{userName}/{pageName}/atom.xml":                   makePageHandler(pageFeed, f),
+			"/p/{userName}/{pageName}/rss.xml":                    makePageHandler(pageFeed, f),
+			"/p/{userName}/{pageName}/feed.json":                  makePageHandler(pageFeed, f),
 			"/p/{userName}/{pageName}/{userName}_{pageName}.json": makePageHandler(jsonPage, f),
+			//Feeds of the public pages of a user, and of the site
+			"/p/{userName}/atom.xml":  makePageHandler(userFeed, f),
+			"/p/{userName}/rss.xml":   makePageHandler(userFeed, f),
+			"/p/{userName}/feed.json": makePageHandler(userFeed, f),
+			"/atom.xml":               makePageHandler(siteFeed, f),
+			"/rss.xml":                makePageHandler(siteFeed, f),
+			"/feed.json":              makePageHandler(siteFeed, f),
 			//Images
 			"/images/{imgID}": makePageHandler(getImage, f),
 			//Administration
@@ -69,15 +112,35 @@ func RegisterPagesOnRouter(m *mux.Router, f AppFactory) error {
 			"/user/images.html":        makePageHandler(pageImagesPost, f),
 			"/user/images/rename.html": makePageHandler(pageImageRename, f),
 			"/user/images/delete.html": makePageHandler(pageImageDelete, f),
+			//Account settings
+			"/account/identities/link.html":    makePageHandler(pageIdentityLinkStart, f),
+			"/account/identities/confirm.html": makePageHandler(pageIdentityLinkPost, f),
+			"/account/identities/unlink.html":  makePageHandler(pageIdentityUnlink, f),
+			"/account/tokens/create.html":      makePageHandler(pageAccessTokenCreate, f),
+			"/account/tokens/revoke.html":      makePageHandler(pageAccessTokenRevoke, f),
+			"/account/takeout/import.html":     makePageHandler(pageTakeoutImport, f),
+			//Organizations
+			"/orgs.html":                          makePageHandler(pageOrganizationCreate, f),
+			"/orgs/{orgName}/members.html":        makePageHandler(pageMemberSet, f),
+			"/orgs/{orgName}/members/remove.html": makePageHandler(pageMemberRemove, f),
+			//Trash
+			"/trash/restore.html": makePageHandler(pageTrashRestore, f),
+			"/trash/purge.html":   makePageHandler(pageTrashPurge, f),
 			//Page administration
-			"/create.html":          makePageHandler(pageCreatePost, f),
-			"/administrate.html":    makePageHandler(pageAdminPost, f),
-			"/change_template.html": makePageHandler(pageChangeTemplatePost, f),
-			"/delete.html":          makePageHandler(pageDeletePost, f),
-			"/deleteItem.html":      makePageHandler(pageDeleteItemPost, f),
-			"/importPage.html":      makePageHandler(pageImportPagePost, f),
+			"/create.html":             makePageHandler(pageCreatePost, f),
+			"/administrate.html":       makePageHandler(pageAdminPost, f),
+			"/change_template.html":    makePageHandler(pageChangeTemplatePost, f),
+			"/delete.html":             makePageHandler(pageDeletePost, f),
+			"/share.html":              makePageHandler(pageSharePost, f),
+			"/share/revoke.html":       makePageHandler(pageShareRevoke, f),
+			"/share/links.html":        makePageHandler(pageShareLinkCreate, f),
+			"/share/links/revoke.html": makePageHandler(pageShareLinkRevoke, f),
+			"/deleteItem.html":         makePageHandler(pageDeleteItemPost, f),
+			"/restoreItem.html":        makePageHandler(pageRestoreItemPost, f),
+			"/importPage.html":         makePageHandler(pageImportPagePost, f),
 			//Pages
-			"/p/{userName}/{pageName}.html": makePageHandler(pageAddItem, f),
+			"/p/{userName}/{pageName}.html":                    makePageHandler(pageAddItem, f),
+			"/p/{userName}/{pageName}/items/{itemID}/snapshot": makePageHandler(pageArchiveItem, f),
 		},
 		"DELETE": {},
 		"OPTION": {},
@@ -98,14 +161,60 @@ func RegisterAPIOnRouter(m *mux.Router, f AppFactory) error {
 	m.HandleFunc("/version", makeAppHandler(getVersion, f, http.StatusOK)).Methods("GET")
 	m.HandleFunc("/currentUser", makeAppHandler(getCurrentUser, f, http.StatusOK)).Methods("GET")
 
+	m.HandleFunc("/account/identities", makeAppHandler(getIdentities, f, http.StatusOK)).Methods("GET")
+	m.HandleFunc("/account/identities", makeAppHandler(linkIdentity, f, http.StatusCreated)).Methods("POST")
+	m.HandleFunc("/account/identities/{provider}/{identity}", makeAppHandler(unlinkIdentity, f, http.StatusOK)).Methods("DELETE")
+	m.HandleFunc("/account/identityLinks", makeAppHandler(createIdentityLink, f, http.StatusCreated)).Methods("POST")
+	m.HandleFunc("/account/tokens", makeAppHandler(getAccessTokens, f, http.StatusOK)).Methods("GET")
+	m.HandleFunc("/account/tokens", makeAppHandler(createAccessToken, f, http.StatusCreated)).Methods("POST")
+	m.HandleFunc("/account/tokens/{tokenID}", makeAppHandler(revokeAccessToken, f, http.StatusOK)).Methods("DELETE")
+	m.HandleFunc("/account/takeout", makeAppHandler(importAccount, f, http.StatusOK)).Methods("POST")
+
+	m.HandleFunc("/orgs", makeAppHandler(getOrganizations, f, http.StatusOK)).Methods("GET")
+	m.HandleFunc("/orgs", makeAppHandler(createOrganization, f, http.StatusCreated)).Methods("POST")
+	m.HandleFunc("/orgs/{orgName}/members", makeAppHandler(getMembers, f, http.StatusOK)).Methods("GET")
+	m.HandleFunc("/orgs/{orgName}/members/{userName}", makeAppHandler(setMember, f, http.StatusOK)).Methods("PUT")
+	m.HandleFunc("/orgs/{orgName}/members/{userName}", makeAppHandler(removeMember, f, http.StatusOK)).Methods("DELETE")
+	m.HandleFunc("/orgs/{orgName}/pages", makeAppHandler(getOrganizationPages, f, http.StatusOK)).Methods("GET")
+
+	m.HandleFunc("/pages", makeAppHandler(getOwnedPages, f, http.StatusOK)).Methods("GET")
+	m.HandleFunc("/pages/public", makeAppHandler(getPublicPages, f, http.StatusOK)).Methods("GET")
+	m.HandleFunc("/pages/shared", makeAppHandler(getSharedPages, f, http.StatusOK)).Methods("GET")
+
+	m.HandleFunc("/search", makeAppHandler(search, f, http.StatusOK)).Methods("GET")
+	m.HandleFunc("/search/index", makeAppHandler(rebuildSearchIndex, f, http.StatusOK)).Methods("POST")
+
+	m.HandleFunc("/users/{userName}/trash", makeAppHandler(getTrash, f, http.StatusOK)).Methods("GET")
+	m.HandleFunc("/users/{userName}/trash/{entryID}/restore", makeAppHandler(restoreTrashEntry, f, http.StatusOK)).Methods("POST")
+	m.HandleFunc("/users/{userName}/trash/{entryID}", makeAppHandler(deleteTrashEntry, f, http.StatusOK)).Methods("DELETE")
+
 	m.HandleFunc("/users/{userName}/pages/{pageName}", makeAppHandler(getPage, f, http.StatusOK)).Methods("GET")
 
+	m.HandleFunc("/users/{userName}/pages/{pageName}/import", makeAppHandler(importPage, f, http.StatusOK)).Methods("POST")
+
+	m.HandleFunc("/users/{userName}/pages/{pageName}/links", makeAppHandler(getLinkReport, f, http.StatusOK)).Methods("GET")
+
+	m.HandleFunc("/users/{userName}/pages/{pageName}/permissions", makeAppHandler(getPermissions, f, http.StatusOK)).Methods("GET")
+	m.HandleFunc("/users/{userName}/pages/{pageName}/permissions/{grantee}", makeAppHandler(grantRole, f, http.StatusOK)).Methods("PUT")
+	m.HandleFunc("/users/{userName}/pages/{pageName}/permissions/{grantee}", makeAppHandler(revokeRole, f, http.StatusOK)).Methods("DELETE")
+
+	m.HandleFunc("/users/{userName}/pages/{pageName}/shareLinks", makeAppHandler(listShareLinks, f, http.StatusOK)).Methods("GET")
+	m.HandleFunc("/users/{userName}/pages/{pageName}/shareLinks", makeAppHandler(createShareLink, f, http.StatusCreated)).Methods("POST")
+	m.HandleFunc("/users/{userName}/pages/{pageName}/shareLinks/{tokenID}", makeAppHandler(revokeShareLink, f, http.StatusOK)).Methods("DELETE")
+
 	m.HandleFunc("/users/{userName}/pages/{pageName}/items", makeAppHandler(getItems, f, http.StatusOK)).Methods("GET")
 	m.HandleFunc("/users/{userName}/pages/{pageName}/items", makeAppHandler(createItem, f, http.StatusCreated)).Methods("POST")
 
 	m.HandleFunc("/users/{userName}/pages/{pageName}/items/{itemID}", makeAppHandler(editItem, f, http.StatusAccepted)).Methods("POST")
 	m.HandleFunc("/users/{userName}/pages/{pageName}/items/{itemID}", makeAppHandler(putItem, f, http.StatusOK)).Methods("PUT")
 	m.HandleFunc("/users/{userName}/pages/{pageName}/items/{itemID}", makeAppHandler(deleteItem, f, http.StatusOK)).Methods("DELETE")
+	m.HandleFunc("/users/{userName}/pages/{pageName}/items/{itemID}/move", makeAppHandler(moveItem, f, http.StatusOK)).Methods("POST")
+	m.HandleFunc("/users/{userName}/pages/{pageName}/items/{itemID}/snapshot", makeAppHandler(archiveItem, f, http.StatusOK)).Methods("POST")
+
+	m.HandleFunc("/users/{userName}/pages/{pageName}/items/{itemID}/revisions", makeAppHandler(getRevisions, f, http.StatusOK)).Methods("GET")
+	m.HandleFunc("/users/{userName}/pages/{pageName}/items/{itemID}/revisions/{number}", makeAppHandler(getRevision, f, http.StatusOK)).Methods("GET")
+	m.HandleFunc("/users/{userName}/pages/{pageName}/items/{itemID}/revisions/{number}/restore", makeAppHandler(restoreRevision, f, http.StatusOK)).Methods("POST")
+	m.HandleFunc("/users/{userName}/pages/{pageName}/items/{itemID}/diff", makeAppHandler(diffRevisions, f, http.StatusOK)).Methods("GET")
 
 	return nil
 }
@@ -114,7 +223,7 @@ func handleError(w http.ResponseWriter, r *http.Request, err error, app App) {
 	logger := app.logInteractor
 	logger.Errorf("%v", err)
 
-	if _, ok := err.(NotAuthorizedError); ok && app.userInteractor != nil {
+	if _, ok := err.(NotAuthorizedError); ok && app.userInteractor != nil && app.accessToken == nil {
 		if ident, execErr := app.userInteractor.CurrentIdentity(); execErr == nil && len(ident.Identity) == 0 {
 			loginURL, execErr := app.userInteractor.LoginURL(r.URL.String())
 			if execErr != nil {
@@ -126,6 +235,17 @@ func handleError(w http.ResponseWriter, r *http.Request, err error, app App) {
 			return
 		}
 	}
+
+	//Invalid data is reported in JSON, with the invalid fields or items
+	switch err.(type) {
+	case DataError, ImportError:
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		if execErr := json.NewEncoder(w).Encode(err); execErr != nil {
+			logger.Errorf("%v", execErr)
+		}
+		return
+	}
 	var execErr error
 
 	data := struct {
@@ -164,12 +284,37 @@ func makeAppHandler(fn func(*http.Request, App) (interface{}, error), f AppFacto
 			return
 		}
 
+		//Scripts authenticate with a personal access token
+		if secret, found := bearerToken(r); found {
+			app, err = app.WithAccessToken(secret)
+			if _, ok := err.(NotAuthorizedError); ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="okinotes", error="invalid_token"`)
+				http.Error(w, "Invalid or expired access token", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		//Unlisted pages are read through share links
+		if secret := r.URL.Query().Get("share"); len(secret) > 0 {
+			app = app.WithShareSecret(secret)
+		}
+
 		data, err := fn(r, app)
 		if err != nil {
 			handleError(w, r, err, app)
 			return
 		}
 
+		if p, ok := data.(pagedResult); ok {
+			if len(p.next) > 0 {
+				w.Header().Set("Link", "<"+p.next+`>; rel="next"`)
+			}
+			data = p.data
+		}
+
 		if data == nil {
 			w.WriteHeader(http.StatusNoContent)
 		} else {
@@ -184,6 +329,49 @@ func makeAppHandler(fn func(*http.Request, App) (interface{}, error), f AppFacto
 	}
 }
 
+//maxListingLimit is the maximum number of pages or items read at once by a listing
+const maxListingLimit = 1000
+
+//pagedResult is the response of a listing of the API read by successive calls.
+//The list is the body of the response, and the URL of the next results is given
+//in a Link header with the "next" relation.
+type pagedResult struct {
+	data interface{}
+	next string //URL of the next results, empty if there are no more
+}
+
+//parsePaging reads the limit and cursor fields of a listing read by successive calls
+func parsePaging(r *http.Request, defaultLimit int) (int, string, error) {
+	limit := defaultLimit
+	if s := r.FormValue("limit"); len(s) > 0 {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxListingLimit {
+			return 0, "", DataError{"limit", fmt.Sprintf("must be a number between 1 and %d", maxListingLimit)}
+		}
+		limit = n
+	}
+	return limit, r.FormValue("cursor"), nil
+}
+
+//nextURL returns the URL of the request reading the results after the given cursor,
+//or an empty string if there are no more results
+func nextURL(r *http.Request, cursor string) string {
+	return nextURLParam(r, "cursor", cursor)
+}
+
+//nextURLParam returns the URL of the request with the given cursor parameter,
+//or an empty string if there is no cursor
+func nextURLParam(r *http.Request, param string, cursor string) string {
+	if len(cursor) == 0 {
+		return ""
+	}
+	u := *r.URL
+	query := u.Query()
+	query.Set(param, cursor)
+	u.RawQuery = query.Encode()
+	return u.RequestURI()
+}
+
 func makeStaticPageHandler(page string, f AppFactory) http.HandlerFunc {
 
 	pageURL := fmt.Sprintf("/%s.html", page)
@@ -212,6 +400,10 @@ func makePageHandler(fn func(*http.Request, App) (handler, error), f AppFactory)
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		//Unlisted pages are read through share links
+		if secret := r.URL.Query().Get("share"); len(secret) > 0 {
+			app = app.WithShareSecret(secret)
+		}
 
 		c, err := fn(r, app)
 		if err != nil {
@@ -266,13 +458,21 @@ func (data *sharedData) init(nav string, loginRedirectURL string, logoutRedirect
 	return nil
 }
 
+//indexPagesLimit is the default number of owned pages listed on the home page
+const indexPagesLimit = 10
+
 func getIndexHTML(r *http.Request, app App) (handler, error) {
 	var err error
 
 	data := struct {
 		sharedData
-		MyPages     []Page
-		MoreMyPages bool
+		MyPages            []Page
+		MoreMyPages        bool
+		NextMyPagesURL     string
+		SharedPages        []Page
+		MoreSharedPages    bool
+		NextSharedPagesURL string
+		MyOrganizations    []Membership
 	}{}
 
 	err = data.init("home", "index.html", "index.html", app)
@@ -289,7 +489,26 @@ func getIndexHTML(r *http.Request, app App) (handler, error) {
 	}
 
 	if len(identity.Identity) > 0 {
-		data.MyPages, data.MoreMyPages, err = app.ListOwnedPages(10) //TODO: limit?
+		limit, cursor, err := parsePaging(r, indexPagesLimit)
+		if err != nil {
+			return nil, err
+		}
+		var next string
+		data.MyPages, next, err = app.ListOwnedPages(limit, cursor)
+		if err != nil {
+			return nil, err
+		}
+		data.MoreMyPages = len(next) > 0
+		data.NextMyPagesURL = nextURL(r, next)
+
+		//The shared pages are paged independently of the owned pages
+		data.SharedPages, next, err = app.ListSharedPages(indexPagesLimit, r.FormValue("sharedCursor"))
+		if err != nil {
+			return nil, err
+		}
+		data.MoreSharedPages = len(next) > 0
+		data.NextSharedPagesURL = nextURLParam(r, "sharedCursor", next)
+		data.MyOrganizations, err = app.Organizations()
 		if err != nil {
 			return nil, err
 		}
@@ -352,787 +571,1932 @@ func postFirstConnectionHTML(r *http.Request, app App) (handler, error) {
 	return redirectHandler{"/index.html"}, nil
 }
 
-type img struct {
-	ID           string
-	Name         string
-	URL128       string
-	URL          string
-	CreationTime time.Time
+type accountData struct {
+	sharedData
+	Identity     Identity
+	Identities   []Identity
+	AccessTokens []Token
+	Scopes       []Scope
+	NewToken     string         //Secret of the access token just created, shown only once
+	Takeout      *TakeoutReport //Set after the import of a takeout archive
 }
 
-func pageImages(r *http.Request, app App) (handler, error) {
+func newAccountData(app App) (accountData, error) {
 	var err error
 
-	data := struct {
-		sharedData
-		UploadURL string
-		Images    []img
-	}{}
+	data := accountData{Scopes: AllScopes}
 
-	err = data.init("media.images", "/user/images.html", "/user/images.html", app)
+	err = data.init("account", "/account.html", "/index.html", app)
 	if err != nil {
-		return nil, err
+		return accountData{}, err
 	}
 
-	data.UploadURL, err = app.UploadURL("/user/images.html")
+	data.Identity, err = app.CurrentIdentity()
 	if err != nil {
-		return nil, err
+		return accountData{}, err
 	}
-
-	imgs, err := app.Images(1000)
+	data.Identities, err = app.Identities()
 	if err != nil {
-		return nil, err
+		return accountData{}, err
 	}
-	for _, i := range imgs {
-		url128, err := app.ImageURL(i, false, 128)
-		if err != nil {
-			return nil, err
-		}
-		url, err := app.ImageURL(i, false, 0)
-		if err != nil {
-			return nil, err
-		}
-
-		data.Images = append(data.Images, img{
-			ID:           i.Key,
-			Name:         i.Filename,
-			URL128:       url128,
-			URL:          url,
-			CreationTime: i.CreationTime,
-		})
+	data.AccessTokens, err = app.AccessTokens()
+	if err != nil {
+		return accountData{}, err
 	}
 
-	return templateHandler{"images.html.tpl", data}, nil
+	return data, nil
 }
-func pageImagesPost(r *http.Request, app App) (handler, error) {
 
-	err := app.StoreImage(r, "file")
+func pageAccount(r *http.Request, app App) (handler, error) {
+	data, err := newAccountData(app)
 	if err != nil {
 		return nil, err
 	}
 
-	return redirectHandler{"/user/images.html"}, nil
+	return templateHandler{"account.html.tpl", data}, nil
 }
-func pageImageRename(r *http.Request, app App) (handler, error) {
-	imgID := r.FormValue("imgID")
-	newName := r.FormValue("imageName")
 
-	err := app.RenameImage(imgID, newName)
+//pageIdentityLinkStart logs out the current user, and asks to log in with the
+//identity to link, coming back to the confirmation page
+func pageIdentityLinkStart(r *http.Request, app App) (handler, error) {
+	secret, err := app.NewIdentityLink()
 	if err != nil {
 		return nil, err
 	}
 
-	return redirectHandler{"/user/images.html"}, nil
-}
-func pageImageDelete(r *http.Request, app App) (handler, error) {
-	imgID := r.FormValue("imgID")
-
-	err := app.DeleteImage(imgID)
+	loginURL, err := app.LoginURL("/account/identities/confirm.html?token=" + url.QueryEscape(secret))
 	if err != nil {
 		return nil, err
 	}
-
-	return redirectHandler{"/user/images.html"}, nil
-}
-
-func getImage(r *http.Request, app App) (handler, error) {
-	vars := mux.Vars(r)
-	imgID := vars["imgID"]
-
-	if imgID == "default" {
-		return redirectHandler{"/static/img/bg-pehoe.jpg"}, nil
-	}
-
-	url, err := app.uploadInteractor.ImageURL(imgID, false, 0)
+	logoutURL, err := app.LogoutURL(loginURL)
 	if err != nil {
 		return nil, err
 	}
 
-	return redirectHandler{url}, nil
+	return redirectHandler{logoutURL}, nil
 }
+func pageIdentityLinkGet(r *http.Request, app App) (handler, error) {
+	var err error
 
-func pageCreatePost(r *http.Request, app App) (handler, error) {
-	pageName := r.FormValue("pageName")
-	templateID := r.FormValue("template")
-	user, err := app.CurrentUser()
+	data := struct {
+		sharedData
+		Token    string
+		UserName string
+		Identity Identity
+	}{}
+
+	data.Token = r.FormValue("token")
+	err = data.init("account", "/account/identities/confirm.html?token="+url.QueryEscape(data.Token), "/index.html", app)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(user.Name) == 0 {
-		loginURL, err := app.LoginURL("/create.html?pageName=" + pageName + "&template=" + templateID)
-		if err != nil {
-			return nil, err
-		}
-
-		return redirectHandler{loginURL}, nil
+	data.Identity, err = app.CurrentIdentity()
+	if err != nil && err != ErrFirstUserConnection {
+		return nil, err
 	}
-
-	if _, err := app.GetTemplate(templateID); err != nil {
-		templateID = "blog2col"
+	if len(data.Identity.Identity) == 0 {
+		return nil, NotAuthorizedError{"Link identity"}
 	}
-
-	page := Page{
-		UserName:   user.Name,
-		Name:       pageName,
-		Title:      pageName,
-		TemplateID: templateID,
+	data.UserName, err = app.IdentityLinkUser(data.Token)
+	if err != nil {
+		return nil, err
 	}
 
-	err = app.CreatePage(page)
+	return templateHandler{"link_identity.html.tpl", data}, nil
+}
+func pageIdentityLinkPost(r *http.Request, app App) (handler, error) {
+	err := app.LinkIdentity(r.FormValue("token"))
 	if err != nil {
 		return nil, err
 	}
 
-	return redirectHandler{"/p/" + user.Name + "/" + pageName + ".html#administrate"}, nil
+	return redirectHandler{"/account.html"}, nil
 }
+func pageIdentityUnlink(r *http.Request, app App) (handler, error) {
+	ident := Ident{r.FormValue("provider"), r.FormValue("identity")}
 
-func pageAdminGet(r *http.Request, app App) (handler, error) {
-
-	userName := r.FormValue("userName")
-	pageName := r.FormValue("pageName")
+	err := app.UnlinkIdentity(ident)
+	if err != nil {
+		return nil, err
+	}
 
-	page, err := app.GetPage(userName, pageName)
+	return redirectHandler{"/account.html"}, nil
+}
+func pageAccessTokenCreate(r *http.Request, app App) (handler, error) {
+	name, scopes, expirationDate, err := parseAccessTokenForm(r)
 	if err != nil {
 		return nil, err
 	}
-	template, err := app.GetTemplate(page.TemplateID)
+	secret, _, err := app.CreateAccessToken(name, scopes, expirationDate)
 	if err != nil {
 		return nil, err
 	}
-	page.Tags.DefaultTo(template.PageTags)
 
-	data := struct {
-		sharedData
-		Page     Page
-		Template Template
-	}{}
-	err = data.init("", "/p/"+userName+"/"+pageName+".html", "index.html", app)
+	data, err := newAccountData(app)
 	if err != nil {
 		return nil, err
 	}
-	data.Page = page
-	data.Template = template
+	data.NewToken = secret
 
-	return templateHandler{"dlg_administrate.html.tpl", data}, nil
+	return templateHandler{"account.html.tpl", data}, nil
 }
-func pageAdminPost(r *http.Request, app App) (handler, error) {
-	userName := r.FormValue("userName")
-	pageName := r.FormValue("pageName")
+func pageAccessTokenRevoke(r *http.Request, app App) (handler, error) {
+	err := app.RevokeAccessToken(r.FormValue("id"))
+	if err != nil {
+		return nil, err
+	}
 
-	newTitle := r.FormValue("title")
-	newContentLicense := r.FormValue("contentLicense")
-	newPolicy := Policy(r.FormValue("policy"))
+	return redirectHandler{"/account.html"}, nil
+}
 
-	//Get tags
-	page, err := app.GetPage(userName, pageName)
+//pageTakeoutExport downloads the takeout archive of the current user
+func pageTakeoutExport(r *http.Request, app App) (handler, error) {
+	//The archive is sent while it is built
+	return streamHandler{app.ExportAccount, "application/zip", app.CurrentUserName() + "_takeout.zip"}, nil
+}
+func pageTakeoutImport(r *http.Request, app App) (handler, error) {
+	file, _, err := r.FormFile("file")
 	if err != nil {
-		errNotFound, notFound := err.(NotInDatastoreError)
-		if notFound && errNotFound.Type == "Page" {
-			return redirectHandler{"/create.html?userName=" + userName + "&pageName=" + pageName}, nil
-		}
 		return nil, err
 	}
-	template, err := app.GetTemplate(page.TemplateID)
+	archive, err := ioutil.ReadAll(io.LimitReader(file, maxTakeoutSize+1))
 	if err != nil {
 		return nil, err
 	}
 
-	newPage := Page{
-		UserName:       userName,
-		Name:           pageName,
-		Title:          newTitle,
-		ContentLicense: newContentLicense,
-		Policy:         newPolicy,
-		TemplateID:     page.TemplateID, //TODO: editable ?
-	}
-
-	for _, tag := range template.PageTags {
-		value := r.FormValue(fmt.Sprintf("tag-%s", tag.Key))
-		if len(value) > 0 && value != tag.DefaultValue {
-			newPage.Tags = append(newPage.Tags, Tag{tag.Key, value})
-		}
+	report, err := app.ImportAccount(archive)
+	if err != nil {
+		return nil, err
 	}
 
-	err = app.UpdatePage(newPage, template.PageTags)
+	data, err := newAccountData(app)
 	if err != nil {
 		return nil, err
 	}
+	data.Takeout = &report
 
-	return redirectHandler{"/p/" + userName + "/" + pageName + ".html"}, nil
+	return templateHandler{"account.html.tpl", data}, nil
 }
 
-func pageChangeTemplateGet(r *http.Request, app App) (handler, error) {
+func pageOrganizations(r *http.Request, app App) (handler, error) {
+	var err error
 
-	userName := r.FormValue("userName")
-	pageName := r.FormValue("pageName")
+	data := struct {
+		sharedData
+		Organizations []Membership
+	}{}
 
-	page, err := app.GetPage(userName, pageName)
+	err = data.init("orgs", "/orgs.html", "/index.html", app)
 	if err != nil {
 		return nil, err
 	}
-	templates, err := app.GetAllTemplates()
+
+	data.Organizations, err = app.Organizations()
 	if err != nil {
 		return nil, err
 	}
 
+	return templateHandler{"organizations.html.tpl", data}, nil
+}
+
+//searchResultsLimit is the default number of results of a search
+const searchResultsLimit = 50
+
+func pageSearch(r *http.Request, app App) (handler, error) {
+	var err error
+
 	data := struct {
 		sharedData
-		UserName     string
-		PageName     string
-		AllTemplates []struct {
-			Template
-			Selected bool
-		}
-		Selected int
+		Query   string
+		Results []SearchResult
 	}{}
-	err = data.init("", "/p/"+userName+"/"+pageName+".html", "index.html", app)
+
+	data.Query = r.FormValue("q")
+
+	err = data.init("search", "/search.html?q="+url.QueryEscape(data.Query), "/index.html", app)
 	if err != nil {
 		return nil, err
 	}
-	data.UserName = userName
-	data.PageName = pageName
 
-	for _, t := range templates {
-		data.AllTemplates = append(data.AllTemplates, struct {
-			Template
-			Selected bool
-		}{
-			t,
-			t.ID == page.TemplateID,
-		})
+	if len(strings.TrimSpace(data.Query)) > 0 {
+		data.Results, err = app.Search(data.Query, searchResultsLimit)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return templateHandler{"dlg_change_template.html.tpl", data}, nil
+	return templateHandler{"search.html.tpl", data}, nil
 }
-func pageChangeTemplatePost(r *http.Request, app App) (handler, error) {
-	userName := r.FormValue("userName")
-	pageName := r.FormValue("pageName")
 
-	newTemplateID := r.FormValue("templateID")
+//trashURL returns the URL of the trash of a user
+func trashURL(userName string) string {
+	return "/trash.html?userName=" + url.QueryEscape(userName)
+}
 
-	err := app.UpdateTemplate(userName, pageName, newTemplateID)
+func pageTrash(r *http.Request, app App) (handler, error) {
+	var err error
+
+	data := struct {
+		sharedData
+		UserName string //Owner of the trash: the current user or one of its organisations
+		Entries  []TrashEntry
+	}{}
+
+	data.UserName = r.FormValue("userName")
+	if len(data.UserName) == 0 {
+		data.UserName = app.CurrentUserName()
+	}
+
+	err = data.init("trash", trashURL(data.UserName), "/index.html", app)
 	if err != nil {
 		return nil, err
 	}
 
-	return redirectHandler{"/p/" + userName + "/" + pageName + ".html"}, nil
+	data.Entries, err = app.Trash(data.UserName)
+	if err != nil {
+		return nil, err
+	}
+
+	return templateHandler{"trash.html.tpl", data}, nil
+}
+func pageTrashRestore(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+
+	err := app.RestoreTrashEntry(userName, r.FormValue("entryID"))
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{trashURL(userName)}, nil
+}
+func pageTrashPurge(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+
+	err := app.PurgeTrashEntry(userName, r.FormValue("entryID"))
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{trashURL(userName)}, nil
+}
+func pageOrganizationCreate(r *http.Request, app App) (handler, error) {
+	orgName := r.FormValue("orgName")
+
+	err := app.CreateOrganization(orgName, r.FormValue("fullName"))
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{"/orgs/" + url.PathEscape(orgName) + ".html"}, nil
+}
+func pageOrganization(r *http.Request, app App) (handler, error) {
+	var err error
+	orgName := mux.Vars(r)["orgName"]
+
+	data := struct {
+		sharedData
+		Organization User
+		Members      []Membership
+		IsOrgAdmin   bool
+		Pages        []Page
+		MorePages    bool
+		NextPagesURL string
+	}{}
+
+	err = data.init("orgs", "/orgs/"+url.PathEscape(orgName)+".html", "/index.html", app)
+	if err != nil {
+		return nil, err
+	}
+
+	data.Organization, err = app.GetOrganization(orgName)
+	if err != nil {
+		return nil, err
+	}
+	data.Members, err = app.OrganizationMembers(orgName)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range data.Members {
+		if m.UserName == data.User.Name {
+			data.IsOrgAdmin = m.IsOrgAdmin
+		}
+	}
+	limit, cursor, err := parsePaging(r, pagesLimit)
+	if err != nil {
+		return nil, err
+	}
+	var next string
+	data.Pages, next, err = app.OrganizationPages(orgName, limit, cursor)
+	if err != nil {
+		return nil, err
+	}
+	data.MorePages = len(next) > 0
+	data.NextPagesURL = nextURL(r, next)
+
+	return templateHandler{"organization.html.tpl", data}, nil
+}
+func pageMemberSet(r *http.Request, app App) (handler, error) {
+	orgName := mux.Vars(r)["orgName"]
+
+	err := app.SetMember(orgName, r.FormValue("userName"), len(r.FormValue("isOrgAdmin")) > 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{"/orgs/" + url.PathEscape(orgName) + ".html"}, nil
+}
+func pageMemberRemove(r *http.Request, app App) (handler, error) {
+	orgName := mux.Vars(r)["orgName"]
+	userName := r.FormValue("userName")
+
+	err := app.RemoveMember(orgName, userName)
+	if err != nil {
+		return nil, err
+	}
+
+	//A member leaving the organization cannot see it anymore
+	if userName == app.CurrentUserName() {
+		return redirectHandler{"/orgs.html"}, nil
+	}
+	return redirectHandler{"/orgs/" + url.PathEscape(orgName) + ".html"}, nil
+}
+
+type img struct {
+	ID           string
+	Name         string
+	URL128       string
+	URL          string
+	CreationTime time.Time
+}
+
+func pageImages(r *http.Request, app App) (handler, error) {
+	var err error
+
+	data := struct {
+		sharedData
+		UploadURL string
+		Images    []img
+	}{}
+
+	err = data.init("media.images", "/user/images.html", "/user/images.html", app)
+	if err != nil {
+		return nil, err
+	}
+
+	data.UploadURL, err = app.UploadURL("/user/images.html")
+	if err != nil {
+		return nil, err
+	}
+
+	imgs, err := app.Images(1000)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range imgs {
+		url128, err := app.ImageURL(i, false, 128)
+		if err != nil {
+			return nil, err
+		}
+		url, err := app.ImageURL(i, false, 0)
+		if err != nil {
+			return nil, err
+		}
+
+		data.Images = append(data.Images, img{
+			ID:           i.Key,
+			Name:         i.Filename,
+			URL128:       url128,
+			URL:          url,
+			CreationTime: i.CreationTime,
+		})
+	}
+
+	return templateHandler{"images.html.tpl", data}, nil
+}
+func pageImagesPost(r *http.Request, app App) (handler, error) {
+
+	err := app.StoreImage(r, "file")
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{"/user/images.html"}, nil
+}
+func pageImageRename(r *http.Request, app App) (handler, error) {
+	imgID := r.FormValue("imgID")
+	newName := r.FormValue("imageName")
+
+	err := app.RenameImage(imgID, newName)
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{"/user/images.html"}, nil
+}
+func pageImageDelete(r *http.Request, app App) (handler, error) {
+	imgID := r.FormValue("imgID")
+
+	err := app.DeleteImage(imgID)
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{"/user/images.html"}, nil
+}
+
+func getImage(r *http.Request, app App) (handler, error) {
+	vars := mux.Vars(r)
+	imgID := vars["imgID"]
+
+	if imgID == "default" {
+		return redirectHandler{"/static/img/bg-pehoe.jpg"}, nil
+	}
+
+	url, err := app.uploadInteractor.ImageURL(imgID, false, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{url}, nil
+}
+
+func pageCreatePost(r *http.Request, app App) (handler, error) {
+	pageName := r.FormValue("pageName")
+	templateID := r.FormValue("template")
+	owner := r.FormValue("owner") //Organization owning the page, if any
+	user, err := app.CurrentUser()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(user.Name) == 0 {
+		loginURL, err := app.LoginURL("/create.html?pageName=" + pageName + "&template=" + templateID)
+		if err != nil {
+			return nil, err
+		}
+
+		return redirectHandler{loginURL}, nil
+	}
+
+	if _, err := app.GetTemplate(templateID); err != nil {
+		templateID = "blog2col"
+	}
+
+	if len(owner) == 0 {
+		owner = user.Name
+	}
+
+	page := Page{
+		UserName:   owner,
+		Name:       pageName,
+		Title:      pageName,
+		TemplateID: templateID,
+	}
+
+	err = app.CreatePage(page)
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{"/p/" + owner + "/" + pageName + ".html#administrate"}, nil
+}
+
+func pageAdminGet(r *http.Request, app App) (handler, error) {
+
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+
+	page, err := app.GetPage(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+	template, err := app.GetTemplate(page.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+	page.Tags.DefaultTo(template.PageTags)
+
+	data := struct {
+		sharedData
+		Page       Page
+		Template   Template
+		ShareLinks []Token
+	}{}
+	err = data.init("", "/p/"+userName+"/"+pageName+".html", "index.html", app)
+	if err != nil {
+		return nil, err
+	}
+	data.Page = page
+	data.Template = template
+	data.ShareLinks, err = app.ShareLinks(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+
+	return templateHandler{"dlg_administrate.html.tpl", data}, nil
+}
+func pageAdminPost(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+
+	newTitle := r.FormValue("title")
+	newContentLicense := r.FormValue("contentLicense")
+	newPolicy := Policy(r.FormValue("policy"))
+	newItemOrder := ItemOrder(r.FormValue("itemOrder"))
+
+	//Get tags
+	page, err := app.GetPage(userName, pageName)
+	if err != nil {
+		errNotFound, notFound := err.(NotInDatastoreError)
+		if notFound && errNotFound.Type == "Page" {
+			return redirectHandler{"/create.html?userName=" + userName + "&pageName=" + pageName}, nil
+		}
+		return nil, err
+	}
+	template, err := app.GetTemplate(page.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+
+	newPage := Page{
+		UserName:       userName,
+		Name:           pageName,
+		Title:          newTitle,
+		ContentLicense: newContentLicense,
+		Policy:         newPolicy,
+		TemplateID:     page.TemplateID, //TODO: editable ?
+		ItemOrder:      newItemOrder,
+	}
+	if len(newPage.ItemOrder) == 0 {
+		newPage.ItemOrder = page.ItemOrder
+	}
+
+	for _, tag := range template.PageTags {
+		value := r.FormValue(fmt.Sprintf("tag-%s", tag.Key))
+		if len(value) > 0 && value != tag.DefaultValue {
+			newPage.Tags = append(newPage.Tags, Tag{tag.Key, value})
+		}
+	}
+
+	err = app.UpdatePage(newPage, template.PageTags)
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{"/p/" + userName + "/" + pageName + ".html"}, nil
+}
+
+func pageChangeTemplateGet(r *http.Request, app App) (handler, error) {
+
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+
+	page, err := app.GetPage(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+	templates, err := app.GetAllTemplates()
+	if err != nil {
+		return nil, err
+	}
+
+	data := struct {
+		sharedData
+		UserName     string
+		PageName     string
+		AllTemplates []struct {
+			Template
+			Selected bool
+		}
+		Selected int
+	}{}
+	err = data.init("", "/p/"+userName+"/"+pageName+".html", "index.html", app)
+	if err != nil {
+		return nil, err
+	}
+	data.UserName = userName
+	data.PageName = pageName
+
+	for _, t := range templates {
+		data.AllTemplates = append(data.AllTemplates, struct {
+			Template
+			Selected bool
+		}{
+			t,
+			t.ID == page.TemplateID,
+		})
+	}
+
+	return templateHandler{"dlg_change_template.html.tpl", data}, nil
+}
+func pageChangeTemplatePost(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+
+	newTemplateID := r.FormValue("templateID")
+
+	err := app.UpdateTemplate(userName, pageName, newTemplateID)
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{"/p/" + userName + "/" + pageName + ".html"}, nil
+}
+
+type pageData struct {
+	sharedData
+	Page       Page
+	Template   Template
+	Offline    bool
+	Role       Role //Role of the current user on the page
+	CanEdit    bool
+	CanReorder bool //Items can be moved by drag and drop
+	Items      []Item
+	NextURL    string //URL of the next items of the page, empty if all are listed
+}
+
+func pagePage(r *http.Request, app App) (handler, error) {
+	vars := mux.Vars(r)
+	userName := vars["userName"]
+	pageName := vars["pageName"]
+
+	page, err := app.GetPage(userName, pageName)
+	if err != nil {
+
+		currentUserName := app.CurrentUserName()
+
+		errNotFound, notFound := err.(NotInDatastoreError)
+		if notFound && errNotFound.Type == "Page" && currentUserName == userName {
+			return redirectHandler{"/create.html?pageName=" + pageName}, nil
+		}
+		return nil, err
+	}
+
+	limit, cursor, err := parsePaging(r, itemsLimit)
+	if err != nil {
+		return nil, err
+	}
+	items, next, err := app.listItems(page, limit, cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if app.CurrentUserIsAdmin() {
+		debug := r.FormValue("debug")
+		if debug == "true" {
+			page.Tags.SetTag("template.debug", "true")
+		}
+	}
+
+	template, err := app.GetTemplate(page.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+	page.Tags.DefaultTo(template.PageTags)
+	for i := range items {
+		items[i].Tags.DefaultTo(template.ItemTags)
+	}
+
+	data := pageData{}
+
+	logoutDest := "index.html"
+	if page.Policy == PolicyPUBLIC {
+		logoutDest = "/p/" + userName + "/" + pageName + ".html"
+	}
+
+	err = data.init("", "/p/"+userName+"/"+pageName+".html", logoutDest, app)
+	if err != nil {
+		return nil, err
+	}
+	data.Page = page
+	data.Template = template
+	data.Role, err = app.PageRole(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+	data.CanEdit = data.Role.Allows(RoleEDITOR)
+	data.CanReorder = data.CanEdit && page.ItemOrder == ItemOrderMANUAL
+	data.Items = items
+	data.NextURL = nextURL(r, next)
+
+	return templateHandler{template.File, data}, nil
+}
+
+func offlinePage(r *http.Request, app App) (handler, error) {
+	vars := mux.Vars(r)
+	userName := vars["userName"]
+	pageName := vars["pageName"]
+
+	page, err := app.GetPage(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, cursor, err := parsePaging(r, itemsLimit)
+	if err != nil {
+		return nil, err
+	}
+	items, next, err := app.listItems(page, limit, cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	if app.CurrentUserIsAdmin() {
+		debug := r.FormValue("debug")
+		if debug == "true" {
+			page.Tags.SetTag("template.debug", "true")
+		}
+	}
+
+	template, err := app.GetTemplate(page.TemplateID)
+	if err != nil {
+		return nil, err
+	}
+	page.Tags.DefaultTo(template.PageTags)
+	for i := range items {
+		items[i].Tags.DefaultTo(template.ItemTags)
+	}
+
+	data := pageData{}
+
+	logoutDest := "index.html"
+	if page.Policy == PolicyPUBLIC {
+		logoutDest = "/p/" + userName + "/" + pageName + ".html"
+	}
+
+	err = data.init("", "/p/"+userName+"/"+pageName+".html", logoutDest, app)
+	if err != nil {
+		return nil, err
+	}
+	data.Offline = true
+	data.Page = page
+	data.Template = template
+	data.Role, err = app.PageRole(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+	data.CanEdit = data.Role.Allows(RoleEDITOR)
+	data.CanReorder = data.CanEdit && page.ItemOrder == ItemOrderMANUAL
+	data.Items = items
+	data.NextURL = nextURL(r, next)
+
+	return templateHandler{template.File, data}, nil
+}
+
+func cacheManifestPage(r *http.Request, app App) (handler, error) {
+	c, err := pagePage(r, app)
+	if err != nil {
+		return nil, err
+	}
+	tContent, ok := c.(templateHandler)
+	if !ok {
+		return nil, errors.New("Conversion failed")
+	}
+	data, ok := tContent.Data.(pageData)
+	if !ok {
+		return nil, errors.New("Conversion failed")
+	}
+
+	cacheTemplate := "p_cache.manifest.tpl"
+	return templateHandler{cacheTemplate, data}, nil
+}
+
+func pageFeed(r *http.Request, app App) (handler, error) {
+	vars := mux.Vars(r)
+
+	feed, err := app.PageFeed(vars["userName"], vars["pageName"])
+	if err != nil {
+		return nil, err
+	}
+
+	return feedHandler(r, feed)
+}
+
+func userFeed(r *http.Request, app App) (handler, error) {
+	vars := mux.Vars(r)
+
+	feed, err := app.UserFeed(vars["userName"])
+	if err != nil {
+		return nil, err
+	}
+
+	return feedHandler(r, feed)
+}
+
+func siteFeed(r *http.Request, app App) (handler, error) {
+	feed, err := app.SiteFeed()
+	if err != nil {
+		return nil, err
+	}
+
+	return feedHandler(r, feed)
+}
+
+//feedHandler writes a feed in the format given by the file name of the request
+func feedHandler(r *http.Request, feed Feed) (handler, error) {
+	var write func(base *url.URL) ([]byte, error)
+	var mimeType string
+
+	switch path.Base(r.URL.Path) {
+	case FeedFileATOM:
+		write, mimeType = feed.Atom, "application/atom+xml"
+	case FeedFileRSS:
+		write, mimeType = feed.RSS, "application/rss+xml"
+	case FeedFileJSON:
+		write, mimeType = feed.JSON, "application/feed+json"
+	default:
+		return nil, NotInDatastoreError{"Feed", r.URL.Path}
+	}
+
+	base := requestBaseURL(r)
+	marshal := func(v interface{}) ([]byte, error) {
+		return write(base)
+	}
+	return marshalHandler{marshal, nil, mimeType, ""}, nil
+}
+
+//requestBaseURL returns the URL of the site, as reached by the request
+func requestBaseURL(r *http.Request) *url.URL {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return &url.URL{Scheme: scheme, Host: r.Host, Path: "/"}
+}
+
+type pageJSONData struct {
+	Page  Page
+	Items []Item
+}
+
+func jsonPage(r *http.Request, app App) (handler, error) {
+
+	c, err := pagePage(r, app)
+	if err != nil {
+		return nil, err
+	}
+	tContent, ok := c.(templateHandler)
+	if !ok {
+		return nil, errors.New("Conversion failed")
+	}
+	data, ok := tContent.Data.(pageData)
+	if !ok {
+		return nil, errors.New("Conversion failed")
+	}
+
+	d := pageJSONData{data.Page, data.Items}
+
+	return marshalHandler{json.Marshal, d, "application/json", data.User.Name + "_" + data.Page.Name + ".json"}, nil
+}
+
+func markdownPage(r *http.Request, app App) (handler, error) {
+	vars := mux.Vars(r)
+
+	page, items, err := app.ExportPage(vars["userName"], vars["pageName"])
+	if err != nil {
+		return nil, err
+	}
+
+	markdown := func(v interface{}) ([]byte, error) {
+		return []byte(page.Markdown(items)), nil
+	}
+
+	return marshalHandler{markdown, nil, "text/markdown; charset=utf-8", page.UserName + "_" + page.Name + ".md"}, nil
+}
+
+func pageSnapshot(r *http.Request, app App) (handler, error) {
+	vars := mux.Vars(r)
+
+	content, err := app.Snapshot(vars["userName"], vars["pageName"], vars["itemID"])
+	if err != nil {
+		return nil, err
+	}
+
+	return snapshotHandler{content}, nil
+}
+
+//pageArchiveItem takes a new snapshot of the page linked by an item, and shows it
+func pageArchiveItem(r *http.Request, app App) (handler, error) {
+	vars := mux.Vars(r)
+
+	_, err := app.ArchiveItem(vars["userName"], vars["pageName"], vars["itemID"])
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{r.URL.Path}, nil
+}
+
+//pageLinkReport lists the broken links of a page to its owners
+func pageLinkReport(r *http.Request, app App) (handler, error) {
+	vars := mux.Vars(r)
+	userName := vars["userName"]
+	pageName := vars["pageName"]
+
+	data := struct {
+		sharedData
+		Report LinkReport
+	}{}
+
+	err := data.init("", "/p/"+userName+"/"+pageName+"/links.html", "/index.html", app)
+	if err != nil {
+		return nil, err
+	}
+
+	data.Report, err = app.LinkReport(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+
+	return templateHandler{"links.html.tpl", data}, nil
+}
+
+func pageImportPageGet(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+
+	return templateHandler{"dlg_importPage.html.tpl", importPageData{UserName: userName, PageName: pageName}}, nil
+}
+
+type importPageData struct {
+	UserName string
+	PageName string
+	Report   *ImportReport //Set after a dry run, or when some items are invalid
+}
+
+//pageImportPagePost imports the uploaded file, in one of the import formats. A dry run shows
+//the changes the import would make in the import dialog.
+func pageImportPagePost(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+	dryRun := len(r.FormValue("dryRun")) > 0
+
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxImportSize+1))
+	if err != nil {
+		return nil, err
+	}
+	page, items, err := ParseImport(r.FormValue("format"), data)
+	if err != nil {
+		return nil, err
+	}
+
+	report, err := app.ImportPage(userName, pageName, page, items, dryRun)
+	if _, invalid := err.(ImportError); invalid {
+		//Show the invalid items in the import dialog
+		return templateHandler{"dlg_importPage.html.tpl", importPageData{userName, pageName, &report}}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if dryRun {
+		return templateHandler{"dlg_importPage.html.tpl", importPageData{userName, pageName, &report}}, nil
+	}
+	return redirectHandler{"/p/" + userName + "/" + pageName + ".html"}, nil
+}
+
+type deleteData struct {
+	Page Page
+}
+
+func pageDeleteGet(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+
+	page, err := app.GetPage(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+
+	data := deleteData{}
+	data.Page = page
+
+	return templateHandler{"dlg_delete.html.tpl", data}, nil
+}
+func pageDeletePost(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+
+	err := app.DeletePage(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{"/index.html"}, nil
+}
+
+func pageShareGet(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+
+	page, err := app.GetPage(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+	permissions, err := app.PagePermissions(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+
+	data := struct {
+		Page        Page
+		Permissions []Permission
+		Roles       []Role
+	}{page, permissions, []Role{RoleVIEWER, RoleEDITOR, RoleOWNER}}
+
+	return templateHandler{"dlg_share.html.tpl", data}, nil
+}
+func pageSharePost(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+
+	role, err := ParseRole(r.FormValue("role"))
+	if err != nil {
+		return nil, err
+	}
+	err = app.GrantRole(userName, pageName, r.FormValue("grantee"), role)
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{"/p/" + userName + "/" + pageName + ".html"}, nil
+}
+func pageShareRevoke(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+
+	err := app.RevokeRole(userName, pageName, r.FormValue("grantee"))
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{"/p/" + userName + "/" + pageName + ".html"}, nil
+}
+
+//shareURL returns the URL of a page read through a share link
+func shareURL(userName string, pageName string, secret string) string {
+	return "/p/" + userName + "/" + pageName + ".html?share=" + url.QueryEscape(secret)
+}
+
+func pageShareLinkCreate(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+
+	expirationDate, err := parseExpiration(r)
+	if err != nil {
+		return nil, err
+	}
+	secret, token, err := app.CreateShareLink(userName, pageName, expirationDate, len(r.FormValue("rotate")) > 0)
+	if err != nil {
+		return nil, err
+	}
+	page, err := app.GetPage(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+
+	data := struct {
+		Page  Page
+		Token Token
+		URL   string
+	}{page, token, shareURL(userName, pageName, secret)}
+
+	return templateHandler{"dlg_share_link.html.tpl", data}, nil
+}
+func pageShareLinkRevoke(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+
+	err := app.RevokeShareLink(userName, pageName, r.FormValue("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{"/p/" + userName + "/" + pageName + ".html"}, nil
+}
+
+type newItemData struct {
+	Page Page
+}
+
+func pageNewItemGet(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+
+	page, err := app.GetPage(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+
+	data := newItemData{}
+	data.Page = page
+
+	return templateHandler{"dlg_newItem.html.tpl", data}, nil
+}
+
+type editItemData struct {
+	Page Page
+	Item Item
+}
+
+func pageEditItemGet(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+	itemID := r.FormValue("itemID")
+
+	page, err := app.GetPage(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+	item, err := app.getItem(userName, pageName, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := editItemData{}
+	data.Page = page
+	data.Item = item
+
+	return templateHandler{"dlg_editItem.html.tpl", data}, nil
+}
+
+func pageAddItem(r *http.Request, app App) (handler, error) {
+	vars := mux.Vars(r)
+	userName := vars["userName"]
+	pageName := vars["pageName"]
+
+	item := Item{
+		Kind:    r.FormValue("kind"),
+		Title:   r.FormValue("title"),
+		Content: r.FormValue("content"),
+		Source:  r.FormValue("source"),
+		URL:     r.FormValue("URL"),
+	}
+
+	_, err := app.CreateItem(userName, pageName, item)
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{"/p/" + userName + "/" + pageName + ".html"}, nil
+}
+
+type deleteItemData struct {
+	Page Page
+	Item Item
+}
+
+func pageDeleteItemGet(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+	itemID := r.FormValue("itemID")
+
+	page, err := app.GetPage(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := app.getItem(userName, pageName, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := deleteItemData{}
+	data.Page = page
+	data.Item = item
+
+	return templateHandler{"dlg_deleteItem.html.tpl", data}, nil
+}
+func pageDeleteItemPost(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+	itemID := r.FormValue("itemID")
+
+	err := app.DeleteItem(userName, pageName, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{"/p/" + userName + "/" + pageName + ".html"}, nil
+}
+
+type itemHistoryData struct {
+	Page      Page
+	Item      Item
+	Revisions []Revision
+	From      int
+	To        int
+	Diff      []DiffLine
+	CanEdit   bool
+}
+
+func pageItemHistoryGet(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+	itemID := r.FormValue("itemID")
+
+	page, err := app.GetPage(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+	item, err := app.getItem(userName, pageName, itemID)
+	if err != nil {
+		return nil, err
+	}
+	revisions, err := app.ItemRevisions(userName, pageName, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	data := itemHistoryData{}
+	data.Page = page
+	data.Item = item
+	data.Revisions = revisions
+
+	role, err := app.PageRole(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+	data.CanEdit = role.Allows(RoleEDITOR)
+
+	//By default, show the changes of the last revision
+	if len(revisions) > 0 {
+		data.To = revisions[len(revisions)-1].Number
+		data.From = data.To - 1
+	}
+	if len(r.FormValue("from")) > 0 || len(r.FormValue("to")) > 0 {
+		data.From, data.To, err = parseRevisionRange(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if data.From > 0 {
+		data.Diff, err = app.DiffRevisions(userName, pageName, itemID, data.From, data.To)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return templateHandler{"dlg_itemHistory.html.tpl", data}, nil
+}
+func pageRestoreItemPost(r *http.Request, app App) (handler, error) {
+	userName := r.FormValue("userName")
+	pageName := r.FormValue("pageName")
+	itemID := r.FormValue("itemID")
+
+	number, err := parseRevisionNumber(r.FormValue("revision"))
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = app.RestoreRevision(userName, pageName, itemID, number)
+	if err != nil {
+		return nil, err
+	}
+
+	return redirectHandler{"/p/" + userName + "/" + pageName + ".html"}, nil
+}
+
+//parseRevisionNumber reads the number of a revision
+func parseRevisionNumber(s string) (int, error) {
+	n, err := strconv.Atoi(s)
+	if err != nil || n < 1 {
+		return 0, DataError{"revision", "must be a revision number"}
+	}
+	return n, nil
+}
+
+//parseRevisionRange reads the revisions to compare in the from and to fields
+func parseRevisionRange(r *http.Request) (int, int, error) {
+	from, err := parseRevisionNumber(r.FormValue("from"))
+	if err != nil {
+		return 0, 0, err
+	}
+	to, err := parseRevisionNumber(r.FormValue("to"))
+	if err != nil {
+		return 0, 0, err
+	}
+	return from, to, nil
 }
+func pageAdminTemplates(r *http.Request, app App) (handler, error) {
+
+	tNow := time.Now()
+
+	err := app.StoreTemplate(Template{
+		ID: "blog2col",
+
+		Name: "Micro-blog",
+		File: "p_blog2col.html.tpl",
+
+		CreationDate:         tNow,
+		LastModificationDate: tNow,
+
+		PageTags: TagDescriptionList{
+			TagDescription{"background", "Background image", "imageId", "The location of the image to be used as a background for the page.", "default"},
+			TagDescription{"title.color", "Title color", "color", "The color to be used for the page title.", "#000000"},
+		},
+
+		ItemTags: TagDescriptionList{},
+	})
+	if err != nil {
+		return nil, err
+	}
 
-type pageData struct {
-	sharedData
-	Page     Page
-	Template Template
-	Offline  bool
-	CanEdit  bool
-	Items    []Item
-}
+	err = app.StoreTemplate(Template{
+		ID: "urllist",
 
-func pagePage(r *http.Request, app App) (handler, error) {
-	vars := mux.Vars(r)
-	userName := vars["userName"]
-	pageName := vars["pageName"]
+		Name: "URL list",
+		File: "p_urllist.html.tpl",
 
-	page, err := app.GetPage(userName, pageName)
+		CreationDate:         tNow,
+		LastModificationDate: tNow,
+
+		PageTags: TagDescriptionList{
+			TagDescription{"background", "Background image", "imageId", "The location of the image to be used as a background for the page.", "default"},
+			TagDescription{"title.color", "Title color", "color", "The color to be used for the page title.", "#000000"},
+		},
+
+		ItemTags: TagDescriptionList{},
+	})
 	if err != nil {
+		return nil, err
+	}
 
-		currentUserName := app.CurrentUserName()
+	err = app.StoreTemplate(Template{
+		ID: "angular",
 
-		errNotFound, notFound := err.(NotInDatastoreError)
-		if notFound && errNotFound.Type == "Page" && currentUserName == userName {
-			return redirectHandler{"/create.html?pageName=" + pageName}, nil
-		}
+		Name: "Micro-blog with offline mode",
+		File: "p_angular.html.tpl",
+
+		CreationDate:         tNow,
+		LastModificationDate: tNow,
+
+		PageTags: TagDescriptionList{
+			TagDescription{"background", "Background image", "imageId", "The location of the image to be used as a background for the page.", "default"},
+			TagDescription{"title.color", "Title color", "color", "The color to be used for the page title.", "#000000"},
+		},
+
+		ItemTags: TagDescriptionList{},
+	})
+	if err != nil {
 		return nil, err
 	}
 
-	items, err := app.listItems(page.UserName, page.Name, 1000)
+	err = app.StoreTemplate(Template{
+		ID: "todolist",
+
+		Name: "TODO list",
+		File: "p_todolist.html.tpl",
+
+		CreationDate:         tNow,
+		LastModificationDate: tNow,
+
+		PageTags: TagDescriptionList{
+			TagDescription{"background", "Background image", "imageId", "The location of the image to be used as a background for the page.", "default"},
+			TagDescription{"title.color", "Title color", "color", "The color to be used for the page title.", "#000000"},
+		},
+
+		ItemTags: TagDescriptionList{
+			TagDescription{"status", "Status", "_status", "The status of the item (new, done, archived, ...).", "new"},
+			TagDescription{"deadline", "Deadline", "datetime", "The deadline for the item", ""},
+		},
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	if app.CurrentUserIsAdmin() {
-		debug := r.FormValue("debug")
-		if debug == "true" {
-			page.Tags.SetTag("template.debug", "true")
-		}
+	return redirectHandler{"/index.html"}, nil
+}
+
+func getVersion(r *http.Request, app App) (interface{}, error) {
+	type data struct {
+		Version string `json:"version"`
 	}
+	return data{app.Version()}, nil
+}
 
-	template, err := app.GetTemplate(page.TemplateID)
+func getCurrentUser(r *http.Request, app App) (interface{}, error) {
+	return app.CurrentUser()
+}
+
+func getIdentities(r *http.Request, app App) (interface{}, error) {
+	identities, err := app.Identities()
 	if err != nil {
 		return nil, err
 	}
-	page.Tags.DefaultTo(template.PageTags)
-	for i := range items {
-		items[i].Tags.DefaultTo(template.ItemTags)
-	}
 
-	data := pageData{}
+	if identities == nil {
+		identities = []Identity{}
+	}
 
-	logoutDest := "index.html"
-	if page.Policy == PolicyPUBLIC {
-		logoutDest = "/p/" + userName + "/" + pageName + ".html"
+	return identities, nil
+}
+func createIdentityLink(r *http.Request, app App) (interface{}, error) {
+	type data struct {
+		Token string `json:"token"`
 	}
 
-	err = data.init("", "/p/"+userName+"/"+pageName+".html", logoutDest, app)
+	secret, err := app.NewIdentityLink()
 	if err != nil {
 		return nil, err
 	}
-	data.Page = page
-	data.Template = template
-	data.CanEdit = (app.CurrentUserName() == userName)
-	data.Items = items
 
-	return templateHandler{template.File, data}, nil
+	return data{secret}, nil
 }
+func linkIdentity(r *http.Request, app App) (interface{}, error) {
+	err := app.LinkIdentity(r.FormValue("token"))
+	if err != nil {
+		return nil, err
+	}
 
-func offlinePage(r *http.Request, app App) (handler, error) {
+	identity, err := app.CurrentIdentity()
+	if err != nil {
+		return nil, err
+	}
+
+	return identity, nil
+}
+func unlinkIdentity(r *http.Request, app App) (interface{}, error) {
 	vars := mux.Vars(r)
-	userName := vars["userName"]
-	pageName := vars["pageName"]
 
-	page, err := app.GetPage(userName, pageName)
+	err := app.UnlinkIdentity(Ident{vars["provider"], vars["identity"]})
 	if err != nil {
 		return nil, err
 	}
 
-	items, err := app.listItems(page.UserName, page.Name, 1000)
+	return nil, nil
+}
+
+//bearerToken returns the secret sent in the Authorization header of the request
+func bearerToken(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if len(auth) < len("Bearer ") || !strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(auth[len("Bearer "):]), true
+}
+
+//parseAccessTokenForm reads the name, the scopes and the validity in days of a new access token
+func parseAccessTokenForm(r *http.Request) (string, []Scope, time.Time, error) {
+	if err := r.ParseForm(); err != nil {
+		return "", nil, time.Time{}, err
+	}
+
+	var scopes []Scope
+	for _, s := range r.Form["scope"] {
+		scope, err := ParseScope(s)
+		if err != nil {
+			return "", nil, time.Time{}, err
+		}
+		scopes = append(scopes, scope)
+	}
+
+	expirationDate, err := parseExpiration(r)
+	if err != nil {
+		return "", nil, time.Time{}, err
+	}
+
+	return r.FormValue("name"), scopes, expirationDate, nil
+}
+
+//parseExpiration reads the validity in days of a token. Returns a zero time for tokens that never expire.
+func parseExpiration(r *http.Request) (time.Time, error) {
+	days := r.FormValue("expiration")
+	if len(days) == 0 || days == "0" {
+		return time.Time{}, nil
+	}
+
+	n, err := strconv.Atoi(days)
+	if err != nil || n < 0 {
+		return time.Time{}, DataError{"expiration", "must be a number of days"}
+	}
+	return time.Now().AddDate(0, 0, n), nil
+}
+
+type accessTokenData struct {
+	ID             string     `json:"id"`
+	Name           string     `json:"name"`
+	Scopes         []Scope    `json:"scopes"`
+	CreationDate   time.Time  `json:"creationDate"`
+	ExpirationDate *time.Time `json:"expirationDate,omitempty"`
+	LastUseDate    *time.Time `json:"lastUseDate,omitempty"`
+	Token          string     `json:"token,omitempty"` //Only when created
+}
+
+func newAccessTokenData(token Token) accessTokenData {
+	data := accessTokenData{
+		ID:           token.ID,
+		Name:         token.Name,
+		Scopes:       token.Scopes,
+		CreationDate: token.CreationDate,
+	}
+	if !token.ExpirationDate.IsZero() {
+		data.ExpirationDate = &token.ExpirationDate
+	}
+	if !token.LastUseDate.IsZero() {
+		data.LastUseDate = &token.LastUseDate
+	}
+	return data
+}
+
+func getAccessTokens(r *http.Request, app App) (interface{}, error) {
+	tokens, err := app.AccessTokens()
 	if err != nil {
 		return nil, err
 	}
 
-	if app.CurrentUserIsAdmin() {
-		debug := r.FormValue("debug")
-		if debug == "true" {
-			page.Tags.SetTag("template.debug", "true")
-		}
+	data := []accessTokenData{}
+	for _, token := range tokens {
+		data = append(data, newAccessTokenData(token))
 	}
 
-	template, err := app.GetTemplate(page.TemplateID)
+	return data, nil
+}
+func createAccessToken(r *http.Request, app App) (interface{}, error) {
+	name, scopes, expirationDate, err := parseAccessTokenForm(r)
 	if err != nil {
 		return nil, err
 	}
-	page.Tags.DefaultTo(template.PageTags)
-	for i := range items {
-		items[i].Tags.DefaultTo(template.ItemTags)
+	secret, token, err := app.CreateAccessToken(name, scopes, expirationDate)
+	if err != nil {
+		return nil, err
 	}
 
-	data := pageData{}
+	data := newAccessTokenData(token)
+	data.Token = secret
+	return data, nil
+}
+func revokeAccessToken(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
 
-	logoutDest := "index.html"
-	if page.Policy == PolicyPUBLIC {
-		logoutDest = "/p/" + userName + "/" + pageName + ".html"
+	err := app.RevokeAccessToken(vars["tokenID"])
+	if err != nil {
+		return nil, err
 	}
 
-	err = data.init("", "/p/"+userName+"/"+pageName+".html", logoutDest, app)
+	return nil, nil
+}
+
+//importAccount restores the takeout archive in the body of the request in the account of the current user
+func importAccount(r *http.Request, app App) (interface{}, error) {
+	archive, err := ioutil.ReadAll(io.LimitReader(r.Body, maxTakeoutSize+1))
 	if err != nil {
 		return nil, err
 	}
-	data.Offline = true
-	data.Page = page
-	data.Template = template
-	data.CanEdit = (app.CurrentUserName() == userName)
-	data.Items = items
 
-	return templateHandler{template.File, data}, nil
+	return app.ImportAccount(archive)
 }
 
-func cacheManifestPage(r *http.Request, app App) (handler, error) {
-	c, err := pagePage(r, app)
+func getOrganizations(r *http.Request, app App) (interface{}, error) {
+	memberships, err := app.Organizations()
 	if err != nil {
 		return nil, err
 	}
-	tContent, ok := c.(templateHandler)
-	if !ok {
-		return nil, errors.New("Conversion failed")
-	}
-	data, ok := tContent.Data.(pageData)
-	if !ok {
-		return nil, errors.New("Conversion failed")
+
+	if memberships == nil {
+		memberships = []Membership{}
 	}
 
-	cacheTemplate := "p_cache.manifest.tpl"
-	return templateHandler{cacheTemplate, data}, nil
+	return memberships, nil
 }
+func createOrganization(r *http.Request, app App) (interface{}, error) {
+	orgName := r.FormValue("orgName")
 
-func xmlPage(r *http.Request, app App) (handler, error) {
-	c, err := pagePage(r, app)
+	err := app.CreateOrganization(orgName, r.FormValue("fullName"))
 	if err != nil {
 		return nil, err
 	}
-	tContent, ok := c.(templateHandler)
-	if !ok {
-		return nil, errors.New("Conversion failed")
+
+	return app.GetOrganization(orgName)
+}
+func getMembers(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
+
+	members, err := app.OrganizationMembers(vars["orgName"])
+	if err != nil {
+		return nil, err
 	}
-	data, ok := tContent.Data.(pageData)
-	if !ok {
-		return nil, errors.New("Conversion failed")
+
+	return members, nil
+}
+func getOrganizationPages(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
+
+	limit, cursor, err := parsePaging(r, pagesLimit)
+	if err != nil {
+		return nil, err
 	}
 
-	atomXML := atom.Feed{
-		Title: data.Page.Title,
-		ID:    "okinotes:page:" + data.Page.UserName + "/" + data.Page.Name,
-		//Link: ,
-		Updated: atom.Time(data.Page.LastModificationDate),
-		Author: &atom.Person{
-			Name: data.Page.UserName,
-		},
+	pages, next, err := app.OrganizationPages(vars["orgName"], limit, cursor)
+	if err != nil {
+		return nil, err
 	}
 
-	for _, item := range data.Items {
-		entry := atom.Entry{
-			Title: item.Title,
-			ID:    "okinotes:item:" + item.ID,
-			//Link
-			Published: atom.Time(item.CreationDate),
-			Updated:   atom.Time(item.LastModificationDate),
-			//Author
-			//Summary
-			Content: &atom.Text{
-				Type: "html",
-				Body: string(item.HTMLContent),
-			},
-		}
+	if pages == nil {
+		pages = []Page{}
+	}
 
-		if len(item.URL) > 0 {
-			entry.Link = append(entry.Link, atom.Link{
-				Rel:  "related",
-				Href: item.URL,
-			})
-		}
-		if len(item.Source) > 0 {
-			entry.Author = &atom.Person{
-				Name: item.Source,
-			}
-		}
+	return pagedResult{pages, nextURL(r, next)}, nil
+}
+func setMember(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
 
-		atomXML.Entry = append(atomXML.Entry, &entry)
+	isOrgAdmin, _ := strconv.ParseBool(r.FormValue("isOrgAdmin"))
+	err := app.SetMember(vars["orgName"], vars["userName"], isOrgAdmin)
+	if err != nil {
+		return nil, err
 	}
 
-	return marshalHandler{xml.Marshal, atomXML, "application/atom+xml", ""}, nil
+	return Membership{UserName: vars["userName"], OrgName: vars["orgName"], IsOrgAdmin: isOrgAdmin}, nil
 }
+func removeMember(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
 
-type pageJSONData struct {
-	Page  Page
-	Items []Item
+	err := app.RemoveMember(vars["orgName"], vars["userName"])
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
 }
 
-func jsonPage(r *http.Request, app App) (handler, error) {
+func search(r *http.Request, app App) (interface{}, error) {
+	limit := searchResultsLimit
+	if s := r.FormValue("limit"); len(s) > 0 {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 {
+			return nil, DataError{"limit", "must be a positive number"}
+		}
+		limit = n
+	}
 
-	c, err := pagePage(r, app)
+	results, err := app.Search(r.FormValue("q"), limit)
 	if err != nil {
 		return nil, err
 	}
-	tContent, ok := c.(templateHandler)
-	if !ok {
-		return nil, errors.New("Conversion failed")
-	}
-	data, ok := tContent.Data.(pageData)
-	if !ok {
-		return nil, errors.New("Conversion failed")
-	}
 
-	d := pageJSONData{data.Page, data.Items}
+	if results == nil {
+		results = []SearchResult{}
+	}
 
-	return marshalHandler{json.Marshal, d, "application/json", data.User.Name + "_" + data.Page.Name + ".json"}, nil
+	return results, nil
 }
+func rebuildSearchIndex(r *http.Request, app App) (interface{}, error) {
+	type data struct {
+		Pages int `json:"pages"`
+	}
 
-func pageImportPageGet(r *http.Request, app App) (handler, error) {
-	userName := r.FormValue("userName")
-	pageName := r.FormValue("pageName")
+	n, err := app.RebuildSearchIndex()
+	if err != nil {
+		return nil, err
+	}
 
-	return templateHandler{"dlg_importPage.html.tpl", struct {
-		UserName string
-		PageName string
-	}{userName, pageName}}, nil
+	return data{n}, nil
 }
-func pageImportPagePost(r *http.Request, app App) (handler, error) {
-	userName := r.FormValue("userName")
-	pageName := r.FormValue("pageName")
 
-	file, _, err := r.FormFile("file")
+func getTrash(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
+
+	entries, err := app.Trash(vars["userName"])
 	if err != nil {
 		return nil, err
 	}
 
-	uploadedContent := pageJSONData{}
-	decoder := json.NewDecoder(file)
-	if err := decoder.Decode(&uploadedContent); err != nil {
-		return nil, err
+	if entries == nil {
+		entries = []TrashEntry{}
 	}
 
-	template, err := app.GetTemplate(uploadedContent.Page.TemplateID)
+	return entries, nil
+}
+func restoreTrashEntry(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
+
+	err := app.RestoreTrashEntry(vars["userName"], vars["entryID"])
 	if err != nil {
 		return nil, err
 	}
 
-	//New page is in uploadedContent. Save page
-	uploadedContent.Page.UserName = userName
-	uploadedContent.Page.Name = pageName
+	return nil, nil
+}
+func deleteTrashEntry(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
 
-	err = app.UpdatePage(uploadedContent.Page, template.PageTags)
+	err := app.PurgeTrashEntry(vars["userName"], vars["entryID"])
 	if err != nil {
 		return nil, err
 	}
 
-	//Save items
-	for _, item := range uploadedContent.Items {
-		_, err = app.UpdateItem(userName, pageName, item, true)
-		if err != nil {
-			if _, err = app.CreateItem(userName, pageName, item); err != nil {
-				return nil, err
-			}
-		}
-	}
-
-	return redirectHandler{"/p/" + userName + "/" + pageName + ".html"}, nil
+	return nil, nil
 }
 
-type deleteData struct {
-	Page Page
-}
+//pagesLimit is the default number of pages returned by a listing
+const pagesLimit = 100
 
-func pageDeleteGet(r *http.Request, app App) (handler, error) {
-	userName := r.FormValue("userName")
-	pageName := r.FormValue("pageName")
+//itemsLimit is the default number of items returned by a listing
+const itemsLimit = maxListingLimit
 
-	page, err := app.GetPage(userName, pageName)
+func getOwnedPages(r *http.Request, app App) (interface{}, error) {
+	limit, cursor, err := parsePaging(r, pagesLimit)
 	if err != nil {
 		return nil, err
 	}
 
-	data := deleteData{}
-	data.Page = page
-
-	return templateHandler{"dlg_delete.html.tpl", data}, nil
-}
-func pageDeletePost(r *http.Request, app App) (handler, error) {
-	userName := r.FormValue("userName")
-	pageName := r.FormValue("pageName")
-
-	err := app.DeletePage(userName, pageName)
+	pages, next, err := app.ListOwnedPages(limit, cursor)
 	if err != nil {
 		return nil, err
 	}
 
-	return redirectHandler{"/index.html"}, nil
-}
+	if pages == nil {
+		pages = []Page{}
+	}
 
-type newItemData struct {
-	Page Page
+	return pagedResult{pages, nextURL(r, next)}, nil
 }
-
-func pageNewItemGet(r *http.Request, app App) (handler, error) {
-	userName := r.FormValue("userName")
-	pageName := r.FormValue("pageName")
-
-	page, err := app.GetPage(userName, pageName)
+func getPublicPages(r *http.Request, app App) (interface{}, error) {
+	limit, cursor, err := parsePaging(r, pagesLimit)
 	if err != nil {
 		return nil, err
 	}
 
-	data := newItemData{}
-	data.Page = page
+	pages, next, err := app.ListPublicPages(limit, cursor)
+	if err != nil {
+		return nil, err
+	}
 
-	return templateHandler{"dlg_newItem.html.tpl", data}, nil
-}
+	if pages == nil {
+		pages = []Page{}
+	}
 
-type editItemData struct {
-	Page Page
-	Item Item
+	return pagedResult{pages, nextURL(r, next)}, nil
 }
 
-func pageEditItemGet(r *http.Request, app App) (handler, error) {
-	userName := r.FormValue("userName")
-	pageName := r.FormValue("pageName")
-	itemID := r.FormValue("itemID")
-
-	page, err := app.GetPage(userName, pageName)
+func getSharedPages(r *http.Request, app App) (interface{}, error) {
+	limit, cursor, err := parsePaging(r, pagesLimit)
 	if err != nil {
 		return nil, err
 	}
-	item, err := app.getItem(userName, pageName, itemID)
+
+	pages, next, err := app.ListSharedPages(limit, cursor)
 	if err != nil {
 		return nil, err
 	}
 
-	data := editItemData{}
-	data.Page = page
-	data.Item = item
+	if pages == nil {
+		pages = []Page{}
+	}
 
-	return templateHandler{"dlg_editItem.html.tpl", data}, nil
+	return pagedResult{pages, nextURL(r, next)}, nil
 }
 
-func pageAddItem(r *http.Request, app App) (handler, error) {
+func getPage(r *http.Request, app App) (interface{}, error) {
 	vars := mux.Vars(r)
 	userName := vars["userName"]
 	pageName := vars["pageName"]
 
-	item := Item{
-		Kind:    r.FormValue("kind"),
-		Title:   r.FormValue("title"),
-		Content: r.FormValue("content"),
-		Source:  r.FormValue("source"),
-		URL:     r.FormValue("URL"),
-	}
-
-	_, err := app.CreateItem(userName, pageName, item)
+	page, err := app.GetPage(userName, pageName)
 	if err != nil {
 		return nil, err
 	}
 
-	return redirectHandler{"/p/" + userName + "/" + pageName + ".html"}, nil
-}
-
-type deleteItemData struct {
-	Page Page
-	Item Item
+	return page, nil
 }
 
-func pageDeleteItemGet(r *http.Request, app App) (handler, error) {
-	userName := r.FormValue("userName")
-	pageName := r.FormValue("pageName")
-	itemID := r.FormValue("itemID")
+//importPage creates or replaces a page with the file in the body of the request, in the import
+//format given by the format parameter (the JSON export by default).
+//With dryRun=true, returns the changes the import would make without storing anything.
+func importPage(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+	dryRun, _ := strconv.ParseBool(query.Get("dryRun"))
 
-	page, err := app.GetPage(userName, pageName)
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxImportSize+1))
 	if err != nil {
 		return nil, err
 	}
-
-	item, err := app.getItem(userName, pageName, itemID)
+	page, items, err := ParseImport(query.Get("format"), data)
 	if err != nil {
 		return nil, err
 	}
 
-	data := deleteItemData{}
-	data.Page = page
-	data.Item = item
-
-	return templateHandler{"dlg_deleteItem.html.tpl", data}, nil
+	return app.ImportPage(vars["userName"], vars["pageName"], page, items, dryRun)
 }
-func pageDeleteItemPost(r *http.Request, app App) (handler, error) {
-	userName := r.FormValue("userName")
-	pageName := r.FormValue("pageName")
-	itemID := r.FormValue("itemID")
 
-	err := app.DeleteItem(userName, pageName, itemID)
-	if err != nil {
-		return nil, err
-	}
+func getLinkReport(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
 
-	return redirectHandler{"/p/" + userName + "/" + pageName + ".html"}, nil
+	return app.LinkReport(vars["userName"], vars["pageName"])
 }
-func pageAdminTemplates(r *http.Request, app App) (handler, error) {
-
-	tNow := time.Now()
-
-	err := app.StoreTemplate(Template{
-		ID: "blog2col",
 
-		Name: "Micro-blog",
-		File: "p_blog2col.html.tpl",
+func archiveItem(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
 
-		CreationDate:         tNow,
-		LastModificationDate: tNow,
+	return app.ArchiveItem(vars["userName"], vars["pageName"], vars["itemID"])
+}
 
-		PageTags: TagDescriptionList{
-			TagDescription{"background", "Background image", "imageId", "The location of the image to be used as a background for the page.", "default"},
-			TagDescription{"title.color", "Title color", "color", "The color to be used for the page title.", "#000000"},
-		},
+func getPermissions(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
 
-		ItemTags: TagDescriptionList{},
-	})
+	permissions, err := app.PagePermissions(vars["userName"], vars["pageName"])
 	if err != nil {
 		return nil, err
 	}
 
-	err = app.StoreTemplate(Template{
-		ID: "urllist",
-
-		Name: "URL list",
-		File: "p_urllist.html.tpl",
-
-		CreationDate:         tNow,
-		LastModificationDate: tNow,
+	if permissions == nil {
+		permissions = []Permission{}
+	}
 
-		PageTags: TagDescriptionList{
-			TagDescription{"background", "Background image", "imageId", "The location of the image to be used as a background for the page.", "default"},
-			TagDescription{"title.color", "Title color", "color", "The color to be used for the page title.", "#000000"},
-		},
+	return permissions, nil
+}
+func grantRole(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
 
-		ItemTags: TagDescriptionList{},
-	})
+	role, err := ParseRole(r.FormValue("role"))
+	if err != nil {
+		return nil, err
+	}
+	err = app.GrantRole(vars["userName"], vars["pageName"], vars["grantee"], role)
 	if err != nil {
 		return nil, err
 	}
 
-	err = app.StoreTemplate(Template{
-		ID: "angular",
-
-		Name: "Micro-blog with offline mode",
-		File: "p_angular.html.tpl",
-
-		CreationDate:         tNow,
-		LastModificationDate: tNow,
-
-		PageTags: TagDescriptionList{
-			TagDescription{"background", "Background image", "imageId", "The location of the image to be used as a background for the page.", "default"},
-			TagDescription{"title.color", "Title color", "color", "The color to be used for the page title.", "#000000"},
-		},
+	return app.repository.GetPermission(vars["userName"], vars["pageName"], vars["grantee"])
+}
+func revokeRole(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
 
-		ItemTags: TagDescriptionList{},
-	})
+	err := app.RevokeRole(vars["userName"], vars["pageName"], vars["grantee"])
 	if err != nil {
 		return nil, err
 	}
 
-	err = app.StoreTemplate(Template{
-		ID: "todolist",
+	return nil, nil
+}
 
-		Name: "TODO list",
-		File: "p_todolist.html.tpl",
+type shareLinkData struct {
+	ID             string     `json:"id"`
+	CreationDate   time.Time  `json:"creationDate"`
+	ExpirationDate *time.Time `json:"expirationDate,omitempty"`
+	URL            string     `json:"url,omitempty"` //Only when created
+}
 
-		CreationDate:         tNow,
-		LastModificationDate: tNow,
+func newShareLinkData(token Token) shareLinkData {
+	data := shareLinkData{
+		ID:           token.ID,
+		CreationDate: token.CreationDate,
+	}
+	if !token.ExpirationDate.IsZero() {
+		data.ExpirationDate = &token.ExpirationDate
+	}
+	return data
+}
 
-		PageTags: TagDescriptionList{
-			TagDescription{"background", "Background image", "imageId", "The location of the image to be used as a background for the page.", "default"},
-			TagDescription{"title.color", "Title color", "color", "The color to be used for the page title.", "#000000"},
-		},
+func listShareLinks(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
 
-		ItemTags: TagDescriptionList{
-			TagDescription{"status", "Status", "_status", "The status of the item (new, done, archived, ...).", "new"},
-			TagDescription{"deadline", "Deadline", "datetime", "The deadline for the item", ""},
-		},
-	})
+	links, err := app.ShareLinks(vars["userName"], vars["pageName"])
 	if err != nil {
 		return nil, err
 	}
 
-	return redirectHandler{"/index.html"}, nil
+	data := []shareLinkData{}
+	for _, link := range links {
+		data = append(data, newShareLinkData(link))
+	}
+
+	return data, nil
 }
+func createShareLink(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
 
-func getVersion(r *http.Request, app App) (interface{}, error) {
-	type data struct {
-		Version string `json:"version"`
+	expirationDate, err := parseExpiration(r)
+	if err != nil {
+		return nil, err
+	}
+	rotate, _ := strconv.ParseBool(r.FormValue("rotate"))
+	secret, token, err := app.CreateShareLink(vars["userName"], vars["pageName"], expirationDate, rotate)
+	if err != nil {
+		return nil, err
 	}
-	return data{app.Version()}, nil
-}
 
-func getCurrentUser(r *http.Request, app App) (interface{}, error) {
-	return app.CurrentUser()
+	data := newShareLinkData(token)
+	data.URL = shareURL(vars["userName"], vars["pageName"], secret)
+	return data, nil
 }
-
-func getPage(r *http.Request, app App) (interface{}, error) {
+func revokeShareLink(r *http.Request, app App) (interface{}, error) {
 	vars := mux.Vars(r)
-	userName := vars["userName"]
-	pageName := vars["pageName"]
 
-	page, err := app.GetPage(userName, pageName)
+	err := app.RevokeShareLink(vars["userName"], vars["pageName"], vars["tokenID"])
 	if err != nil {
 		return nil, err
 	}
 
-	return page, nil
+	return nil, nil
 }
 
 func getItems(r *http.Request, app App) (interface{}, error) {
@@ -1140,7 +2504,17 @@ func getItems(r *http.Request, app App) (interface{}, error) {
 	userName := vars["userName"]
 	pageName := vars["pageName"]
 
-	items, err := app.listItems(userName, pageName, 1000) //TODO: read limit in query + use paging
+	//Check the read permission
+	page, err := app.GetPage(userName, pageName)
+	if err != nil {
+		return nil, err
+	}
+
+	limit, cursor, err := parsePaging(r, itemsLimit)
+	if err != nil {
+		return nil, err
+	}
+	items, next, err := app.listItems(page, limit, cursor)
 	if err != nil {
 		return nil, err
 	}
@@ -1149,7 +2523,7 @@ func getItems(r *http.Request, app App) (interface{}, error) {
 		items = []Item{}
 	}
 
-	return items, nil
+	return pagedResult{items, nextURL(r, next)}, nil
 }
 func createItem(r *http.Request, app App) (interface{}, error) {
 	vars := mux.Vars(r)
@@ -1270,3 +2644,71 @@ func deleteItem(r *http.Request, app App) (interface{}, error) {
 
 	return nil, nil
 }
+func moveItem(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
+
+	//The item is moved either before or after another item
+	targetID, after := r.FormValue("before"), false
+	if len(targetID) == 0 {
+		targetID, after = r.FormValue("after"), true
+	}
+	if len(targetID) == 0 {
+		return nil, DataError{"before", "the ID of the item before or after which to move the item is required"}
+	}
+
+	return app.MoveItem(vars["userName"], vars["pageName"], vars["itemID"], targetID, after)
+}
+
+func getRevisions(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
+
+	revisions, err := app.ItemRevisions(vars["userName"], vars["pageName"], vars["itemID"])
+	if err != nil {
+		return nil, err
+	}
+
+	if revisions == nil {
+		revisions = []Revision{}
+	}
+
+	return revisions, nil
+}
+func getRevision(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
+
+	number, err := parseRevisionNumber(vars["number"])
+	if err != nil {
+		return nil, err
+	}
+
+	return app.GetRevision(vars["userName"], vars["pageName"], vars["itemID"], number)
+}
+func restoreRevision(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
+
+	number, err := parseRevisionNumber(vars["number"])
+	if err != nil {
+		return nil, err
+	}
+
+	return app.RestoreRevision(vars["userName"], vars["pageName"], vars["itemID"], number)
+}
+func diffRevisions(r *http.Request, app App) (interface{}, error) {
+	vars := mux.Vars(r)
+
+	from, to, err := parseRevisionRange(r)
+	if err != nil {
+		return nil, err
+	}
+
+	diff, err := app.DiffRevisions(vars["userName"], vars["pageName"], vars["itemID"], from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	if diff == nil {
+		diff = []DiffLine{}
+	}
+
+	return diff, nil
+}